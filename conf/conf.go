@@ -8,9 +8,12 @@ import (
 )
 
 type ServerConf struct {
-	Port string `json:"port"`
+	Port                   string `json:"port"`
+	ShutdownTimeoutSeconds int    `json:"shutdownTimeoutSeconds"` // 优雅关闭的最长等待时间，<=0 时使用默认值
 }
 
+// DatabaseConf 数据库配置
+// Type 可选 mysql（默认）、postgres、sqlite；sqlite 模式下 Name 为数据库文件路径，其余连接参数不使用。
 type DatabaseConf struct {
 	Type     string `json:"type"`
 	Host     string `json:"host"`
@@ -18,19 +21,100 @@ type DatabaseConf struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	SSLMode  string `json:"sslMode"` // 仅 postgres 使用，默认为 disable
+}
+
+// RateLimitRule 描述一个限流预算：每个身份(deviceID/realDeviceID/IP)在 windowSeconds 秒内最多请求 limit 次
+// limit 小于等于 0 表示不限制
+type RateLimitRule struct {
+	Limit         int `json:"limit"`
+	WindowSeconds int `json:"windowSeconds"`
+}
+
+// RateLimitConf 限流配置
+// msgIDs 中按 msg_id 配置单独的预算，未配置的 msg_id 使用 default
+type RateLimitConf struct {
+	Enabled bool                     `json:"enabled"`
+	Default RateLimitRule            `json:"default"`
+	MsgIDs  map[string]RateLimitRule `json:"msgIDs"`
+}
+
+// RuleFor 返回指定 msg_id 的限流预算
+func (rc RateLimitConf) RuleFor(msgID string) RateLimitRule {
+	if rule, ok := rc.MsgIDs[msgID]; ok {
+		return rule
+	}
+	return rc.Default
+}
+
+// ProofOfWorkConf 建号工作量证明配置
+// difficulty 为要求的 sha256 前导零比特数，0 表示不要求工作量证明；
+// maxSkewSeconds 为客户端 powTimestamp 与服务器时间允许的最大偏差
+type ProofOfWorkConf struct {
+	Difficulty     int `json:"difficulty"`
+	MaxSkewSeconds int `json:"maxSkewSeconds"`
+}
+
+// RegistrationConf 自动建号限流配置，每个维度都是滑动窗口内允许新建的账号数
+type RegistrationConf struct {
+	PerIP           RateLimitRule   `json:"perIP"`
+	PerRealDeviceID RateLimitRule   `json:"perRealDeviceID"`
+	PerDeviceInfo   RateLimitRule   `json:"perDeviceInfo"`
+	ProofOfWork     ProofOfWorkConf `json:"proofOfWork"`
+}
+
+// RoleIDConf RoleID 分配器配置
+// sequence 为序列名，不同服务器/区服使用不同的序列名和号段；
+// start/end 为本序列可分配的 RoleID 范围（闭区间，end 为 0 表示不设上限）；
+// blockSize 为每个实例一次向数据库预留的 RoleID 数量
+type RoleIDConf struct {
+	Sequence  string `json:"sequence"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	BlockSize int64  `json:"blockSize"`
+}
+
+// ExpiryConf 过期物品清理配置
+// sweepIntervalSeconds 为后台清理的周期，batchSize 为每批读取的玩家数量，小于等于 0 时使用默认值
+type ExpiryConf struct {
+	SweepIntervalSeconds int `json:"sweepIntervalSeconds"`
+	BatchSize            int `json:"batchSize"`
+}
+
+// WalletConf 货币钱包配置
+// initialBalances 为新建钱包时各货币的初始余额，key 为货币类型（gold、diamonds、tickets、coloringAgent、normalFortuneCards、advanceFortuneCards），未配置的货币从 0 开始
+type WalletConf struct {
+	InitialBalances map[string]int64 `json:"initialBalances"`
+}
+
+// MatchConf 对局结果上报配置
+// perDevice 为每个玩家在滑动窗口内最多可以记录的对局数，limit 或 windowSeconds 小于等于 0 时不限制
+type MatchConf struct {
+	PerDevice RateLimitRule `json:"perDevice"`
 }
 
 // Config 结构体已简化，不再包含 BanResponses
 type Config struct {
-	Server   ServerConf   `json:"server"`
-	Database DatabaseConf `json:"database"`
+	Server       ServerConf       `json:"server"`
+	Database     DatabaseConf     `json:"database"`
+	RateLimit    RateLimitConf    `json:"rateLimit"`
+	Registration RegistrationConf `json:"registration"`
+	RoleID       RoleIDConf       `json:"roleID"`
+	Expiry       ExpiryConf       `json:"expiry"`
+	Wallet       WalletConf       `json:"wallet"`
+	Match        MatchConf        `json:"match"`
+	// ResponseFormats 为每个 msg_id 配置被拦截时的响应格式、错误码与提示文本，支持运行时热加载
+	ResponseFormats *ResponseFormatConf `json:"responseFormats"`
 }
 
+// configPath 配置文件路径
+const configPath = "configs/config.json"
+
 var Conf *Config
 
 func Init() {
 	log.Println("Loading configuration...")
-	bytes, err := os.ReadFile("configs/config.json")
+	bytes, err := os.ReadFile(configPath)
 	if err != nil {
 		log.Fatalf("Failed to read config file: %v", err)
 	}
@@ -38,5 +122,6 @@ func Init() {
 	if err := json.Unmarshal(bytes, Conf); err != nil {
 		log.Fatalf("Failed to parse config file: %v", err)
 	}
+	setResponseFormats(Conf.ResponseFormats)
 	log.Println("Configuration loaded.")
-}
\ No newline at end of file
+}
@@ -1,17 +1,152 @@
 // internal/conf/response_format.go
 package conf
 
-// textResponseMsgIDs 存储所有应以纯文本格式响应的 msg_id
-// 使用 map[int]bool 模拟一个集合(Set)，查找效率为 O(1)，非常高。
-var textResponseMsgIDs = map[int]bool{
-	30099: true, // 示例：msg_id 30099 返回纯文本
-	50023: true, // 示例：msg_id 50023 返回纯文本
-	// 后续所有需要返回纯文本的 msg_id 都添加到这里
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 响应格式
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// ResponseFormat 描述一个 msg_id 被前置安全策略拦截时的响应方式
+// format 为 json 或 text；banErrorCode 为 JSON 响应中的错误码；banMessage 为返回给客户端的（虚假）提示文本
+type ResponseFormat struct {
+	Format       string `json:"format"`
+	BanErrorCode int    `json:"banErrorCode"`
+	BanMessage   string `json:"banMessage"`
+}
+
+// ResponseFormatConf 是 config.json 中的 responseFormats 配置
+// msgIDs 中按 msg_id 覆盖 default 中的字段，未填写的字段沿用 default
+type ResponseFormatConf struct {
+	Default ResponseFormat            `json:"default"`
+	MsgIDs  map[string]ResponseFormat `json:"msgIDs"`
+}
+
+// defaultResponseFormats 在配置文件缺少 responseFormats 时使用，与旧版本的硬编码行为一致
+func defaultResponseFormats() ResponseFormatConf {
+	return ResponseFormatConf{
+		Default: ResponseFormat{
+			Format:       FormatJSON,
+			BanErrorCode: -3001,
+			BanMessage:   "获取版本信息失败，请重试", //虚假的错误提示信息误导黑客
+		},
+		MsgIDs: map[string]ResponseFormat{
+			"30099": {Format: FormatText}, // 示例：msg_id 30099 返回纯文本
+			"50023": {Format: FormatText}, // 示例：msg_id 50023 返回纯文本
+		},
+	}
+}
+
+// responseFormats 保存当前生效的配置，热加载时整体原子替换
+var responseFormats atomic.Pointer[ResponseFormatConf]
+
+// setResponseFormats 校验并替换当前生效的响应格式配置
+func setResponseFormats(rc *ResponseFormatConf) {
+	if rc == nil || (rc.Default == ResponseFormat{} && len(rc.MsgIDs) == 0) {
+		defaults := defaultResponseFormats()
+		rc = &defaults
+	}
+	if rc.Default.Format == "" {
+		rc.Default.Format = FormatJSON
+	}
+	if rc.Default.BanErrorCode == 0 {
+		rc.Default.BanErrorCode = -3001
+	}
+	for msgID, rf := range rc.MsgIDs {
+		if _, err := strconv.Atoi(msgID); err != nil {
+			log.Printf("Warning: invalid msg_id '%s' in responseFormats, ignored.", msgID)
+			delete(rc.MsgIDs, msgID)
+			continue
+		}
+		if rf.Format != "" && rf.Format != FormatJSON && rf.Format != FormatText {
+			log.Printf("Warning: invalid format '%s' for msg_id %s in responseFormats, using default.", rf.Format, msgID)
+			rf.Format = ""
+			rc.MsgIDs[msgID] = rf
+		}
+	}
+	responseFormats.Store(rc)
+}
+
+// ResponseFormatFor 返回指定 msg_id 合并了默认值之后的响应配置
+func ResponseFormatFor(msgID int) ResponseFormat {
+	rc := responseFormats.Load()
+	if rc == nil {
+		defaults := defaultResponseFormats()
+		rc = &defaults
+	}
+	result := rc.Default
+	if rf, ok := rc.MsgIDs[strconv.Itoa(msgID)]; ok {
+		if rf.Format != "" {
+			result.Format = rf.Format
+		}
+		if rf.BanErrorCode != 0 {
+			result.BanErrorCode = rf.BanErrorCode
+		}
+		if rf.BanMessage != "" {
+			result.BanMessage = rf.BanMessage
+		}
+	}
+	return result
 }
 
 // IsTextResponse 是一个公共函数，用于检查给定的 msg_id 是否需要以纯文本格式响应
-// 默认情况下，如果一个 msg_id 不在此列表中，它将被视为需要 JSON 响应。
+// 默认情况下，如果一个 msg_id 没有配置为 text，它将被视为需要 JSON 响应。
 func IsTextResponse(msgID int) bool {
-	_, found := textResponseMsgIDs[msgID]
-	return found
-}
\ No newline at end of file
+	return ResponseFormatFor(msgID).Format == FormatText
+}
+
+// ReloadResponseFormats 重新读取配置文件中的 responseFormats 并立即生效
+// 只替换响应格式部分，端口、数据库等配置仍需重启才能生效
+func ReloadResponseFormats() error {
+	bytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	var partial struct {
+		ResponseFormats *ResponseFormatConf `json:"responseFormats"`
+	}
+	if err := json.Unmarshal(bytes, &partial); err != nil {
+		return err
+	}
+	setResponseFormats(partial.ResponseFormats)
+	return nil
+}
+
+// WatchResponseFormats 定期检查配置文件的修改时间，发生变化时热加载 responseFormats
+// 关闭 stop 后协程退出
+func WatchResponseFormats(interval time.Duration, stop <-chan struct{}) {
+	var lastModTime time.Time
+	if info, err := os.Stat(configPath); err == nil {
+		lastModTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(configPath)
+			if err != nil || !info.ModTime().After(lastModTime) {
+				continue
+			}
+			lastModTime = info.ModTime()
+			if err := ReloadResponseFormats(); err != nil {
+				log.Printf("Failed to reload responseFormats: %v", err)
+				continue
+			}
+			log.Println("responseFormats reloaded from config file.")
+		}
+	}
+}
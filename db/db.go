@@ -5,39 +5,94 @@ import (
 
 	"fmt"
 	"log"
+	"strings"
 	"dmmserver/conf"
-	"dmmserver/model"
+	"dmmserver/db/migrations"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
+// InitDB 连接数据库并执行所有尚未执行的迁移（db/migrations）
 func InitDB() {
-	log.Println("Initializing database connection...")
-	var err error
-	c := conf.Conf.Database
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		c.User, c.Password, c.Host, c.Port, c.Name)
+	Connect()
+
+	log.Println("Applying database migrations...")
+	if err := migrations.Up(DB); err != nil {
+		log.Fatalf("Failed to apply database migrations: %v", err)
+	}
+
+	log.Println("Database initialization and migration complete.")
+}
 
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Connect 只建立数据库连接，不执行迁移，供 migrate 子命令使用
+func Connect() {
+	c := conf.Conf.Database
+	log.Printf("Initializing database connection (type=%s)...", c.Type)
+	dialector, err := Dialector(c)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	log.Println("Auto-migrating database tables...")
-	err = DB.AutoMigrate(
-		&model.BanDeviceID{},
-		&model.BanIP{},
-		&model.BanRealDeviceID{},
-		&model.PlayerData{},
-		&model.ServerSettings{},
-		&model.PlayerInfo{},
-		&model.BanDeviceInfo{},
-	)
+	DB, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
-		log.Fatalf("Failed to auto-migrate tables: %v", err)
+		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	log.Println("Database initialization and migration complete.")
+	if dialector.Name() == "sqlite" {
+		// SQLite 同一时间只允许一个写连接，限制连接数避免 database is locked
+		if sqlDB, err := DB.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
+	}
+}
+
+// Dialector 根据 conf.Database.Type 选择数据库驱动
+// 支持 mysql（默认）、postgres 和 sqlite，sqlite 不需要单独的数据库服务，适合本地开发。
+func Dialector(c conf.DatabaseConf) (gorm.Dialector, error) {
+	switch c.Type {
+	case "", "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			c.User, c.Password, c.Host, c.Port, c.Name)
+		return mysql.Open(dsn), nil
+	case "postgres", "postgresql":
+		sslMode := c.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			c.Host, c.Port, c.User, c.Password, c.Name, sslMode)
+		return postgres.Open(dsn), nil
+	case "sqlite", "sqlite3":
+		path := c.Name
+		if path == "" {
+			path = "dmmserver.db"
+		}
+		if !strings.Contains(path, "?") {
+			path += "?_busy_timeout=5000"
+		}
+		return sqlite.Open(path), nil
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", c.Type)
+	}
+}
+
+// Close 关闭底层的数据库连接池，由bootstrap在服务器关闭的最后一步调用
+func Close() {
+	if DB == nil {
+		return
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		log.Printf("Failed to get database connection pool: %v", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Printf("Failed to close database connection pool: %v", err)
+		return
+	}
+	log.Println("Database connection pool closed.")
 }
\ No newline at end of file
@@ -0,0 +1,155 @@
+// internal/db/migrations/0001_initial_schema.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0001 记录引入迁移系统时已有的全部数据表。
+// 这里使用结构体快照而不是直接引用 model 包，model 之后的修改应通过新的迁移完成。
+// 已有部署执行该迁移时 AutoMigrate 只会补齐缺失的表和列，不会删除数据。
+
+type banDeviceID0001 struct {
+	DeviceID    string `gorm:"primaryKey"`
+	BanningTime time.Time
+}
+
+func (banDeviceID0001) TableName() string { return "Ban_DeviceID" }
+
+type banIP0001 struct {
+	IP          string `gorm:"primaryKey"`
+	BanningTime time.Time
+}
+
+func (banIP0001) TableName() string { return "Ban_IP" }
+
+type banRealDeviceID0001 struct {
+	RealDeviceID string `gorm:"primaryKey"`
+	BanningTime  time.Time
+}
+
+func (banRealDeviceID0001) TableName() string { return "Ban_realDeviceID" }
+
+type banDeviceInfo0001 struct {
+	DeviceInfo  string `gorm:"primaryKey"`
+	BanningTime time.Time
+}
+
+func (banDeviceInfo0001) TableName() string { return "Ban_DeviceInfo" }
+
+type playerData0001 struct {
+	DeviceID            string `gorm:"primaryKey"`
+	RoleID              int
+	OpenID              string
+	Audit               int
+	CreateAccountTime   int64
+	AccountSafe         bool
+	NotSafe             bool
+	OpenIDMatched       bool
+	CustomAccount       string
+	GuideLevel          int
+	IsSetPwd            bool
+	Mail                string
+	ReputationScore     int
+	ReputationLimitTime int
+	InspectorLevel      int
+	PublicInfo          string `gorm:"type:text"`
+	Cards               jsonText
+	OwnedSkins          jsonText
+	OwnedCharacters     jsonText
+	CardSkins           jsonText
+	CardStyles          jsonText
+	PlayerRadar         string `gorm:"type:text"`
+	EmotionData         jsonText
+	AssetsData          jsonText
+	BoxesData           jsonText
+	LightnessData       jsonText
+	PlaytimeData        jsonText
+	AuthKey             string
+	AuthKeyExpire       int64
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+func (playerData0001) TableName() string { return "dmm_playerdata" }
+
+type serverSettings0001 struct {
+	GraphicsOptions        string
+	MiscOptions            string
+	ServerIP               *string
+	ServerPort             *string
+	ServerOverDayTimeStamp int64
+	PlaytimeSettings       string
+	CreatedAt              time.Time `gorm:"autoCreateTime"`
+	UpdatedAt              time.Time `gorm:"autoUpdateTime"`
+}
+
+func (serverSettings0001) TableName() string { return "dmm_settings" }
+
+type playerInfo0001 struct {
+	DeviceID     string    `gorm:"primaryKey"`
+	RoleID       int       `gorm:"uniqueIndex"`
+	RealDeviceID string    `gorm:"type:text"`
+	DeviceInfo   string    `gorm:"type:text"`
+	IP           string    `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+func (playerInfo0001) TableName() string { return "dmm_playerinfo" }
+
+type registrationAttempt0001 struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement"`
+	DeviceID         string    `gorm:"size:191"`
+	IP               string    `gorm:"size:64;index"`
+	RealDeviceID     string    `gorm:"size:191;index"`
+	StableDeviceInfo string    `gorm:"size:191;index"`
+	Accepted         bool      `gorm:"index"`
+	Reason           string    `gorm:"size:255"`
+	CreatedAt        time.Time `gorm:"index"`
+}
+
+func (registrationAttempt0001) TableName() string { return "dmm_registration_attempts" }
+
+type roleIDSequence0001 struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	NextValue int64
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (roleIDSequence0001) TableName() string { return "dmm_role_id_sequence" }
+
+func initialSchemaTables() []interface{} {
+	return []interface{}{
+		&banDeviceID0001{},
+		&banIP0001{},
+		&banRealDeviceID0001{},
+		&playerData0001{},
+		&serverSettings0001{},
+		&playerInfo0001{},
+		&banDeviceInfo0001{},
+		&registrationAttempt0001{},
+		&roleIDSequence0001{},
+	}
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(initialSchemaTables()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			tables := initialSchemaTables()
+			for i := len(tables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(tables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,87 @@
+// internal/db/migrations/0002_normalize_playerinfo_history.go
+package migrations
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 0002 把 dmm_playerinfo 中旧版本写入的 JSON 数组格式历史记录（如 ["a","b"]）
+// 统一转换为当前使用的双换行符分隔格式（a\n\nb）。
+// model.PlayerInfo 同时兼容两种格式，因此 Down 不需要还原数据。
+
+const playerInfoBatchSize = 500
+
+type playerInfoHistory0002 struct {
+	DeviceID     string `gorm:"primaryKey"`
+	RealDeviceID string
+	DeviceInfo   string
+	IP           string
+}
+
+func (playerInfoHistory0002) TableName() string { return "dmm_playerinfo" }
+
+// legacyArrayToText 如果 value 是 JSON 字符串数组则返回转换后的文本，否则 ok 为 false
+func legacyArrayToText(value string) (string, bool) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+	var items []string
+	if err := json.Unmarshal([]byte(trimmed), &items); err != nil {
+		return "", false
+	}
+	var parts []string
+	for _, item := range items {
+		if item != "" {
+			parts = append(parts, item)
+		}
+	}
+	return strings.Join(parts, "\n\n"), true
+}
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "normalize_playerinfo_history",
+		Up: func(tx *gorm.DB) error {
+			converted := 0
+			var rows []playerInfoHistory0002
+			result := tx.Model(&playerInfoHistory0002{}).FindInBatches(&rows, playerInfoBatchSize, func(batch *gorm.DB, _ int) error {
+				for _, row := range rows {
+					updates := map[string]interface{}{}
+					if text, ok := legacyArrayToText(row.RealDeviceID); ok {
+						updates["real_device_id"] = text
+					}
+					if text, ok := legacyArrayToText(row.DeviceInfo); ok {
+						updates["device_info"] = text
+					}
+					if text, ok := legacyArrayToText(row.IP); ok {
+						updates["ip"] = text
+					}
+					if len(updates) == 0 {
+						continue
+					}
+					// 使用 UpdateColumns 避免修改 updated_at
+					if err := tx.Model(&playerInfoHistory0002{}).Where("device_id = ?", row.DeviceID).UpdateColumns(updates).Error; err != nil {
+						return err
+					}
+					converted++
+				}
+				return nil
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			log.Printf("已将 %d 条玩家设备历史记录从JSON数组转换为双换行符格式", converted)
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			log.Println("0002_normalize_playerinfo_history 为单向数据转换，回滚时保留双换行符格式")
+			return nil
+		},
+	})
+}
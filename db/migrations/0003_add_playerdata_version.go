@@ -0,0 +1,34 @@
+// internal/db/migrations/0003_add_playerdata_version.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// 0003 为 dmm_playerdata 增加 version 列，用于乐观锁。
+// 每次写入玩家数据时 version 加一，写入前比较版本号，避免并发请求互相覆盖。
+
+type playerDataVersion0003 struct {
+	Version int64 `gorm:"not null;default:0"`
+}
+
+func (playerDataVersion0003) TableName() string { return "dmm_playerdata" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "add_playerdata_version",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&playerDataVersion0003{}, "Version") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&playerDataVersion0003{}, "Version")
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerDataVersion0003{}, "Version") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&playerDataVersion0003{}, "Version")
+		},
+	})
+}
@@ -0,0 +1,32 @@
+// internal/db/migrations/0004_create_reward_grants.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0004 新建 dmm_reward_grants 表，记录奖励发放的幂等键，防止重试时重复发放。
+
+type rewardGrant0004 struct {
+	DeviceID  string `gorm:"primaryKey;size:191"`
+	GrantKey  string `gorm:"primaryKey;size:191"`
+	Items     jsonText
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (rewardGrant0004) TableName() string { return "dmm_reward_grants" }
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "create_reward_grants",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&rewardGrant0004{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&rewardGrant0004{})
+		},
+	})
+}
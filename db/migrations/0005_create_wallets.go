@@ -0,0 +1,50 @@
+// internal/db/migrations/0005_create_wallets.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0005 新建 dmm_wallets 货币钱包表和只追加的 dmm_wallet_ledger 流水表。
+
+type wallet0005 struct {
+	DeviceID            string `gorm:"primaryKey;size:191"`
+	Gold                int64  `gorm:"not null;default:0"`
+	Diamonds            int64  `gorm:"not null;default:0"`
+	Tickets             int64  `gorm:"not null;default:0"`
+	ColoringAgent       int64  `gorm:"not null;default:0"`
+	NormalFortuneCards  int64  `gorm:"not null;default:0"`
+	AdvanceFortuneCards int64  `gorm:"not null;default:0"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (wallet0005) TableName() string { return "dmm_wallets" }
+
+type walletLedgerEntry0005 struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID     string `gorm:"size:191;index:idx_wallet_ledger_device,priority:1"`
+	Currency     string `gorm:"size:32"`
+	Amount       int64
+	BalanceAfter int64
+	Reason       string    `gorm:"size:64"`
+	MsgID        string    `gorm:"size:16"`
+	CreatedAt    time.Time `gorm:"index:idx_wallet_ledger_device,priority:2"`
+}
+
+func (walletLedgerEntry0005) TableName() string { return "dmm_wallet_ledger" }
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "create_wallets",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&wallet0005{}, &walletLedgerEntry0005{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&walletLedgerEntry0005{}, &wallet0005{})
+		},
+	})
+}
@@ -0,0 +1,46 @@
+// internal/db/migrations/0006_create_shop.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0006 新建 dmm_shop_purchases 商店购买记录表和 dmm_shop_stock 限量商品库存表。
+
+type shopPurchase0006 struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID    string `gorm:"size:191;uniqueIndex:idx_shop_purchase_order,priority:1;index:idx_shop_purchase_item,priority:1"`
+	OrderKey    string `gorm:"size:128;uniqueIndex:idx_shop_purchase_order,priority:2"`
+	ShopItemID  int    `gorm:"index:idx_shop_purchase_item,priority:2"`
+	ItemID      int
+	Count       int
+	ExpiredTime int
+	Currency    string `gorm:"size:32"`
+	Price       int64
+	CreatedAt   time.Time
+}
+
+func (shopPurchase0006) TableName() string { return "dmm_shop_purchases" }
+
+type shopStock0006 struct {
+	ShopItemID int `gorm:"primaryKey;autoIncrement:false"`
+	Sold       int `gorm:"not null;default:0"`
+	UpdatedAt  time.Time
+}
+
+func (shopStock0006) TableName() string { return "dmm_shop_stock" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "create_shop",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&shopPurchase0006{}, &shopStock0006{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&shopPurchase0006{}, &shopStock0006{})
+		},
+	})
+}
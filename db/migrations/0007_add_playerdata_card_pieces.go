@@ -0,0 +1,36 @@
+// internal/db/migrations/0007_add_playerdata_card_pieces.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// 0007 为 dmm_playerdata 增加 card_pieces 列，保存玩家的卡牌碎片数量。
+// 已有玩家的碎片初始化为空数组，避免 MySQL 的 json 列写入空字符串。
+
+type playerDataCardPieces0007 struct {
+	CardPieces jsonText
+}
+
+func (playerDataCardPieces0007) TableName() string { return "dmm_playerdata" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "add_playerdata_card_pieces",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerDataCardPieces0007{}, "CardPieces") {
+				if err := tx.Migrator().AddColumn(&playerDataCardPieces0007{}, "CardPieces"); err != nil {
+					return err
+				}
+			}
+			return tx.Exec("UPDATE dmm_playerdata SET card_pieces = '[]' WHERE card_pieces IS NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerDataCardPieces0007{}, "CardPieces") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&playerDataCardPieces0007{}, "CardPieces")
+		},
+	})
+}
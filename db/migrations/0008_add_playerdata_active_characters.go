@@ -0,0 +1,36 @@
+// internal/db/migrations/0008_add_playerdata_active_characters.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// 0008 为 dmm_playerdata 增加 active_characters 列，保存玩家当前出战的角色。
+// 已有玩家初始化为原来固定返回的 [100,200]。
+
+type playerDataActiveCharacters0008 struct {
+	ActiveCharacters jsonText
+}
+
+func (playerDataActiveCharacters0008) TableName() string { return "dmm_playerdata" }
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "add_playerdata_active_characters",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerDataActiveCharacters0008{}, "ActiveCharacters") {
+				if err := tx.Migrator().AddColumn(&playerDataActiveCharacters0008{}, "ActiveCharacters"); err != nil {
+					return err
+				}
+			}
+			return tx.Exec("UPDATE dmm_playerdata SET active_characters = '[100,200]' WHERE active_characters IS NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerDataActiveCharacters0008{}, "ActiveCharacters") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&playerDataActiveCharacters0008{}, "ActiveCharacters")
+		},
+	})
+}
@@ -0,0 +1,37 @@
+// internal/db/migrations/0009_create_mail.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0009 新建 dmm_mail 玩家邮件表。
+
+type mail0009 struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID    string `gorm:"size:191;index:idx_mail_device,priority:1"`
+	Title       string `gorm:"size:128"`
+	Body        string `gorm:"type:text"`
+	Attachments jsonText
+	IsRead      bool      `gorm:"not null;default:false"`
+	IsClaimed   bool      `gorm:"not null;default:false"`
+	ExpireAt    int64     `gorm:"not null;default:0"`
+	CreatedAt   time.Time `gorm:"index:idx_mail_device,priority:2"`
+}
+
+func (mail0009) TableName() string { return "dmm_mail" }
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "create_mail",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&mail0009{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&mail0009{})
+		},
+	})
+}
@@ -0,0 +1,66 @@
+// internal/db/migrations/0010_create_broadcast_mail.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0010 新建 dmm_broadcast_mail 群发邮件表和 dmm_broadcast_mail_states 投递记录表，
+// 并为 dmm_playerdata 增加 pf_id 列，用于按平台筛选群发邮件的收件人。
+
+type broadcastMail0010 struct {
+	ID            uint64 `gorm:"primaryKey;autoIncrement"`
+	Title         string `gorm:"size:128"`
+	Body          string `gorm:"type:text"`
+	Attachments   jsonText
+	RoleIDs       jsonText
+	CreatedBefore int64 `gorm:"not null;default:0"`
+	VIPOnly       bool  `gorm:"not null;default:false"`
+	PfID          int   `gorm:"not null;default:0"`
+	SendAt        int64 `gorm:"not null;default:0;index"`
+	ExpireAt      int64 `gorm:"not null;default:0"`
+	CreatedAt     time.Time
+}
+
+func (broadcastMail0010) TableName() string { return "dmm_broadcast_mail" }
+
+type broadcastMailState0010 struct {
+	BroadcastID uint64 `gorm:"primaryKey;autoIncrement:false"`
+	DeviceID    string `gorm:"primaryKey;size:191"`
+	MailID      uint64
+	CreatedAt   time.Time
+}
+
+func (broadcastMailState0010) TableName() string { return "dmm_broadcast_mail_states" }
+
+type playerDataPfID0010 struct {
+	PfID int `gorm:"not null;default:0"`
+}
+
+func (playerDataPfID0010) TableName() string { return "dmm_playerdata" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "create_broadcast_mail",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&broadcastMail0010{}, &broadcastMailState0010{}); err != nil {
+				return err
+			}
+			if tx.Migrator().HasColumn(&playerDataPfID0010{}, "PfID") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&playerDataPfID0010{}, "PfID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&playerDataPfID0010{}, "PfID") {
+				if err := tx.Migrator().DropColumn(&playerDataPfID0010{}, "PfID"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&broadcastMailState0010{}, &broadcastMail0010{})
+		},
+	})
+}
@@ -0,0 +1,55 @@
+// internal/db/migrations/0011_create_gift_codes.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0011 新建礼包卡号使用的 dmm_gift_code_batches 批次表、dmm_gift_codes 卡号表和 dmm_gift_code_redemptions 兑换记录表。
+
+type giftCodeBatch0011 struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement"`
+	Name         string `gorm:"size:128"`
+	Rewards      jsonText
+	MaxUses      int   `gorm:"not null;default:0"`
+	OnePerPlayer bool  `gorm:"not null;default:false"`
+	StartAt      int64 `gorm:"not null;default:0"`
+	EndAt        int64 `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+}
+
+func (giftCodeBatch0011) TableName() string { return "dmm_gift_code_batches" }
+
+type giftCode0011 struct {
+	Code      string `gorm:"primaryKey;size:64"`
+	BatchID   uint64 `gorm:"index"`
+	Uses      int    `gorm:"not null;default:0"`
+	CreatedAt time.Time
+}
+
+func (giftCode0011) TableName() string { return "dmm_gift_codes" }
+
+type giftCodeRedemption0011 struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Code      string `gorm:"size:64;uniqueIndex:idx_gift_code_redemption,priority:1"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_gift_code_redemption,priority:2;index:idx_gift_code_redemption_batch,priority:2"`
+	BatchID   uint64 `gorm:"index:idx_gift_code_redemption_batch,priority:1"`
+	CreatedAt time.Time
+}
+
+func (giftCodeRedemption0011) TableName() string { return "dmm_gift_code_redemptions" }
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "create_gift_codes",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&giftCodeBatch0011{}, &giftCode0011{}, &giftCodeRedemption0011{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&giftCodeRedemption0011{}, &giftCode0011{}, &giftCodeBatch0011{})
+		},
+	})
+}
@@ -0,0 +1,46 @@
+// internal/db/migrations/0012_create_player_tasks.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0012 新建每日和每周任务使用的 dmm_player_tasks 任务进度表和 dmm_player_task_states 任务状态表。
+
+type playerTask0012 struct {
+	DeviceID    string `gorm:"primaryKey;size:191"`
+	TaskID      int    `gorm:"primaryKey;autoIncrement:false"`
+	Period      string `gorm:"size:16;not null"`
+	PeriodStart int64  `gorm:"not null;default:0"`
+	Progress    int    `gorm:"not null;default:0"`
+	Claimed     bool   `gorm:"not null;default:false"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (playerTask0012) TableName() string { return "dmm_player_tasks" }
+
+type playerTaskState0012 struct {
+	DeviceID          string `gorm:"primaryKey;size:191"`
+	DailyPeriodStart  int64  `gorm:"not null;default:0"`
+	WeeklyPeriodStart int64  `gorm:"not null;default:0"`
+	Rerolls           int    `gorm:"not null;default:0"`
+	UpdatedAt         time.Time
+}
+
+func (playerTaskState0012) TableName() string { return "dmm_player_task_states" }
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "create_player_tasks",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&playerTask0012{}, &playerTaskState0012{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&playerTaskState0012{}, &playerTask0012{})
+		},
+	})
+}
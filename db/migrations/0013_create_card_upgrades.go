@@ -0,0 +1,36 @@
+// internal/db/migrations/0013_create_card_upgrades.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0013 新建 dmm_card_upgrades 卡牌升级记录表，用于拒绝客户端重发的升级请求。
+
+type cardUpgrade0013 struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_card_upgrade_order,priority:1"`
+	OrderKey  string `gorm:"size:128;uniqueIndex:idx_card_upgrade_order,priority:2"`
+	CardID    int
+	Level     int
+	Pieces    int
+	Gold      int64
+	CreatedAt time.Time
+}
+
+func (cardUpgrade0013) TableName() string { return "dmm_card_upgrades" }
+
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "create_card_upgrades",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&cardUpgrade0013{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&cardUpgrade0013{})
+		},
+	})
+}
@@ -0,0 +1,34 @@
+// internal/db/migrations/0014_create_syntheses.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0014 新建 dmm_syntheses 合成记录表，用于拒绝客户端重发的合成请求。
+
+type synthesis0014 struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID    string `gorm:"size:191;uniqueIndex:idx_synthesis_order,priority:1"`
+	OrderKey    string `gorm:"size:128;uniqueIndex:idx_synthesis_order,priority:2"`
+	ItemID      int
+	ExpiredTime int
+	CreatedAt   time.Time
+}
+
+func (synthesis0014) TableName() string { return "dmm_syntheses" }
+
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "create_syntheses",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&synthesis0014{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&synthesis0014{})
+		},
+	})
+}
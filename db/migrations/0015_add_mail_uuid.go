@@ -0,0 +1,72 @@
+// internal/db/migrations/0015_add_mail_uuid.go
+package migrations
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// 0015 为 dmm_mail 增加 uuid 列，附件领取以 "mail:<uuid>" 为幂等键。
+// 邮件ID在删除后可能被数据库重新分配，不能作为幂等键；已有邮件在建立唯一索引前逐行生成 uuid。
+
+type mailUUID0015 struct {
+	ID   uint64 `gorm:"primaryKey;autoIncrement"`
+	UUID string `gorm:"size:36;uniqueIndex:idx_mail_uuid"`
+}
+
+func (mailUUID0015) TableName() string { return "dmm_mail" }
+
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "add_mail_uuid",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&mailUUID0015{}, "UUID") {
+				if err := tx.Migrator().AddColumn(&mailUUID0015{}, "UUID"); err != nil {
+					return err
+				}
+			}
+			var mails []mailUUID0015
+			if err := tx.Where("uuid IS NULL OR uuid = ''").Find(&mails).Error; err != nil {
+				return err
+			}
+			for _, mail := range mails {
+				uuid, err := newUUID0015()
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&mailUUID0015{}).Where("id = ?", mail.ID).Update("uuid", uuid).Error; err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&mailUUID0015{}, "idx_mail_uuid") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&mailUUID0015{}, "idx_mail_uuid")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&mailUUID0015{}, "idx_mail_uuid") {
+				if err := tx.Migrator().DropIndex(&mailUUID0015{}, "idx_mail_uuid"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasColumn(&mailUUID0015{}, "UUID") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&mailUUID0015{}, "UUID")
+		},
+	})
+}
+
+// newUUID0015 生成一个随机（第 4 版）UUID，与 services/mail 中新邮件使用的格式相同
+func newUUID0015() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,33 @@
+// internal/db/migrations/0016_create_match_results.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0016 新建 dmm_match_results 对局结果表，每场对局只推进一次 matchPlayed 任务。
+
+type matchResult0016 struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_match_result,priority:1"`
+	MatchID   string `gorm:"size:64;uniqueIndex:idx_match_result,priority:2"`
+	Won       bool   `gorm:"not null;default:false"`
+	CreatedAt time.Time
+}
+
+func (matchResult0016) TableName() string { return "dmm_match_results" }
+
+func init() {
+	register(Migration{
+		Version: 16,
+		Name:    "create_match_results",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&matchResult0016{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&matchResult0016{})
+		},
+	})
+}
@@ -0,0 +1,34 @@
+// internal/db/migrations/0017_add_task_state_login_period.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// 0017 为 dmm_player_task_states 增加 login_period_start 列，记录最近一次计入登录任务的每日周期。
+// 同一天内多次登录只推进一次登录任务的进度。
+
+type playerTaskStateLoginPeriod0017 struct {
+	LoginPeriodStart int64 `gorm:"not null;default:0"`
+}
+
+func (playerTaskStateLoginPeriod0017) TableName() string { return "dmm_player_task_states" }
+
+func init() {
+	register(Migration{
+		Version: 17,
+		Name:    "add_task_state_login_period",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&playerTaskStateLoginPeriod0017{}, "LoginPeriodStart") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&playerTaskStateLoginPeriod0017{}, "LoginPeriodStart")
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&playerTaskStateLoginPeriod0017{}, "LoginPeriodStart") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&playerTaskStateLoginPeriod0017{}, "LoginPeriodStart")
+		},
+	})
+}
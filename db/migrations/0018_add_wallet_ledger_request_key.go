@@ -0,0 +1,34 @@
+// internal/db/migrations/0018_add_wallet_ledger_request_key.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// 0018 为 dmm_wallet_ledger 增加 request_key 列，记录触发余额变动的请求的幂等键。
+// msg_id 只能区分接口，同一个接口的不同请求通过 request_key 区分。
+
+type walletLedgerRequestKey0018 struct {
+	RequestKey string `gorm:"size:191"`
+}
+
+func (walletLedgerRequestKey0018) TableName() string { return "dmm_wallet_ledger" }
+
+func init() {
+	register(Migration{
+		Version: 18,
+		Name:    "add_wallet_ledger_request_key",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&walletLedgerRequestKey0018{}, "RequestKey") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&walletLedgerRequestKey0018{}, "RequestKey")
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&walletLedgerRequestKey0018{}, "RequestKey") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&walletLedgerRequestKey0018{}, "RequestKey")
+		},
+	})
+}
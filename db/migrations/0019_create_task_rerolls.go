@@ -0,0 +1,34 @@
+// internal/db/migrations/0019_create_task_rerolls.go
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 0019 新建 dmm_task_rerolls 任务重置记录表，用于拒绝客户端重发的重置请求。
+
+type taskReroll0019 struct {
+	ID         uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID   string `gorm:"size:191;uniqueIndex:idx_task_reroll_request,priority:1"`
+	RequestKey string `gorm:"size:128;uniqueIndex:idx_task_reroll_request,priority:2"`
+	OldTaskID  int
+	NewTaskID  int
+	CreatedAt  time.Time
+}
+
+func (taskReroll0019) TableName() string { return "dmm_task_rerolls" }
+
+func init() {
+	register(Migration{
+		Version: 19,
+		Name:    "create_task_rerolls",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&taskReroll0019{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&taskReroll0019{})
+		},
+	})
+}
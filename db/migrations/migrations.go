@@ -0,0 +1,153 @@
+// internal/db/migrations/migrations.go
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration 是一个带编号的数据库迁移
+// Up 与 Down 在同一个事务中执行，并同时写入/删除 schema_migrations 中的记录。
+// 注意：MySQL 的 DDL 语句会隐式提交事务，建表类迁移的 Down 需要自行保证可重复执行。
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration 记录已经执行过的迁移
+type SchemaMigration struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// TableName 指定表名
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status 描述一个迁移的执行状态
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var registry []Migration
+
+// register 由各迁移文件的 init() 调用，注册一个迁移
+func register(m Migration) {
+	for _, existing := range registry {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("duplicate migration version %d", m.Version))
+		}
+	}
+	registry = append(registry, m)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+// ensureTable 确保 schema_migrations 表存在
+func ensureTable(db *gorm.DB) error {
+	return db.AutoMigrate(&SchemaMigration{})
+}
+
+// applied 返回已执行迁移的版本号集合
+func applied(db *gorm.DB) (map[int64]SchemaMigration, error) {
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int64]SchemaMigration, len(records))
+	for _, r := range records {
+		result[r.Version] = r
+	}
+	return result, nil
+}
+
+// Up 按版本号顺序执行所有尚未执行的迁移
+func Up(db *gorm.DB) error {
+	if err := ensureTable(db); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	done, err := applied(db)
+	if err != nil {
+		return fmt.Errorf("load schema_migrations: %w", err)
+	}
+
+	for _, m := range registry {
+		if _, ok := done[m.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %04d_%s...", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down 按版本号倒序回滚最近执行的 steps 个迁移
+func Down(db *gorm.DB, steps int) error {
+	if err := ensureTable(db); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	done, err := applied(db)
+	if err != nil {
+		return fmt.Errorf("load schema_migrations: %w", err)
+	}
+
+	for i := len(registry) - 1; i >= 0 && steps > 0; i-- {
+		m := registry[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		log.Printf("Reverting migration %04d_%s...", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// List 返回所有已注册迁移及其执行状态
+func List(db *gorm.DB) ([]Status, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, fmt.Errorf("load schema_migrations: %w", err)
+	}
+
+	result := make([]Status, 0, len(registry))
+	for _, m := range registry {
+		record, ok := done[m.Version]
+		result = append(result, Status{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,24 @@
+// internal/db/migrations/types.go
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// jsonText 用于迁移快照中存放JSON文本的列，按数据库方言选择列类型：
+// MySQL 使用 json，PostgreSQL 使用 jsonb，SQLite 没有独立的JSON类型，使用 text。
+// model 中对应的字段仍然是 string，读写时不需要转换。
+type jsonText string
+
+// GormDBDataType 实现 gorm 的 GormDBDataTypeInterface
+func (jsonText) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "jsonb"
+	case "sqlite":
+		return "text"
+	default:
+		return "json"
+	}
+}
@@ -3,11 +3,15 @@ package handler
 
 import (
 	"crypto/md5"
-	"dmmserver/db"
 	"dmmserver/game_error"
 	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/mail"
 	"dmmserver/services/playtime"
+	"dmmserver/services/registration"
+	"dmmserver/services/roleid"
 	"dmmserver/services/serversettings"
+	"dmmserver/services/tasks"
 	"dmmserver/utils"
 	"encoding/hex"
 	"encoding/json"
@@ -82,18 +86,42 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 2. 业务逻辑 (数据库交互和数据生成)
 	// -------------------------------------------------------------
 	// 根据 deviceID 查询 dmm_playerdata
+	players := repository.FromContext(c)
 	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	existingPlayer, err := players.GetByDeviceID(deviceID)
+	if err != nil {
 		log.Printf("未找到 deviceID 为 '%s' 的玩家。正在创建默认数据。", deviceID)
 		//		playerExists = false
 
+		// 自动建号前检查建号频率和工作量证明，防止脚本批量刷号
+		attempt := registration.Attempt{
+			DeviceID: deviceID,
+			IP:       utils.GetClientIP(c),
+		}
+		attempt.RealDeviceID, _ = msgData["realDeviceID"].(string)
+		attempt.DeviceInfo, _ = msgData["deviceInfo"].(string)
+		attempt.PowNonce, _ = msgData["powNonce"].(string)
+		if powTimestamp, ok := msgData["powTimestamp"].(float64); ok {
+			attempt.PowTimestamp = int64(powTimestamp)
+		}
+		reservation, err := registration.Reserve(attempt)
+		if err != nil {
+			return nil, err
+		}
+		created := false
+		defer func() {
+			// 建号失败时归还预留的名额
+			if !created {
+				registration.Release(reservation, "建号失败")
+			}
+		}()
+
 		// 创建默认玩家数据
 
-		// 查询当前最大的 RoleID
-		var maxRoleID int
-		if err := db.DB.Model(&model.PlayerData{}).Select("COALESCE(MAX(role_id), 0)").Row().Scan(&maxRoleID); err != nil {
-			log.Printf("查询最大 RoleID 失败: %v", err)
+		// 从 RoleID 分配器获取新的 RoleID（号段由数据库序列表预留，多实例并发也不会重复）
+		newRoleID, err := roleid.Next()
+		if err != nil {
+			log.Printf("分配 RoleID 失败: %v", err)
 			return nil, game_error.New(-2, "数据库查询错误")
 		}
 
@@ -121,7 +149,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用CharacterManager创建默认角色数据
-		cm := utils.NewCharacterManager()
+		cm := utils.NewCharacterManagerWithRepository(players)
 		defaultCharacters := cm.GetDefaultCharacters()
 		charactersJSON, err := json.Marshal(defaultCharacters)
 		if err != nil {
@@ -130,7 +158,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用CardSkinManager创建默认卡牌皮肤数据
-		csm := utils.NewCardSkinManager()
+		csm := utils.NewCardSkinManagerWithRepository(players)
 		defaultCardSkins := csm.GetDefaultCardSkins()
 		cardSkinsJSON, err := json.Marshal(defaultCardSkins)
 		if err != nil {
@@ -139,7 +167,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用CardStyleManager创建默认卡牌样式数据
-		cstm := utils.NewCardStyleManager()
+		cstm := utils.NewCardStyleManagerWithRepository(players)
 		defaultCardStyles := cstm.GetDefaultCardStyles()
 		cardStylesJSON, err := json.Marshal(defaultCardStyles)
 		if err != nil {
@@ -148,7 +176,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用PublicInfoManager创建默认公开信息
-		pm := utils.NewPublicInfoManager()
+		pm := utils.NewPublicInfoManagerWithRepository(players)
 		defaultPublicInfo := pm.GetDefaultPublicInfo()
 		publicInfoStr, err := pm.ConvertPublicInfoToKeyValue(&defaultPublicInfo)
 		if err != nil {
@@ -157,7 +185,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用EmotionManager创建默认表情数据
-		em := utils.NewEmotionManager()
+		em := utils.NewEmotionManagerWithRepository(players)
 		defaultEmotionData := em.GetDefaultEmotionData()
 		emotionDataJSON, err := json.Marshal(defaultEmotionData)
 		if err != nil {
@@ -178,7 +206,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用AssetsManager创建默认资产数据
-		am := utils.NewAssetsManager()
+		am := utils.NewAssetsManagerWithRepository(players)
 		defaultAssetsData := am.GetDefaultAssetsData()
 		assetsDataJSON, err := json.Marshal(defaultAssetsData)
 		if err != nil {
@@ -187,7 +215,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用BoxesManager创建默认装饰框数据
-		bm := utils.NewBoxesManager()
+		bm := utils.NewBoxesManagerWithRepository(players)
 		defaultBoxesData := bm.GetDefaultBoxesData()
 		boxesDataJSON, err := json.Marshal(defaultBoxesData)
 		if err != nil {
@@ -196,7 +224,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 
 		// 使用LightnessManager创建默认炫光数据
-		lm := utils.NewLightnessManager()
+		lm := utils.NewLightnessManagerWithRepository(players)
 		defaultLightnessData := lm.GetDefaultLightnessData()
 		lightnessDataJSON, err := json.Marshal(defaultLightnessData)
 		if err != nil {
@@ -207,7 +235,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		// 创建新玩家数据
 		playerData = model.PlayerData{
 			DeviceID:            deviceID,
-			RoleID:              maxRoleID + 1,
+			RoleID:              newRoleID,
 			OpenID:              "324438392",
 			AuthKey:             fmt.Sprintf("%s_%s", part1, part2),
 			AuthKeyExpire:       time.Now().Add(2 * time.Hour).Unix(),
@@ -229,6 +257,8 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			OwnedCharacters:     string(charactersJSON), // 添加角色数据
 			CardSkins:           string(cardSkinsJSON),  // 添加卡牌皮肤数据
 			CardStyles:          string(cardStylesJSON), // 添加卡牌样式数据
+			CardPieces:          "[]",                   // 新玩家没有卡牌碎片
+			ActiveCharacters:    "[100,200]",            // 默认出战角色
 			EmotionData:         string(emotionDataJSON), // 添加表情数据
 			PlaytimeData:        string(playtimeDataJSON), // 添加游玩时长数据
 			AssetsData:          string(assetsDataJSON),   // 添加资产数据
@@ -236,12 +266,14 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			LightnessData:       string(lightnessDataJSON), // 添加炫光数据
 		}
 
-		if result := db.DB.Create(&playerData); result.Error != nil {
-			log.Printf("创建新玩家数据失败: %v", result.Error)
+		if err := players.Create(&playerData); err != nil {
+			log.Printf("创建新玩家数据失败: %v", err)
 			return nil, game_error.New(-2, "数据库写入错误")
 		}
+		created = true
 		log.Printf("成功创建 deviceID 为 '%s' 的新玩家数据", deviceID)
 	} else {
+		playerData = *existingPlayer
 		log.Printf("找到 deviceID 为 '%s' 的玩家", deviceID)
 	}
 
@@ -371,7 +403,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查OwnedCharacters字段是否为空，如果为空则设置默认角色数据
 	if playerData.OwnedCharacters == "" || playerData.OwnedCharacters == "null" {
 		// 使用CharacterManager设置默认角色数据
-		cm := utils.NewCharacterManager()
+		cm := utils.NewCharacterManagerWithRepository(players)
 		defaultCharacters := cm.GetDefaultCharacters()
 		charactersJSON, err := json.Marshal(defaultCharacters)
 		if err != nil {
@@ -386,7 +418,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查CardSkins字段是否为空，如果为空则设置默认卡牌皮肤数据
 	if playerData.CardSkins == "" || playerData.CardSkins == "null" {
 		// 使用CardSkinManager设置默认卡牌皮肤数据
-		csm := utils.NewCardSkinManager()
+		csm := utils.NewCardSkinManagerWithRepository(players)
 		defaultCardSkins := csm.GetDefaultCardSkins()
 		cardSkinsJSON, err := json.Marshal(defaultCardSkins)
 		if err != nil {
@@ -402,7 +434,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查CardStyles字段是否为空，如果为空则设置默认卡牌样式数据
 	if playerData.CardStyles == "" || playerData.CardStyles == "null" {
 		// 使用CardStyleManager设置默认卡牌样式数据
-		cstm := utils.NewCardStyleManager()
+		cstm := utils.NewCardStyleManagerWithRepository(players)
 		defaultCardStyles := cstm.GetDefaultCardStyles()
 		cardStylesJSON, err := json.Marshal(defaultCardStyles)
 		if err != nil {
@@ -418,7 +450,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查PublicInfo字段是否为空，如果为空则设置默认公开信息数据
 	if playerData.PublicInfo == "" || playerData.PublicInfo == "null" {
 		// 使用PublicInfoManager创建默认公开信息
-		pm := utils.NewPublicInfoManager()
+		pm := utils.NewPublicInfoManagerWithRepository(players)
 		defaultPublicInfo := pm.GetDefaultPublicInfo()
 		publicInfoStr, err := pm.ConvertPublicInfoToKeyValue(&defaultPublicInfo)
 		if err != nil {
@@ -455,7 +487,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查PlayerRadar字段是否为空，如果为空则设置默认雷达数据
 	if playerData.PlayerRadar == "" || playerData.PlayerRadar == "null" {
 		// 使用RadarManager创建默认雷达信息
-		rm := utils.NewRadarManager()
+		rm := utils.NewRadarManagerWithRepository(players)
 		defaultRadarInfo := rm.GetDefaultRadarInfo()
 		radarInfoStr, err := rm.ConvertRadarInfoToKeyValue(&defaultRadarInfo)
 		if err != nil {
@@ -471,7 +503,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查AssetsData字段是否为空，如果为空则设置默认资产数据
 	if playerData.AssetsData == "" || playerData.AssetsData == "null" {
 		// 使用AssetsManager创建默认资产数据
-		am := utils.NewAssetsManager()
+		am := utils.NewAssetsManagerWithRepository(players)
 		defaultAssetsData := am.GetDefaultAssetsData()
 		assetsDataJSON, err := json.Marshal(defaultAssetsData)
 		if err != nil {
@@ -487,7 +519,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查BoxesData字段是否为空，如果为空则设置默认装饰框数据
 	if playerData.BoxesData == "" || playerData.BoxesData == "null" {
 		// 使用BoxesManager创建默认装饰框数据
-		bm := utils.NewBoxesManager()
+		bm := utils.NewBoxesManagerWithRepository(players)
 		defaultBoxesData := bm.GetDefaultBoxesData()
 		boxesDataJSON, err := json.Marshal(defaultBoxesData)
 		if err != nil {
@@ -503,7 +535,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查LightnessData字段是否为空，如果为空则设置默认炫光数据
 	if playerData.LightnessData == "" || playerData.LightnessData == "null" {
 		// 使用LightnessManager创建默认炫光数据
-		lm := utils.NewLightnessManager()
+		lm := utils.NewLightnessManagerWithRepository(players)
 		defaultLightnessData := lm.GetDefaultLightnessData()
 		lightnessDataJSON, err := json.Marshal(defaultLightnessData)
 		if err != nil {
@@ -519,7 +551,7 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 检查EmotionData字段是否为空，如果为空则设置默认表情数据
 	if playerData.EmotionData == "" || playerData.EmotionData == "null" {
 		// 使用EmotionManager创建默认表情数据
-		em := utils.NewEmotionManager()
+		em := utils.NewEmotionManagerWithRepository(players)
 		defaultEmotionData := em.GetDefaultEmotionData()
 		emotionDataJSON, err := json.Marshal(defaultEmotionData)
 		if err != nil {
@@ -533,16 +565,26 @@ func handle30001(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	}
 
 	// 将更新后的玩家数据保存到数据库
-	updateResult := db.DB.Save(&playerData)
-	if updateResult.Error != nil {
-		log.Printf("更新玩家数据失败: %v", updateResult.Error)
+	if err := players.Save(&playerData); err != nil {
+		log.Printf("更新玩家数据失败: %v", err)
 		return nil, game_error.New(-2, "数据库更新错误")
 	}
 
+	// 登录时投递玩家尚未收到的群发邮件，与上面保存的玩家数据在同一个事务中提交
+	// 失败时不影响登录，玩家数据仍由 dispatchHandler 提交，打开邮箱时会再次投递
+	if _, err := mail.DeliverBroadcasts(players, deviceID); err != nil {
+		log.Printf("登录时投递群发邮件失败, deviceID=%s: %v", deviceID, err)
+	}
+
+	// 每天的第一次登录推进每日和每周的登录任务，同样与玩家数据一起提交，失败时不影响登录
+	if err := tasks.RecordLogin(players, deviceID); err != nil {
+		log.Printf("记录登录任务进度失败, deviceID=%s: %v", deviceID, err)
+	}
+
 	// 3. 成功响应构建
 	// --------------------------------
 	// 使用PublicInfoManager获取玩家名字和年龄
-	pm := utils.NewPublicInfoManager()
+	pm := utils.NewPublicInfoManagerWithRepository(players)
 	publicInfo, err := pm.GetPublicInfo(deviceID)
 	if err != nil {
 		log.Printf("获取玩家公开信息失败: %v", err)
@@ -5,31 +5,35 @@ import (
 	"encoding/json"
 //	"fmt"
 	"log"
-	"time"
+	"strconv"
 
-	"dmmserver/db"
 	"dmmserver/game_error"
 	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/server/session"
 	"dmmserver/services/serversettings"
+	"dmmserver/services/wallet"
 	"dmmserver/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 func init() {
-	Register("30002", handle30002)
+	Register("30002", handle30002, WithSession())
 }
 
 // handle30002 处理获取玩家完整档案请求
 func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]interface{}, error) {
 	// 创建所需的管理器实例，避免重复创建
-	pm := utils.NewPublicInfoManager()
-	cardManager := utils.NewCardManager()
-	cardSkinManager := utils.NewCardSkinManager()
-	cardStyleManager := utils.NewCardStyleManager()
-	radarManager := utils.NewRadarManager()
-	emotionManager := utils.NewEmotionManager()
-	boxesManager := utils.NewBoxesManager()
+	// 所有管理器共享本次请求的玩家工作单元，同一玩家在一个请求中只读取一次
+	players := repository.FromContext(c)
+	pm := utils.NewPublicInfoManagerWithRepository(players)
+	cardManager := utils.NewCardManagerWithRepository(players)
+	cardSkinManager := utils.NewCardSkinManagerWithRepository(players)
+	cardStyleManager := utils.NewCardStyleManagerWithRepository(players)
+	radarManager := utils.NewRadarManagerWithRepository(players)
+	emotionManager := utils.NewEmotionManagerWithRepository(players)
+	boxesManager := utils.NewBoxesManagerWithRepository(players)
 	log.Printf("Executing handler for msg_id=30002. Received msgData: %+v", msgData)
 
 	// 1. 参数解析和验证
@@ -43,32 +47,6 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		}
 	}
 
-	deviceID, ok := msgData["deviceID"].(string)
-	if !ok || deviceID == "" {
-		log.Println("错误：msg_id=30002 缺少 'deviceID' 参数")
-		return nil, game_error.New(-5, "缺少 'deviceID' 参数")
-	}
-
-	authKey, ok := msgData["authKey"].(string)
-	if !ok || authKey == "" {
-		log.Println("错误：msg_id=30002 缺少 'authKey' 参数")
-		return nil, game_error.New(-5, "缺少 'authKey' 参数")
-	}
-
-	// 获取accountName和roleID
-	accountName, ok := msgData["accountName"].(string)
-	if !ok || accountName == "" {
-		log.Println("错误：msg_id=30002 缺少 'accountName' 参数")
-		return nil, game_error.New(-5, "缺少 'accountName' 参数")
-	}
-
-	roleIDFloat, ok := msgData["roleID"].(float64)
-	if !ok {
-		log.Println("错误：msg_id=30002 'roleID' 参数类型错误")
-		return nil, game_error.New(-13, "非法参数")
-	}
-	roleID := int(roleIDFloat)
-
 	// 获取requestRoleID参数
 	requestRoleIDFloat, ok := msgData["requestRoleID"].(float64)
 	if !ok {
@@ -77,64 +55,30 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	}
 	requestRoleID := int(requestRoleIDFloat)
 
-	// 2. 业务逻辑
+	// 2. 获取已通过会话校验的玩家数据
 	// -------------------------------------------------------------
-	// 根据 deviceID 查询 dmm_playerdata
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
-		log.Printf("未找到 deviceID 为 '%s' 的玩家", deviceID)
+	// authKey、accountName、roleID 的校验已由 server 层的会话中间件完成
+	verifiedPlayerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30002 会话中没有玩家数据")
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
+	playerData := *verifiedPlayerData
+	roleID := playerData.RoleID
 
-	// 3. 验证 authKey 是否过期且匹配
-	currentTime := time.Now().Unix()
-	if playerData.AuthKeyExpire < currentTime {
-		log.Printf("authKey 已过期，过期时间: %d, 当前时间: %d", playerData.AuthKeyExpire, currentTime)
-		return nil, game_error.New(-12, "登录秘钥失效，请重新登录")
-	}
-
-	if playerData.AuthKey != authKey {
-		log.Printf("authKey 不匹配，请求的 authKey: %s, 数据库中的 authKey: %s", authKey, playerData.AuthKey)
-		return nil, game_error.New(-11, "登录验证错误，账号或已在别处登录")
-	}
-
-	// 验证accountName和roleID是否与数据库中的匹配
-	// 使用PublicInfoManager获取玩家名字
-	publicInfoObj, err := pm.GetPublicInfo(deviceID)
-	if err != nil {
-		log.Printf("获取玩家公开信息失败: %v", err)
+	publicInfoObj, ok := session.PublicInfo(c)
+	if !ok {
+		log.Println("错误：msg_id=30002 会话中没有玩家公开信息")
 		return nil, game_error.New(-3, "获取玩家数据失败")
 	}
-	
-	// 检查publicInfoObj.Name是否为空，如果为空则更新为请求中的accountName
-	if publicInfoObj.Name == "" {
-		log.Printf("数据库中的 accountName 为空，使用请求中的 accountName: %s 更新数据库", accountName)
-		publicInfoObj.Name = accountName
-		// 保存更新后的公开信息
-		err = pm.SavePublicInfo(deviceID, publicInfoObj)
-		if err != nil {
-			log.Printf("更新玩家公开信息失败: %v", err)
-			// 即使更新失败，仍然继续处理请求
-		}
-	} else if publicInfoObj.Name != accountName {
-		log.Printf("accountName 不匹配，请求的 accountName: %s, 数据库中的 accountName: %s", accountName, publicInfoObj.Name)
-		return nil, game_error.New(-13, "非法参数")
-	}
 
-	if playerData.RoleID != roleID {
-		log.Printf("roleID 不匹配，请求的 roleID: %d, 数据库中的 roleID: %d", roleID, playerData.RoleID)
-		return nil, game_error.New(-13, "非法参数")
-	}
-	log.Printf("Current Playerdata : %s\n", playerData)
-	log.Printf("Current result : %s", result)
-	// 4. 获取服务器设置
+	// 3. 获取服务器设置
 	// 在处理请求前检查设置是否过期
 	serversettings.CheckAndRefreshIfStale()
 	// 使用缓存的服务器设置
 	// serverSettings := serversettings.GetSettings() // 移除未使用的变量
 
-	// 5. 获取被请求查看的玩家数据
+	// 4. 获取被请求查看的玩家数据
 	// 否则查看其他玩家的档案
 	var requestedPlayerData model.PlayerData
 	isSelf := requestRoleID == roleID
@@ -145,24 +89,17 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	}
 	// 注意：这里不再使用原始的数据库查询，而是统一使用PublicInfoManager获取玩家信息
 
-	// 6. 构建响应数据
+	// 5. 构建响应数据
 	// 这里我们需要从数据库中读取玩家数据，并构建响应数据
 	// 获取服务器跨天时间戳
-	var serverOverDayTimeStamp int64 = time.Now().Unix() // 默认使用当前时间
-	// 从服务器设置中获取serverOverDayTimeStamp
-	serverSettings := serversettings.GetSettings()
-	serverOverDayTimeStamp = serverSettings.ServerOverDayTimeStamp
-	if serverOverDayTimeStamp == 0 {
-		log.Printf("serverOverDayTimeStamp为0，使用当前时间")
-		serverOverDayTimeStamp = 1660924815 // 2022-08-16 00:00:00	
-	}
+	// 从服务器设置中获取serverOverDayTimeStamp，未配置时使用默认值
+	serverOverDayTimeStamp := serversettings.OverDayTimeStamp()
 
 	// 根据roleID获取玩家公开信息
-	if isSelf {
-		// 如果是查询自己的信息，直接使用playerData中的数据
-		publicInfoObj, err = pm.ParsePublicInfoFromJSON(requestedPlayerData.PublicInfo)
-	} else {
+	var err error
+	if !isSelf {
 		// 如果是查询他人信息，使用requestRoleID获取
+		// 查询自己的信息时直接使用会话中已校验的公开信息
 		publicInfoObj, err = pm.GetPublicInfoByRoleID(requestRoleID)
 	}
 
@@ -231,7 +168,7 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 构建ownedCharacters - 从数据库中读取角色数据并转换为客户端需要的格式
 	ownedCharacters := func() []map[string]interface{} {
 		// 创建角色管理器
-		cm := utils.NewCharacterManager()
+		cm := utils.NewCharacterManagerWithRepository(players)
 
 		// 获取角色数据
 		var characters []utils.Character
@@ -273,7 +210,7 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// 构建ownedSkins - 使用SkinPartManager从数据库中读取皮肤数据并转换为客户端需要的格式
 	ownedSkins := func() map[string]interface{} {
 		// 创建皮肤部件管理器
-		sm := utils.NewSkinPartManager()
+		sm := utils.NewSkinPartManagerWithRepository(players)
 
 		// 获取皮肤部件数据
 		var skinPartIDs []string
@@ -331,10 +268,10 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	var responseData map[string]interface{}
 
 	// 预先获取卡牌皮肤数据和卡牌样式数据，避免重复获取
-	var cardSkins []utils.CardSkin
+	var cardSkins utils.CardSkins
 	var cardOwnSkins []int
 	var cardSkinExpiredTimes []int
-	var cardStyles []utils.CardStyle
+	var cardStyles utils.CardStyles
 	var cardOwnStyles []int
 	var cardStyleExpiredTimes []int
 	var cards []utils.Card
@@ -345,6 +282,7 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	var cardCurSkins []interface{}
 	var cardCurStyles []interface{}
 	var interfaceCardIDs []interface{}
+	var cardPieces []utils.CardPiece
 
 	if isSelf {
 		// 直接使用playerData中的数据，而不是再次查询数据库
@@ -367,6 +305,13 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			cardIDs, cardLevels, cardCurSkins, cardCurStyles, _ = cardManager.ExtractCardArrays(cards)
 		}
 		
+		// 解析卡牌碎片数据
+		cardPieces, err = cardManager.ParseCardPiecesFromJSON(requestedPlayerData.CardPieces)
+		if err != nil {
+			log.Printf("解析卡牌碎片数据失败: %v", err)
+			return nil, err
+		}
+
 		// 将int类型的cardIDs转换为interface{}类型
 		interfaceCardIDs = make([]interface{}, len(cardIDs))
 		for i, id := range cardIDs {
@@ -384,11 +329,18 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	}
 
 	if isSelf {
+		// 从钱包中读取货币余额，玩家第一次查询时按配置的初始余额创建钱包
+		playerWallet, err := wallet.Get(requestedPlayerData.DeviceID)
+		if err != nil {
+			log.Printf("获取玩家钱包失败: %v", err)
+			return nil, err
+		}
+
 		// 返回完整的个人信息
 		responseData = map[string]interface{}{
 			"publicInfo":             publicInfoMap,
 			"serverOverDayTimeStamp": serverOverDayTimeStamp,
-			"gold":                   "170899",
+			"gold":                   strconv.FormatInt(playerWallet.Gold, 10),
 			// 使用已获取的雷达信息
 			"radarThief":             radarThief,
 			"radarPolice":            radarPolice,
@@ -400,21 +352,21 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			"ownedSkins":             ownedSkins,
 			"ownedSkinIDs":           []int{},
 			"ownedSkinExpiredTime":   []int{},
-			"coloringAgentNum":       "20",
+			"coloringAgentNum":       strconv.FormatInt(playerWallet.ColoringAgent, 10),
 			"personality":            "869",
 			"personalityRank":        0,
 			"onlineState":            1, // 实时生成的在线状态
-			"diamonds":               2147483647,
-			"tickets":                2147483647,
-			"normalFortuneCards":     0,
-			"advanceFortuneCards":    0,
-			"activeCharacterID":      []int{100, 200},
+			"diamonds":               playerWallet.Diamonds,
+			"tickets":                playerWallet.Tickets,
+			"normalFortuneCards":     playerWallet.NormalFortuneCards,
+			"advanceFortuneCards":    playerWallet.AdvanceFortuneCards,
+			"activeCharacterID":      utils.NewCharacterManagerWithRepository(players).ParseActiveCharacterIDsFromJSON(requestedPlayerData.ActiveCharacters),
 			"activeRoleType":         "1",
 			"recordVisible":          false,
 			"likeCount":              0,
 			"ownedAssets": func() []map[string]interface{} {
 				// 创建资产管理器
-				am := utils.NewAssetsManager()
+				am := utils.NewAssetsManagerWithRepository(players)
 				
 				// 获取资产数据
 				var assets []utils.Asset
@@ -473,8 +425,8 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 				
 				// 将OwnedHeadBoxes转换为map
 				return map[string]interface{}{
-					"headBoxID":   boxesData.OwnedHeadBoxes.HeadBoxID,
-					"expiredTime": boxesData.OwnedHeadBoxes.ExpiredTime,
+					"headBoxID":   boxesData.OwnedHeadBoxes.IDs(),
+					"expiredTime": boxesData.OwnedHeadBoxes.ExpiredTimes(),
 				}
 			}(),
 			"ownedBubbleBoxes": func() map[string]interface{} {
@@ -497,8 +449,8 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 				
 				// 将OwnedBubbleBoxes转换为map
 				return map[string]interface{}{
-					"bubbleBoxID": boxesData.OwnedBubbleBoxes.BubbleBoxID,
-					"expiredTime": boxesData.OwnedBubbleBoxes.ExpiredTime,
+					"bubbleBoxID": boxesData.OwnedBubbleBoxes.IDs(),
+					"expiredTime": boxesData.OwnedBubbleBoxes.ExpiredTimes(),
 				}
 			}(),
 			// 使用EmotionManager获取表情数据
@@ -520,10 +472,18 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 					emotionData = emotionManager.GetDefaultEmotionData()
 				}
 				
-				// 将OwnedIngameEmotion转换为map
+				// 将OwnedIngameEmotion转换为map，ID保持数据库中的数字或字符串写法
+				ids, err := emotionData.OwnedIngameEmotion.RawIDs()
+				if err != nil {
+					log.Printf("序列化表情ID失败: %v", err)
+					return map[string]interface{}{
+						"id":          emotionData.OwnedIngameEmotion.IDs(),
+						"expiredTime": emotionData.OwnedIngameEmotion.ExpiredTimes(),
+					}
+				}
 				return map[string]interface{}{
-					"id":          emotionData.OwnedIngameEmotion.ID,
-					"expiredTime": emotionData.OwnedIngameEmotion.ExpiredTime,
+					"id":          ids,
+					"expiredTime": emotionData.OwnedIngameEmotion.ExpiredTimes(),
 				}
 			}(),
 			"ingameEmotionConfigs": func() []map[string]interface{} {
@@ -567,7 +527,7 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			"sendGiftPointLevel": 1,
 			"lightness": func() map[string]interface{} {
 				// 创建炫光管理器
-				lm := utils.NewLightnessManager()
+				lm := utils.NewLightnessManagerWithRepository(players)
 				
 				// 获取炫光数据
 				var lightnessResult map[string]interface{}
@@ -610,45 +570,7 @@ func handle30002(c *gin.Context, msgData map[string]interface{}) (map[string]int
 			"cardLevels":            cardLevels,
 			"cardCurSkin":           cardCurSkins,
 			"cardCurStyle": cardCurStyles,
-			"cardPiece": []map[string]interface{}{
-				{"cardID": 105, "num": 611},
-				{"cardID": 200, "num": 1087},
-				{"cardID": 103, "num": 774},
-				{"cardID": 102, "num": 639},
-				{"cardID": 108, "num": 560},
-				{"cardID": 109, "num": 98},
-				{"cardID": 101, "num": 0},
-				{"cardID": 104, "num": 175},
-				{"cardID": 210, "num": 72},
-				{"cardID": 107, "num": 121},
-				{"cardID": 100, "num": 507},
-				{"cardID": 106, "num": 306},
-				{"cardID": 110, "num": 62},
-				{"cardID": 111, "num": 105},
-				{"cardID": 112, "num": 168},
-				{"cardID": 114, "num": 313},
-				{"cardID": 113, "num": 8},
-				{"cardID": 115, "num": 103},
-				{"cardID": 230, "num": 1021},
-				{"cardID": 116, "num": 7},
-				{"cardID": 117, "num": 257},
-				{"cardID": 118, "num": 239},
-				{"cardID": 119, "num": 148},
-				{"cardID": 120, "num": 70},
-				{"cardID": 240, "num": 152},
-				{"cardID": 121, "num": 0},
-				{"cardID": 250, "num": 136},
-				{"cardID": 122, "num": 76},
-				{"cardID": 123, "num": 151},
-				{"cardID": 124, "num": 270},
-				{"cardID": 125, "num": 862},
-				{"cardID": 280, "num": 351},
-				{"cardID": 126, "num": 777},
-				{"cardID": 127, "num": 973},
-				{"cardID": 290, "num": 1352},
-				{"cardID": 128, "num": 15},
-				{"cardID": 129, "num": 40},
-			},
+			"cardPiece": cardPieces,
 			// 获取卡牌皮肤数据 - 只获取一次数据
 			"cardOwnSkin": cardOwnSkins,
 			"cardSkinExpiredTime": cardSkinExpiredTimes,
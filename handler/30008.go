@@ -4,39 +4,26 @@ package handler
 import (
 	"log"
 	"github.com/gin-gonic/gin"
-	"dmmserver/game_error"
 )
 
 func init() {
-	Register("30008", handle30008)
+	RegisterTyped("30008", handle30008)
+}
+
+// versionCheckRequest 是 msg_id=30008 的请求参数
+type versionCheckRequest struct {
+	PfID       int `json:"pfID" msg:"required"`
+	SequenceID int `json:"sequenceID" msg:"required"`
 }
 
 // handle30008 现在是一个纯业务逻辑函数
 // 它甚至不再需要调用 banning 服务，因为检查已在前置中间件中完成
-func handle30008(c *gin.Context, msgData map[string]interface{}) (map[string]interface{}, error) {
+// 参数的存在性与类型校验由 RegisterTyped 完成（缺失返回 -5，类型错误返回 -13）
+func handle30008(c *gin.Context, req *versionCheckRequest) (map[string]interface{}, error) {
 	log.Printf("Executing handler for msg_id=30008. Ban checks already passed.")
+	log.Printf("Parsed pfID: %d, sequenceID: %d", req.PfID, req.SequenceID)
 
-	// 1. Parameter Parsing and Validation
-	// ---------------------------------------------
-	// Check for pfID (expected int)
-	pfIDFloat, ok := msgData["pfID"].(float64)
-	if !ok {
-		log.Printf("Error: Missing or invalid 'pfID' parameter for msg_id=30008")
-		return nil, game_error.New(-5, "Missing or invalid 'pfID' parameter.") // Using -5 for missing parameter
-	}
-	pfID := int(pfIDFloat)
-	log.Printf("Parsed pfID: %d", pfID)
-
-	// Check for sequenceID (expected int)
-	sequenceIDFloat, ok := msgData["sequenceID"].(float64)
-	if !ok {
-		log.Printf("Error: Missing or invalid 'sequenceID' parameter for msg_id=30008")
-		return nil, game_error.New(-5, "Missing or invalid 'sequenceID' parameter.") // Using -5 for missing parameter
-	}
-	sequenceID := int(sequenceIDFloat)
-	log.Printf("Parsed sequenceID: %d", sequenceID)
-
-	// 2. Business Logic
+	// Business Logic
 	// -----------------
 	// Implement your specific business logic here based on the parsed parameters.
 
@@ -44,8 +31,8 @@ func handle30008(c *gin.Context, msgData map[string]interface{}) (map[string]int
 
 	// 业务成功，返回版本信息数据和 nil 错误
 	versionInfo := map[string]interface{}{
-	//	"pfID":       pfID,
-	//	"sequenceID": sequenceID,
+	//	"pfID":       req.PfID,
+	//	"sequenceID": req.SequenceID,
 	//  "version": "1.2.3",
 	//  "update_url": "http://example.com/update",
 	}
@@ -54,4 +41,4 @@ func handle30008(c *gin.Context, msgData map[string]interface{}) (map[string]int
 	// return nil, game_error.New(-3, "没有角色信息")
 
 	return versionInfo, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,42 @@
+// internal/handler/30020.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/shop"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30020", handle30020, WithSession())
+}
+
+// shopListRequest 是 msg_id=30020 的请求参数
+type shopListRequest struct {
+	SequenceID int `json:"sequenceID"` // 只用于日志，客户端可以不传
+}
+
+// handle30020 处理获取商店商品列表请求
+// 返回当前在售的商品，以及玩家对每个商品的已购买次数和剩余库存
+func handle30020(c *gin.Context, req *shopListRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30020. sequenceID: %d", req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30020 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	listings, err := shop.List(playerData.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"shopItems": listings,
+	}, nil
+}
@@ -0,0 +1,51 @@
+// internal/handler/30021.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/shop"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30021", handle30021, WithSession())
+}
+
+// shopPurchaseRequest 是 msg_id=30021 的请求参数
+type shopPurchaseRequest struct {
+	ShopItemID int `json:"shopItemID" msg:"required"`
+	SequenceID int `json:"sequenceID" msg:"required"`
+}
+
+// handle30021 处理商店购买请求
+// 扣款、发放物品和购买记录由 shop.Purchase 在一个事务中完成，请求的工作单元中尚未提交的修改也在这个事务中提交
+func handle30021(c *gin.Context, req *shopPurchaseRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30021. shopItemID: %d, sequenceID: %d", req.ShopItemID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30021 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	orderKey := repository.RequestKey(playerData.AuthKey, req.SequenceID)
+	receipt, err := shop.Purchase(repository.FromContext(c), playerData.DeviceID, req.ShopItemID, orderKey, "30021")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"shopItemID":     receipt.ShopItemID,
+		"itemID":         receipt.ItemID,
+		"count":          receipt.Count,
+		"expiredTime":    receipt.ExpiredTime,
+		"currencyItemID": receipt.CurrencyItemID,
+		"price":          receipt.Price,
+		"balance":        receipt.Balance,
+	}, nil
+}
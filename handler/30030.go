@@ -0,0 +1,54 @@
+// internal/handler/30030.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/cardupgrade"
+	"dmmserver/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30030", handle30030, WithSession())
+}
+
+// cardUpgradeRequest 是 msg_id=30030 的请求参数
+type cardUpgradeRequest struct {
+	CardID     int `json:"cardID" msg:"required"`
+	SequenceID int `json:"sequenceID" msg:"required"`
+}
+
+// handle30030 处理卡牌升级请求
+// 按 configs/card_levels.json 扣除该卡牌的碎片和金币，碎片不足返回 -52，金币不足返回 -4，重发的请求返回 -8
+func handle30030(c *gin.Context, req *cardUpgradeRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30030. cardID: %d, sequenceID: %d", req.CardID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30030 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	orderKey := repository.RequestKey(playerData.AuthKey, req.SequenceID)
+	result, err := cardupgrade.Upgrade(repository.FromContext(c), playerData.DeviceID, req.CardID, orderKey, "30030")
+	if err != nil {
+		return nil, err
+	}
+
+	// 升级成功后推进升级卡牌的任务，失败时不影响升级结果
+	if err := tasks.Record(playerData.DeviceID, tasks.EventCardUpgraded, 1); err != nil {
+		log.Printf("记录升级卡牌任务进度失败, deviceID=%s: %v", playerData.DeviceID, err)
+	}
+
+	return map[string]interface{}{
+		"cardID": result.CardID,
+		"level":  result.Level,
+		"num":    result.Pieces,
+		"gold":   result.Gold,
+	}, nil
+}
@@ -0,0 +1,46 @@
+// internal/handler/30040.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/synthesis"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30040", handle30040, WithSession())
+}
+
+// synthesisRequest 是 msg_id=30040 的请求参数
+type synthesisRequest struct {
+	ItemID     int `json:"itemID" msg:"required"`
+	SequenceID int `json:"sequenceID" msg:"required"`
+}
+
+// handle30040 处理皮肤合成请求
+// 按 configs/recipes.json 中目标物品的配方扣除碎片和货币，没有配方或已永久拥有返回 -53，重发的请求返回 -8
+func handle30040(c *gin.Context, req *synthesisRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30040. itemID: %d, sequenceID: %d", req.ItemID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30040 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	orderKey := repository.RequestKey(playerData.AuthKey, req.SequenceID)
+	result, err := synthesis.Synthesize(repository.FromContext(c), playerData.DeviceID, req.ItemID, orderKey, "30040")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"itemID":      result.ItemID,
+		"expiredTime": result.ExpiredTime,
+	}, nil
+}
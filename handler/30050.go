@@ -0,0 +1,49 @@
+// internal/handler/30050.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30050", handle30050, WithSession())
+}
+
+// equipCharacterRequest 是 msg_id=30050 的请求参数
+type equipCharacterRequest struct {
+	Slot        int `json:"slot" msg:"required"`
+	CharacterID int `json:"characterID" msg:"required"`
+	SequenceID  int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30050 更换出战角色
+// slot 为 activeCharacterID 中的位置，角色未拥有返回 -7，已过期返回 -136
+func handle30050(c *gin.Context, req *equipCharacterRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30050. slot: %d, characterID: %d, sequenceID: %d", req.Slot, req.CharacterID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30050 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	cm := utils.NewCharacterManagerWithRepository(repository.FromContext(c))
+	if err := cm.EquipCharacter(playerData.DeviceID, req.Slot, req.CharacterID); err != nil {
+		return nil, err
+	}
+	activeCharacterIDs, err := cm.GetActiveCharacterIDs(playerData.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"activeCharacterID": activeCharacterIDs,
+	}, nil
+}
@@ -0,0 +1,56 @@
+// internal/handler/30051.go
+package handler
+
+import (
+	"encoding/json"
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30051", handle30051, WithSession())
+}
+
+// equipSkinPartsRequest 是 msg_id=30051 的请求参数
+type equipSkinPartsRequest struct {
+	CharacterID int           `json:"characterID" msg:"required"`
+	SkinPartIDs []json.Number `json:"skinPartIDs" msg:"required"` // 可以是字符串或整数
+	SequenceID  int           `json:"sequenceID"`                 // 重复换装的结果相同，只记录到日志
+}
+
+// handle30051 给角色换上一组皮肤部件
+// 每个部件都必须已拥有且未过期，未拥有返回 -7，已过期返回 -136
+func handle30051(c *gin.Context, req *equipSkinPartsRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30051. characterID: %d, skinPartIDs: %v, sequenceID: %d", req.CharacterID, req.SkinPartIDs, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30051 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	skinPartIDs := make([]string, len(req.SkinPartIDs))
+	for i, id := range req.SkinPartIDs {
+		skinPartIDs[i] = id.String()
+	}
+
+	cm := utils.NewCharacterManagerWithRepository(repository.FromContext(c))
+	if err := cm.EquipSkinParts(playerData.DeviceID, req.CharacterID, skinPartIDs); err != nil {
+		return nil, err
+	}
+	character, err := cm.GetCharacterByID(playerData.DeviceID, req.CharacterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"characterID":     character.CharacterID,
+		"currentSkinInfo": character.CurrentSkinInfo,
+	}, nil
+}
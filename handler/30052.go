@@ -0,0 +1,60 @@
+// internal/handler/30052.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30052", handle30052, WithSession())
+}
+
+// equipCardSkinRequest 是 msg_id=30052 的请求参数
+type equipCardSkinRequest struct {
+	CardID     int `json:"cardID" msg:"required"`
+	SkinID     int `json:"skinID" msg:"required"`
+	SequenceID int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30052 更换卡牌皮肤
+// skinID 为 0 或卡牌的默认皮肤时换回默认，其他皮肤未拥有返回 -7，已过期返回 -136
+func handle30052(c *gin.Context, req *equipCardSkinRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30052. cardID: %d, skinID: %d, sequenceID: %d", req.CardID, req.SkinID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30052 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	cm := utils.NewCardManagerWithRepository(repository.FromContext(c))
+	if err := cm.EquipCardSkin(playerData.DeviceID, req.CardID, req.SkinID); err != nil {
+		return nil, err
+	}
+	return equippedCard(cm, playerData.DeviceID, req.CardID)
+}
+
+// equippedCard 返回更换皮肤或样式后的卡牌，30052 和 30053 共用
+func equippedCard(cm *utils.CardManager, deviceID string, cardID int) (map[string]interface{}, error) {
+	cards, err := cm.GetCards(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range cards {
+		if card.ID == cardID {
+			return map[string]interface{}{
+				"id":       card.ID,
+				"curSkin":  card.CurSkin,
+				"curStyle": card.CurStyle,
+			}, nil
+		}
+	}
+	return nil, game_error.New(-13, "未拥有该卡牌")
+}
@@ -0,0 +1,42 @@
+// internal/handler/30053.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30053", handle30053, WithSession())
+}
+
+// equipCardStyleRequest 是 msg_id=30053 的请求参数
+type equipCardStyleRequest struct {
+	CardID     int `json:"cardID" msg:"required"`
+	StyleID    int `json:"styleID" msg:"required"`
+	SequenceID int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30053 更换卡牌样式
+// styleID 为 0 或卡牌的默认样式时换回默认，其他样式未拥有返回 -7，已过期返回 -136
+func handle30053(c *gin.Context, req *equipCardStyleRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30053. cardID: %d, styleID: %d, sequenceID: %d", req.CardID, req.StyleID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30053 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	cm := utils.NewCardManagerWithRepository(repository.FromContext(c))
+	if err := cm.EquipCardStyle(playerData.DeviceID, req.CardID, req.StyleID); err != nil {
+		return nil, err
+	}
+	return equippedCard(cm, playerData.DeviceID, req.CardID)
+}
@@ -0,0 +1,42 @@
+// internal/handler/30054.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30054", handle30054, WithSession())
+}
+
+// equipHeadBoxRequest 是 msg_id=30054 的请求参数
+type equipHeadBoxRequest struct {
+	HeadBoxID  int `json:"headBoxID" msg:"required"`
+	SequenceID int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30054 更换头像框，未拥有返回 -7，已过期返回 -136
+func handle30054(c *gin.Context, req *equipHeadBoxRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30054. headBoxID: %d, sequenceID: %d", req.HeadBoxID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30054 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	if err := utils.NewBoxesManagerWithRepository(repository.FromContext(c)).EquipHeadBox(playerData.DeviceID, req.HeadBoxID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"activeHeadBoxID": req.HeadBoxID,
+	}, nil
+}
@@ -0,0 +1,42 @@
+// internal/handler/30055.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30055", handle30055, WithSession())
+}
+
+// equipBubbleBoxRequest 是 msg_id=30055 的请求参数
+type equipBubbleBoxRequest struct {
+	BubbleBoxID int `json:"bubbleBoxID" msg:"required"`
+	SequenceID  int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30055 更换聊天气泡，未拥有返回 -7，已过期返回 -136
+func handle30055(c *gin.Context, req *equipBubbleBoxRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30055. bubbleBoxID: %d, sequenceID: %d", req.BubbleBoxID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30055 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	if err := utils.NewBoxesManagerWithRepository(repository.FromContext(c)).EquipBubbleBox(playerData.DeviceID, req.BubbleBoxID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"activeBubbleBoxID": req.BubbleBoxID,
+	}, nil
+}
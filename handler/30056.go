@@ -0,0 +1,43 @@
+// internal/handler/30056.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30056", handle30056, WithSession())
+}
+
+// equipLightnessRequest 是 msg_id=30056 的请求参数
+type equipLightnessRequest struct {
+	Config     int `json:"config" msg:"required"`
+	SequenceID int `json:"sequenceID"` // 重复换装的结果相同，只记录到日志
+}
+
+// handle30056 更换炫光配置
+// config 为 0 时不使用炫光，其他炫光未拥有返回 -7，已过期返回 -136
+func handle30056(c *gin.Context, req *equipLightnessRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30056. config: %d, sequenceID: %d", req.Config, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30056 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	if err := utils.NewLightnessManagerWithRepository(repository.FromContext(c)).UpdateLightnessConfig(playerData.DeviceID, req.Config); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"config": req.Config,
+	}, nil
+}
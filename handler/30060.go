@@ -0,0 +1,41 @@
+// internal/handler/30060.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30060", handle30060, WithSession())
+}
+
+// mailListRequest 是 msg_id=30060 的请求参数
+type mailListRequest struct {
+	SequenceID int `json:"sequenceID"` // 只用于日志，客户端可以不传
+}
+
+// handle30060 返回玩家邮箱中未过期的邮件，按发送时间从新到旧
+func handle30060(c *gin.Context, req *mailListRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30060. sequenceID: %d", req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30060 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	mails, err := mail.List(playerData.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mails": mails,
+	}, nil
+}
@@ -0,0 +1,42 @@
+// internal/handler/30061.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30061", handle30061, WithSession())
+}
+
+// mailReadRequest 是 msg_id=30061 的请求参数
+type mailReadRequest struct {
+	MailID     uint64 `json:"mailID" msg:"required"`
+	SequenceID int    `json:"sequenceID"` // 只用于日志，客户端可以不传
+}
+
+// handle30061 阅读一封邮件并标记为已读，邮件不存在返回 -76
+func handle30061(c *gin.Context, req *mailReadRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30061. mailID: %d, sequenceID: %d", req.MailID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30061 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	view, err := mail.Read(playerData.DeviceID, req.MailID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mail": view,
+	}, nil
+}
@@ -0,0 +1,45 @@
+// internal/handler/30062.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30062", handle30062, WithSession())
+}
+
+// mailClaimRequest 是 msg_id=30062 的请求参数
+type mailClaimRequest struct {
+	MailID     uint64 `json:"mailID" msg:"required"`
+	SequenceID int    `json:"sequenceID"` // 重复领取由邮件的已领取状态拦截，只记录到日志
+}
+
+// handle30062 领取一封邮件的附件
+// 邮件不存在返回 -76，没有附件或已领取返回 -77
+func handle30062(c *gin.Context, req *mailClaimRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30062. mailID: %d, sequenceID: %d", req.MailID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30062 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	items, err := mail.Claim(repository.FromContext(c), playerData.DeviceID, req.MailID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mailID":      req.MailID,
+		"attachments": items,
+	}, nil
+}
@@ -0,0 +1,42 @@
+// internal/handler/30063.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30063", handle30063, WithSession())
+}
+
+// mailDeleteRequest 是 msg_id=30063 的请求参数
+type mailDeleteRequest struct {
+	MailID     uint64 `json:"mailID" msg:"required"`
+	SequenceID int    `json:"sequenceID"` // 只用于日志，客户端可以不传
+}
+
+// handle30063 删除一封邮件
+// 邮件不存在返回 -76，未读返回 -79，附件未领取返回 -78
+func handle30063(c *gin.Context, req *mailDeleteRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30063. mailID: %d, sequenceID: %d", req.MailID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30063 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	if err := mail.Delete(playerData.DeviceID, req.MailID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mailID": req.MailID,
+	}, nil
+}
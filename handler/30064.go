@@ -0,0 +1,43 @@
+// internal/handler/30064.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30064", handle30064, WithSession())
+}
+
+// mailClaimAllRequest 是 msg_id=30064 的请求参数
+type mailClaimAllRequest struct {
+	SequenceID int `json:"sequenceID"` // 重复领取由邮件的已领取状态拦截，只记录到日志
+}
+
+// handle30064 一键领取所有邮件的附件，没有可领取的附件时返回空列表
+func handle30064(c *gin.Context, req *mailClaimAllRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30064. sequenceID: %d", req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30064 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	mailIDs, items, err := mail.ClaimAll(repository.FromContext(c), playerData.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mailIDs":     mailIDs,
+		"attachments": items,
+	}, nil
+}
@@ -2,18 +2,19 @@
 package handler
 
 import (
-	"dmmserver/utils"
 	"log"
-	"time"
 	"dmmserver/db"
 	"dmmserver/game_error"
 	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/server/session"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 func init() {
-	Register("30065", handle30065)
+	// 30065 在引入会话校验之前只检查 authKey 和 accountName，不检查 roleID
+	Register("30065", handle30065, WithSession(), WithoutRoleIDCheck())
 }
 
 // handle30065 处理设备信息更新请求
@@ -43,56 +44,24 @@ func handle30065(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		return nil, game_error.New(-5, "缺少 'deviceInfo' 参数")
 	}
 
-	authKey, ok := msgData["authKey"].(string)
-	if !ok || authKey == "" {
-		log.Println("错误：msg_id=30065 缺少 'authKey' 参数")
-		return nil, game_error.New(-5, "缺少 'authKey' 参数")
-	}
-	
-	// 获取accountName和roleID
-	accountName, ok := msgData["accountName"].(string)
-	if !ok || accountName == "" {
-		log.Println("错误：msg_id=30065 缺少 'accountName' 参数")
-		return nil, game_error.New(-5, "缺少 'accountName' 参数")
-	}
-	
-	// 2. 业务逻辑
+	// 2. 获取已通过会话校验的玩家数据
 	// -------------------------------------------------------------
-	// 根据 deviceID 查询 dmm_playerdata
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
-		log.Printf("未找到 deviceID 为 '%s' 的玩家", deviceID)
+	// authKey、accountName 的校验已由 server 层的会话中间件完成（不检查 roleID）
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30065 会话中没有玩家数据")
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
-	// 3. 验证 authKey 是否过期且匹配
-	currentTime := time.Now().Unix()
-	if playerData.AuthKeyExpire < currentTime {
-		log.Printf("authKey 已过期，过期时间: %d, 当前时间: %d", playerData.AuthKeyExpire, currentTime)
-		return nil, game_error.New(-12, "登录秘钥失效，请重新登录")
-	}
-
-	if playerData.AuthKey != authKey {
-		log.Printf("authKey 不匹配，请求的 authKey: %s, 数据库中的 authKey: %s", authKey, playerData.AuthKey)
-		return nil, game_error.New(-11, "登录验证错误，账号或已在别处登录")
-	}
-	
-	// 验证accountName和roleID是否与数据库中的匹配
-	// 使用PublicInfoManager获取玩家名字
-	pm := utils.NewPublicInfoManager()
-	publicInfo, err := pm.GetPublicInfo(deviceID)
-	if err != nil {
-		log.Printf("获取玩家公开信息失败: %v", err)
-		return nil, game_error.New(-3, "未找到玩家数据")
-	}
-	
-	if publicInfo.Name != accountName {
-		log.Printf("accountName 不匹配，请求的 accountName: %s, 数据库中的 accountName: %s", accountName, publicInfo.Name)
-		return nil, game_error.New(-13, "非法参数")
+	// 记录客户端上报的平台ID，用于按平台筛选群发邮件
+	if pfID, ok := msgData["pfID"].(float64); ok && int(pfID) != playerData.PfID {
+		if err := repository.FromContext(c).UpdateColumns(playerData.DeviceID, map[string]interface{}{"pf_id": int(pfID)}); err != nil {
+			log.Printf("更新平台ID失败: %v", err)
+			return nil, game_error.New(-2, "数据库更新错误")
+		}
 	}
 
-	// 4. 处理设备信息更新
+	// 3. 处理设备信息更新
 	// 从请求中获取realDeviceID
 	realDeviceID, ok := msgData["realDeviceID"].(string)
 	if !ok || realDeviceID == "" {
@@ -102,7 +71,7 @@ func handle30065(c *gin.Context, msgData map[string]interface{}) (map[string]int
 
 	// 在dmm_playerinfo表中查找对应的设备信息
 	var playerInfo model.PlayerInfo
-	result = db.DB.Where("device_id = ?", deviceID).First(&playerInfo)
+	result := db.DB.Where("device_id = ?", deviceID).First(&playerInfo)
 
 	// 如果在dmm_playerinfo表中找不到记录，则创建新记录
 	if result.Error != nil {
@@ -195,7 +164,7 @@ func handle30065(c *gin.Context, msgData map[string]interface{}) (map[string]int
 		log.Printf("成功更新 deviceID 为 '%s' 的玩家设备信息", deviceID)
 	}
 
-	// 5. 成功响应构建
+	// 4. 成功响应构建
 	// --------------------------------
 	responseData := map[string]interface{}{
 	//	"result": "success",
@@ -0,0 +1,45 @@
+// internal/handler/30070.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/giftcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30070", handle30070, WithSession())
+}
+
+// giftCodeRedeemRequest 是 msg_id=30070 的请求参数
+type giftCodeRedeemRequest struct {
+	Code       string `json:"code" msg:"required"`
+	SequenceID int    `json:"sequenceID"` // 重复兑换由卡号记录拒绝（-40、-42），只记录到日志
+}
+
+// handle30070 兑换礼包卡号
+// 卡号无效、不在兑换时间内或已用完返回 -39，已兑换过该卡号返回 -40，已领取过同批次的礼包返回 -42
+func handle30070(c *gin.Context, req *giftCodeRedeemRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30070. code: %s, sequenceID: %d", req.Code, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30070 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	items, err := giftcode.Redeem(repository.FromContext(c), playerData.DeviceID, req.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"code":    req.Code,
+		"rewards": items,
+	}, nil
+}
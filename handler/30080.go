@@ -0,0 +1,46 @@
+// internal/handler/30080.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30080", handle30080, WithSession())
+}
+
+// taskListRequest 是 msg_id=30080 的请求参数
+type taskListRequest struct {
+	SequenceID int `json:"sequenceID"` // 只用于日志，客户端可以不传
+}
+
+// handle30080 返回玩家当前的每日和每周任务、下次重置时间以及今天剩余的重置次数
+func handle30080(c *gin.Context, req *taskListRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30080. sequenceID: %d", req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30080 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	board, err := tasks.List(playerData.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"tasks":           board.Tasks,
+		"dailyResetTime":  board.DailyResetTime,
+		"weeklyResetTime": board.WeeklyResetTime,
+		"rerollsLeft":     board.RerollsLeft,
+		"rerollCurrency":  board.RerollCurrency,
+		"rerollPrice":     board.RerollPrice,
+	}, nil
+}
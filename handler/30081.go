@@ -0,0 +1,45 @@
+// internal/handler/30081.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30081", handle30081, WithSession())
+}
+
+// taskClaimRequest 是 msg_id=30081 的请求参数
+type taskClaimRequest struct {
+	TaskID     int `json:"taskID" msg:"required"`
+	SequenceID int `json:"sequenceID"` // 重复领取由任务的已领取状态拦截，只记录到日志
+}
+
+// handle30081 领取一个已完成任务的奖励
+// 任务不存在返回 -100，未完成返回 -101，已领取返回 -102
+func handle30081(c *gin.Context, req *taskClaimRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30081. taskID: %d, sequenceID: %d", req.TaskID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30081 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	items, err := tasks.Claim(repository.FromContext(c), playerData.DeviceID, req.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"taskID":  req.TaskID,
+		"rewards": items,
+	}, nil
+}
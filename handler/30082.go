@@ -0,0 +1,46 @@
+// internal/handler/30082.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+	"dmmserver/server/session"
+	"dmmserver/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30082", handle30082, WithSession())
+}
+
+// taskRerollRequest 是 msg_id=30082 的请求参数
+type taskRerollRequest struct {
+	TaskID     int `json:"taskID" msg:"required"`
+	SequenceID int `json:"sequenceID" msg:"required"` // 与 authKey 组成重置请求的幂等键
+}
+
+// handle30082 花费货币把一个未完成的任务更换为另一个任务
+// 任务不存在返回 -100，重置次数已用完返回 -103，任务已完成或没有可更换的任务返回 -104，货币不足返回 -4，重复的请求返回 -8
+func handle30082(c *gin.Context, req *taskRerollRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30082. taskID: %d, sequenceID: %d", req.TaskID, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30082 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	requestKey := repository.RequestKey(playerData.AuthKey, req.SequenceID)
+	task, err := tasks.Reroll(repository.FromContext(c), playerData.DeviceID, req.TaskID, requestKey, "30082")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"oldTaskID": req.TaskID,
+		"task":      task,
+	}, nil
+}
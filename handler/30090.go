@@ -0,0 +1,49 @@
+// internal/handler/30090.go
+package handler
+
+import (
+	"log"
+
+	"dmmserver/game_error"
+	"dmmserver/server/session"
+	"dmmserver/services/match"
+	"dmmserver/services/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterTyped("30090", handle30090, WithSession())
+}
+
+// matchResultRequest 是 msg_id=30090 的请求参数
+type matchResultRequest struct {
+	MatchID    string `json:"matchID" msg:"required"`
+	Won        bool   `json:"won"`
+	SequenceID int    `json:"sequenceID"` // 重复上报由对局ID拒绝（-8），只记录到日志
+}
+
+// handle30090 上报一场已结束的对局，推进完成对局的任务
+// 同一场对局重复上报返回 -8，超过每个玩家的上报上限返回 -15，这两种情况都不会推进任务进度
+func handle30090(c *gin.Context, req *matchResultRequest) (map[string]interface{}, error) {
+	log.Printf("Executing handler for msg_id=30090. matchID: %s, won: %v, sequenceID: %d", req.MatchID, req.Won, req.SequenceID)
+
+	playerData, ok := session.PlayerData(c)
+	if !ok {
+		log.Println("错误：msg_id=30090 会话中没有玩家数据")
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	if err := match.Report(playerData.DeviceID, req.MatchID, req.Won); err != nil {
+		return nil, err
+	}
+
+	// 记录成功后推进完成对局的任务，失败时不影响上报结果
+	if err := tasks.Record(playerData.DeviceID, tasks.EventMatchPlayed, 1); err != nil {
+		log.Printf("记录完成对局任务进度失败, deviceID=%s: %v", playerData.DeviceID, err)
+	}
+
+	return map[string]interface{}{
+		"matchID": req.MatchID,
+	}, nil
+}
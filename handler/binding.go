@@ -0,0 +1,138 @@
+// internal/handler/binding.go
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"dmmserver/game_error"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TypedHandlerFunc 是强类型处理器的函数签名
+// 请求参数由分发器根据结构体定义自动解析和校验，处理器只需关注业务逻辑
+type TypedHandlerFunc[T any] func(c *gin.Context, req *T) (map[string]interface{}, error)
+
+// RegisterTyped 为指定 msg_id 注册一个强类型处理器
+// T 必须是结构体类型，字段通过 json 标签指定参数名，通过 msg 标签声明校验规则：
+//   - msg:"required"          参数必须存在，缺失时返回 -5
+//   - msg:"required,nonempty" 参数必须存在且不能为空字符串，否则返回 -5
+//
+// 参数类型与字段类型不匹配时（例如 roleID 传了字符串）统一返回 -13。
+// 参数名区分大小写，只有与 json 标签完全一致的参数会被解析（例如 "sequenceid" 不会填入 sequenceID）。
+// 内部会包装为普通的 HandlerFunc 注册，因此可以与旧的处理器共存，逐个 msg_id 迁移。
+// opts 与 Register 相同，例如传入 WithSession() 要求会话校验。
+func RegisterTyped[T any](msgID string, handler TypedHandlerFunc[T], opts ...HandlerOption) {
+	if reflect.TypeOf((*T)(nil)).Elem().Kind() != reflect.Struct {
+		log.Fatalf("RegisterTyped: request type for msg_id %s must be a struct", msgID)
+	}
+	Register(msgID, func(c *gin.Context, msgData map[string]interface{}) (map[string]interface{}, error) {
+		req, err := bindMsgData[T](msgID, msgData)
+		if err != nil {
+			return nil, err
+		}
+		return handler(c, req)
+	}, opts...)
+}
+
+// fieldRule 描述请求结构体中一个参数的校验规则
+type fieldRule struct {
+	name     string // msg中的参数名
+	required bool   // 参数必须存在
+	nonempty bool   // 字符串参数不能为空
+}
+
+// fieldRuleCache 缓存每个请求类型解析出的校验规则，避免每次请求都做反射
+var fieldRuleCache sync.Map // key: reflect.Type, value: []fieldRule
+
+// bindMsgData 将 msgData 解析到类型 T 中并执行校验
+func bindMsgData[T any](msgID string, msgData map[string]interface{}) (*T, error) {
+	rules := rulesFor(reflect.TypeOf((*T)(nil)).Elem())
+
+	// 1. 校验必填参数
+	for _, rule := range rules {
+		value, exists := msgData[rule.name]
+		if rule.required && (!exists || value == nil) {
+			log.Printf("错误：msg_id=%s 缺少 '%s' 参数", msgID, rule.name)
+			return nil, game_error.New(-5)
+		}
+		if rule.nonempty {
+			if str, ok := value.(string); ok && str == "" {
+				log.Printf("错误：msg_id=%s 参数 '%s' 不能为空", msgID, rule.name)
+				return nil, game_error.New(-5)
+			}
+		}
+	}
+
+	// 2. 按字段类型解析参数，类型不匹配统一视为非法参数
+	// 只保留名字完全一致的参数，避免 encoding/json 把大小写不同的参数名也填入字段
+	declared := make(map[string]interface{}, len(rules))
+	for _, rule := range rules {
+		if value, exists := msgData[rule.name]; exists {
+			declared[rule.name] = value
+		}
+	}
+	raw, err := json.Marshal(declared)
+	if err != nil {
+		log.Printf("错误：msg_id=%s 序列化参数失败: %v", msgID, err)
+		return nil, game_error.New(-13)
+	}
+	req := new(T)
+	if err := json.Unmarshal(raw, req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			log.Printf("错误：msg_id=%s 参数 '%s' 类型错误，期望 %s，实际 %s", msgID, typeErr.Field, typeErr.Type, typeErr.Value)
+		} else {
+			log.Printf("错误：msg_id=%s 解析参数失败: %v", msgID, err)
+		}
+		return nil, game_error.New(-13)
+	}
+
+	return req, nil
+}
+
+// rulesFor 解析结构体类型上的 json/msg 标签，匿名嵌入的结构体会被展开（与 encoding/json 的行为一致）
+func rulesFor(t reflect.Type) []fieldRule {
+	if cached, ok := fieldRuleCache.Load(t); ok {
+		return cached.([]fieldRule)
+	}
+
+	var rules []fieldRule
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		if field.Anonymous && jsonTag == "" && field.Type.Kind() == reflect.Struct {
+			rules = append(rules, rulesFor(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := strings.Split(jsonTag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		rule := fieldRule{name: name}
+		for _, opt := range strings.Split(field.Tag.Get("msg"), ",") {
+			switch strings.TrimSpace(opt) {
+			case "required":
+				rule.required = true
+			case "nonempty":
+				rule.nonempty = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	fieldRuleCache.Store(t, rules)
+	return rules
+}
@@ -0,0 +1,104 @@
+// internal/handler/binding_test.go
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"dmmserver/game_error"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindingRequest 覆盖 bindMsgData 支持的各种校验规则
+type bindingRequest struct {
+	RoleID     int    `json:"roleID" msg:"required"`
+	Name       string `json:"name" msg:"required,nonempty"`
+	Comment    string `json:"comment" msg:"nonempty"`
+	SequenceID int    `json:"sequenceID"`
+}
+
+// errorCode 返回 err 中的游戏错误码，没有错误时返回 0
+func errorCode(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var gameErr *game_error.GameError
+	if !errors.As(err, &gameErr) {
+		t.Fatalf("返回的错误 %v 不是游戏错误", err)
+	}
+	return gameErr.Code
+}
+
+func TestBindMsgData(t *testing.T) {
+	cases := []struct {
+		name    string
+		msgData map[string]interface{}
+		want    int
+	}{
+		{"all fields", map[string]interface{}{"roleID": float64(1000), "name": "tester", "comment": "hi", "sequenceID": float64(7)}, 0},
+		{"optional fields omitted", map[string]interface{}{"roleID": float64(1000), "name": "tester"}, 0},
+		{"missing required", map[string]interface{}{"name": "tester"}, -5},
+		{"required is null", map[string]interface{}{"roleID": nil, "name": "tester"}, -5},
+		{"required nonempty is empty", map[string]interface{}{"roleID": float64(1000), "name": ""}, -5},
+		{"optional nonempty is empty", map[string]interface{}{"roleID": float64(1000), "name": "tester", "comment": ""}, -5},
+		{"wrong type", map[string]interface{}{"roleID": "1000", "name": "tester"}, -13},
+		{"wrong type in optional field", map[string]interface{}{"roleID": float64(1000), "name": "tester", "sequenceID": "7"}, -13},
+		{"required with different case", map[string]interface{}{"ROLEID": float64(1000), "name": "tester"}, -5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bindMsgData[bindingRequest]("test", tc.msgData)
+			if got := errorCode(t, err); got != tc.want {
+				t.Fatalf("bindMsgData 返回错误码 %d (%v)，期望 %d", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// 参数名区分大小写：大小写不同的参数既不满足必填校验，也不会被填入字段
+func TestBindMsgDataMatchesNamesExactly(t *testing.T) {
+	req, err := bindMsgData[bindingRequest]("test", map[string]interface{}{
+		"roleID":     float64(1000),
+		"name":       "tester",
+		"SEQUENCEID": float64(7),
+		"sequenceid": float64(8),
+		"Comment":    "hi",
+	})
+	if err != nil {
+		t.Fatalf("bindMsgData 返回错误: %v", err)
+	}
+	if req.SequenceID != 0 || req.Comment != "" {
+		t.Fatalf("大小写不同的参数被填入了字段: %+v", req)
+	}
+	if req.RoleID != 1000 || req.Name != "tester" {
+		t.Fatalf("解析结果为 %+v，期望 roleID=1000, name=tester", req)
+	}
+}
+
+// RegisterTyped 包装的处理器在校验失败时不会被调用
+func TestRegisterTypedSkipsHandlerOnBindError(t *testing.T) {
+	const msgID = "test-binding"
+	called := false
+	RegisterTyped(msgID, func(_ *gin.Context, req *bindingRequest) (map[string]interface{}, error) {
+		called = true
+		return map[string]interface{}{"roleID": req.RoleID}, nil
+	})
+	t.Cleanup(func() { delete(handlerRegistry, msgID) })
+	handler, ok := GetHandler(msgID)
+	if !ok {
+		t.Fatal("RegisterTyped 没有注册处理器")
+	}
+
+	if _, err := handler(nil, map[string]interface{}{"name": "tester"}); errorCode(t, err) != -5 {
+		t.Fatalf("缺少必填参数时返回 %v，期望 -5", err)
+	}
+	if called {
+		t.Fatal("校验失败时仍然调用了处理器")
+	}
+	data, err := handler(nil, map[string]interface{}{"roleID": float64(1000), "name": "tester"})
+	if err != nil || !called || data["roleID"] != 1000 {
+		t.Fatalf("处理器返回 %v, %v，期望收到解析后的 roleID", data, err)
+	}
+}
@@ -4,6 +4,8 @@ package handler
 import (
 	"log"
 
+	"dmmserver/server/session"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,23 +13,72 @@ import (
 // 它不再直接操作发送，而是返回一个包含结果数据的map和一个error对象
 type HandlerFunc func(c *gin.Context, msgData map[string]interface{}) (map[string]interface{}, error)
 
+// handlerEntry 是注册表中的一项，保存处理器及其注册选项
+type handlerEntry struct {
+	handler        HandlerFunc
+	requireSession bool            // 是否需要在进入处理器前完成会话校验
+	sessionOptions session.Options // 会话校验的选项，见 WithoutRoleIDCheck
+}
+
+// HandlerOption 用于在注册时为处理器声明额外的行为
+type HandlerOption func(*handlerEntry)
+
+// WithSession 声明该 msg_id 需要会话校验
+// server 层会在调用处理器前校验 authKey/accountName/roleID，
+// 并将校验后的玩家数据通过 session.PlayerData / session.PublicInfo 提供给处理器
+func WithSession() HandlerOption {
+	return func(e *handlerEntry) {
+		e.requireSession = true
+	}
+}
+
+// WithoutRoleIDCheck 声明会话校验不比较请求中的 roleID，与 WithSession 一起使用
+// 只用于在引入会话校验之前就不检查 roleID 的 msg_id（如 30065），保持它们原有的行为
+func WithoutRoleIDCheck() HandlerOption {
+	return func(e *handlerEntry) {
+		e.sessionOptions.SkipRoleID = true
+	}
+}
+
 // handlerRegistry 是一个全局的 map，作为所有 msg_id 处理器的“注册表”
-// key 是 msg_id (字符串), value 是对应的处理函数
-var handlerRegistry = make(map[string]HandlerFunc)
+// key 是 msg_id (字符串), value 是对应的处理函数及其选项
+var handlerRegistry = make(map[string]*handlerEntry)
 
 // Register 用于向全局注册表中注册一个处理器
 // 每个处理器文件(如30008.go)都会在init()中调用此函数来“自我注册”
-func Register(msgID string, handler HandlerFunc) {
+func Register(msgID string, handler HandlerFunc, opts ...HandlerOption) {
 	if _, exists := handlerRegistry[msgID]; exists {
 		log.Printf("Warning: Handler for msg_id %s is being overwritten.", msgID)
 	}
-	handlerRegistry[msgID] = handler
-	log.Printf("Handler registered for msg_id: %s", msgID)
+	entry := &handlerEntry{handler: handler}
+	for _, opt := range opts {
+		opt(entry)
+	}
+	handlerRegistry[msgID] = entry
+	log.Printf("Handler registered for msg_id: %s (session: %t)", msgID, entry.requireSession)
 }
 
 // GetHandler 根据 msg_id 从注册表中查找并返回处理器
 func GetHandler(msgID string) (HandlerFunc, bool) {
-	handler, found := handlerRegistry[msgID]
+	entry, found := handlerRegistry[msgID]
 	log.Printf("GetHandler: Looking up handler for msg_id '%s'. Found: %t", msgID, found) // 添加日志
-	return handler, found
-}
\ No newline at end of file
+	if !found {
+		return nil, false
+	}
+	return entry.handler, true
+}
+
+// RequiresSession 判断指定 msg_id 是否在注册时声明了 WithSession
+func RequiresSession(msgID string) bool {
+	entry, found := handlerRegistry[msgID]
+	return found && entry.requireSession
+}
+
+// SessionOptions 返回指定 msg_id 在注册时声明的会话校验选项
+func SessionOptions(msgID string) session.Options {
+	entry, found := handlerRegistry[msgID]
+	if !found {
+		return session.Options{}
+	}
+	return entry.sessionOptions
+}
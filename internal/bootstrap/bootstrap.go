@@ -2,23 +2,73 @@
 package bootstrap
 
 import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"dmmserver/conf"
 	"dmmserver/db"
 	_ "dmmserver/handler" // 【关键】匿名导入handler包以触发其下所有文件的init()函数
 	"dmmserver/server"
 	"dmmserver/services/banning"
+	"dmmserver/services/cardupgrade"
+	"dmmserver/services/expiry"
 	"dmmserver/services/playtime"
+	"dmmserver/services/roleid"
 	"dmmserver/services/serversettings"
+	"dmmserver/services/shop"
+	"dmmserver/services/synthesis"
+	"dmmserver/services/tasks"
+	"dmmserver/utils"
 )
 
+// responseFormatReloadInterval 检查配置文件是否被修改的间隔
+const responseFormatReloadInterval = 10 * time.Second
+
+// defaultShutdownTimeout 未配置 server.shutdownTimeoutSeconds 时的优雅关闭期限
+const defaultShutdownTimeout = 15 * time.Second
+
 // Run 启动服务器的完整流程
 func Run() {
-	// 1. 加载配置
+	// 1. 加载配置，并在后台监听 responseFormats 的修改以支持热加载
 	conf.Init()
+	stopWatch := make(chan struct{})
+	go conf.WatchResponseFormats(responseFormatReloadInterval, stopWatch)
 
 	// 2. 初始化数据库连接并自动建表
 	db.InitDB()
 
+	// 初始化 RoleID 分配器（确保序列记录存在）
+	roleid.Init()
+
+	// 加载物品注册表（configs/items.json），发放和回收物品时按它找到对应的管理器
+	if err := utils.InitItemRegistry(); err != nil {
+		log.Fatalf("Failed to load item registry: %v", err)
+	}
+
+	// 加载商店目录（configs/shop.json），商品的物品ID需要已在物品注册表中登记
+	if err := shop.Init(); err != nil {
+		log.Fatalf("Failed to load shop catalog: %v", err)
+	}
+
+	// 加载卡牌升级消耗表（configs/card_levels.json）
+	if err := cardupgrade.Init(); err != nil {
+		log.Fatalf("Failed to load card level table: %v", err)
+	}
+
+	// 加载合成配方（configs/recipes.json），目标物品和碎片需要已在物品注册表中登记
+	if err := synthesis.Init(); err != nil {
+		log.Fatalf("Failed to load synthesis recipes: %v", err)
+	}
+
+	// 加载每日和每周任务定义（configs/tasks.json），奖励的物品ID需要已在物品注册表中登记
+	if err := tasks.Init(); err != nil {
+		log.Fatalf("Failed to load task definitions: %v", err)
+	}
+
 	// 3. 初始化后台服务模块（加载封禁列表并启动智能刷新协程）
 	banning.Init()
 
@@ -28,7 +78,54 @@ func Run() {
 	// 初始化游戏时长控制模块（加载游戏时长数据并启动智能刷新和每日重置协程）
 	playtime.Init()
 
+	// 启动过期物品清理协程（移除过期的限时物品并卸下正在使用的过期装备）
+	expiry.Init()
+
 	// 4. 所有准备工作完成，最后启动Web服务器。
 	//    handler的注册已通过上面的匿名导入自动完成。
-	server.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go server.Run()
+
+	// 5. 等待 SIGINT/SIGTERM，然后按顺序优雅关闭
+	<-ctx.Done()
+	stop()
+	log.Println("Received shutdown signal, shutting down...")
+	close(stopWatch)
+	shutdown()
+}
+
+// shutdown 按依赖顺序关闭各模块：
+// 先停止接收请求并等待进行中的请求完成，再停止后台服务并把游玩时长写回数据库，最后关闭数据库连接池。
+// 整个过程最多占用 server.shutdownTimeoutSeconds：等待进行中的请求最多占用其中的四分之三，
+// 超时后不再等待，剩余的时间留给后续步骤；后续步骤在截止时间前没有完成时直接退出。
+func shutdown() {
+	timeout := defaultShutdownTimeout
+	if seconds := conf.Conf.Server.ShutdownTimeoutSeconds; seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	drainCtx, cancelDrain := context.WithTimeout(ctx, timeout*3/4)
+	defer cancelDrain()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Printf("Server shutdown did not complete cleanly, continuing with remaining steps: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		banning.Stop()
+		serversettings.Stop()
+		expiry.Stop()   // 等待正在进行的过期清理结束，之后才能关闭数据库
+		playtime.Stop() // 停止协程并把内存中的游玩时长写回数据库
+		db.Close()
+	}()
+	select {
+	case <-done:
+		log.Println("Server exited gracefully.")
+	case <-ctx.Done():
+		log.Printf("Shutdown did not finish within %s, exiting anyway.", timeout)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,164 @@
+// internal/bootstrap/broadcast.go
+package bootstrap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/services/mail"
+	"dmmserver/utils"
+)
+
+const broadcastUsage = `用法: dmmserver broadcast <send|list|cancel>
+  send [选项]   保存一封群发邮件，玩家下次登录或打开邮箱时收到
+    -title            标题（必填）
+    -body             正文
+    -attachments      附件，JSON格式，如 '[{"itemID":30,"count":5}]'
+    -roles            收件人 roleID，逗号分隔，不填表示不限
+    -created-before   只发给在此时间之前创建的账号
+    -vip              只发给VIP玩家
+    -pf               只发给该平台ID的玩家
+    -send-at          定时发送的时间，不填表示立即发送
+    -expire-at        邮件过期时间，不填表示永不过期
+    时间可以是 Unix 秒或 "2006-01-02 15:04:05"（UTC+8）
+  list          列出所有群发邮件
+  cancel <id>   取消一封群发邮件，已经收到的玩家不受影响`
+
+// Broadcast 执行 broadcast 子命令，只加载配置并连接数据库，不启动服务器
+func Broadcast(args []string) {
+	if len(args) == 0 {
+		fmt.Println(broadcastUsage)
+		os.Exit(2)
+	}
+
+	conf.Init()
+	db.Connect()
+	defer db.Close()
+	service := mail.NewService(db.DB)
+
+	switch args[0] {
+	case "send":
+		// 附件需要按物品注册表校验
+		if err := utils.InitItemRegistry(); err != nil {
+			log.Fatalf("Failed to load item registry: %v", err)
+		}
+		b, err := parseBroadcastFlags(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(broadcastUsage)
+			os.Exit(2)
+		}
+		broadcast, err := service.SendBroadcast(b)
+		if err != nil {
+			log.Fatalf("Failed to send broadcast: %v", err)
+		}
+		log.Printf("Broadcast %d saved.", broadcast.ID)
+	case "list":
+		broadcasts, err := service.ListBroadcasts()
+		if err != nil {
+			log.Fatalf("Failed to list broadcasts: %v", err)
+		}
+		for _, b := range broadcasts {
+			fmt.Printf("%d\t%s\tsendAt=%s\texpireAt=%s\troles=%s\tcreatedBefore=%s\tvip=%v\tpf=%d\tattachments=%s\n",
+				b.ID, b.Title, formatAdminTime(b.SendAt), formatAdminTime(b.ExpireAt), b.RoleIDs,
+				formatAdminTime(b.CreatedBefore), b.VIPOnly, b.PfID, b.Attachments)
+		}
+	case "cancel":
+		if len(args) < 2 {
+			fmt.Println(broadcastUsage)
+			os.Exit(2)
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid broadcast id %q", args[1])
+		}
+		if err := service.CancelBroadcast(id); err != nil {
+			log.Fatalf("Failed to cancel broadcast %d: %v", id, err)
+		}
+		log.Printf("Broadcast %d cancelled.", id)
+	default:
+		fmt.Println(broadcastUsage)
+		os.Exit(2)
+	}
+}
+
+// parseBroadcastFlags 解析 broadcast send 的选项
+func parseBroadcastFlags(args []string) (mail.Broadcast, error) {
+	var b mail.Broadcast
+	fs := flag.NewFlagSet("broadcast send", flag.ContinueOnError)
+	fs.StringVar(&b.Title, "title", "", "")
+	fs.StringVar(&b.Body, "body", "", "")
+	attachments := fs.String("attachments", "", "")
+	roles := fs.String("roles", "", "")
+	createdBefore := fs.String("created-before", "", "")
+	fs.BoolVar(&b.VIPOnly, "vip", false, "")
+	fs.IntVar(&b.PfID, "pf", 0, "")
+	sendAt := fs.String("send-at", "", "")
+	expireAt := fs.String("expire-at", "", "")
+	if err := fs.Parse(args); err != nil {
+		return b, err
+	}
+	if b.Title == "" {
+		return b, fmt.Errorf("-title is required")
+	}
+
+	if *attachments != "" {
+		if err := json.Unmarshal([]byte(*attachments), &b.Attachments); err != nil {
+			return b, fmt.Errorf("invalid -attachments: %w", err)
+		}
+	}
+	if *roles != "" {
+		for _, text := range strings.Split(*roles, ",") {
+			roleID, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil {
+				return b, fmt.Errorf("invalid roleID %q", text)
+			}
+			b.RoleIDs = append(b.RoleIDs, roleID)
+		}
+	}
+	var err error
+	if b.CreatedBefore, err = parseAdminTime(*createdBefore); err != nil {
+		return b, fmt.Errorf("invalid -created-before: %w", err)
+	}
+	if b.SendAt, err = parseAdminTime(*sendAt); err != nil {
+		return b, fmt.Errorf("invalid -send-at: %w", err)
+	}
+	if b.ExpireAt, err = parseAdminTime(*expireAt); err != nil {
+		return b, fmt.Errorf("invalid -expire-at: %w", err)
+	}
+	return b, nil
+}
+
+// adminTimeZone 管理命令中不带时区的时间按 UTC+8 解析
+var adminTimeZone = time.FixedZone("CST", 8*60*60)
+
+// parseAdminTime 解析 Unix 秒或 "2006-01-02 15:04:05" 格式的时间，空字符串返回 0
+func parseAdminTime(text string) (int64, error) {
+	if text == "" {
+		return 0, nil
+	}
+	if unix, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return unix, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", text, adminTimeZone)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// formatAdminTime 把 Unix 秒格式化为 UTC+8 时间，0 显示为 "-"
+func formatAdminTime(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).In(adminTimeZone).Format("2006-01-02 15:04:05")
+}
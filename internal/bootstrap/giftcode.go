@@ -0,0 +1,213 @@
+// internal/bootstrap/giftcode.go
+package bootstrap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/model"
+	"dmmserver/services/giftcode"
+	"dmmserver/utils"
+)
+
+const giftCodeUsage = `用法: dmmserver giftcode <generate|import|list|export>
+  generate [选项]        生成一批随机卡号并导出为CSV
+    -count            卡号数量（必填）
+    -length           随机部分的长度，默认 12
+    -prefix           卡号前缀
+  import [选项]          导入已有的卡号，如渠道提供的卡号或一个公共卡号
+    -file             卡号文件，每行一个卡号，或第一列为卡号的CSV
+    -codes            卡号，逗号分隔，如 -codes WELCOME2026
+  generate 和 import 共用的选项：
+    -name             批次名称（必填）
+    -rewards          奖励，JSON格式（必填），如 '[{"itemID":30,"count":5}]'
+    -max-uses         每个卡号可以被兑换的总次数，默认 1（一次性卡号），0 表示不限
+    -one-per-player   每个玩家在该批次中只能兑换一个卡号
+    -start-at         开始兑换的时间，不填表示立即开始
+    -end-at           停止兑换的时间，不填表示不限
+    -out              导出卡号的CSV文件，不填时输出到标准输出
+    时间可以是 Unix 秒或 "2006-01-02 15:04:05"（UTC+8）
+  list                   列出所有批次
+  export <批次ID> [-out 文件]  导出批次中的卡号及兑换次数`
+
+// GiftCode 执行 giftcode 子命令，只加载配置并连接数据库，不启动服务器
+func GiftCode(args []string) {
+	if len(args) == 0 {
+		fmt.Println(giftCodeUsage)
+		os.Exit(2)
+	}
+
+	conf.Init()
+	db.Connect()
+	defer db.Close()
+	service := giftcode.NewService(db.DB)
+
+	switch args[0] {
+	case "generate", "import":
+		// 奖励需要按物品注册表校验
+		if err := utils.InitItemRegistry(); err != nil {
+			log.Fatalf("Failed to load item registry: %v", err)
+		}
+		b, codes, out, err := parseGiftCodeFlags(args[0], args[1:])
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(giftCodeUsage)
+			os.Exit(2)
+		}
+		batch, err := service.CreateBatch(b, codes)
+		if err != nil {
+			log.Fatalf("Failed to create gift code batch: %v", err)
+		}
+		log.Printf("Gift code batch %d created with %d codes.", batch.ID, len(codes))
+		exportGiftCodes(service, batch, out)
+	case "list":
+		batches, err := service.ListBatches()
+		if err != nil {
+			log.Fatalf("Failed to list gift code batches: %v", err)
+		}
+		for _, b := range batches {
+			fmt.Printf("%d\t%s\tmaxUses=%d\tonePerPlayer=%v\tstartAt=%s\tendAt=%s\trewards=%s\n",
+				b.ID, b.Name, b.MaxUses, b.OnePerPlayer, formatAdminTime(b.StartAt), formatAdminTime(b.EndAt), b.Rewards)
+		}
+	case "export":
+		if len(args) < 2 {
+			fmt.Println(giftCodeUsage)
+			os.Exit(2)
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid batch id %q", args[1])
+		}
+		fs := flag.NewFlagSet("giftcode export", flag.ContinueOnError)
+		out := fs.String("out", "", "")
+		if err := fs.Parse(args[2:]); err != nil {
+			fmt.Println(giftCodeUsage)
+			os.Exit(2)
+		}
+		batch, err := service.Batch(id)
+		if err != nil {
+			log.Fatalf("Failed to export gift code batch %d: %v", id, err)
+		}
+		exportGiftCodes(service, batch, *out)
+	default:
+		fmt.Println(giftCodeUsage)
+		os.Exit(2)
+	}
+}
+
+// parseGiftCodeFlags 解析 giftcode generate 和 giftcode import 的选项，返回批次、卡号和导出文件
+func parseGiftCodeFlags(command string, args []string) (giftcode.Batch, []string, string, error) {
+	var b giftcode.Batch
+	fs := flag.NewFlagSet("giftcode "+command, flag.ContinueOnError)
+	fs.StringVar(&b.Name, "name", "", "")
+	rewards := fs.String("rewards", "", "")
+	fs.IntVar(&b.MaxUses, "max-uses", 1, "")
+	fs.BoolVar(&b.OnePerPlayer, "one-per-player", false, "")
+	startAt := fs.String("start-at", "", "")
+	endAt := fs.String("end-at", "", "")
+	out := fs.String("out", "", "")
+	count := fs.Int("count", 0, "")
+	length := fs.Int("length", 12, "")
+	prefix := fs.String("prefix", "", "")
+	file := fs.String("file", "", "")
+	codeList := fs.String("codes", "", "")
+	if err := fs.Parse(args); err != nil {
+		return b, nil, "", err
+	}
+	if b.Name == "" || *rewards == "" {
+		return b, nil, "", fmt.Errorf("-name and -rewards are required")
+	}
+	if err := json.Unmarshal([]byte(*rewards), &b.Rewards); err != nil {
+		return b, nil, "", fmt.Errorf("invalid -rewards: %w", err)
+	}
+	var err error
+	if b.StartAt, err = parseAdminTime(*startAt); err != nil {
+		return b, nil, "", fmt.Errorf("invalid -start-at: %w", err)
+	}
+	if b.EndAt, err = parseAdminTime(*endAt); err != nil {
+		return b, nil, "", fmt.Errorf("invalid -end-at: %w", err)
+	}
+
+	var codes []string
+	if command == "generate" {
+		codes, err = giftcode.GenerateCodes(*count, *length, *prefix)
+	} else {
+		codes, err = readGiftCodes(*file, *codeList)
+	}
+	return b, codes, *out, err
+}
+
+// readGiftCodes 从文件或逗号分隔的列表中读取要导入的卡号
+// 文件可以每行一个卡号，也可以是第一列为卡号的CSV；第一行为 "code" 时视为表头跳过。
+func readGiftCodes(path string, list string) ([]string, error) {
+	if (path == "") == (list == "") {
+		return nil, fmt.Errorf("exactly one of -file and -codes must be set")
+	}
+	if list != "" {
+		return strings.Split(list, ","), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var codes []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		code := strings.TrimSpace(record[0])
+		if code == "" || (len(codes) == 0 && strings.EqualFold(code, "code")) {
+			continue
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// exportGiftCodes 把批次中的卡号及兑换次数写入CSV文件，path 为空时输出到标准输出
+func exportGiftCodes(service *giftcode.Service, batch *model.GiftCodeBatch, path string) {
+	codes, err := service.Codes(batch.ID)
+	if err != nil {
+		log.Fatalf("Failed to read gift codes of batch %d: %v", batch.ID, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", path, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"code", "batch_id", "batch_name", "max_uses", "uses"})
+	for _, code := range codes {
+		writer.Write([]string{code.Code, strconv.FormatUint(batch.ID, 10), batch.Name, strconv.Itoa(batch.MaxUses), strconv.Itoa(code.Uses)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Fatalf("Failed to export gift codes: %v", err)
+	}
+	if path != "" {
+		log.Printf("Exported %d codes of batch %d to %s.", len(codes), batch.ID, path)
+	}
+}
@@ -0,0 +1,66 @@
+// internal/bootstrap/migrate.go
+package bootstrap
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+)
+
+const migrateUsage = `用法: dmmserver migrate <up|down|status>
+  up            执行所有尚未执行的迁移
+  down [steps]  回滚最近执行的 steps 个迁移（默认 1）
+  status        列出所有迁移及其执行状态`
+
+// Migrate 执行 migrate 子命令，只加载配置并连接数据库，不启动服务器
+func Migrate(args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+
+	conf.Init()
+	db.Connect()
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		if err := migrations.Up(db.DB); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Println("All migrations applied.")
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := migrations.Down(db.DB, steps); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		log.Printf("Reverted up to %d migration(s).", steps)
+	case "status":
+		list, err := migrations.List(db.DB)
+		if err != nil {
+			log.Fatalf("Failed to load migration status: %v", err)
+		}
+		for _, s := range list {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -1,7 +1,26 @@
 package main
 
-import "dmmserver/internal/bootstrap"
+import (
+	"os"
+
+	"dmmserver/internal/bootstrap"
+)
 
 func main() {
+	// 子命令：dmmserver migrate <up|down|status>
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		bootstrap.Migrate(os.Args[2:])
+		return
+	}
+	// 子命令：dmmserver broadcast <send|list|cancel>
+	if len(os.Args) > 1 && os.Args[1] == "broadcast" {
+		bootstrap.Broadcast(os.Args[2:])
+		return
+	}
+	// 子命令：dmmserver giftcode <generate|import|list|export>
+	if len(os.Args) > 1 && os.Args[1] == "giftcode" {
+		bootstrap.GiftCode(os.Args[2:])
+		return
+	}
 	bootstrap.Run()
 }
\ No newline at end of file
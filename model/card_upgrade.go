@@ -0,0 +1,22 @@
+// internal/model/card_upgrade.go
+package model
+
+import "time"
+
+// CardUpgrade 记录玩家的一次卡牌升级
+// 同一玩家的同一个 OrderKey 只能升级一次，用于拒绝客户端重发的升级请求。
+type CardUpgrade struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_card_upgrade_order,priority:1"`
+	OrderKey  string `gorm:"size:128;uniqueIndex:idx_card_upgrade_order,priority:2"` // 会话的 authKey 与请求的 sequenceID，见 repository.RequestKey
+	CardID    int
+	Level     int   // 升级后的等级
+	Pieces    int   // 消耗的碎片数量
+	Gold      int64 // 消耗的金币
+	CreatedAt time.Time
+}
+
+// TableName 指定表名
+func (CardUpgrade) TableName() string {
+	return "dmm_card_upgrades"
+}
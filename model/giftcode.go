@@ -0,0 +1,49 @@
+// internal/model/giftcode.go
+package model
+
+import "time"
+
+// GiftCodeBatch 是一批礼包卡号，同一批次的卡号发放相同的奖励
+// 奖励通过 reward 服务发放：每人限领一次的批次以 "giftcode:batch:<ID>" 为幂等键，其他批次以 "giftcode:<卡号>" 为幂等键。
+type GiftCodeBatch struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement"`
+	Name         string `gorm:"size:128"`
+	Rewards      string `gorm:"type:json"`              // 奖励列表，格式为 [{"itemID":900001,"count":1,"expiredTime":0}, ...]
+	MaxUses      int    `gorm:"not null;default:0"`     // 每个卡号可以被兑换的总次数，1 表示一次性卡号，0 表示不限
+	OnePerPlayer bool   `gorm:"not null;default:false"` // 每个玩家在该批次中只能兑换一个卡号
+	StartAt      int64  `gorm:"not null;default:0"`     // 开始兑换的时间（Unix 秒），0 表示不限
+	EndAt        int64  `gorm:"not null;default:0"`     // 停止兑换的时间（Unix 秒），0 表示不限
+	CreatedAt    time.Time
+}
+
+// TableName 指定表名
+func (GiftCodeBatch) TableName() string {
+	return "dmm_gift_code_batches"
+}
+
+// GiftCode 是一个礼包卡号，卡号统一保存为大写
+type GiftCode struct {
+	Code      string `gorm:"primaryKey;size:64"`
+	BatchID   uint64 `gorm:"index"`
+	Uses      int    `gorm:"not null;default:0"` // 已被兑换的次数
+	CreatedAt time.Time
+}
+
+// TableName 指定表名
+func (GiftCode) TableName() string {
+	return "dmm_gift_codes"
+}
+
+// GiftCodeRedemption 记录一次兑换，同一个卡号每个玩家只能兑换一次
+type GiftCodeRedemption struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Code      string `gorm:"size:64;uniqueIndex:idx_gift_code_redemption,priority:1"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_gift_code_redemption,priority:2;index:idx_gift_code_redemption_batch,priority:2"`
+	BatchID   uint64 `gorm:"index:idx_gift_code_redemption_batch,priority:1"`
+	CreatedAt time.Time
+}
+
+// TableName 指定表名
+func (GiftCodeRedemption) TableName() string {
+	return "dmm_gift_code_redemptions"
+}
@@ -0,0 +1,59 @@
+// internal/model/mail.go
+package model
+
+import "time"
+
+// Mail 是玩家邮箱中的一封邮件
+// 附件领取时通过 reward 服务以 "mail:<UUID>" 为幂等键发放，同一封邮件的附件只会发放一次。
+type Mail struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement"`
+	UUID        string    `gorm:"size:36;uniqueIndex:idx_mail_uuid"` // 发送时生成，用作附件的幂等键；邮件ID在删除后可能被重新分配，不能用作幂等键
+	DeviceID    string    `gorm:"size:191;index:idx_mail_device,priority:1"`
+	Title       string    `gorm:"size:128"`
+	Body        string    `gorm:"type:text"`
+	Attachments string    `gorm:"type:json"` // 附件列表，格式为 [{"itemID":900001,"count":1,"expiredTime":0}, ...]，没有附件时为 []
+	IsRead      bool      `gorm:"not null;default:false"`
+	IsClaimed   bool      `gorm:"not null;default:false"` // 附件是否已领取，没有附件的邮件始终为 false
+	ExpireAt    int64     `gorm:"not null;default:0"`     // 过期时间（Unix 秒），0 表示永不过期；过期的邮件不再显示，也不能领取
+	CreatedAt   time.Time `gorm:"index:idx_mail_device,priority:2"`
+}
+
+// TableName 指定表名
+func (Mail) TableName() string {
+	return "dmm_mail"
+}
+
+// BroadcastMail 是一封群发邮件，发给全部玩家、指定的 roleID 或符合筛选条件的玩家
+// 发送时不会为每个玩家写入邮件，玩家下次登录或打开邮箱时才生成自己的副本（见 BroadcastMailState）。
+type BroadcastMail struct {
+	ID            uint64 `gorm:"primaryKey;autoIncrement"`
+	Title         string `gorm:"size:128"`
+	Body          string `gorm:"type:text"`
+	Attachments   string `gorm:"type:json"`                // 附件列表，格式与 Mail.Attachments 相同
+	RoleIDs       string `gorm:"type:json"`                // 收件人的 roleID 列表，格式为 [10001,10002]，空数组表示不限
+	CreatedBefore int64  `gorm:"not null;default:0"`       // 只发给在此时间之前创建的账号（Unix 秒），0 表示不限
+	VIPOnly       bool   `gorm:"not null;default:false"`   // 只发给VIP玩家
+	PfID          int    `gorm:"not null;default:0"`       // 只发给该平台的玩家，0 表示不限
+	SendAt        int64  `gorm:"not null;default:0;index"` // 开始投递的时间（Unix 秒），用于定时发送
+	ExpireAt      int64  `gorm:"not null;default:0"`       // 过期时间（Unix 秒），0 表示永不过期；过期后不再投递，已投递的副本同时过期
+	CreatedAt     time.Time
+}
+
+// TableName 指定表名
+func (BroadcastMail) TableName() string {
+	return "dmm_broadcast_mail"
+}
+
+// BroadcastMailState 记录群发邮件在某个玩家邮箱中的副本，同一封群发邮件每个玩家只会收到一次
+// 附件的已读和领取状态保存在副本（Mail）上。
+type BroadcastMailState struct {
+	BroadcastID uint64 `gorm:"primaryKey;autoIncrement:false"`
+	DeviceID    string `gorm:"primaryKey;size:191"`
+	MailID      uint64 // 玩家邮箱中的副本ID
+	CreatedAt   time.Time
+}
+
+// TableName 指定表名
+func (BroadcastMailState) TableName() string {
+	return "dmm_broadcast_mail_states"
+}
@@ -0,0 +1,19 @@
+// internal/model/match.go
+package model
+
+import "time"
+
+// MatchResult 记录玩家上报的一场对局结果
+// 同一玩家的同一个 MatchID 只记录一次，重复上报不会再次推进任务进度。
+type MatchResult struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID  string `gorm:"size:191;uniqueIndex:idx_match_result,priority:1"`
+	MatchID   string `gorm:"size:64;uniqueIndex:idx_match_result,priority:2"` // 客户端上报的对局ID
+	Won       bool   `gorm:"not null;default:false"`                          // 玩家是否获胜
+	CreatedAt time.Time
+}
+
+// TableName 指定表名
+func (MatchResult) TableName() string {
+	return "dmm_match_results"
+}
@@ -35,6 +35,12 @@ type PlayerData struct {
 	CardSkins          string `gorm:"type:json"` // 格式为 [{"cardOwnSkin":601826,"cardSkinExpiredTime":0}, ...]
 	// 卡牌样式数据，使用JSON格式存储，包含cardOwnStyle和cardStyleExpiredTime两个属性
 	CardStyles         string `gorm:"type:json"` // 格式为 [{"cardOwnStyle":650041,"cardStyleExpiredTime":0}, ...]
+	// 卡牌碎片数据，使用JSON格式存储，每项包含cardID和num两个属性
+	CardPieces         string `gorm:"type:json"` // 格式为 [{"cardID":105,"num":611}, ...]
+	// 出战角色，使用JSON数组存储，按位置（0、1）保存客户端 activeCharacterID 中的角色ID
+	ActiveCharacters   string `gorm:"type:json"` // 格式为 [100,200]
+	// 客户端上报的平台ID，由 30065 更新，用于按平台筛选群发邮件
+	PfID               int `gorm:"not null;default:0"`
 	// 玩家雷达数据，使用键值对格式存储，包含radarThief、radarPolice、radarRemainRoundPolice和radarRemainRoundThief
 	PlayerRadar        string `gorm:"type:text"` // 格式为 radarThief=[64,64,36,4,4]\n\nradarPolice=[]\n\nradarRemainRoundPolice=1\n\nradarRemainRoundThief=0
 	// 表情数据，使用JSON格式存储，包含ownedIngameEmotion和ingameEmotionConfigs
@@ -51,6 +57,8 @@ type PlayerData struct {
 //	Others             string `gorm:"type:json"` // 格式为 {"field1":value1,"field2":value2,...}
 	AuthKey            string
 	AuthKeyExpire      int64
+	// 乐观锁版本号，每次写入加一，由 repository 维护，业务代码不要直接修改
+	Version            int64 `gorm:"not null;default:0"`
 	CreatedAt          time.Time `gorm:"autoCreateTime"`
 	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
 }
@@ -0,0 +1,23 @@
+// internal/model/registration.go
+package model
+
+import "time"
+
+// RegistrationAttempt 记录每一次自动建号尝试（包括被拒绝的尝试）
+// 建号限流按 IP / realDeviceID / 稳定deviceInfo 统计滑动窗口内 Accepted=true 的记录数；
+// Accepted=false 的记录供封禁服务排查批量注册的来源。
+type RegistrationAttempt struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement"`
+	DeviceID         string    `gorm:"size:191"`
+	IP               string    `gorm:"size:64;index"`
+	RealDeviceID     string    `gorm:"size:191;index"`
+	StableDeviceInfo string    `gorm:"size:191;index"`
+	Accepted         bool      `gorm:"index"`
+	Reason           string    `gorm:"size:255"` // 被拒绝的原因，接受时为空
+	CreatedAt        time.Time `gorm:"index"`
+}
+
+// TableName 指定表名
+func (RegistrationAttempt) TableName() string {
+	return "dmm_registration_attempts"
+}
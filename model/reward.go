@@ -0,0 +1,18 @@
+// internal/model/reward.go
+package model
+
+import "time"
+
+// RewardGrant 记录已经发放过的奖励，同一个玩家的同一个幂等键只会发放一次
+// 该记录与玩家数据的修改在同一个事务中写入，事务回滚时记录也不会留下。
+type RewardGrant struct {
+	DeviceID  string    `gorm:"primaryKey;size:191"`
+	GrantKey  string    `gorm:"primaryKey;size:191"` // 调用方提供的幂等键，如 "mail:<uuid>"、"shop:order-456"
+	Items     string    `gorm:"type:json"`           // 发放的物品列表，格式为 [{"itemID":900001,"count":1,"expiredTime":0}, ...]
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName 指定表名
+func (RewardGrant) TableName() string {
+	return "dmm_reward_grants"
+}
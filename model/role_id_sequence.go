@@ -0,0 +1,18 @@
+// internal/model/role_id_sequence.go
+package model
+
+import "time"
+
+// RoleIDSequence 是 RoleID 分配器使用的序列表
+// 每个服务器/区服使用一个独立的 Name，NextValue 为下一个尚未被任何实例预留的 RoleID。
+// 各服务器实例每次从这里原子地预留一整段 RoleID，在内存中依次分配。
+type RoleIDSequence struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	NextValue int64
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// TableName 指定表名
+func (RoleIDSequence) TableName() string {
+	return "dmm_role_id_sequence"
+}
@@ -0,0 +1,36 @@
+// internal/model/shop.go
+package model
+
+import "time"
+
+// ShopPurchase 记录玩家的一次商店购买
+// 同一玩家的同一个 OrderKey 只能购买一次，用于拒绝客户端重发的购买请求。
+type ShopPurchase struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID    string `gorm:"size:191;uniqueIndex:idx_shop_purchase_order,priority:1;index:idx_shop_purchase_item,priority:1"`
+	OrderKey    string `gorm:"size:128;uniqueIndex:idx_shop_purchase_order,priority:2"` // 会话的 authKey 与请求的 sequenceID，见 repository.RequestKey
+	ShopItemID  int    `gorm:"index:idx_shop_purchase_item,priority:2"`
+	ItemID      int    // 发放的物品ID
+	Count       int    // 发放的数量
+	ExpiredTime int    // 发放后的过期时间（Unix 秒），0 表示永久
+	Currency    string `gorm:"size:32"` // 支付的货币道具，格式为 "item:<物品ID>"
+	Price       int64  // 支付的数量
+	CreatedAt   time.Time
+}
+
+// TableName 指定表名
+func (ShopPurchase) TableName() string {
+	return "dmm_shop_purchases"
+}
+
+// ShopStock 记录限量商品已经售出的数量
+type ShopStock struct {
+	ShopItemID int `gorm:"primaryKey;autoIncrement:false"`
+	Sold       int `gorm:"not null;default:0"`
+	UpdatedAt  time.Time
+}
+
+// TableName 指定表名
+func (ShopStock) TableName() string {
+	return "dmm_shop_stock"
+}
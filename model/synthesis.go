@@ -0,0 +1,20 @@
+// internal/model/synthesis.go
+package model
+
+import "time"
+
+// Synthesis 记录玩家的一次皮肤合成
+// 同一玩家的同一个 OrderKey 只能合成一次，用于拒绝客户端重发的合成请求。
+type Synthesis struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID    string `gorm:"size:191;uniqueIndex:idx_synthesis_order,priority:1"`
+	OrderKey    string `gorm:"size:128;uniqueIndex:idx_synthesis_order,priority:2"` // 会话的 authKey 与请求的 sequenceID，见 repository.RequestKey
+	ItemID      int
+	ExpiredTime int // 合成物品的过期时间（Unix 秒），0 表示永久
+	CreatedAt   time.Time
+}
+
+// TableName 指定表名
+func (Synthesis) TableName() string {
+	return "dmm_syntheses"
+}
@@ -0,0 +1,53 @@
+// internal/model/task.go
+package model
+
+import "time"
+
+// PlayerTask 是玩家在当前周期内被分配的一个每日或每周任务及其进度
+// 跨天或跨周时，上一个周期的任务被删除并重新分配；奖励以 "task:<任务ID>:<周期开始时间>" 为幂等键发放。
+type PlayerTask struct {
+	DeviceID    string `gorm:"primaryKey;size:191"`
+	TaskID      int    `gorm:"primaryKey;autoIncrement:false"`
+	Period      string `gorm:"size:16;not null"`       // "daily" 或 "weekly"
+	PeriodStart int64  `gorm:"not null;default:0"`     // 任务所属周期的开始时间（Unix 秒）
+	Progress    int    `gorm:"not null;default:0"`     // 当前进度，达到任务目标后不再增加
+	Claimed     bool   `gorm:"not null;default:false"` // 奖励是否已领取
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName 指定表名
+func (PlayerTask) TableName() string {
+	return "dmm_player_tasks"
+}
+
+// PlayerTaskState 记录玩家任务最近一次分配时所在的周期和当天已使用的重置次数
+type PlayerTaskState struct {
+	DeviceID          string `gorm:"primaryKey;size:191"`
+	DailyPeriodStart  int64  `gorm:"not null;default:0"` // 每日任务所属周期的开始时间（Unix 秒）
+	WeeklyPeriodStart int64  `gorm:"not null;default:0"` // 每周任务所属周期的开始时间（Unix 秒）
+	Rerolls           int    `gorm:"not null;default:0"` // 当天已使用的重置次数，跨天时清零
+	LoginPeriodStart  int64  `gorm:"not null;default:0"` // 最近一次计入登录任务的每日周期开始时间（Unix 秒），每天只计入第一次登录
+	UpdatedAt         time.Time
+}
+
+// TableName 指定表名
+func (PlayerTaskState) TableName() string {
+	return "dmm_player_task_states"
+}
+
+// TaskReroll 记录玩家的一次任务重置
+// 同一玩家的同一个 RequestKey 只能重置一次，用于拒绝客户端重发的重置请求。
+type TaskReroll struct {
+	ID         uint64 `gorm:"primaryKey;autoIncrement"`
+	DeviceID   string `gorm:"size:191;uniqueIndex:idx_task_reroll_request,priority:1"`
+	RequestKey string `gorm:"size:128;uniqueIndex:idx_task_reroll_request,priority:2"` // 会话的 authKey 与请求的 sequenceID，见 repository.RequestKey
+	OldTaskID  int    // 被更换的任务
+	NewTaskID  int    // 更换后的任务
+	CreatedAt  time.Time
+}
+
+// TableName 指定表名
+func (TaskReroll) TableName() string {
+	return "dmm_task_rerolls"
+}
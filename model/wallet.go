@@ -0,0 +1,42 @@
+// internal/model/wallet.go
+package model
+
+import "time"
+
+// Wallet 是玩家的货币钱包，每个玩家一条记录
+// 余额只能通过 services/wallet 修改，每次修改都会在 dmm_wallet_ledger 中追加一条流水。
+type Wallet struct {
+	DeviceID            string `gorm:"primaryKey;size:191"`
+	Gold                int64  `gorm:"not null;default:0"` // 金币
+	Diamonds            int64  `gorm:"not null;default:0"` // 钻石
+	Tickets             int64  `gorm:"not null;default:0"` // 点券
+	ColoringAgent       int64  `gorm:"not null;default:0"` // 染色剂
+	NormalFortuneCards  int64  `gorm:"not null;default:0"` // 普通幸运卡
+	AdvanceFortuneCards int64  `gorm:"not null;default:0"` // 高级幸运卡
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// TableName 指定表名
+func (Wallet) TableName() string {
+	return "dmm_wallets"
+}
+
+// WalletLedgerEntry 是钱包的一条流水，只追加不修改
+// 同一玩家同一货币所有流水的 Amount 之和等于当前余额。
+type WalletLedgerEntry struct {
+	ID           uint64    `gorm:"primaryKey;autoIncrement"`
+	DeviceID     string    `gorm:"size:191;index:idx_wallet_ledger_device,priority:1"`
+	Currency     string    `gorm:"size:32"` // 货币类型，如 "gold"、"diamonds"
+	Amount       int64     // 变动数量，增加为正数，扣除为负数
+	BalanceAfter int64     // 变动后的余额
+	Reason       string    `gorm:"size:64"`  // 变动原因，如 "initial"、"cardUpgrade"、"synthesis"
+	MsgID        string    `gorm:"size:16"`  // 触发变动的 msg_id，后台操作为空
+	RequestKey   string    `gorm:"size:191"` // 触发变动的请求的幂等键，同一次请求的流水使用相同的值，后台操作为空
+	CreatedAt    time.Time `gorm:"index:idx_wallet_ledger_device,priority:2"`
+}
+
+// TableName 指定表名
+func (WalletLedgerEntry) TableName() string {
+	return "dmm_wallet_ledger"
+}
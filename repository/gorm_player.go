@@ -0,0 +1,120 @@
+// internal/repository/gorm_player.go
+package repository
+
+import (
+	"errors"
+
+	"dmmserver/db"
+	"dmmserver/model"
+
+	"gorm.io/gorm"
+)
+
+// GormPlayerRepository 是基于 GORM 的 PlayerRepository 实现
+type GormPlayerRepository struct {
+	db *gorm.DB // 为 nil 时在每次调用时使用全局的 db.DB
+}
+
+// NewGormPlayerRepository 创建一个使用指定连接（或事务）的仓库
+func NewGormPlayerRepository(database *gorm.DB) *GormPlayerRepository {
+	return &GormPlayerRepository{db: database}
+}
+
+func (r *GormPlayerRepository) conn() *gorm.DB {
+	if r.db != nil {
+		return r.db
+	}
+	return db.DB
+}
+
+// GetByDeviceID 按 deviceID 读取玩家
+func (r *GormPlayerRepository) GetByDeviceID(deviceID string) (*model.PlayerData, error) {
+	var player model.PlayerData
+	if err := r.conn().Where("device_id = ?", deviceID).First(&player).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlayerNotFound
+		}
+		return nil, err
+	}
+	return &player, nil
+}
+
+// GetByRoleID 按 roleID 读取玩家
+func (r *GormPlayerRepository) GetByRoleID(roleID int) (*model.PlayerData, error) {
+	var player model.PlayerData
+	if err := r.conn().Where("role_id = ?", roleID).First(&player).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlayerNotFound
+		}
+		return nil, err
+	}
+	return &player, nil
+}
+
+// Create 新建玩家记录
+func (r *GormPlayerRepository) Create(player *model.PlayerData) error {
+	return r.conn().Create(player).Error
+}
+
+// Save 在版本号未变化时保存玩家记录的全部列
+func (r *GormPlayerRepository) Save(player *model.PlayerData) error {
+	version := player.Version
+	player.Version = version + 1
+	result := r.conn().Model(player).Where("version = ?", version).
+		Select("*").Omit("device_id", "created_at").Updates(player)
+	if result.Error != nil {
+		player.Version = version
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		player.Version = version
+		return r.notUpdated(player.DeviceID)
+	}
+	return nil
+}
+
+// UpdateColumns 按 deviceID 只更新指定的列
+func (r *GormPlayerRepository) UpdateColumns(deviceID string, columns map[string]interface{}) error {
+	return r.conn().Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Updates(bumpVersion(columns)).Error
+}
+
+// UpdateColumnsIfVersion 按 deviceID 和版本号更新指定的列
+func (r *GormPlayerRepository) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	result := r.conn().Model(&model.PlayerData{}).
+		Where("device_id = ? AND version = ?", deviceID, version).
+		Updates(bumpVersion(columns))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.notUpdated(deviceID)
+	}
+	return nil
+}
+
+// UpdateColumnsByRoleID 按 roleID 只更新指定的列
+func (r *GormPlayerRepository) UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error {
+	return r.conn().Model(&model.PlayerData{}).Where("role_id = ?", roleID).Updates(bumpVersion(columns)).Error
+}
+
+// notUpdated 在带版本号的更新没有命中任何记录时区分玩家不存在和版本冲突
+func (r *GormPlayerRepository) notUpdated(deviceID string) error {
+	var count int64
+	if err := r.conn().Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrPlayerNotFound
+	}
+	return ErrVersionConflict
+}
+
+// bumpVersion 复制 columns 并加上 version = version + 1
+func bumpVersion(columns map[string]interface{}) map[string]interface{} {
+	updates := make(map[string]interface{}, len(columns)+1)
+	for column, value := range columns {
+		updates[column] = value
+	}
+	updates["version"] = gorm.Expr("version + 1")
+	return updates
+}
@@ -0,0 +1,173 @@
+// internal/repository/memory_player.go
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"dmmserver/model"
+
+	"gorm.io/gorm/schema"
+)
+
+var (
+	playerSchemaOnce sync.Once
+	playerSchema     *schema.Schema
+	playerSchemaErr  error
+)
+
+// loadPlayerSchema 解析 PlayerData 的 GORM 结构，用于把列名映射到结构体字段
+func loadPlayerSchema() (*schema.Schema, error) {
+	playerSchemaOnce.Do(func() {
+		playerSchema, playerSchemaErr = schema.Parse(&model.PlayerData{}, &sync.Map{}, schema.NamingStrategy{})
+	})
+	return playerSchema, playerSchemaErr
+}
+
+// MemoryPlayerRepository 是保存在内存中的 PlayerRepository 实现，用于单元测试
+// 读写时都会复制 PlayerData，调用方修改返回值不会影响仓库中的数据，行为与数据库一致。
+type MemoryPlayerRepository struct {
+	mu      sync.RWMutex
+	players map[string]model.PlayerData // key: deviceID
+}
+
+// NewMemoryPlayerRepository 创建一个内存仓库，可以传入初始玩家数据
+func NewMemoryPlayerRepository(players ...model.PlayerData) *MemoryPlayerRepository {
+	r := &MemoryPlayerRepository{players: make(map[string]model.PlayerData)}
+	for _, p := range players {
+		r.players[p.DeviceID] = p
+	}
+	return r
+}
+
+// GetByDeviceID 按 deviceID 读取玩家
+func (r *MemoryPlayerRepository) GetByDeviceID(deviceID string) (*model.PlayerData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	player, ok := r.players[deviceID]
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	return &player, nil
+}
+
+// GetByRoleID 按 roleID 读取玩家
+func (r *MemoryPlayerRepository) GetByRoleID(roleID int) (*model.PlayerData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, player := range r.players {
+		if player.RoleID == roleID {
+			return &player, nil
+		}
+	}
+	return nil, ErrPlayerNotFound
+}
+
+// Create 新建玩家记录，deviceID 已存在时返回错误
+func (r *MemoryPlayerRepository) Create(player *model.PlayerData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.players[player.DeviceID]; ok {
+		return fmt.Errorf("player %s already exists", player.DeviceID)
+	}
+	now := time.Now()
+	if player.CreatedAt.IsZero() {
+		player.CreatedAt = now
+	}
+	player.UpdatedAt = now
+	r.players[player.DeviceID] = *player
+	return nil
+}
+
+// Save 在版本号未变化时保存玩家记录的全部列
+func (r *MemoryPlayerRepository) Save(player *model.PlayerData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.players[player.DeviceID]
+	if !ok {
+		return ErrPlayerNotFound
+	}
+	if stored.Version != player.Version {
+		return ErrVersionConflict
+	}
+	player.Version++
+	player.CreatedAt = stored.CreatedAt
+	player.UpdatedAt = time.Now()
+	r.players[player.DeviceID] = *player
+	return nil
+}
+
+// UpdateColumns 按 deviceID 只更新指定的列
+func (r *MemoryPlayerRepository) UpdateColumns(deviceID string, columns map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	player, ok := r.players[deviceID]
+	if !ok {
+		return nil
+	}
+	if err := setColumns(&player, columns); err != nil {
+		return err
+	}
+	player.Version++
+	r.players[deviceID] = player
+	return nil
+}
+
+// UpdateColumnsIfVersion 按 deviceID 和版本号只更新指定的列
+func (r *MemoryPlayerRepository) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	player, ok := r.players[deviceID]
+	if !ok {
+		return ErrPlayerNotFound
+	}
+	if player.Version != version {
+		return ErrVersionConflict
+	}
+	if err := setColumns(&player, columns); err != nil {
+		return err
+	}
+	player.Version++
+	r.players[deviceID] = player
+	return nil
+}
+
+// UpdateColumnsByRoleID 按 roleID 只更新指定的列
+func (r *MemoryPlayerRepository) UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for deviceID, player := range r.players {
+		if player.RoleID != roleID {
+			continue
+		}
+		if err := setColumns(&player, columns); err != nil {
+			return err
+		}
+		player.Version++
+		r.players[deviceID] = player
+	}
+	return nil
+}
+
+// setColumns 按数据库列名设置 PlayerData 的字段，并像 GORM 一样刷新 UpdatedAt
+func setColumns(player *model.PlayerData, columns map[string]interface{}) error {
+	s, err := loadPlayerSchema()
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(player).Elem()
+	for column, v := range columns {
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if err := field.Set(context.Background(), value, v); err != nil {
+			return fmt.Errorf("set column %q: %w", column, err)
+		}
+	}
+	player.UpdatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,65 @@
+// internal/repository/player.go
+package repository
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"dmmserver/model"
+)
+
+// ErrPlayerNotFound 表示按 deviceID 或 roleID 没有找到玩家
+var ErrPlayerNotFound = errors.New("player not found")
+
+// ErrVersionConflict 表示写入时玩家数据已被其他请求修改（版本号不一致）
+var ErrVersionConflict = errors.New("player version conflict")
+
+// PlayerRepository 封装对 dmm_playerdata 的读写
+// 管理器和处理器通过它访问玩家数据，而不是直接使用全局的 db.DB，
+// 这样业务逻辑可以换成 MemoryPlayerRepository 在没有数据库的情况下测试。
+type PlayerRepository interface {
+	// GetByDeviceID 按 deviceID 读取玩家，不存在时返回 ErrPlayerNotFound
+	GetByDeviceID(deviceID string) (*model.PlayerData, error)
+	// GetByRoleID 按 roleID 读取玩家，不存在时返回 ErrPlayerNotFound
+	GetByRoleID(roleID int) (*model.PlayerData, error)
+	// Create 新建玩家记录
+	Create(player *model.PlayerData) error
+	// Save 保存玩家记录的全部列
+	// 只有数据库中的 Version 仍等于 player.Version 时才会写入，否则返回 ErrVersionConflict；
+	// 写入成功后 player.Version 加一。
+	Save(player *model.PlayerData) error
+	// UpdateColumns 只更新指定的列，key 为数据库列名（如 "assets_data"）
+	// 与原先的 db.DB.Model().Where().Update() 一致，没有匹配的记录时不返回错误。
+	// 该方法不检查版本号，但同样会把 Version 加一，读-改-写应使用 UpdateColumnsIfVersion。
+	UpdateColumns(deviceID string, columns map[string]interface{}) error
+	// UpdateColumnsIfVersion 只有玩家当前的 Version 等于 version 时才更新指定的列，并把 Version 加一
+	// 版本不一致时返回 ErrVersionConflict，玩家不存在时返回 ErrPlayerNotFound。
+	UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error
+	// UpdateColumnsByRoleID 与 UpdateColumns 相同，按 roleID 定位玩家
+	UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error
+}
+
+var defaultRepository atomic.Pointer[repositoryHolder]
+
+// repositoryHolder 包装接口值，便于使用 atomic.Pointer 存取
+type repositoryHolder struct {
+	repo PlayerRepository
+}
+
+// Default 返回全局默认的玩家仓库，未设置时使用基于 db.DB 的 GORM 实现
+func Default() PlayerRepository {
+	if holder := defaultRepository.Load(); holder != nil {
+		return holder.repo
+	}
+	return &GormPlayerRepository{}
+}
+
+// SetDefault 替换全局默认的玩家仓库，NewXxxManager() 和各处理器都会使用它
+// 测试中可以传入 NewMemoryPlayerRepository()，传入 nil 时恢复为 GORM 实现。
+func SetDefault(repo PlayerRepository) {
+	if repo == nil {
+		defaultRepository.Store(nil)
+		return
+	}
+	defaultRepository.Store(&repositoryHolder{repo: repo})
+}
@@ -0,0 +1,11 @@
+// internal/repository/request_key.go
+package repository
+
+import "strconv"
+
+// RequestKey 由会话的 authKey 和请求的 sequenceID 组成一次客户端请求的幂等键
+// 购买、卡牌升级、合成和任务重置用它拒绝客户端重发的同一个请求，钱包流水用它关联同一次请求的变动。
+// sequenceID 在重新登录后可能从头计数，加上每次登录都会变化的 authKey 才能唯一标识一次请求。
+func RequestKey(authKey string, sequenceID int) string {
+	return authKey + ":" + strconv.Itoa(sequenceID)
+}
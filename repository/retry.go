@@ -0,0 +1,145 @@
+// internal/repository/retry.go
+package repository
+
+import (
+	"errors"
+	"log"
+
+	"dmmserver/model"
+)
+
+// MaxConflictRetries 读-改-写遇到版本冲突时最多重试的次数
+const MaxConflictRetries = 3
+
+// RetryOnConflict 以乐观锁执行一次读-改-写，版本冲突时重新执行
+// fn 通过 tx 读取玩家时会记下版本号，之后通过 tx 写回该玩家都会带上版本比较；
+// 任何一次写回发生冲突，都会用新的 tx 重新执行 fn（重新读取最新数据），最多重试 MaxConflictRetries 次。
+// 管理器会把仓库错误转换为 GameError，所以是否冲突由 tx 记录，而不是从 fn 的返回值判断。
+// repo 是请求级工作单元时，fn 直接在工作单元上执行并在结束时提交，详见 UnitOfWork。
+// 在另一次 RetryOnConflict 的 tx 上调用时（管理器方法互相调用），fn 加入外层的读-改-写：
+// 写回仍然比较外层第一次读取的版本号，冲突时由外层从头重新执行。
+func RetryOnConflict(repo PlayerRepository, fn func(tx PlayerRepository) error) error {
+	if uow, ok := repo.(*UnitOfWork); ok && uow.request {
+		return uow.retryOnConflict(fn)
+	}
+	if tx, ok := repo.(*versionedRepository); ok {
+		return fn(tx)
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		tx := newVersionedRepository(repo)
+		err = fn(tx)
+		if !tx.conflicted {
+			return err
+		}
+		if attempt == MaxConflictRetries {
+			break
+		}
+		log.Printf("玩家数据版本冲突，重新读取后重试 (%d/%d)", attempt+1, MaxConflictRetries)
+	}
+	log.Printf("玩家数据版本冲突，已达到最大重试次数: %v", err)
+	if err == nil {
+		err = ErrVersionConflict
+	}
+	return err
+}
+
+// versionedRepository 记录一次读-改-写中读取到的版本号，并把写入转换为带版本比较的写入
+// 只在单个 goroutine 中使用，不需要加锁。
+type versionedRepository struct {
+	base       PlayerRepository
+	versions   map[string]int64 // deviceID -> 第一次读取时的版本号
+	byRoleID   map[int]string   // roleID -> deviceID
+	conflicted bool
+}
+
+func newVersionedRepository(base PlayerRepository) *versionedRepository {
+	return &versionedRepository{
+		base:     base,
+		versions: make(map[string]int64),
+		byRoleID: make(map[int]string),
+	}
+}
+
+// remember 记录玩家的版本号，同一玩家以第一次读取的版本为准
+func (r *versionedRepository) remember(player *model.PlayerData) {
+	if _, ok := r.versions[player.DeviceID]; !ok {
+		r.versions[player.DeviceID] = player.Version
+	}
+	r.byRoleID[player.RoleID] = player.DeviceID
+}
+
+// GetByDeviceID 读取玩家并记录版本号
+func (r *versionedRepository) GetByDeviceID(deviceID string) (*model.PlayerData, error) {
+	player, err := r.base.GetByDeviceID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.remember(player)
+	return player, nil
+}
+
+// GetByRoleID 读取玩家并记录版本号
+func (r *versionedRepository) GetByRoleID(roleID int) (*model.PlayerData, error) {
+	player, err := r.base.GetByRoleID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	r.remember(player)
+	return player, nil
+}
+
+// Create 新建玩家记录
+func (r *versionedRepository) Create(player *model.PlayerData) error {
+	if err := r.base.Create(player); err != nil {
+		return err
+	}
+	r.remember(player)
+	return nil
+}
+
+// Save 保存玩家记录的全部列，底层仓库会比较 player.Version
+func (r *versionedRepository) Save(player *model.PlayerData) error {
+	err := r.base.Save(player)
+	if errors.Is(err, ErrVersionConflict) {
+		r.conflicted = true
+	}
+	if err == nil {
+		r.versions[player.DeviceID] = player.Version
+	}
+	return err
+}
+
+// UpdateColumns 对读取过的玩家使用读取时的版本号更新，其他玩家直接交给底层仓库
+func (r *versionedRepository) UpdateColumns(deviceID string, columns map[string]interface{}) error {
+	version, ok := r.versions[deviceID]
+	if !ok {
+		return r.base.UpdateColumns(deviceID, columns)
+	}
+	err := r.UpdateColumnsIfVersion(deviceID, version, columns)
+	if errors.Is(err, ErrPlayerNotFound) {
+		// 与 UpdateColumns 的约定一致，没有匹配的记录时不返回错误
+		return nil
+	}
+	return err
+}
+
+// UpdateColumnsIfVersion 带版本比较更新指定的列，成功后记录新的版本号
+func (r *versionedRepository) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	err := r.base.UpdateColumnsIfVersion(deviceID, version, columns)
+	if errors.Is(err, ErrVersionConflict) {
+		r.conflicted = true
+	}
+	if err == nil {
+		r.versions[deviceID] = version + 1
+	}
+	return err
+}
+
+// UpdateColumnsByRoleID 对读取过的玩家使用读取时的版本号更新，其他玩家直接交给底层仓库
+func (r *versionedRepository) UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error {
+	if deviceID, ok := r.byRoleID[roleID]; ok {
+		return r.UpdateColumns(deviceID, columns)
+	}
+	return r.base.UpdateColumnsByRoleID(roleID, columns)
+}
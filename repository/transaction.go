@@ -0,0 +1,69 @@
+// internal/repository/transaction.go
+package repository
+
+import (
+	"errors"
+	"log"
+
+	"dmmserver/game_error"
+
+	"gorm.io/gorm"
+)
+
+// RetryTx 在 database 的一个事务中执行 fn，fn 通过工作单元 players 修改的玩家数据在事务结束前一次性提交
+// 玩家数据在事务期间被其他请求修改时（提交时版本冲突），会重新执行整个事务，最多重试 MaxConflictRetries 次。
+// 每次执行都使用新的事务和新的工作单元，fn 只能通过 tx 和 players 读写数据，重新执行时会重新读取最新数据。
+func RetryTx(database *gorm.DB, fn func(tx *gorm.DB, players *UnitOfWork) error) error {
+	return RetryRequestTx(database, nil, fn)
+}
+
+// RetryRequestTx 与 RetryTx 相同，并把请求级工作单元 request 中尚未提交的修改（如会话校验补全的名字）放在同一个事务中提交
+// 事务中的工作单元先在最新数据上重新应用这些修改再执行 fn，事务提交后 request 改用提交后的玩家数据，
+// 因此处理器在服务之后读到的是最新数据，dispatchHandler 结束时也没有剩下需要提交的修改。
+// request 不是请求级工作单元（如 nil 或 Default()）时与 RetryTx 相同。
+func RetryRequestTx(database *gorm.DB, request PlayerRepository, fn func(tx *gorm.DB, players *UnitOfWork) error) error {
+	uow, ok := request.(*UnitOfWork)
+	if !ok || !uow.request {
+		uow = nil
+	}
+	for attempt := 0; ; attempt++ {
+		var players *UnitOfWork
+		err := database.Transaction(func(tx *gorm.DB) error {
+			players = NewUnitOfWork(NewGormPlayerRepository(tx))
+			if uow != nil {
+				if err := uow.replayPending(players); err != nil {
+					return err
+				}
+			}
+			if err := fn(tx, players); err != nil {
+				return err
+			}
+			return players.Commit()
+		})
+		if err == nil && uow != nil {
+			uow.adopt(players)
+		}
+		if !errors.Is(err, ErrVersionConflict) || attempt == MaxConflictRetries {
+			return err
+		}
+		log.Printf("事务中玩家数据版本冲突，重新执行事务 (%d/%d)", attempt+1, MaxConflictRetries)
+	}
+}
+
+// ToGameError 记录服务失败的原因，并把错误转换为返回给客户端的 GameError
+// 业务错误原样返回，版本冲突返回 -290（数据不同步），其他错误返回 -2；err 为 nil 时返回 nil。
+// format 和 args 描述失败的操作，日志中会在其后追加错误信息。
+func ToGameError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	log.Printf(format+": %v", append(args, err)...)
+	var gameErr *game_error.GameError
+	if errors.As(err, &gameErr) {
+		return gameErr
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		return game_error.New(-290, "数据不同步")
+	}
+	return game_error.New(-2, "数据库写入错误")
+}
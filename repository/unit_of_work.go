@@ -0,0 +1,431 @@
+// internal/repository/unit_of_work.go
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"dmmserver/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unitOfWorkKey 请求级玩家工作单元在 gin.Context 中的键名
+const unitOfWorkKey = "player_unit_of_work"
+
+// QueryStats 统计一个工作单元实际发往底层仓库的查询次数
+type QueryStats struct {
+	Loads  int // 读取玩家的次数（每个玩家每个请求最多一次）
+	Writes int // 写入的次数（新建 + 提交时的 UPDATE）
+}
+
+// trackedPlayer 是工作单元中缓存的一个玩家
+type trackedPlayer struct {
+	original model.PlayerData // 从底层仓库读取（或最近一次提交）时的数据
+	current  model.PlayerData // 请求中修改后的数据
+}
+
+// UnitOfWork 是请求级的玩家工作单元，本身也实现了 PlayerRepository
+// 同一个请求中每个玩家只从底层仓库读取一次，管理器的读写都在内存中进行，
+// Commit 时把每个玩家发生变化的列合并为一条 UPDATE 写回。
+//
+// Attach 创建的请求级工作单元没有数据库事务保护，管理器通过 RetryOnConflict 执行的读-改-写
+// 会在每次操作结束时立即提交，冲突时重新读取最新数据后重试该操作，并发的两次发放都会生效。
+// NewUnitOfWork 创建的工作单元（服务在事务中使用）只在 Commit 时写回，冲突由服务重新执行整个事务。
+// 处理器调用的服务通过 RetryRequestTx 把请求级工作单元尚未提交的修改一起放进服务的事务。
+type UnitOfWork struct {
+	mu       sync.Mutex
+	base     PlayerRepository
+	players  map[string]*trackedPlayer // key: deviceID
+	byRoleID map[int]string            // roleID -> deviceID
+	stats    QueryStats
+	request  bool // 是否为 Attach 创建的请求级工作单元
+	depth    int  // 正在执行的读-改-写操作的嵌套层数
+}
+
+// NewUnitOfWork 创建一个基于 base 的工作单元
+func NewUnitOfWork(base PlayerRepository) *UnitOfWork {
+	return &UnitOfWork{
+		base:     base,
+		players:  make(map[string]*trackedPlayer),
+		byRoleID: make(map[int]string),
+	}
+}
+
+// Attach 为本次请求创建工作单元并保存到 gin.Context 中
+func Attach(c *gin.Context, base PlayerRepository) *UnitOfWork {
+	uow := NewUnitOfWork(base)
+	uow.request = true
+	c.Set(unitOfWorkKey, uow)
+	return uow
+}
+
+// UnitOfWorkFromContext 获取本次请求的工作单元
+func UnitOfWorkFromContext(c *gin.Context) (*UnitOfWork, bool) {
+	value, exists := c.Get(unitOfWorkKey)
+	if !exists {
+		return nil, false
+	}
+	uow, ok := value.(*UnitOfWork)
+	return uow, ok
+}
+
+// FromContext 返回本次请求应使用的玩家仓库：有工作单元时返回工作单元，否则返回 Default()
+func FromContext(c *gin.Context) PlayerRepository {
+	if uow, ok := UnitOfWorkFromContext(c); ok {
+		return uow
+	}
+	return Default()
+}
+
+// track 缓存一个从底层仓库读取的玩家，调用方需持有锁
+func (u *UnitOfWork) track(player *model.PlayerData) *trackedPlayer {
+	t := &trackedPlayer{original: *player, current: *player}
+	u.players[player.DeviceID] = t
+	u.byRoleID[player.RoleID] = player.DeviceID
+	return t
+}
+
+// load 返回缓存中的玩家，不存在时从底层仓库读取，调用方需持有锁
+func (u *UnitOfWork) load(deviceID string) (*trackedPlayer, error) {
+	if t, ok := u.players[deviceID]; ok {
+		return t, nil
+	}
+	u.stats.Loads++
+	player, err := u.base.GetByDeviceID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return u.track(player), nil
+}
+
+// loadByRoleID 与 load 相同，按 roleID 定位玩家，调用方需持有锁
+func (u *UnitOfWork) loadByRoleID(roleID int) (*trackedPlayer, error) {
+	if deviceID, ok := u.byRoleID[roleID]; ok {
+		return u.players[deviceID], nil
+	}
+	u.stats.Loads++
+	player, err := u.base.GetByRoleID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := u.players[player.DeviceID]; ok {
+		// 已经按 deviceID 缓存过，以缓存中的数据为准
+		u.byRoleID[roleID] = player.DeviceID
+		return t, nil
+	}
+	return u.track(player), nil
+}
+
+// GetByDeviceID 返回玩家数据的副本
+func (u *UnitOfWork) GetByDeviceID(deviceID string) (*model.PlayerData, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, err := u.load(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	player := t.current
+	return &player, nil
+}
+
+// GetByRoleID 返回玩家数据的副本
+func (u *UnitOfWork) GetByRoleID(roleID int) (*model.PlayerData, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, err := u.loadByRoleID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	player := t.current
+	return &player, nil
+}
+
+// Create 新建玩家会立即写入底层仓库，之后的修改在 Commit 时写回
+func (u *UnitOfWork) Create(player *model.PlayerData) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.Writes++
+	if err := u.base.Create(player); err != nil {
+		return err
+	}
+	u.track(player)
+	return nil
+}
+
+// Save 用 player 替换缓存中的数据，Commit 时只写回与读取时不同的列
+// 与底层仓库一致，player.Version 必须等于缓存中的版本号。
+func (u *UnitOfWork) Save(player *model.PlayerData) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, ok := u.players[player.DeviceID]
+	if !ok {
+		// 没有读取过的玩家直接交给底层仓库
+		u.stats.Writes++
+		return u.base.Save(player)
+	}
+	if player.Version != t.current.Version {
+		return ErrVersionConflict
+	}
+	player.Version++
+	t.current = *player
+	u.byRoleID[player.RoleID] = player.DeviceID
+	return nil
+}
+
+// UpdateColumns 在内存中修改指定的列
+func (u *UnitOfWork) UpdateColumns(deviceID string, columns map[string]interface{}) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, err := u.load(deviceID)
+	if errors.Is(err, ErrPlayerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return t.set(columns)
+}
+
+// UpdateColumnsIfVersion 在内存中检查版本号并修改指定的列
+// 缓存中的版本号只在本请求内递增，与数据库的冲突在 Commit 时检查。
+func (u *UnitOfWork) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, err := u.load(deviceID)
+	if err != nil {
+		return err
+	}
+	if t.current.Version != version {
+		return ErrVersionConflict
+	}
+	return t.set(columns)
+}
+
+// UpdateColumnsByRoleID 在内存中修改指定的列
+func (u *UnitOfWork) UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, err := u.loadByRoleID(roleID)
+	if errors.Is(err, ErrPlayerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return t.set(columns)
+}
+
+// set 修改缓存中的列并递增版本号
+func (t *trackedPlayer) set(columns map[string]interface{}) error {
+	if err := setColumns(&t.current, columns); err != nil {
+		return err
+	}
+	t.current.Version++
+	return nil
+}
+
+// retryOnConflict 在请求级工作单元中执行一次读-改-写并立即提交
+// 提交时版本冲突则重新读取最新数据，保留本次操作之前尚未提交的修改，再重新执行 fn，最多重试 MaxConflictRetries 次。
+// 嵌套的操作（管理器的操作中调用另一个管理器）只执行 fn，由最外层的操作统一提交。
+func (u *UnitOfWork) retryOnConflict(fn func(tx PlayerRepository) error) error {
+	u.mu.Lock()
+	u.depth++
+	nested := u.depth > 1
+	var pending map[string]map[string]interface{}
+	var err error
+	if !nested {
+		pending, err = u.pendingColumns()
+	}
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.depth--
+		u.mu.Unlock()
+	}()
+	if nested {
+		return fn(u)
+	}
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := fn(u); err != nil {
+			return err
+		}
+		u.mu.Lock()
+		err = u.commit()
+		if errors.Is(err, ErrVersionConflict) && attempt < MaxConflictRetries {
+			log.Printf("玩家数据版本冲突，重新读取后重试 (%d/%d)", attempt+1, MaxConflictRetries)
+			err = u.rebase(pending)
+			u.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		u.mu.Unlock()
+		if errors.Is(err, ErrVersionConflict) {
+			log.Printf("玩家数据版本冲突，已达到最大重试次数: %v", err)
+		}
+		return err
+	}
+}
+
+// Commit 把每个玩家发生变化的列合并为一条 UPDATE 写回底层仓库
+// 写入时以读取时的版本号做比较，期间玩家被其他请求修改过则返回 ErrVersionConflict。
+// 请求级工作单元中的读-改-写已经在各自结束时提交，剩余的修改来自不检查版本号的写入（如 UpdateColumns），
+// 冲突时在最新数据上重新应用这些列后再次提交，最多重试 MaxConflictRetries 次。
+func (u *UnitOfWork) Commit() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.request {
+		return u.commit()
+	}
+	for attempt := 0; ; attempt++ {
+		pending, err := u.pendingColumns()
+		if err != nil {
+			return err
+		}
+		err = u.commit()
+		if !errors.Is(err, ErrVersionConflict) || attempt == MaxConflictRetries {
+			return err
+		}
+		log.Printf("提交玩家数据时版本冲突，在最新数据上重新应用修改 (%d/%d)", attempt+1, MaxConflictRetries)
+		if err := u.rebase(pending); err != nil {
+			return err
+		}
+	}
+}
+
+// commit 执行一次 Commit，调用方需持有锁
+func (u *UnitOfWork) commit() error {
+	for deviceID, t := range u.players {
+		columns, err := dirtyColumns(&t.original, &t.current)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		u.stats.Writes++
+		if err := u.base.UpdateColumnsIfVersion(deviceID, t.original.Version, columns); err != nil {
+			return fmt.Errorf("commit player %s: %w", deviceID, err)
+		}
+		t.current.Version = t.original.Version + 1
+		t.original = t.current
+	}
+	return nil
+}
+
+// pendingColumns 返回每个玩家尚未提交的列，调用方需持有锁
+func (u *UnitOfWork) pendingColumns() (map[string]map[string]interface{}, error) {
+	pending := make(map[string]map[string]interface{})
+	for deviceID, t := range u.players {
+		columns, err := dirtyColumns(&t.original, &t.current)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) > 0 {
+			pending[deviceID] = columns
+		}
+	}
+	return pending, nil
+}
+
+// rebase 重新读取仍有未提交修改的玩家，丢弃这些修改后在最新数据上重新应用 pending 中的列，调用方需持有锁
+func (u *UnitOfWork) rebase(pending map[string]map[string]interface{}) error {
+	for deviceID, t := range u.players {
+		columns, err := dirtyColumns(&t.original, &t.current)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if err := u.reload(deviceID, t, pending[deviceID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reload 从底层仓库重新读取玩家，并在最新数据上重新应用 columns，调用方需持有锁
+func (u *UnitOfWork) reload(deviceID string, t *trackedPlayer, columns map[string]interface{}) error {
+	u.stats.Loads++
+	player, err := u.base.GetByDeviceID(deviceID)
+	if err != nil {
+		return err
+	}
+	t.original = *player
+	t.current = *player
+	u.byRoleID[player.RoleID] = deviceID
+	if len(columns) == 0 {
+		return nil
+	}
+	return t.set(columns)
+}
+
+// replayPending 在服务事务的工作单元 players 中重新应用本工作单元尚未提交的修改，详见 RetryRequestTx
+func (u *UnitOfWork) replayPending(players *UnitOfWork) error {
+	u.mu.Lock()
+	pending, err := u.pendingColumns()
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for deviceID, columns := range pending {
+		if err := players.UpdateColumns(deviceID, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adopt 在服务的事务提交后改用 players 中已提交的玩家数据，replayPending 重新应用的修改随之视为已提交
+func (u *UnitOfWork) adopt(players *UnitOfWork) {
+	players.mu.Lock()
+	defer players.mu.Unlock()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, t := range players.players {
+		player := t.current
+		u.track(&player)
+	}
+}
+
+// Stats 返回本工作单元的查询统计
+func (u *UnitOfWork) Stats() QueryStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stats
+}
+
+// dirtyColumns 比较两份玩家数据，返回发生变化的列（不含版本号和由数据库维护的时间戳）
+func dirtyColumns(original, current *model.PlayerData) (map[string]interface{}, error) {
+	s, err := loadPlayerSchema()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	originalValue := reflect.ValueOf(original).Elem()
+	currentValue := reflect.ValueOf(current).Elem()
+
+	columns := map[string]interface{}{}
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.DBName == "version" || field.PrimaryKey || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+			continue
+		}
+		before, _ := field.ValueOf(ctx, originalValue)
+		after, _ := field.ValueOf(ctx, currentValue)
+		if !reflect.DeepEqual(before, after) {
+			columns[field.DBName] = after
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,137 @@
+// internal/server/ratelimit.go
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/game_error"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitSweepInterval 清理过期计数窗口的间隔
+const rateLimitSweepInterval = time.Minute
+
+// rateWindow 是一个身份在某个 msg_id 下的固定窗口计数
+type rateWindow struct {
+	start  time.Time
+	length time.Duration
+	count  int
+}
+
+// RateLimiter 按 msg_id + 身份(deviceID/realDeviceID/IP) 进行固定窗口计数的限流器
+// now 可以注入，方便在不依赖真实时间的情况下验证限流行为
+type RateLimiter struct {
+	mu        sync.Mutex
+	cfg       conf.RateLimitConf
+	now       func() time.Time
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+// NewRateLimiter 创建一个限流器，now 为 nil 时使用 time.Now
+func NewRateLimiter(cfg conf.RateLimitConf, now func() time.Time) *RateLimiter {
+	if now == nil {
+		now = time.Now
+	}
+	return &RateLimiter{
+		cfg:     cfg,
+		now:     now,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow 判断本次请求是否在预算内
+// identities 中任意一个身份超出预算都会拒绝请求；被拒绝的请求不计入其他身份的预算，
+// 避免同一IP下的刷接口行为把正常设备的额度一起耗尽。空字符串身份会被忽略。
+func (rl *RateLimiter) Allow(msgID string, identities ...string) bool {
+	if !rl.cfg.Enabled {
+		return true
+	}
+	rule := rl.cfg.RuleFor(msgID)
+	if rule.Limit <= 0 || rule.WindowSeconds <= 0 {
+		return true
+	}
+	length := time.Duration(rule.WindowSeconds) * time.Second
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweepLocked(now)
+
+	keys := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		if identity == "" {
+			continue
+		}
+		key := msgID + "|" + identity
+		if w, ok := rl.windows[key]; ok && now.Sub(w.start) < w.length && w.count >= rule.Limit {
+			return false
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		w, ok := rl.windows[key]
+		if !ok || now.Sub(w.start) >= w.length {
+			rl.windows[key] = &rateWindow{start: now, length: length, count: 1}
+			continue
+		}
+		w.count++
+	}
+	return true
+}
+
+// sweepLocked 定期清理已经过期的计数窗口，防止内存无限增长（调用方需持有锁）
+func (rl *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	for key, w := range rl.windows {
+		if now.Sub(w.start) >= w.length {
+			delete(rl.windows, key)
+		}
+	}
+}
+
+// rateLimitMiddleware 限流中间件
+// 依赖 banningEnforcementMiddleware 预先解析出的 msg_id 与 msg_data，
+// 超出预算时按 conf.IsTextResponse 的配置返回 -15。
+func rateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		msgID := c.GetString("msg_id")
+
+		var deviceID, realDeviceID string
+		if msgData, ok := c.Get("msg_data"); ok {
+			if msgDataMap, ok := msgData.(map[string]interface{}); ok {
+				deviceID, _ = msgDataMap["deviceID"].(string)
+				realDeviceID, _ = msgDataMap["realDeviceID"].(string)
+			}
+		}
+		ip := utils.GetClientIP(c)
+
+		var identities []string
+		if ip != "" {
+			identities = append(identities, "ip:"+ip)
+		}
+		if deviceID != "" {
+			identities = append(identities, "device:"+deviceID)
+		}
+		if realDeviceID != "" {
+			identities = append(identities, "realDevice:"+realDeviceID)
+		}
+
+		if !limiter.Allow(msgID, identities...) {
+			log.Printf("Request with msg_id [%s] rejected by rate limit. ip=%s deviceID=%s realDeviceID=%s", msgID, ip, deviceID, realDeviceID)
+			abortWithGameError(c, msgID, game_error.New(-15))
+			return
+		}
+		c.Next()
+	}
+}
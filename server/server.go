@@ -2,13 +2,18 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv" // 用于将字符串msg_id转换为整数
+	"sync/atomic"
 	"dmmserver/conf"
 	"dmmserver/game_error"
 	"dmmserver/handler"
+	"dmmserver/repository"
+	"dmmserver/server/session"
 	"dmmserver/services/banning"
 //	"dmmserver/services/playtime"
 	"dmmserver/services/serversettings"
@@ -16,6 +21,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// httpServer 当前正在运行的HTTP服务器，Shutdown 通过它停止接收新请求
+var httpServer atomic.Pointer[http.Server]
+
 // activityMiddleware 负责处理与刷新机制相关的逻辑
 func activityMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -98,18 +106,17 @@ func banningEnforcementMiddleware() gin.HandlerFunc {
 				return
 			}
 
-			// 根据 response_format.go 中的配置来判断响应格式
-			if conf.IsTextResponse(msgIDInt) {
+			// 根据 config.json 中 responseFormats 的配置来判断响应格式、错误码和提示文本
+			banResponse := conf.ResponseFormatFor(msgIDInt)
+			if banResponse.Format == conf.FormatText {
 				// 发送纯文本响应
 				c.AbortWithStatus(http.StatusOK)
-				// 这里的文本内容也可以配置化，但为了简洁，先硬编码一个通用文本
-				c.String(http.StatusOK, "获取版本信息失败，请重试") //虚假的错误提示信息误导黑客
+				c.String(http.StatusOK, banResponse.BanMessage) //虚假的错误提示信息误导黑客
 			} else {
 				// 发送JSON响应 (默认行为)
-				// 封禁的JSON错误码直接使用 -3001
 				c.AbortWithStatusJSON(http.StatusOK, gin.H{
-					"errorCode": -3001,
-					"errorMsg":  "获取版本信息失败，请重试",//虚假的错误提示信息误导黑客
+					"errorCode": banResponse.BanErrorCode,
+					"errorMsg":  banResponse.BanMessage, //虚假的错误提示信息误导黑客
 				})
 			}
 			return // 确保请求中止，不再传递给后续处理器
@@ -124,25 +131,7 @@ func banningEnforcementMiddleware() gin.HandlerFunc {
 				
 				// 检查是否是GameError类型的错误，如果是则直接返回对应的错误码
 				if gameErr, ok := err.(*game_error.GameError); ok {
-					// 根据 response_format.go 中的配置来判断响应格式
-					msgIDInt, convErr := strconv.Atoi(msgIDStr)
-					if convErr != nil {
-						// 如果 msg_id 不是有效的整数，则返回默认JSON错误
-						c.AbortWithStatusJSON(http.StatusOK, gin.H{
-							"errorCode": gameErr.Code,
-							"errorMsg":  gameErr.Message,
-						})
-					} else if conf.IsTextResponse(msgIDInt) {
-						// 发送纯文本响应
-						c.AbortWithStatus(http.StatusOK)
-						c.String(http.StatusOK, gameErr.Message)
-					} else {
-						// 发送JSON响应
-						c.AbortWithStatusJSON(http.StatusOK, gin.H{
-							"errorCode": gameErr.Code,
-							"errorMsg":  gameErr.Message,
-						})
-					}
+					abortWithGameError(c, msgIDStr, gameErr)
 					return // 确保请求中止，不再传递给后续处理器
 				}
 			}
@@ -155,6 +144,79 @@ func banningEnforcementMiddleware() gin.HandlerFunc {
 	}
 }
 
+// abortWithGameError 中止请求并按 msg_id 配置的响应格式返回错误
+// 纯文本格式只返回错误消息，JSON格式返回 errorCode 与 errorMsg
+func abortWithGameError(c *gin.Context, msgIDStr string, gameErr *game_error.GameError) {
+	// 根据 config.json 中 responseFormats 的配置来判断响应格式
+	msgIDInt, convErr := strconv.Atoi(msgIDStr)
+	if convErr == nil && conf.IsTextResponse(msgIDInt) {
+		// 发送纯文本响应
+		c.AbortWithStatus(http.StatusOK)
+		c.String(http.StatusOK, gameErr.Message)
+		return
+	}
+	// 发送JSON响应（msg_id 不是有效整数时同样使用JSON）
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{
+		"errorCode": gameErr.Code,
+		"errorMsg":  gameErr.Message,
+	})
+}
+
+// sessionMiddleware 会话校验中间件
+// 只对注册时声明了 handler.WithSession() 的 msg_id 生效，
+// 校验通过后将玩家数据和公开信息注入上下文，供业务 handler 直接使用。
+func sessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		msgID := c.GetString("msg_id")
+		if !handler.RequiresSession(msgID) {
+			c.Next()
+			return
+		}
+
+		msgData, _ := c.Get("msg_data")
+		msgDataMap, _ := msgData.(map[string]interface{})
+		if msgDataMap == nil {
+			msgDataMap = make(map[string]interface{})
+		}
+
+		playerData, publicInfo, err := session.Verify(repository.FromContext(c), msgDataMap, handler.SessionOptions(msgID))
+		if err != nil {
+			log.Printf("Request with msg_id [%s] rejected by session check: %v", msgID, err)
+			writeError(c, msgID, err)
+			c.Abort()
+			return
+		}
+
+		session.Set(c, playerData, publicInfo)
+		c.Next()
+	}
+}
+
+// unitOfWorkMiddleware 为每个请求创建玩家工作单元
+// 会话校验、处理器和各个管理器通过 repository.FromContext(c) 共享同一份玩家数据，
+// 管理器的读-改-写在各自结束时提交，其余修改由 dispatchHandler 在处理器成功返回后统一提交；
+// 这里在请求结束时记录实际的查询次数。
+func unitOfWorkMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uow := repository.Attach(c, repository.Default())
+		c.Next()
+		stats := uow.Stats()
+		log.Printf("msg_id [%s] player queries: loads=%d writes=%d", c.GetString("msg_id"), stats.Loads, stats.Writes)
+	}
+}
+
+// writeError 按 dispatchHandler 的统一格式发送错误响应
+func writeError(c *gin.Context, msgID string, err error) {
+	if gameErr, ok := err.(*game_error.GameError); ok {
+		// 如果是业务逻辑返回的 GameError
+		c.JSON(http.StatusOK, gin.H{"errorCode": gameErr.Code})
+	} else {
+		// 如果是其他未知错误 (例如数据库连接错误等)，记录日志并返回一个通用的服务器逻辑错误
+		log.Printf("Unhandled internal error for msg_id %s: %v", msgID, err)
+		c.JSON(http.StatusOK, gin.H{"errorCode": -65})
+	}
+}
+
 // dispatchHandler 现在是唯一的“发送网关”，它统一处理业务逻辑的返回和发送
 func dispatchHandler(c *gin.Context) {
 	// 直接从上下文中获取由中间件解析好的数据
@@ -187,19 +249,26 @@ func dispatchHandler(c *gin.Context) {
 	// 调用业务 handler，获取返回的数据和错误
 	data, err := h(c, msgData)
 
-	// 处理业务 handler 返回的错误
+	// 处理业务 handler 返回的错误（工作单元中未提交的修改随之丢弃）
 	if err != nil {
-		if gameErr, ok := err.(*game_error.GameError); ok {
-			// 如果是业务逻辑返回的 GameError
-			c.JSON(http.StatusOK, gin.H{"errorCode": gameErr.Code})
-		} else {
-			// 如果是其他未知错误 (例如数据库连接错误等)，记录日志并返回一个通用的服务器逻辑错误
-			log.Printf("Unhandled internal error for msg_id %s: %v", msgID, err)
-			c.JSON(http.StatusOK, gin.H{"errorCode": -65})
-		}
+		writeError(c, msgID, err)
 		return
 	}
 
+	// 业务成功后，把工作单元中修改过的玩家数据一次性写回数据库
+	if uow, ok := repository.UnitOfWorkFromContext(c); ok {
+		if err := uow.Commit(); err != nil {
+			log.Printf("提交玩家数据失败, msg_id=%s: %v", msgID, err)
+			if errors.Is(err, repository.ErrVersionConflict) {
+				// 玩家数据在本次请求期间被其他请求修改，提示客户端刷新后重试
+				writeError(c, msgID, game_error.New(-290))
+			} else {
+				writeError(c, msgID, game_error.New(-2))
+			}
+			return
+		}
+	}
+
 	// 处理业务成功的情况
 	response := gin.H{}
 	if data != nil {
@@ -222,18 +291,34 @@ func Run() {
 	// 顺序很重要：
 	// 1. activityMiddleware 负责更新活动时间戳和强制刷新
 	// 2. banningEnforcementMiddleware 负责拦截封禁请求
-	// 3. PlaytimeMiddleware 负责检查玩家游戏时长限制
-	// 4. dispatchHandler 负责业务分发和统一响应
-	//apiGroup.Use(activityMiddleware(), banningEnforcementMiddleware(), PlaytimeMiddleware())
-	apiGroup.Use(activityMiddleware(), banningEnforcementMiddleware())
+	// 3. rateLimitMiddleware 负责按 msg_id 与 deviceID/realDeviceID/IP 限制请求频率
+	// 4. unitOfWorkMiddleware 负责创建请求级的玩家工作单元
+	// 5. sessionMiddleware 负责对声明了 WithSession 的 msg_id 进行登录凭证校验
+	// 6. PlaytimeMiddleware 负责检查玩家游戏时长限制
+	// 7. dispatchHandler 负责业务分发、提交工作单元和统一响应
+	limiter := NewRateLimiter(conf.Conf.RateLimit, nil)
+	//apiGroup.Use(activityMiddleware(), banningEnforcementMiddleware(), rateLimitMiddleware(limiter), unitOfWorkMiddleware(), sessionMiddleware(), PlaytimeMiddleware())
+	apiGroup.Use(activityMiddleware(), banningEnforcementMiddleware(), rateLimitMiddleware(limiter), unitOfWorkMiddleware(), sessionMiddleware())
 	{
 		// 注册唯一的请求处理路由
 		apiGroup.POST("/", dispatchHandler)
 	}
 
 	port := ":" + conf.Conf.Server.Port
+	srv := &http.Server{Addr: port, Handler: r}
+	httpServer.Store(srv)
 	log.Printf("Server is starting, listening on port %s", port)
-	if err := r.Run(port); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to run server: %v", err)
 	}
+}
+
+// Shutdown 停止接收新连接，并等待正在处理的请求完成，直到 ctx 超时
+func Shutdown(ctx context.Context) error {
+	srv := httpServer.Load()
+	if srv == nil {
+		return nil
+	}
+	log.Println("Server is shutting down, draining in-flight requests...")
+	return srv.Shutdown(ctx)
 }
\ No newline at end of file
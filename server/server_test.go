@@ -0,0 +1,777 @@
+// internal/server/server_test.go
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/cardupgrade"
+	"dmmserver/services/reward"
+	"dmmserver/services/shop"
+	"dmmserver/services/synthesis"
+	"dmmserver/services/tasks"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// 配置文件和物品注册表都使用相对于仓库根目录的路径
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	conf.Init()
+	conf.Conf.Registration = conf.RegistrationConf{}
+	if err := utils.InitItemRegistry(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setupDB 为每个测试创建独立的 SQLite 数据库
+func setupDB(t *testing.T) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "server.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		repository.SetDefault(nil)
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// countingRepository 统计实际发往底层仓库的读写次数
+type countingRepository struct {
+	base repository.PlayerRepository
+
+	mu     sync.Mutex
+	loads  int
+	writes int
+}
+
+func (r *countingRepository) count(write bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if write {
+		r.writes++
+	} else {
+		r.loads++
+	}
+}
+
+func (r *countingRepository) reset() repository.QueryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := repository.QueryStats{Loads: r.loads, Writes: r.writes}
+	r.loads, r.writes = 0, 0
+	return stats
+}
+
+func (r *countingRepository) GetByDeviceID(deviceID string) (*model.PlayerData, error) {
+	r.count(false)
+	return r.base.GetByDeviceID(deviceID)
+}
+
+func (r *countingRepository) GetByRoleID(roleID int) (*model.PlayerData, error) {
+	r.count(false)
+	return r.base.GetByRoleID(roleID)
+}
+
+func (r *countingRepository) Create(player *model.PlayerData) error {
+	r.count(true)
+	return r.base.Create(player)
+}
+
+func (r *countingRepository) Save(player *model.PlayerData) error {
+	r.count(true)
+	return r.base.Save(player)
+}
+
+func (r *countingRepository) UpdateColumns(deviceID string, columns map[string]interface{}) error {
+	r.count(true)
+	return r.base.UpdateColumns(deviceID, columns)
+}
+
+func (r *countingRepository) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	r.count(true)
+	return r.base.UpdateColumnsIfVersion(deviceID, version, columns)
+}
+
+func (r *countingRepository) UpdateColumnsByRoleID(roleID int, columns map[string]interface{}) error {
+	r.count(true)
+	return r.base.UpdateColumnsByRoleID(roleID, columns)
+}
+
+// newEngine 按 Run 中的顺序组装中间件，withUnitOfWork 为 false 时模拟引入工作单元之前的行为
+// extra 插在封禁检查之后、工作单元之前。
+func newEngine(withUnitOfWork bool, extra ...gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	middlewares := append([]gin.HandlerFunc{banningEnforcementMiddleware()}, extra...)
+	if withUnitOfWork {
+		middlewares = append(middlewares, unitOfWorkMiddleware())
+	}
+	middlewares = append(middlewares, sessionMiddleware())
+	r.Use(middlewares...)
+	r.POST("/", dispatchHandler)
+	return r
+}
+
+// post 发送一个 msg_id 请求并返回解析后的响应
+func post(t *testing.T, engine *gin.Engine, msgID string, msg map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("序列化请求失败: %v", err)
+	}
+	form := url.Values{"msg_id": {msgID}, "msg": {string(msgJSON)}}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("msg_id=%s 响应不是 JSON: %s", msgID, w.Body.String())
+	}
+	return response
+}
+
+// createPlayer 按新账号的默认数据创建一个玩家并返回会话参数
+func createPlayer(t *testing.T, deviceID string, roleID int) map[string]interface{} {
+	t.Helper()
+	players := &repository.GormPlayerRepository{}
+	pm := utils.NewPublicInfoManagerWithRepository(players)
+	publicInfo := pm.GetDefaultPublicInfo()
+	publicInfo.Name = "tester"
+	publicInfoStr, err := pm.ConvertPublicInfoToKeyValue(&publicInfo)
+	if err != nil {
+		t.Fatalf("转换公开信息失败: %v", err)
+	}
+	mustJSON := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("序列化玩家数据失败: %v", err)
+		}
+		return string(data)
+	}
+
+	player := model.PlayerData{
+		DeviceID:         deviceID,
+		RoleID:           roleID,
+		AuthKey:          "auth-" + deviceID,
+		AuthKeyExpire:    time.Now().Add(time.Hour).Unix(),
+		PublicInfo:       publicInfoStr,
+		OwnedCharacters:  mustJSON(utils.NewCharacterManagerWithRepository(players).GetDefaultCharacters()),
+		ActiveCharacters: "[100,200]",
+		CardSkins:        mustJSON(utils.NewCardSkinManagerWithRepository(players).GetDefaultCardSkins()),
+		CardStyles:       mustJSON(utils.NewCardStyleManagerWithRepository(players).GetDefaultCardStyles()),
+		CardPieces:       "[]",
+		EmotionData:      mustJSON(utils.NewEmotionManagerWithRepository(players).GetDefaultEmotionData()),
+		AssetsData:       mustJSON(utils.NewAssetsManagerWithRepository(players).GetDefaultAssetsData()),
+		BoxesData:        mustJSON(utils.NewBoxesManagerWithRepository(players).GetDefaultBoxesData()),
+		LightnessData:    mustJSON(utils.NewLightnessManagerWithRepository(players).GetDefaultLightnessData()),
+	}
+	if err := players.Create(&player); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+
+	return map[string]interface{}{
+		"deviceID":    deviceID,
+		"authKey":     player.AuthKey,
+		"accountName": publicInfo.Name,
+		"roleID":      roleID,
+		"pfID":        1,
+		"version":     "1.0.0",
+		"baseVerCode": 1,
+		"compVerCode": 1,
+		"sv":          1,
+		"sequenceID":  1,
+	}
+}
+
+// withFields 在会话参数的基础上添加请求参数
+func withFields(sessionMsg map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	msg := make(map[string]interface{}, len(sessionMsg)+len(fields))
+	for key, value := range sessionMsg {
+		msg[key] = value
+	}
+	for key, value := range fields {
+		msg[key] = value
+	}
+	return msg
+}
+
+// 统计各 msg_id 在引入请求级工作单元前后实际发往玩家仓库的查询次数
+// 工作单元之前会话校验和每个管理器各自读写数据库，之后每个玩家每个请求最多读取一次、写入一次。
+func TestQueriesPerMsgID(t *testing.T) {
+	cases := []struct {
+		msgID  string
+		fields func(sessionMsg map[string]interface{}) map[string]interface{}
+	}{
+		{"30002", func(s map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"requestRoleID": s["roleID"]}
+		}},
+		{"30050", func(map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"slot": 0, "characterID": 200}
+		}},
+		{"30054", func(map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"headBoxID": 900001}
+		}},
+		{"30065", func(map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"bundleIdentifier": "dmm", "deviceInfo": "test device", "realDeviceID": "real", "pfID": 2}
+		}},
+	}
+
+	measure := func(t *testing.T, withUnitOfWork bool, msgID string, fields func(map[string]interface{}) map[string]interface{}) repository.QueryStats {
+		setupDB(t)
+		counting := &countingRepository{base: &repository.GormPlayerRepository{}}
+		repository.SetDefault(counting)
+		// 封禁检查中记录客户端IP的读取发生在工作单元之前，两种情况下相同，不计入统计
+		engine := newEngine(withUnitOfWork, func(c *gin.Context) {
+			counting.reset()
+			c.Next()
+		})
+
+		sessionMsg := createPlayer(t, "device-"+msgID, 1000)
+		response := post(t, engine, msgID, withFields(sessionMsg, fields(sessionMsg)))
+		if code := response["errorCode"]; code != float64(0) {
+			t.Fatalf("msg_id=%s 返回错误: %v", msgID, response)
+		}
+		return counting.reset()
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.msgID, func(t *testing.T) {
+			before := measure(t, false, tc.msgID, tc.fields)
+			after := measure(t, true, tc.msgID, tc.fields)
+			t.Logf("msg_id=%s 无工作单元: loads=%d writes=%d, 有工作单元: loads=%d writes=%d",
+				tc.msgID, before.Loads, before.Writes, after.Loads, after.Writes)
+
+			if after.Loads != 1 {
+				t.Errorf("有工作单元时读取了 %d 次玩家，期望 1 次", after.Loads)
+			}
+			if after.Writes > 1 {
+				t.Errorf("有工作单元时写入了 %d 次玩家，期望最多 1 次", after.Writes)
+			}
+			if after.Loads+after.Writes > before.Loads+before.Writes {
+				t.Errorf("工作单元增加了查询次数: 之前 %d 次，之后 %d 次",
+					before.Loads+before.Writes, after.Loads+after.Writes)
+			}
+		})
+	}
+}
+
+// failingRepository 让所有带版本比较的写入失败，请求结束时工作单元中还有未提交的修改就会返回错误
+type failingRepository struct {
+	repository.PlayerRepository
+}
+
+func (r *failingRepository) UpdateColumnsIfVersion(deviceID string, version int64, columns map[string]interface{}) error {
+	return errors.New("写入失败")
+}
+
+// 新账号首次请求时会话校验在工作单元中补全名字，之后购买在商店自己的事务中修改玩家数据：
+// 补全的名字和购买在同一个事务中提交，请求结束时工作单元中不再有需要提交的修改。
+func TestPurchaseWithDirtyUnitOfWork(t *testing.T) {
+	if err := shop.Init(); err != nil {
+		t.Fatalf("加载商店目录失败: %v", err)
+	}
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-shop", 1000)
+	pm := utils.NewPublicInfoManagerWithRepository(players)
+	publicInfo, err := pm.GetPublicInfo("device-shop")
+	if err != nil {
+		t.Fatalf("读取公开信息失败: %v", err)
+	}
+	publicInfo.Name = ""
+	if err := pm.SavePublicInfo("device-shop", publicInfo); err != nil {
+		t.Fatalf("清空名字失败: %v", err)
+	}
+
+	// 商店的事务不经过全局仓库，请求的工作单元在服务之后还要提交任何修改都会失败
+	repository.SetDefault(&failingRepository{PlayerRepository: players})
+	response := post(t, newEngine(true), "30021", withFields(sessionMsg, map[string]interface{}{"shopItemID": 5, "sequenceID": 7}))
+	if code := response["errorCode"]; code != float64(0) {
+		t.Fatalf("购买返回错误: %v", response)
+	}
+
+	cardSkins, err := utils.NewCardSkinManagerWithRepository(players).GetCardSkins("device-shop")
+	if err != nil {
+		t.Fatalf("读取卡牌皮肤失败: %v", err)
+	}
+	if !cardSkins.IsOwned(600001) {
+		t.Fatal("购买的卡牌皮肤没有发放")
+	}
+	count, err := utils.NewAssetsManagerWithRepository(players).GetAssetCount("device-shop", 29)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("资产 29 的数量为 %d，期望 2", count)
+	}
+	if publicInfo, err = pm.GetPublicInfo("device-shop"); err != nil {
+		t.Fatalf("读取公开信息失败: %v", err)
+	}
+	if publicInfo.Name != "tester" {
+		t.Fatalf("会话校验补全的名字为 %q，期望和购买一起提交", publicInfo.Name)
+	}
+}
+
+// 支付的货币道具不足时返回 -4，货币道具和物品都不变
+func TestPurchaseShortOfCurrencyItem(t *testing.T) {
+	if err := shop.Init(); err != nil {
+		t.Fatalf("加载商店目录失败: %v", err)
+	}
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-shop", 1000)
+	am := utils.NewAssetsManagerWithRepository(players)
+	if err := am.ConsumeAsset("device-shop", 29, 3); err != nil {
+		t.Fatalf("扣除资产失败: %v", err)
+	}
+
+	response := post(t, newEngine(true), "30021", withFields(sessionMsg, map[string]interface{}{"shopItemID": 5, "sequenceID": 7}))
+	if code := response["errorCode"]; code != float64(-4) {
+		t.Fatalf("货币道具不足时购买返回错误码 %v，期望 -4", code)
+	}
+	if count, err := am.GetAssetCount("device-shop", 29); err != nil || count != 1 {
+		t.Fatalf("资产 29 的数量为 %d (%v)，期望仍为 1", count, err)
+	}
+	cardSkins, err := utils.NewCardSkinManagerWithRepository(players).GetCardSkins("device-shop")
+	if err != nil {
+		t.Fatalf("读取卡牌皮肤失败: %v", err)
+	}
+	if cardSkins.IsOwned(600001) {
+		t.Fatal("购买失败后卡牌皮肤仍然被发放")
+	}
+}
+
+// 限流使用注入的时钟：超出预算返回 -15，窗口过去之后恢复
+func TestRateLimitWindowWithFakeClock(t *testing.T) {
+	setupDB(t)
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(conf.RateLimitConf{
+		Enabled: true,
+		Default: conf.RateLimitRule{Limit: 100, WindowSeconds: 60},
+		MsgIDs:  map[string]conf.RateLimitRule{"30020": {Limit: 2, WindowSeconds: 10}},
+	}, func() time.Time { return now })
+
+	engine := gin.New()
+	engine.Use(banningEnforcementMiddleware(), rateLimitMiddleware(limiter))
+	engine.POST("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"errorCode": 0})
+	})
+	msg := map[string]interface{}{"deviceID": "device-limit"}
+
+	steps := []struct {
+		advance time.Duration
+		want    float64
+	}{
+		{0, 0},
+		{time.Second, 0},
+		{time.Second, -15},
+		{7 * time.Second, -15},
+		{time.Second, 0}, // 第一个请求之后 10 秒，窗口重新开始
+		{time.Second, 0},
+		{time.Second, -15},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		response := post(t, engine, "30020", msg)
+		if code := response["errorCode"]; code != step.want {
+			t.Fatalf("第 %d 个请求返回 %v，期望 errorCode=%v", i+1, response, step.want)
+		}
+	}
+}
+
+// 客户端重发同一个 sequenceID 的卡牌升级请求时返回 -8，碎片和金币只扣除一次
+func TestCardUpgradeRejectsRepeatedSequenceID(t *testing.T) {
+	if err := cardupgrade.Init(); err != nil {
+		t.Fatalf("加载升级消耗表失败: %v", err)
+	}
+	oldBalances := conf.Conf.Wallet.InitialBalances
+	conf.Conf.Wallet.InitialBalances = map[string]int64{"gold": 1000}
+	t.Cleanup(func() { conf.Conf.Wallet.InitialBalances = oldBalances })
+
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-upgrade", 1000)
+	if err := utils.NewItemManagerWithRepository(players).GrantItem("device-upgrade", 7000100, 30, 0); err != nil {
+		t.Fatalf("发放卡牌碎片失败: %v", err)
+	}
+
+	engine := newEngine(true)
+	fields := map[string]interface{}{"cardID": 100, "sequenceID": 7}
+	if code := post(t, engine, "30030", withFields(sessionMsg, fields))["errorCode"]; code != float64(0) {
+		t.Fatalf("升级返回错误码 %v", code)
+	}
+	if code := post(t, engine, "30030", withFields(sessionMsg, fields))["errorCode"]; code != float64(-8) {
+		t.Fatalf("重发的升级请求返回错误码 %v，期望 -8", code)
+	}
+
+	pieces, err := utils.NewCardManagerWithRepository(players).GetCardPieces("device-upgrade")
+	if err != nil {
+		t.Fatalf("读取卡牌碎片失败: %v", err)
+	}
+	for _, piece := range pieces {
+		if piece.CardID == 100 && piece.Num != 20 {
+			t.Fatalf("卡牌 100 的碎片剩余 %d，期望只扣除一次后剩余 20", piece.Num)
+		}
+	}
+}
+
+// 初始金币用完后，通过奖励获得的金币可以继续用于升级卡牌
+func TestCardUpgradeSpendsRewardedGold(t *testing.T) {
+	if err := cardupgrade.Init(); err != nil {
+		t.Fatalf("加载升级消耗表失败: %v", err)
+	}
+	oldBalances := conf.Conf.Wallet.InitialBalances
+	conf.Conf.Wallet.InitialBalances = nil
+	t.Cleanup(func() { conf.Conf.Wallet.InitialBalances = oldBalances })
+
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-upgrade", 1000)
+	if err := utils.NewItemManagerWithRepository(players).GrantItem("device-upgrade", 7000100, 30, 0); err != nil {
+		t.Fatalf("发放卡牌碎片失败: %v", err)
+	}
+
+	engine := newEngine(true)
+	upgrade := func(sequenceID int) map[string]interface{} {
+		return post(t, engine, "30030", withFields(sessionMsg, map[string]interface{}{"cardID": 100, "sequenceID": sequenceID}))
+	}
+	if code := upgrade(1)["errorCode"]; code != float64(-4) {
+		t.Fatalf("没有金币时升级返回错误码 %v，期望 -4", code)
+	}
+	if _, err := reward.Grant("device-upgrade", "test:gold", []reward.Item{{ItemID: 9900001, Count: 150}}); err != nil {
+		t.Fatalf("发放金币失败: %v", err)
+	}
+	response := upgrade(2)
+	if code := response["errorCode"]; code != float64(0) {
+		t.Fatalf("获得金币后升级返回错误码 %v", code)
+	}
+	if gold := response["gold"]; gold != float64(50) {
+		t.Fatalf("升级后剩余金币 %v，期望 50", gold)
+	}
+}
+
+// 客户端重发同一个 sequenceID 的合成请求时返回 -8，碎片和金币只扣除一次
+func TestSynthesisRejectsRepeatedSequenceID(t *testing.T) {
+	if err := synthesis.Init(); err != nil {
+		t.Fatalf("加载合成配方失败: %v", err)
+	}
+	oldBalances := conf.Conf.Wallet.InitialBalances
+	conf.Conf.Wallet.InitialBalances = map[string]int64{"gold": 1000}
+	t.Cleanup(func() { conf.Conf.Wallet.InitialBalances = oldBalances })
+
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-synthesis", 1000)
+	if err := utils.NewItemManagerWithRepository(players).GrantItem("device-synthesis", 7000101, 40, 0); err != nil {
+		t.Fatalf("发放卡牌碎片失败: %v", err)
+	}
+
+	engine := newEngine(true)
+	fields := map[string]interface{}{"itemID": 650001, "sequenceID": 7}
+	if code := post(t, engine, "30040", withFields(sessionMsg, fields))["errorCode"]; code != float64(0) {
+		t.Fatalf("合成返回错误码 %v", code)
+	}
+	if code := post(t, engine, "30040", withFields(sessionMsg, fields))["errorCode"]; code != float64(-8) {
+		t.Fatalf("重发的合成请求返回错误码 %v，期望 -8", code)
+	}
+
+	pieces, err := utils.NewCardManagerWithRepository(players).GetCardPieces("device-synthesis")
+	if err != nil {
+		t.Fatalf("读取卡牌碎片失败: %v", err)
+	}
+	for _, piece := range pieces {
+		if piece.CardID == 101 && piece.Num != 20 {
+			t.Fatalf("卡牌 101 的碎片剩余 %d，期望只扣除一次后剩余 20", piece.Num)
+		}
+	}
+}
+
+// 初始金币用完后，通过奖励获得的金币可以继续用于合成
+func TestSynthesisSpendsRewardedGold(t *testing.T) {
+	if err := synthesis.Init(); err != nil {
+		t.Fatalf("加载合成配方失败: %v", err)
+	}
+	oldBalances := conf.Conf.Wallet.InitialBalances
+	conf.Conf.Wallet.InitialBalances = nil
+	t.Cleanup(func() { conf.Conf.Wallet.InitialBalances = oldBalances })
+
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-synthesis", 1000)
+	if err := utils.NewItemManagerWithRepository(players).GrantItem("device-synthesis", 7000101, 20, 0); err != nil {
+		t.Fatalf("发放卡牌碎片失败: %v", err)
+	}
+
+	engine := newEngine(true)
+	synthesize := func(sequenceID int) float64 {
+		fields := map[string]interface{}{"itemID": 650001, "sequenceID": sequenceID}
+		code, _ := post(t, engine, "30040", withFields(sessionMsg, fields))["errorCode"].(float64)
+		return code
+	}
+	if code := synthesize(1); code != -4 {
+		t.Fatalf("没有金币时合成返回错误码 %v，期望 -4", code)
+	}
+	if _, err := reward.Grant("device-synthesis", "test:gold", []reward.Item{{ItemID: 9900001, Count: 500}}); err != nil {
+		t.Fatalf("发放金币失败: %v", err)
+	}
+	if code := synthesize(2); code != 0 {
+		t.Fatalf("获得金币后合成返回错误码 %v", code)
+	}
+	playerWallet, err := wallet.Get("device-synthesis")
+	if err != nil {
+		t.Fatalf("读取钱包失败: %v", err)
+	}
+	if playerWallet.Gold != 0 {
+		t.Fatalf("合成后剩余金币 %d，期望 0", playerWallet.Gold)
+	}
+}
+
+// 同一场对局重复上报时返回 -8，超过每个玩家的上报上限返回 -15，被拒绝的对局不会被记录，也不推进任务进度
+func TestMatchResultReports(t *testing.T) {
+	oldMatch := conf.Conf.Match
+	conf.Conf.Match = conf.MatchConf{PerDevice: conf.RateLimitRule{Limit: 2, WindowSeconds: 3600}}
+	t.Cleanup(func() { conf.Conf.Match = oldMatch })
+
+	setupDB(t)
+	book, err := tasks.NewBook(tasks.Settings{DailySlots: tasks.AllSlots}, []tasks.Task{
+		{TaskID: 1, Period: tasks.Daily, Event: tasks.EventMatchPlayed, Target: 3, Rewards: []reward.Item{{ItemID: 30, Count: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("创建任务定义表失败: %v", err)
+	}
+	oldBook := tasks.CurrentBook()
+	tasks.SetBook(book)
+	t.Cleanup(func() { tasks.SetBook(oldBook) })
+
+	sessionMsg := createPlayer(t, "device-match", 1000)
+	engine := newEngine(true)
+	steps := []struct {
+		matchID string
+		want    float64
+	}{
+		{"match-1", 0},
+		{"match-1", -8},
+		{"match-2", 0},
+		{"match-3", -15},
+		{"match-3", -15},
+	}
+	for i, step := range steps {
+		fields := map[string]interface{}{"matchID": step.matchID, "won": true}
+		if code := post(t, engine, "30090", withFields(sessionMsg, fields))["errorCode"]; code != step.want {
+			t.Fatalf("第 %d 次上报 %s 返回错误码 %v，期望 %v", i+1, step.matchID, code, step.want)
+		}
+	}
+
+	var matchIDs []string
+	if err := db.DB.Model(&model.MatchResult{}).Where("device_id = ?", "device-match").Order("id").Pluck("match_id", &matchIDs).Error; err != nil {
+		t.Fatalf("读取对局结果失败: %v", err)
+	}
+	if strings.Join(matchIDs, ",") != "match-1,match-2" {
+		t.Fatalf("记录的对局为 %v，期望 match-1 和 match-2", matchIDs)
+	}
+	board, err := tasks.List("device-match")
+	if err != nil {
+		t.Fatalf("读取任务失败: %v", err)
+	}
+	if len(board.Tasks) != 1 || board.Tasks[0].Progress != 2 {
+		t.Fatalf("任务为 %+v，期望完成对局的任务进度为 2", board.Tasks)
+	}
+}
+
+// 会话中间件对每种凭证错误返回对应的错误码，30065 不检查 roleID
+func TestSessionRejections(t *testing.T) {
+	cases := []struct {
+		name   string
+		msgID  string
+		expire time.Duration // 非零时把 authKey 的过期时间改为当前时间加上该值
+		fields map[string]interface{}
+		want   float64
+	}{
+		{name: "valid", msgID: "30020", want: 0},
+		{name: "missing deviceID", msgID: "30020", fields: map[string]interface{}{"deviceID": ""}, want: -5},
+		{name: "missing authKey", msgID: "30020", fields: map[string]interface{}{"authKey": ""}, want: -5},
+		{name: "missing accountName", msgID: "30020", fields: map[string]interface{}{"accountName": ""}, want: -5},
+		{name: "missing roleID", msgID: "30020", fields: map[string]interface{}{"roleID": nil}, want: -5},
+		{name: "unknown deviceID", msgID: "30020", fields: map[string]interface{}{"deviceID": "device-unknown"}, want: -3},
+		{name: "expired authKey", msgID: "30020", expire: -time.Minute, want: -12},
+		{name: "wrong authKey", msgID: "30020", fields: map[string]interface{}{"authKey": "auth-other"}, want: -11},
+		{name: "wrong accountName", msgID: "30020", fields: map[string]interface{}{"accountName": "other"}, want: -13},
+		{name: "wrong roleID", msgID: "30020", fields: map[string]interface{}{"roleID": 1001}, want: -13},
+		{name: "roleID of wrong type", msgID: "30020", fields: map[string]interface{}{"roleID": "1000"}, want: -13},
+		{name: "30065 ignores roleID", msgID: "30065", fields: map[string]interface{}{"roleID": 1001}, want: 0},
+		{name: "30065 checks accountName", msgID: "30065", fields: map[string]interface{}{"accountName": "other"}, want: -13},
+		{name: "30065 checks authKey", msgID: "30065", fields: map[string]interface{}{"authKey": "auth-other"}, want: -11},
+		{name: "30065 checks expiry", msgID: "30065", expire: -time.Minute, want: -12},
+	}
+	deviceFields := map[string]interface{}{"bundleIdentifier": "dmm", "deviceInfo": "test device", "realDeviceID": "real"}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setupDB(t)
+			sessionMsg := createPlayer(t, "device-session", 1000)
+			if tc.expire != 0 {
+				expire := time.Now().Add(tc.expire).Unix()
+				if err := (&repository.GormPlayerRepository{}).UpdateColumns("device-session", map[string]interface{}{"auth_key_expire": expire}); err != nil {
+					t.Fatalf("修改 authKey 过期时间失败: %v", err)
+				}
+			}
+			msg := withFields(sessionMsg, deviceFields)
+			for key, value := range tc.fields {
+				if value == nil {
+					delete(msg, key)
+				} else {
+					msg[key] = value
+				}
+			}
+			if code := post(t, newEngine(true), tc.msgID, msg)["errorCode"]; code != tc.want {
+				t.Fatalf("msg_id=%s 返回错误码 %v，期望 %v", tc.msgID, code, tc.want)
+			}
+		})
+	}
+}
+
+// 换装类 msg_id 30050-30056：已拥有的可以装备，未拥有返回 -7，已过期返回 -136，非法参数返回 -13
+func TestEquipHandlers(t *testing.T) {
+	expired := int(time.Now().Add(-time.Hour).Unix())
+	grants := []struct {
+		itemID      int
+		expiredTime int
+	}{
+		{300, 0}, {400, expired}, // 角色
+		{1500, 0}, {1501, expired}, // 角色部件
+		{600001, 0}, {600002, expired}, // 卡牌皮肤
+		{650001, 0}, {650002, expired}, // 卡牌样式
+		{900002, 0}, {900003, expired}, // 头像框
+		{910002, 0}, {910003, expired}, // 气泡框
+		{8001001, 0}, {8001002, expired}, // 炫光
+	}
+	cases := []struct {
+		name   string
+		msgID  string
+		fields map[string]interface{}
+		want   float64
+	}{
+		{"character", "30050", map[string]interface{}{"slot": 0, "characterID": 300}, 0},
+		{"character not owned", "30050", map[string]interface{}{"slot": 0, "characterID": 500}, -7},
+		{"character expired", "30050", map[string]interface{}{"slot": 0, "characterID": 400}, -136},
+		{"character slot too large", "30050", map[string]interface{}{"slot": 5, "characterID": 300}, -13},
+		{"character slot negative", "30050", map[string]interface{}{"slot": -1, "characterID": 300}, -13},
+		{"skin part", "30051", map[string]interface{}{"characterID": 100, "skinPartIDs": []interface{}{1500}}, 0},
+		{"skin part not owned", "30051", map[string]interface{}{"characterID": 100, "skinPartIDs": []interface{}{1502}}, -7},
+		{"skin part expired", "30051", map[string]interface{}{"characterID": 100, "skinPartIDs": []interface{}{"1501"}}, -136},
+		{"skin part on unknown character", "30051", map[string]interface{}{"characterID": 999, "skinPartIDs": []interface{}{1500}}, -31},
+		{"card skin", "30052", map[string]interface{}{"cardID": 100, "skinID": 600001}, 0},
+		{"card skin not owned", "30052", map[string]interface{}{"cardID": 100, "skinID": 600003}, -7},
+		{"card skin expired", "30052", map[string]interface{}{"cardID": 100, "skinID": 600002}, -136},
+		{"card skin on unowned card", "30052", map[string]interface{}{"cardID": 999, "skinID": 600001}, -13},
+		{"card style", "30053", map[string]interface{}{"cardID": 100, "styleID": 650001}, 0},
+		{"card style not owned", "30053", map[string]interface{}{"cardID": 100, "styleID": 650003}, -7},
+		{"card style expired", "30053", map[string]interface{}{"cardID": 100, "styleID": 650002}, -136},
+		{"head box", "30054", map[string]interface{}{"headBoxID": 900002}, 0},
+		{"head box not owned", "30054", map[string]interface{}{"headBoxID": 900004}, -7},
+		{"head box expired", "30054", map[string]interface{}{"headBoxID": 900003}, -136},
+		{"bubble box", "30055", map[string]interface{}{"bubbleBoxID": 910002}, 0},
+		{"bubble box not owned", "30055", map[string]interface{}{"bubbleBoxID": 910004}, -7},
+		{"bubble box expired", "30055", map[string]interface{}{"bubbleBoxID": 910003}, -136},
+		{"lightness", "30056", map[string]interface{}{"config": 1001}, 0},
+		{"lightness not owned", "30056", map[string]interface{}{"config": 1003}, -7},
+		{"lightness expired", "30056", map[string]interface{}{"config": 1002}, -136},
+	}
+
+	setupDB(t)
+	sessionMsg := createPlayer(t, "device-equip", 1000)
+	im := utils.NewItemManagerWithRepository(&repository.GormPlayerRepository{})
+	for _, grant := range grants {
+		if err := im.GrantItem("device-equip", grant.itemID, 0, grant.expiredTime); err != nil {
+			t.Fatalf("发放物品 %d 失败: %v", grant.itemID, err)
+		}
+	}
+	engine := newEngine(true)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := post(t, engine, tc.msgID, withFields(sessionMsg, tc.fields))["errorCode"]; code != tc.want {
+				t.Fatalf("msg_id=%s 返回错误码 %v，期望 %v", tc.msgID, code, tc.want)
+			}
+		})
+	}
+}
+
+// 连续两次换装只修改各自的字段，后一次不会覆盖前一次的结果
+func TestSequentialEquipsKeepEachOther(t *testing.T) {
+	setupDB(t)
+	players := &repository.GormPlayerRepository{}
+	sessionMsg := createPlayer(t, "device-equip", 1000)
+	im := utils.NewItemManagerWithRepository(players)
+	for _, itemID := range []int{300, 600001, 650001, 900002, 910002} {
+		if err := im.GrantItem("device-equip", itemID, 0, 0); err != nil {
+			t.Fatalf("发放物品 %d 失败: %v", itemID, err)
+		}
+	}
+	engine := newEngine(true)
+	equip := func(msgID string, fields map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		response := post(t, engine, msgID, withFields(sessionMsg, fields))
+		if code := response["errorCode"]; code != float64(0) {
+			t.Fatalf("msg_id=%s 返回错误码 %v", msgID, code)
+		}
+		return response
+	}
+
+	equip("30054", map[string]interface{}{"headBoxID": 900002})
+	equip("30055", map[string]interface{}{"bubbleBoxID": 910002})
+	publicInfo, err := utils.NewPublicInfoManagerWithRepository(players).GetPublicInfo("device-equip")
+	if err != nil {
+		t.Fatalf("读取公开信息失败: %v", err)
+	}
+	if publicInfo.ActiveHeadBoxID != 900002 || publicInfo.ActiveBubbleBoxID != 910002 {
+		t.Fatalf("头像框为 %d、气泡框为 %d，期望 900002 和 910002", publicInfo.ActiveHeadBoxID, publicInfo.ActiveBubbleBoxID)
+	}
+
+	equip("30052", map[string]interface{}{"cardID": 100, "skinID": 600001})
+	card := equip("30053", map[string]interface{}{"cardID": 100, "styleID": 650001})
+	if card["curSkin"] != float64(600001) || card["curStyle"] != float64(650001) {
+		t.Fatalf("卡牌皮肤为 %v、样式为 %v，期望 600001 和 650001", card["curSkin"], card["curStyle"])
+	}
+
+	equip("30050", map[string]interface{}{"slot": 0, "characterID": 300})
+	active := equip("30050", map[string]interface{}{"slot": 1, "characterID": 100})["activeCharacterID"]
+	if got, _ := json.Marshal(active); string(got) != "[300,100]" {
+		t.Fatalf("出战角色为 %s，期望 [300,100]", got)
+	}
+}
@@ -0,0 +1,139 @@
+// internal/server/session/session.go
+package session
+
+import (
+	"log"
+	"time"
+
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 会话信息在 gin.Context 中的键名
+const (
+	playerDataKey = "session_player_data"
+	publicInfoKey = "session_public_info"
+)
+
+// Options 调整 Verify 的校验项，零值表示全部校验
+type Options struct {
+	SkipRoleID bool // 不检查请求中的 roleID，用于在引入会话校验之前就不检查 roleID 的 msg_id（如 30065）
+}
+
+// Verify 校验请求中的登录凭证
+// 依次检查：deviceID 对应的玩家是否存在(-3)、authKey 是否过期(-12)、authKey 是否匹配(-11)、
+// accountName 与 roleID 是否与数据库一致(-13)。校验通过后返回玩家数据和公开信息。
+// players 通常是本次请求的工作单元（repository.FromContext），这样处理器不会再次读取同一玩家。
+func Verify(players repository.PlayerRepository, msgData map[string]interface{}, opts Options) (*model.PlayerData, *utils.PublicInfo, error) {
+	// 1. 参数解析
+	deviceID, ok := msgData["deviceID"].(string)
+	if !ok || deviceID == "" {
+		log.Println("会话校验失败：缺少 'deviceID' 参数")
+		return nil, nil, game_error.New(-5, "缺少 'deviceID' 参数")
+	}
+
+	authKey, ok := msgData["authKey"].(string)
+	if !ok || authKey == "" {
+		log.Println("会话校验失败：缺少 'authKey' 参数")
+		return nil, nil, game_error.New(-5, "缺少 'authKey' 参数")
+	}
+
+	accountName, ok := msgData["accountName"].(string)
+	if !ok || accountName == "" {
+		log.Println("会话校验失败：缺少 'accountName' 参数")
+		return nil, nil, game_error.New(-5, "缺少 'accountName' 参数")
+	}
+
+	roleID := 0
+	if !opts.SkipRoleID {
+		roleIDValue, exists := msgData["roleID"]
+		if !exists {
+			log.Println("会话校验失败：缺少 'roleID' 参数")
+			return nil, nil, game_error.New(-5, "缺少 'roleID' 参数")
+		}
+		roleIDFloat, ok := roleIDValue.(float64)
+		if !ok {
+			log.Println("会话校验失败：'roleID' 参数类型错误")
+			return nil, nil, game_error.New(-13, "非法参数")
+		}
+		roleID = int(roleIDFloat)
+	}
+
+	// 2. 根据 deviceID 查询 dmm_playerdata
+	playerData, err := players.GetByDeviceID(deviceID)
+	if err != nil {
+		log.Printf("未找到 deviceID 为 '%s' 的玩家", deviceID)
+		return nil, nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	// 3. 验证 authKey 是否过期且匹配
+	currentTime := time.Now().Unix()
+	if playerData.AuthKeyExpire < currentTime {
+		log.Printf("authKey 已过期，过期时间: %d, 当前时间: %d", playerData.AuthKeyExpire, currentTime)
+		return nil, nil, game_error.New(-12, "登录秘钥失效，请重新登录")
+	}
+
+	if playerData.AuthKey != authKey {
+		log.Printf("authKey 不匹配，请求的 authKey: %s, 数据库中的 authKey: %s", authKey, playerData.AuthKey)
+		return nil, nil, game_error.New(-11, "登录验证错误，账号或已在别处登录")
+	}
+
+	// 4. 验证 accountName 和 roleID 是否与数据库中的匹配
+	pm := utils.NewPublicInfoManagerWithRepository(players)
+	publicInfo, err := pm.ParsePublicInfoFromJSON(playerData.PublicInfo)
+	if err != nil {
+		log.Printf("解析玩家公开信息失败: %v", err)
+		return nil, nil, game_error.New(-3, "获取玩家数据失败")
+	}
+
+	// 数据库中的名字为空时（新建账号），使用请求中的 accountName 补全
+	if publicInfo.Name == "" {
+		log.Printf("数据库中的 accountName 为空，使用请求中的 accountName: %s 更新数据库", accountName)
+		publicInfo.Name = accountName
+		if err := pm.SavePublicInfo(deviceID, publicInfo); err != nil {
+			log.Printf("更新玩家公开信息失败: %v", err)
+			// 即使更新失败，仍然继续处理请求
+		}
+	} else if publicInfo.Name != accountName {
+		log.Printf("accountName 不匹配，请求的 accountName: %s, 数据库中的 accountName: %s", accountName, publicInfo.Name)
+		return nil, nil, game_error.New(-13, "非法参数")
+	}
+
+	if !opts.SkipRoleID && playerData.RoleID != roleID {
+		log.Printf("roleID 不匹配，请求的 roleID: %d, 数据库中的 roleID: %d", roleID, playerData.RoleID)
+		return nil, nil, game_error.New(-13, "非法参数")
+	}
+
+	return playerData, publicInfo, nil
+}
+
+// Set 将校验通过的玩家数据和公开信息存入上下文
+func Set(c *gin.Context, playerData *model.PlayerData, publicInfo *utils.PublicInfo) {
+	c.Set(playerDataKey, playerData)
+	c.Set(publicInfoKey, publicInfo)
+}
+
+// PlayerData 获取当前会话中已校验的玩家数据
+// 只有注册时声明了 WithSession 的 msg_id 才会有值
+func PlayerData(c *gin.Context) (*model.PlayerData, bool) {
+	value, exists := c.Get(playerDataKey)
+	if !exists {
+		return nil, false
+	}
+	playerData, ok := value.(*model.PlayerData)
+	return playerData, ok
+}
+
+// PublicInfo 获取当前会话中已校验的玩家公开信息
+func PublicInfo(c *gin.Context) (*utils.PublicInfo, bool) {
+	value, exists := c.Get(publicInfoKey)
+	if !exists {
+		return nil, false
+	}
+	publicInfo, ok := value.(*utils.PublicInfo)
+	return publicInfo, ok
+}
@@ -13,15 +13,19 @@ import (
 )
 
 var (
-	ipBans                    sync.Map    // key: IP地址 (string), value: true (bool)
-	deviceIDBans              sync.Map    // key: DeviceID (string), value: true (bool)
-	realDeviceIDBans          sync.Map    // key: RealDeviceID (string), value: true (bool)
-	deviceInfoBans            sync.Map    // key: DeviceInfo (string), value: true (bool)
+	// 每次刷新都会构建新的 sync.Map 并整体原子替换，避免复制 sync.Map
+	ipBans                    atomic.Pointer[sync.Map] // key: IP地址 (string), value: true (bool)
+	deviceIDBans              atomic.Pointer[sync.Map] // key: DeviceID (string), value: true (bool)
+	realDeviceIDBans          atomic.Pointer[sync.Map] // key: RealDeviceID (string), value: true (bool)
+	deviceInfoBans            atomic.Pointer[sync.Map] // key: DeviceInfo (string), value: true (bool)
 
 	activityInWindow          atomic.Bool // 标记在当前窗口内是否有客户端活动
 	lastRefreshTime           time.Time   // 记录上次成功刷新封禁列表的时间
 	refreshMutex              sync.Mutex  // 互斥锁，确保同一时间只有一个协程在刷新列表
 	noActivityStreakStartTime time.Time   // 记录无活动周期的开始时间，用于15分钟暂停逻辑
+
+	stopCh   = make(chan struct{}) // 关闭后后台刷新协程退出
+	stopOnce sync.Once
 )
 
 const (
@@ -45,42 +49,42 @@ func loadBansFromDB() {
 	// 重新加载IP封禁
 	var ips []model.BanIP
 	db.DB.Find(&ips) // 从数据库中查找所有IP封禁记录
-	var newIpBans sync.Map // 创建一个新的map来存储数据
+	newIpBans := &sync.Map{} // 创建一个新的map来存储数据
 	for _, ban := range ips {
 		newIpBans.Store(ban.IP, true) // 将IP作为key存入map
 	}
-	ipBans = newIpBans // 原子替换旧的map，确保数据一致性
+	ipBans.Store(newIpBans) // 原子替换旧的map，确保数据一致性
 	log.Printf("Loaded %d IP bans.", len(ips))
 
 	// 重新加载DeviceID封禁
 	var devices []model.BanDeviceID
 	db.DB.Find(&devices)
-	var newDeviceIDBans sync.Map
+	newDeviceIDBans := &sync.Map{}
 	for _, ban := range devices {
 		newDeviceIDBans.Store(ban.DeviceID, true)
 	}
-	deviceIDBans = newDeviceIDBans
+	deviceIDBans.Store(newDeviceIDBans)
 	log.Printf("Loaded %d DeviceID bans.", len(devices))
 
 	// 重新加载RealDeviceID封禁 (假设RealDeviceID在model中也有对应表)
 	var realDevices []model.BanRealDeviceID
 	db.DB.Find(&realDevices)
-	var newRealDeviceIDBans sync.Map
+	newRealDeviceIDBans := &sync.Map{}
 	for _, ban := range realDevices {
 		newRealDeviceIDBans.Store(ban.RealDeviceID, true)
 	}
-	realDeviceIDBans = newRealDeviceIDBans
+	realDeviceIDBans.Store(newRealDeviceIDBans)
 	log.Printf("Loaded %d RealDeviceID bans.", len(realDevices))
 	
 	// 重新加载DeviceInfo封禁
 	var deviceInfos []model.BanDeviceInfo
 	db.DB.Find(&deviceInfos)
-	var newDeviceInfoBans sync.Map
+	newDeviceInfoBans := &sync.Map{}
 	for _, ban := range deviceInfos {
 		stableDeviceInfo := model.GetStableDeviceInfo(ban.DeviceInfo)
 		newDeviceInfoBans.Store(stableDeviceInfo, true)
 	}
-	deviceInfoBans = newDeviceInfoBans
+	deviceInfoBans.Store(newDeviceInfoBans)
 	log.Printf("Loaded %d DeviceInfo bans.", len(deviceInfos))
 
 	lastRefreshTime = time.Now() // 更新上次刷新时间
@@ -91,7 +95,14 @@ func startIntelligentTicker() {
 	ticker := time.NewTicker(refreshInterval) // 定时器周期调整为55秒
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-stopCh:
+			log.Println("Banning service ticker stopped.")
+			return
+		case <-ticker.C:
+		}
+
 		if activityInWindow.Load() {
 			activityInWindow.Store(false)       // 重置活动标记，为下一个窗口期做准备
 			noActivityStreakStartTime = time.Time{} // 客户端有活动，重置无活动周期的开始时间
@@ -123,6 +134,13 @@ func startIntelligentTicker() {
 	}
 }
 
+// Stop 停止后台刷新协程，由bootstrap在关闭服务器时调用
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopCh)
+	})
+}
+
 // NotifyActivity 由API中间件调用，标记发生了客户端活动
 func NotifyActivity() {
 	activityInWindow.Store(true) // 设置原子布尔值为true
@@ -150,7 +168,11 @@ func CheckRequestIPBanned(c *gin.Context) bool {
 	if ip == "" {
 		return false // 无法获取IP，暂时放行
 	}
-	_, found := ipBans.Load(ip) // 在内存map中查找
+	bans := ipBans.Load()
+	if bans == nil {
+		return false
+	}
+	_, found := bans.Load(ip) // 在内存map中查找
 	return found
 }
 
@@ -159,7 +181,11 @@ func CheckDeviceIDBanned(deviceID string) bool {
 	if deviceID == "" {
 		return false
 	}
-	_, found := deviceIDBans.Load(deviceID)
+	bans := deviceIDBans.Load()
+	if bans == nil {
+		return false
+	}
+	_, found := bans.Load(deviceID)
 	return found
 }
 
@@ -168,7 +194,11 @@ func CheckRealDeviceIDBanned(realDeviceID string) bool {
 	if realDeviceID == "" {
 		return false
 	}
-	_, found := realDeviceIDBans.Load(realDeviceID)
+	bans := realDeviceIDBans.Load()
+	if bans == nil {
+		return false
+	}
+	_, found := bans.Load(realDeviceID)
 	return found
 }
 
@@ -179,8 +209,12 @@ func CheckDeviceInfoBanned(deviceInfo string) bool {
 	}
 	// 使用工具函数获取稳定的设备信息（移除变化的内存值部分）
 	stableDeviceInfo := model.GetStableDeviceInfo(deviceInfo)
+	bans := deviceInfoBans.Load()
+	if bans == nil {
+		return false
+	}
 	var found bool
-	deviceInfoBans.Range(func(key, value interface{}) bool {
+	bans.Range(func(key, value interface{}) bool {
 		// 由于封禁列表中的deviceInfo也已经处理过，这里可以直接比较
 		if key.(string) == stableDeviceInfo {
 			found = true
@@ -189,5 +223,4 @@ func CheckDeviceInfoBanned(deviceInfo string) bool {
 		return true // 继续遍历
 	})
 	return found
-	return found
 }
\ No newline at end of file
@@ -0,0 +1,135 @@
+// internal/services/cardupgrade/cardupgrade.go
+package cardupgrade
+
+import (
+	"log"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/gorm"
+)
+
+// reasonCardUpgrade 卡牌升级写入钱包流水的原因
+const reasonCardUpgrade = "cardUpgrade"
+
+// Result 是一次升级的结果
+type Result struct {
+	CardID int   // 卡牌ID
+	Level  int   // 升级后的等级
+	Pieces int   // 剩余的该卡牌碎片数量
+	Gold   int64 // 剩余的金币
+}
+
+// Service 在一个数据库事务中扣除碎片和金币并提升卡牌等级
+type Service struct {
+	db      *gorm.DB
+	table   *LevelTable
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接和升级消耗表的升级服务
+func NewService(database *gorm.DB, table *LevelTable) *Service {
+	return &Service{db: database, table: table}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// Upgrade 使用全局数据库连接和当前升级消耗表升级卡牌，与请求级工作单元 players 一起提交，详见 Service.Upgrade
+func Upgrade(players repository.PlayerRepository, deviceID string, cardID int, orderKey string, msgID string) (*Result, error) {
+	return NewService(db.DB, CurrentLevelTable()).WithRequest(players).Upgrade(deviceID, cardID, orderKey, msgID)
+}
+
+// Upgrade 把卡牌升一级，按升级消耗表扣除该卡牌的碎片和金币
+// orderKey 由 repository.RequestKey 生成，客户端重发同一个请求时返回 -8，升级记录与扣除和升级在同一个事务中写入。
+// 碎片不足返回 -52，金币不足返回 -4，未拥有该卡牌或已达到最高等级返回 -13；任何一步失败都不会产生修改。
+func (s *Service) Upgrade(deviceID string, cardID int, orderKey string, msgID string) (*Result, error) {
+	if deviceID == "" || orderKey == "" {
+		return nil, game_error.New(-5, "缺少升级参数")
+	}
+
+	var result *Result
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		var count int64
+		if err := tx.Model(&model.CardUpgrade{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return game_error.New(-8, "重复的升级请求")
+		}
+
+		cm := utils.NewCardManagerWithRepository(players)
+
+		cards, err := cm.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
+		level := -1
+		for _, card := range cards {
+			if card.ID == cardID {
+				level = card.Level
+				break
+			}
+		}
+		if level < 0 {
+			return game_error.New(-13, "未拥有该卡牌")
+		}
+		cost, ok := s.table.CostFor(level + 1)
+		if !ok {
+			log.Printf("卡牌 %d 已达到最高等级 %d, deviceID=%s", cardID, level, deviceID)
+			return game_error.New(-13, "卡牌已达到最高等级")
+		}
+
+		card, pieces, err := cm.UpgradeCard(deviceID, cardID, level, cost.Pieces)
+		if err != nil {
+			return err
+		}
+		var gold int64
+		if cost.Gold > 0 {
+			if gold, err = wallet.NewService(tx).Debit(deviceID, wallet.Gold, cost.Gold, wallet.Trace{Reason: reasonCardUpgrade, MsgID: msgID, RequestKey: orderKey}); err != nil {
+				return err
+			}
+		} else {
+			playerWallet, err := wallet.NewService(tx).Get(deviceID)
+			if err != nil {
+				return err
+			}
+			gold = playerWallet.Gold
+		}
+
+		upgrade := model.CardUpgrade{
+			DeviceID: deviceID,
+			OrderKey: orderKey,
+			CardID:   cardID,
+			Level:    card.Level,
+			Pieces:   cost.Pieces,
+			Gold:     cost.Gold,
+		}
+		if err := tx.Create(&upgrade).Error; err != nil {
+			return err
+		}
+		result = &Result{CardID: cardID, Level: card.Level, Pieces: pieces, Gold: gold}
+		return nil
+	})
+	if err != nil && s.alreadyUpgraded(deviceID, orderKey) {
+		// 并发的相同请求已经先一步完成升级（升级记录唯一索引冲突）
+		err = game_error.New(-8, "重复的升级请求")
+	}
+	return result, repository.ToGameError(err, "升级卡牌失败, deviceID=%s, cardID=%d", deviceID, cardID)
+}
+
+// alreadyUpgraded 检查升级记录是否已经存在
+func (s *Service) alreadyUpgraded(deviceID string, orderKey string) bool {
+	var count int64
+	err := s.db.Model(&model.CardUpgrade{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error
+	return err == nil && count > 0
+}
@@ -0,0 +1,104 @@
+// internal/services/cardupgrade/levels.go
+package cardupgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// levelTablePath 卡牌升级消耗表数据文件的路径
+const levelTablePath = "configs/card_levels.json"
+
+// LevelCost 升到 Level 级需要消耗的碎片和金币
+type LevelCost struct {
+	Level  int   `json:"level"`
+	Pieces int   `json:"pieces"`
+	Gold   int64 `json:"gold"`
+}
+
+// levelTableFile 是 card_levels.json 的结构
+type levelTableFile struct {
+	Levels []LevelCost `json:"levels"`
+}
+
+// LevelTable 卡牌升级消耗表，等级从 2 开始连续配置，最后一项即最高等级
+type LevelTable struct {
+	costs map[int]LevelCost
+	max   int
+}
+
+// NewLevelTable 创建升级消耗表，并检查等级从 2 开始连续、消耗不为负数
+func NewLevelTable(levels []LevelCost) (*LevelTable, error) {
+	table := &LevelTable{costs: make(map[int]LevelCost, len(levels)), max: 1}
+	for _, cost := range levels {
+		if cost.Level != table.max+1 {
+			return nil, fmt.Errorf("card level %d is out of order, expected %d", cost.Level, table.max+1)
+		}
+		if cost.Pieces < 0 || cost.Gold < 0 {
+			return nil, fmt.Errorf("card level %d has negative cost", cost.Level)
+		}
+		table.costs[cost.Level] = cost
+		table.max = cost.Level
+	}
+	return table, nil
+}
+
+// LoadLevelTable 从JSON文件加载升级消耗表
+func LoadLevelTable(path string) (*LevelTable, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file levelTableFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewLevelTable(file.Levels)
+}
+
+// CostFor 返回升到 level 级的消耗，超过最高等级时第二个返回值为 false
+func (t *LevelTable) CostFor(level int) (LevelCost, bool) {
+	cost, ok := t.costs[level]
+	return cost, ok
+}
+
+// MaxLevel 返回最高等级
+func (t *LevelTable) MaxLevel() int {
+	return t.max
+}
+
+var (
+	levelTableMu sync.RWMutex
+	levelTable   *LevelTable
+)
+
+// Init 加载 configs/card_levels.json，由bootstrap调用
+func Init() error {
+	loaded, err := LoadLevelTable(levelTablePath)
+	if err != nil {
+		return err
+	}
+	SetLevelTable(loaded)
+	log.Printf("Card level table loaded: max level %d", loaded.max)
+	return nil
+}
+
+// CurrentLevelTable 返回当前使用的升级消耗表，未加载时返回空表（卡牌无法升级）
+func CurrentLevelTable() *LevelTable {
+	levelTableMu.RLock()
+	defer levelTableMu.RUnlock()
+	if levelTable == nil {
+		return &LevelTable{costs: map[int]LevelCost{}, max: 1}
+	}
+	return levelTable
+}
+
+// SetLevelTable 替换当前使用的升级消耗表
+func SetLevelTable(t *LevelTable) {
+	levelTableMu.Lock()
+	defer levelTableMu.Unlock()
+	levelTable = t
+}
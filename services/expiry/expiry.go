@@ -0,0 +1,126 @@
+// internal/services/expiry/expiry.go
+package expiry
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/model"
+	"dmmserver/utils"
+)
+
+const (
+	defaultSweepInterval = 10 * time.Minute // 未配置 expiry.sweepIntervalSeconds 时的清理周期
+	defaultBatchSize     = 200              // 未配置 expiry.batchSize 时每批读取的玩家数量
+)
+
+var (
+	stopCh   = make(chan struct{}) // 关闭后后台清理协程退出
+	stopOnce sync.Once
+	running  sync.WaitGroup // 后台清理协程
+)
+
+// Init 模块初始化函数，由bootstrap调用
+func Init() {
+	log.Println("Expiry sweeper is starting...")
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		startSweepTicker() // 启动后台清理协程
+	}()
+	log.Println("Expiry sweeper started successfully.")
+}
+
+// Stop 停止后台清理协程并等待它退出，由bootstrap在关闭数据库之前调用
+// 正在清理的玩家处理完后协程才会退出，之后不会再访问数据库。
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopCh)
+	})
+	running.Wait()
+}
+
+// sweepInterval 返回配置的清理周期
+func sweepInterval() time.Duration {
+	if conf.Conf != nil && conf.Conf.Expiry.SweepIntervalSeconds > 0 {
+		return time.Duration(conf.Conf.Expiry.SweepIntervalSeconds) * time.Second
+	}
+	return defaultSweepInterval
+}
+
+// batchSize 返回配置的每批玩家数量
+func batchSize() int {
+	if conf.Conf != nil && conf.Conf.Expiry.BatchSize > 0 {
+		return conf.Conf.Expiry.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// startSweepTicker 启动时先清理一次，之后按周期清理所有玩家
+func startSweepTicker() {
+	interval := sweepInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		SweepAll(time.Now())
+
+		select {
+		case <-stopCh:
+			log.Println("Expiry sweeper ticker stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepAll 按 deviceID 顺序分批遍历所有玩家，清理在 now 时已过期的物品
+// 单个玩家清理失败只记录日志，不影响其他玩家；服务器关闭时在当前玩家处理完后停止。
+func SweepAll(now time.Time) utils.SweepResult {
+	var total utils.SweepResult
+	manager := utils.NewExpiryManager()
+	size := batchSize()
+	players := 0
+	lastDeviceID := ""
+
+	for {
+		var deviceIDs []string
+		if err := db.DB.Model(&model.PlayerData{}).
+			Where("device_id > ?", lastDeviceID).
+			Order("device_id").
+			Limit(size).
+			Pluck("device_id", &deviceIDs).Error; err != nil {
+			log.Printf("读取玩家列表失败，本轮过期清理中止: %v", err)
+			break
+		}
+
+		for _, deviceID := range deviceIDs {
+			select {
+			case <-stopCh:
+				return total
+			default:
+			}
+
+			result, err := manager.SweepPlayer(deviceID, now)
+			if err != nil {
+				log.Printf("清理玩家 %s 的过期物品失败: %v", deviceID, err)
+				continue
+			}
+			total.Add(result)
+			players++
+		}
+
+		if len(deviceIDs) < size {
+			break
+		}
+		lastDeviceID = deviceIDs[len(deviceIDs)-1]
+	}
+
+	if total.Removed > 0 || total.Unequipped > 0 {
+		log.Printf("过期清理完成：检查玩家 %d 个，移除过期物品 %d 个，卸下装备 %d 个", players, total.Removed, total.Unequipped)
+	}
+	return total
+}
@@ -0,0 +1,77 @@
+// internal/services/giftcode/codes.go
+package giftcode
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"dmmserver/game_error"
+)
+
+// codeAlphabet 生成卡号使用的字符，去掉了容易混淆的 0、O、1、I
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// maxCodeLength 卡号（包括前缀）的最大长度，与 dmm_gift_codes.code 列的长度一致
+const maxCodeLength = 64
+
+// GenerateCodes 生成 count 个互不相同的随机卡号，每个卡号由 prefix 和 length 个随机字符组成
+func GenerateCodes(count int, length int, prefix string) ([]string, error) {
+	prefix = normalizeCode(prefix)
+	if count <= 0 || length < 6 || len(prefix)+length > maxCodeLength {
+		return nil, fmt.Errorf("invalid code count %d or length %d", count, length)
+	}
+	alphabetSize := big.NewInt(int64(len(codeAlphabet)))
+	seen := make(map[string]bool, count)
+	codes := make([]string, 0, count)
+	for len(codes) < count {
+		var b strings.Builder
+		b.WriteString(prefix)
+		for i := 0; i < length; i++ {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(codeAlphabet[n.Int64()])
+		}
+		code := b.String()
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeCode 去掉卡号两端的空白并转换为大写，兑换时不区分大小写
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// normalizeCodes 转换并检查一批卡号：不能为空或重复，只能包含字母、数字、"-" 和 "_"
+func normalizeCodes(codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, game_error.New(-5, "缺少礼包卡号")
+	}
+	seen := make(map[string]bool, len(codes))
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = normalizeCode(code)
+		if code == "" || len(code) > maxCodeLength || strings.IndexFunc(code, invalidCodeRune) >= 0 {
+			return nil, game_error.New(-13, fmt.Sprintf("礼包卡号 %q 无效", code))
+		}
+		if seen[code] {
+			return nil, game_error.New(-13, fmt.Sprintf("礼包卡号 %s 重复", code))
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	return normalized, nil
+}
+
+// invalidCodeRune 判断卡号中是否包含不允许的字符
+func invalidCodeRune(r rune) bool {
+	return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
@@ -0,0 +1,247 @@
+// internal/services/giftcode/giftcode.go
+package giftcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+
+	"gorm.io/gorm"
+)
+
+// insertBatchSize 创建批次时每条 INSERT 写入的卡号数量
+const insertBatchSize = 500
+
+// Batch 描述要创建的一批礼包卡号
+type Batch struct {
+	Name         string
+	Rewards      []reward.Item
+	MaxUses      int   // 每个卡号可以被兑换的总次数，1 表示一次性卡号，0 表示不限
+	OnePerPlayer bool  // 每个玩家在该批次中只能兑换一个卡号
+	StartAt      int64 // 开始兑换的时间（Unix 秒），0 表示不限
+	EndAt        int64 // 停止兑换的时间（Unix 秒），0 表示不限
+}
+
+// Service 管理礼包卡号批次，并在一个数据库事务中完成兑换和发放奖励
+type Service struct {
+	db      *gorm.DB
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接的礼包卡号服务
+func NewService(database *gorm.DB) *Service {
+	return &Service{db: database}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// Redeem 使用全局数据库连接兑换礼包卡号，与请求级工作单元 players 一起提交，详见 Service.Redeem
+func Redeem(players repository.PlayerRepository, deviceID string, code string) ([]reward.Item, error) {
+	return NewService(db.DB).WithRequest(players).Redeem(deviceID, code, time.Now())
+}
+
+// CreateBatch 保存一个批次及其全部卡号，卡号由 GenerateCodes 生成或从文件导入
+// 卡号统一转换为大写，与已有卡号重复时整批都不会保存。
+func (s *Service) CreateBatch(b Batch, codes []string) (*model.GiftCodeBatch, error) {
+	if b.Name == "" || len(b.Rewards) == 0 {
+		return nil, game_error.New(-5, "缺少礼包参数")
+	}
+	if b.MaxUses < 0 {
+		return nil, game_error.New(-13, "兑换次数不能为负数")
+	}
+	if b.StartAt != 0 && b.EndAt != 0 && b.EndAt <= b.StartAt {
+		return nil, game_error.New(-13, "停止兑换的时间必须晚于开始时间")
+	}
+	for _, item := range b.Rewards {
+		if err := reward.Validate(item); err != nil {
+			return nil, err
+		}
+	}
+	normalized, err := normalizeCodes(codes)
+	if err != nil {
+		return nil, err
+	}
+	rewardsJSON, err := json.Marshal(b.Rewards)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &model.GiftCodeBatch{
+		Name:         b.Name,
+		Rewards:      string(rewardsJSON),
+		MaxUses:      b.MaxUses,
+		OnePerPlayer: b.OnePerPlayer,
+		StartAt:      b.StartAt,
+		EndAt:        b.EndAt,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		rows := make([]model.GiftCode, len(normalized))
+		for i, code := range normalized {
+			rows[i] = model.GiftCode{Code: code, BatchID: batch.ID}
+		}
+		return tx.CreateInBatches(rows, insertBatchSize).Error
+	})
+	if err != nil {
+		log.Printf("保存礼包批次失败: %v", err)
+		return nil, game_error.New(-2, "数据库写入错误")
+	}
+	log.Printf("已保存礼包批次 %d: %s，共 %d 个卡号", batch.ID, batch.Name, len(normalized))
+	return batch, nil
+}
+
+// ListBatches 返回全部批次，按ID从新到旧
+func (s *Service) ListBatches() ([]model.GiftCodeBatch, error) {
+	var batches []model.GiftCodeBatch
+	if err := s.db.Order("id DESC").Find(&batches).Error; err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// Batch 按ID查找批次，不存在时返回 -39
+func (s *Service) Batch(batchID uint64) (*model.GiftCodeBatch, error) {
+	var batch model.GiftCodeBatch
+	if err := s.db.First(&batch, batchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, game_error.New(-39, "礼包批次不存在")
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// Codes 返回批次中的全部卡号及其已兑换次数，按卡号排序
+func (s *Service) Codes(batchID uint64) ([]model.GiftCode, error) {
+	var codes []model.GiftCode
+	if err := s.db.Where("batch_id = ?", batchID).Order("code").Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Redeem 兑换一个礼包卡号，返回发放的奖励
+// 卡号不存在、不在兑换时间内或兑换次数已用完返回 -39，玩家已兑换过该卡号返回 -40，
+// 玩家已兑换过每人限领一次的批次中的其他卡号返回 -42；兑换次数、兑换记录和奖励在同一个事务中写入。
+func (s *Service) Redeem(deviceID string, code string, now time.Time) ([]reward.Item, error) {
+	code = normalizeCode(code)
+	if deviceID == "" || code == "" {
+		return nil, game_error.New(-39, "礼包卡号无效")
+	}
+
+	var items []reward.Item
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		var giftCode model.GiftCode
+		if err := tx.Where("code = ?", code).First(&giftCode).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return game_error.New(-39, "礼包卡号无效")
+			}
+			return err
+		}
+		var batch model.GiftCodeBatch
+		if err := tx.First(&batch, giftCode.BatchID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return game_error.New(-39, "礼包卡号无效")
+			}
+			return err
+		}
+		if !activeAt(&batch, now) {
+			log.Printf("礼包卡号 %s 不在兑换时间内, deviceID=%s", code, deviceID)
+			return game_error.New(-39, "礼包卡号无效")
+		}
+
+		var count int64
+		if err := tx.Model(&model.GiftCodeRedemption{}).Where("code = ? AND device_id = ?", code, deviceID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return game_error.New(-40, "您已获得该奖励")
+		}
+		if batch.OnePerPlayer {
+			if err := tx.Model(&model.GiftCodeRedemption{}).Where("batch_id = ? AND device_id = ?", batch.ID, deviceID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return game_error.New(-42, "您已领取过该种礼包")
+			}
+		}
+
+		// 带次数条件的 UPDATE 保证并发兑换时一次性卡号只会被兑换一次
+		used := tx.Model(&model.GiftCode{}).Where("code = ?", code)
+		if batch.MaxUses > 0 {
+			used = used.Where("uses < ?", batch.MaxUses)
+		}
+		result := used.Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			log.Printf("礼包卡号 %s 的兑换次数已用完, deviceID=%s", code, deviceID)
+			return game_error.New(-39, "礼包卡号无效")
+		}
+
+		rewards, err := parseRewards(&batch)
+		if err != nil {
+			return err
+		}
+		granted, err := reward.GrantTx(tx, players, deviceID, grantKey(&batch, code), rewards)
+		if err != nil {
+			return err
+		}
+		if !granted {
+			// 并发的兑换请求已经先一步发放了奖励
+			if batch.OnePerPlayer {
+				return game_error.New(-42, "您已领取过该种礼包")
+			}
+			return game_error.New(-40, "您已获得该奖励")
+		}
+		if err := tx.Create(&model.GiftCodeRedemption{Code: code, DeviceID: deviceID, BatchID: batch.ID}).Error; err != nil {
+			return err
+		}
+		items = rewards
+		return nil
+	})
+	if err == nil {
+		log.Printf("玩家 %s 兑换了礼包卡号 %s", deviceID, code)
+	}
+	return items, repository.ToGameError(err, "兑换礼包卡号失败, deviceID=%s, code=%s", deviceID, code)
+}
+
+// activeAt 判断批次在时刻 t 是否可以兑换
+func activeAt(batch *model.GiftCodeBatch, t time.Time) bool {
+	now := t.Unix()
+	return (batch.StartAt == 0 || now >= batch.StartAt) && (batch.EndAt == 0 || now < batch.EndAt)
+}
+
+// grantKey 返回发放奖励使用的幂等键，每人限领一次的批次按批次去重，其他批次按卡号去重
+func grantKey(batch *model.GiftCodeBatch, code string) string {
+	if batch.OnePerPlayer {
+		return fmt.Sprintf("giftcode:batch:%d", batch.ID)
+	}
+	return "giftcode:" + code
+}
+
+// parseRewards 解析批次的奖励列表
+func parseRewards(batch *model.GiftCodeBatch) ([]reward.Item, error) {
+	var rewards []reward.Item
+	if err := json.Unmarshal([]byte(batch.Rewards), &rewards); err != nil {
+		log.Printf("解析礼包批次 %d 的奖励失败: %v", batch.ID, err)
+		return nil, game_error.New(-54, "操作失败（配置错误），请重试")
+	}
+	return rewards, nil
+}
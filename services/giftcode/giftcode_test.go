@@ -0,0 +1,111 @@
+// internal/services/giftcode/giftcode_test.go
+package giftcode
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+	"dmmserver/utils"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 重复兑换同一个卡号返回 -40，每人限领一次的批次中兑换第二个卡号返回 -42，两种情况都不会再次发放奖励
+func TestRedeemRejectsRepeats(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "giftcode.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	registry, err := utils.LoadItemRegistry("../../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	utils.SetItemRegistry(registry)
+
+	const deviceID = "device-giftcode"
+	players := &repository.GormPlayerRepository{}
+	assets := utils.NewAssetsManagerWithRepository(players)
+	assetsJSON, _ := json.Marshal(assets.GetDefaultAssetsData())
+	if err := players.Create(&model.PlayerData{DeviceID: deviceID, RoleID: 1000, AssetsData: string(assetsJSON)}); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+	before, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+
+	s := NewService(db.DB)
+	rewards := []reward.Item{{ItemID: 30, Count: 5}}
+	if _, err := s.CreateBatch(Batch{Name: "通用", Rewards: rewards}, []string{"SHARED"}); err != nil {
+		t.Fatalf("创建批次失败: %v", err)
+	}
+	limited, err := s.CreateBatch(Batch{Name: "限领", Rewards: rewards, MaxUses: 1, OnePerPlayer: true}, []string{"ONCE1", "ONCE2"})
+	if err != nil {
+		t.Fatalf("创建批次失败: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{"第一次兑换通用卡号", "shared", 0},
+		{"重复兑换同一个卡号", "SHARED", -40},
+		{"兑换限领批次的卡号", "ONCE1", 0},
+		{"重复兑换限领批次的同一个卡号", "ONCE1", -40},
+		{"兑换限领批次的另一个卡号", "ONCE2", -42},
+	}
+	for _, tt := range tests {
+		_, err := Redeem(players, deviceID, tt.code)
+		got := 0
+		if err != nil {
+			var gameErr *game_error.GameError
+			if !errors.As(err, &gameErr) {
+				t.Fatalf("%s: 返回了非游戏错误 %v", tt.name, err)
+			}
+			got = gameErr.Code
+		}
+		if got != tt.wantCode {
+			t.Fatalf("%s: 返回 %d (%v)，期望 %d", tt.name, got, err, tt.wantCode)
+		}
+	}
+
+	after, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	if after != before+10 {
+		t.Fatalf("资产 30 的数量为 %d，期望 %d", after, before+10)
+	}
+	codes, err := s.Codes(limited.ID)
+	if err != nil {
+		t.Fatalf("读取卡号失败: %v", err)
+	}
+	for _, code := range codes {
+		if code.Code == "ONCE2" && code.Uses != 0 {
+			t.Fatalf("被拒绝的卡号 ONCE2 的兑换次数为 %d", code.Uses)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// internal/services/mail/broadcast.go
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Broadcast 描述一封群发邮件及其收件人筛选条件，各条件同时满足的玩家才会收到
+type Broadcast struct {
+	Title         string
+	Body          string
+	Attachments   []reward.Item
+	RoleIDs       []int // 收件人的 roleID，为空时不限
+	CreatedBefore int64 // 只发给在此时间之前创建的账号（Unix 秒），0 表示不限
+	VIPOnly       bool  // 只发给VIP玩家
+	PfID          int   // 只发给该平台的玩家，0 表示不限
+	SendAt        int64 // 开始投递的时间（Unix 秒），0 表示立即投递
+	ExpireAt      int64 // 过期时间（Unix 秒），0 表示永不过期
+}
+
+// SendBroadcast 使用全局数据库连接保存群发邮件，详见 Service.SendBroadcast
+func SendBroadcast(b Broadcast) (*model.BroadcastMail, error) {
+	return NewService(db.DB).SendBroadcast(b)
+}
+
+// DeliverBroadcasts 使用全局数据库连接投递群发邮件，与请求级工作单元 players 一起提交，详见 Service.DeliverBroadcasts
+func DeliverBroadcasts(players repository.PlayerRepository, deviceID string) (int, error) {
+	return NewService(db.DB).WithRequest(players).DeliverBroadcasts(deviceID, time.Now())
+}
+
+// SendBroadcast 保存一封群发邮件，玩家的副本在其下次登录或打开邮箱时生成
+func (s *Service) SendBroadcast(b Broadcast) (*model.BroadcastMail, error) {
+	if b.Title == "" {
+		return nil, game_error.New(-5, "缺少邮件参数")
+	}
+	if b.ExpireAt != 0 && b.ExpireAt <= b.SendAt {
+		return nil, game_error.New(-13, "过期时间必须晚于发送时间")
+	}
+	for _, item := range b.Attachments {
+		if err := reward.Validate(item); err != nil {
+			return nil, err
+		}
+	}
+	if b.Attachments == nil {
+		b.Attachments = []reward.Item{}
+	}
+	if b.RoleIDs == nil {
+		b.RoleIDs = []int{}
+	}
+	attachmentsJSON, err := json.Marshal(b.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	roleIDsJSON, err := json.Marshal(b.RoleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcast := &model.BroadcastMail{
+		Title:         b.Title,
+		Body:          b.Body,
+		Attachments:   string(attachmentsJSON),
+		RoleIDs:       string(roleIDsJSON),
+		CreatedBefore: b.CreatedBefore,
+		VIPOnly:       b.VIPOnly,
+		PfID:          b.PfID,
+		SendAt:        b.SendAt,
+		ExpireAt:      b.ExpireAt,
+	}
+	if err := s.db.Create(broadcast).Error; err != nil {
+		log.Printf("保存群发邮件失败: %v", err)
+		return nil, game_error.New(-2, "数据库写入错误")
+	}
+	log.Printf("已保存群发邮件 %d: %s", broadcast.ID, broadcast.Title)
+	return broadcast, nil
+}
+
+// ListBroadcasts 返回全部群发邮件，按ID从新到旧
+func (s *Service) ListBroadcasts() ([]model.BroadcastMail, error) {
+	var broadcasts []model.BroadcastMail
+	if err := s.db.Order("id DESC").Find(&broadcasts).Error; err != nil {
+		return nil, err
+	}
+	return broadcasts, nil
+}
+
+// CancelBroadcast 删除一封群发邮件，之后不再投递；已经投递到玩家邮箱的副本不受影响
+func (s *Service) CancelBroadcast(id uint64) error {
+	result := s.db.Delete(&model.BroadcastMail{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return game_error.New(-76, "邮件不存在")
+	}
+	return nil
+}
+
+// DeliverBroadcasts 为玩家生成在 now 时已开始投递、尚未过期且符合筛选条件的群发邮件副本，返回新投递的数量
+// 投递记录与副本在同一个事务中写入，投递记录的主键保证同一玩家只会收到一次。
+// 不符合筛选条件的群发邮件不做记录，之后条件满足时（如成为VIP）仍会投递。
+func (s *Service) DeliverBroadcasts(deviceID string, now time.Time) (int, error) {
+	count := 0
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, _ *repository.UnitOfWork) error {
+		count = 0
+		var player model.PlayerData
+		if err := tx.Where("device_id = ?", deviceID).First(&player).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return game_error.New(-3, "未找到玩家数据")
+			}
+			return err
+		}
+
+		delivered := tx.Model(&model.BroadcastMailState{}).Select("broadcast_id").Where("device_id = ?", deviceID)
+		var broadcasts []model.BroadcastMail
+		err := tx.Where("send_at <= ? AND (expire_at = 0 OR expire_at > ?)", now.Unix(), now.Unix()).
+			Where("id NOT IN (?)", delivered).
+			Order("id").Find(&broadcasts).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range broadcasts {
+			if !matches(&broadcasts[i], &player) {
+				continue
+			}
+			ok, err := deliver(tx, &broadcasts[i], deviceID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		log.Printf("已为玩家 %s 投递 %d 封群发邮件", deviceID, count)
+	}
+	return count, nil
+}
+
+// deliver 在事务 tx 中写入投递记录并在玩家邮箱中生成副本，其他请求已经投递过时返回 false
+func deliver(tx *gorm.DB, broadcast *model.BroadcastMail, deviceID string) (bool, error) {
+	state := model.BroadcastMailState{BroadcastID: broadcast.ID, DeviceID: deviceID}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&state)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	uuid, err := newUUID()
+	if err != nil {
+		return false, err
+	}
+	mail := model.Mail{
+		UUID:        uuid,
+		DeviceID:    deviceID,
+		Title:       broadcast.Title,
+		Body:        broadcast.Body,
+		Attachments: broadcast.Attachments,
+		ExpireAt:    broadcast.ExpireAt,
+	}
+	if err := tx.Create(&mail).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Model(&model.BroadcastMailState{}).
+		Where("broadcast_id = ? AND device_id = ?", broadcast.ID, deviceID).
+		Update("mail_id", mail.ID).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// matches 判断玩家是否符合群发邮件的筛选条件
+func matches(broadcast *model.BroadcastMail, player *model.PlayerData) bool {
+	var roleIDs []int
+	if broadcast.RoleIDs != "" && broadcast.RoleIDs != "null" {
+		if err := json.Unmarshal([]byte(broadcast.RoleIDs), &roleIDs); err != nil {
+			log.Printf("解析群发邮件 %d 的 roleID 列表失败: %v", broadcast.ID, err)
+			return false
+		}
+	}
+	if len(roleIDs) > 0 && !slices.Contains(roleIDs, player.RoleID) {
+		return false
+	}
+	if broadcast.CreatedBefore > 0 && player.CreateAccountTime >= broadcast.CreatedBefore {
+		return false
+	}
+	if broadcast.PfID != 0 && player.PfID != broadcast.PfID {
+		return false
+	}
+	if broadcast.VIPOnly {
+		var playtime struct {
+			IsVIP bool `json:"isVIP"`
+		}
+		if player.PlaytimeData == "" || json.Unmarshal([]byte(player.PlaytimeData), &playtime) != nil || !playtime.IsVIP {
+			return false
+		}
+	}
+	return true
+}
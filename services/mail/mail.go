@@ -0,0 +1,323 @@
+// internal/services/mail/mail.go
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+
+	"gorm.io/gorm"
+)
+
+// maxListed 邮件列表最多返回的邮件数量，按发送时间从新到旧
+const maxListed = 100
+
+// View 是返回给客户端的一封邮件
+type View struct {
+	MailID      uint64        `json:"mailID"`
+	Title       string        `json:"title"`
+	Body        string        `json:"body"`
+	Attachments []reward.Item `json:"attachments"`
+	IsRead      bool          `json:"isRead"`
+	IsClaimed   bool          `json:"isClaimed"`
+	CreateTime  int64         `json:"createTime"` // 发送时间（Unix 秒）
+	ExpireAt    int64         `json:"expireAt"`   // 过期时间（Unix 秒），0 表示永不过期
+}
+
+// Service 管理玩家邮箱，附件通过 reward.GrantTx 发放
+type Service struct {
+	db      *gorm.DB
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接的邮件服务
+func NewService(database *gorm.DB) *Service {
+	return &Service{db: database}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// Send 使用全局数据库连接发送邮件，详见 Service.Send
+func Send(deviceID string, title string, body string, attachments []reward.Item, expireAt int64) (*model.Mail, error) {
+	return NewService(db.DB).Send(deviceID, title, body, attachments, expireAt)
+}
+
+// List 使用全局数据库连接列出邮件，详见 Service.List
+func List(deviceID string) ([]View, error) {
+	return NewService(db.DB).List(deviceID, time.Now())
+}
+
+// Read 使用全局数据库连接阅读邮件，详见 Service.Read
+func Read(deviceID string, mailID uint64) (*View, error) {
+	return NewService(db.DB).Read(deviceID, mailID, time.Now())
+}
+
+// Claim 使用全局数据库连接领取附件，与请求级工作单元 players 一起提交，详见 Service.Claim
+func Claim(players repository.PlayerRepository, deviceID string, mailID uint64) ([]reward.Item, error) {
+	return NewService(db.DB).WithRequest(players).Claim(deviceID, mailID, time.Now())
+}
+
+// ClaimAll 使用全局数据库连接领取全部附件，与请求级工作单元 players 一起提交，详见 Service.ClaimAll
+func ClaimAll(players repository.PlayerRepository, deviceID string) ([]uint64, []reward.Item, error) {
+	return NewService(db.DB).WithRequest(players).ClaimAll(deviceID, time.Now())
+}
+
+// Delete 使用全局数据库连接删除邮件，详见 Service.Delete
+func Delete(deviceID string, mailID uint64) error {
+	return NewService(db.DB).Delete(deviceID, mailID, time.Now())
+}
+
+// Send 给玩家发送一封邮件，expireAt 为过期时间（Unix 秒），0 表示永不过期
+// 附件中的物品必须已在物品注册表中登记；玩家不存在时返回 -3。
+func (s *Service) Send(deviceID string, title string, body string, attachments []reward.Item, expireAt int64) (*model.Mail, error) {
+	if deviceID == "" || title == "" {
+		return nil, game_error.New(-5, "缺少邮件参数")
+	}
+	for _, item := range attachments {
+		if err := reward.Validate(item); err != nil {
+			return nil, err
+		}
+	}
+	if attachments == nil {
+		attachments = []reward.Item{}
+	}
+	attachmentsJSON, err := json.Marshal(attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		log.Printf("检查邮件收件人失败, deviceID=%s: %v", deviceID, err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	if count == 0 {
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	mail := &model.Mail{UUID: uuid, DeviceID: deviceID, Title: title, Body: body, Attachments: string(attachmentsJSON), ExpireAt: expireAt}
+	if err := s.db.Create(mail).Error; err != nil {
+		log.Printf("发送邮件失败, deviceID=%s: %v", deviceID, err)
+		return nil, game_error.New(-2, "数据库写入错误")
+	}
+	log.Printf("已发送邮件 %d 给玩家 %s: %s", mail.ID, deviceID, title)
+	return mail, nil
+}
+
+// List 返回玩家在 now 时未过期的邮件，返回前先投递尚未收到的群发邮件，并删除已经过期的邮件
+func (s *Service) List(deviceID string, now time.Time) ([]View, error) {
+	if _, err := s.DeliverBroadcasts(deviceID, now); err != nil {
+		log.Printf("投递群发邮件失败, deviceID=%s: %v", deviceID, err)
+	}
+	if err := s.db.Where("device_id = ? AND expire_at > 0 AND expire_at <= ?", deviceID, now.Unix()).Delete(&model.Mail{}).Error; err != nil {
+		log.Printf("删除过期邮件失败, deviceID=%s: %v", deviceID, err)
+	}
+
+	var mails []model.Mail
+	err := s.active(s.db, deviceID, now).Order("id DESC").Limit(maxListed).Find(&mails).Error
+	if err != nil {
+		log.Printf("读取邮件失败, deviceID=%s: %v", deviceID, err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	views := make([]View, 0, len(mails))
+	for i := range mails {
+		view, err := toView(&mails[i])
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, *view)
+	}
+	return views, nil
+}
+
+// Read 把邮件标记为已读并返回邮件内容，邮件不存在或已过期返回 -76
+func (s *Service) Read(deviceID string, mailID uint64, now time.Time) (*View, error) {
+	mail, err := s.find(s.db, deviceID, mailID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !mail.IsRead {
+		if err := s.db.Model(&model.Mail{}).Where("id = ?", mail.ID).Update("is_read", true).Error; err != nil {
+			log.Printf("标记邮件已读失败, mailID=%d: %v", mail.ID, err)
+			return nil, game_error.New(-2, "数据库写入错误")
+		}
+		mail.IsRead = true
+	}
+	return toView(mail)
+}
+
+// Claim 领取一封邮件的附件并把邮件标记为已读，返回发放的物品
+// 错误码：-76 邮件不存在或已过期，-77 没有附件或附件已领取。
+func (s *Service) Claim(deviceID string, mailID uint64, now time.Time) ([]reward.Item, error) {
+	var items []reward.Item
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		mail, err := s.find(tx, deviceID, mailID, now)
+		if err != nil {
+			return err
+		}
+		if items, err = claimMail(tx, players, mail); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return game_error.New(-77, "邮件附件不存在")
+		}
+		return nil
+	})
+	return items, repository.ToGameError(err, "领取邮件附件失败, deviceID=%s, mailID=%d", deviceID, mailID)
+}
+
+// ClaimAll 领取玩家所有未过期邮件中尚未领取的附件，返回领取了附件的邮件ID和发放的全部物品
+// 全部附件在同一个事务中发放，任何一封邮件的附件发放失败都不会产生修改。
+func (s *Service) ClaimAll(deviceID string, now time.Time) ([]uint64, []reward.Item, error) {
+	var mailIDs []uint64
+	var items []reward.Item
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		mailIDs, items = []uint64{}, []reward.Item{}
+		var mails []model.Mail
+		if err := s.active(tx, deviceID, now).Where("is_claimed = ?", false).Order("id").Find(&mails).Error; err != nil {
+			return err
+		}
+		for i := range mails {
+			claimed, err := claimMail(tx, players, &mails[i])
+			if err != nil {
+				return err
+			}
+			if len(claimed) > 0 {
+				mailIDs = append(mailIDs, mails[i].ID)
+				items = append(items, claimed...)
+			}
+		}
+		return nil
+	})
+	return mailIDs, items, repository.ToGameError(err, "领取全部邮件附件失败, deviceID=%s", deviceID)
+}
+
+// Delete 删除一封邮件
+// 错误码：-76 邮件不存在或已过期，-79 邮件未读，-78 附件尚未领取。
+func (s *Service) Delete(deviceID string, mailID uint64, now time.Time) error {
+	mail, err := s.find(s.db, deviceID, mailID, now)
+	if err != nil {
+		return err
+	}
+	if !mail.IsRead {
+		return game_error.New(-79, "邮件处于未读状态")
+	}
+	attachments, err := parseAttachments(mail)
+	if err != nil {
+		return err
+	}
+	if len(attachments) > 0 && !mail.IsClaimed {
+		return game_error.New(-78, "请先领取邮件附件")
+	}
+	if err := s.db.Delete(&model.Mail{}, mail.ID).Error; err != nil {
+		log.Printf("删除邮件失败, mailID=%d: %v", mail.ID, err)
+		return game_error.New(-2, "数据库写入错误")
+	}
+	return nil
+}
+
+// claimMail 在事务中把邮件标记为已领取和已读，并以 "mail:<UUID>" 为幂等键发放附件
+// 没有附件或附件已经领取时返回空列表，不做修改。
+func claimMail(tx *gorm.DB, players *repository.UnitOfWork, mail *model.Mail) ([]reward.Item, error) {
+	attachments, err := parseAttachments(mail)
+	if err != nil || len(attachments) == 0 || mail.IsClaimed {
+		return nil, err
+	}
+	// 带条件的 UPDATE 保证并发的领取请求中只有一个能把邮件改为已领取
+	result := tx.Model(&model.Mail{}).Where("id = ? AND is_claimed = ?", mail.ID, false).
+		Updates(map[string]interface{}{"is_claimed": true, "is_read": true})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	granted, err := reward.GrantTx(tx, players, mail.DeviceID, "mail:"+mail.UUID, attachments)
+	if err != nil {
+		return nil, err
+	}
+	if !granted {
+		log.Printf("邮件 %d 的附件已经发放过，跳过发放", mail.ID)
+		return nil, nil
+	}
+	return attachments, nil
+}
+
+// active 返回查询玩家在 now 时未过期邮件的条件
+func (s *Service) active(tx *gorm.DB, deviceID string, now time.Time) *gorm.DB {
+	return tx.Model(&model.Mail{}).Where("device_id = ? AND (expire_at = 0 OR expire_at > ?)", deviceID, now.Unix())
+}
+
+// find 查找玩家未过期的一封邮件，不存在时返回 -76
+func (s *Service) find(tx *gorm.DB, deviceID string, mailID uint64, now time.Time) (*model.Mail, error) {
+	var mail model.Mail
+	err := s.active(tx, deviceID, now).Where("id = ?", mailID).First(&mail).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, game_error.New(-76, "邮件不存在")
+	}
+	if err != nil {
+		log.Printf("读取邮件失败, deviceID=%s, mailID=%d: %v", deviceID, mailID, err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	return &mail, nil
+}
+
+// parseAttachments 解析邮件的附件列表
+func parseAttachments(mail *model.Mail) ([]reward.Item, error) {
+	attachments := []reward.Item{}
+	if mail.Attachments == "" || mail.Attachments == "null" {
+		return attachments, nil
+	}
+	if err := json.Unmarshal([]byte(mail.Attachments), &attachments); err != nil {
+		log.Printf("解析邮件 %d 的附件失败: %v", mail.ID, err)
+		return nil, game_error.New(-2, "数据处理错误")
+	}
+	return attachments, nil
+}
+
+// toView 把邮件转换为返回给客户端的格式
+func toView(mail *model.Mail) (*View, error) {
+	attachments, err := parseAttachments(mail)
+	if err != nil {
+		return nil, err
+	}
+	return &View{
+		MailID:      mail.ID,
+		Title:       mail.Title,
+		Body:        mail.Body,
+		Attachments: attachments,
+		IsRead:      mail.IsRead,
+		IsClaimed:   mail.IsClaimed,
+		CreateTime:  mail.CreatedAt.Unix(),
+		ExpireAt:    mail.ExpireAt,
+	}, nil
+}
+
+// newUUID 生成一个随机（第 4 版）UUID，作为邮件附件的幂等键
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,282 @@
+// internal/services/mail/mail_test.go
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+	"dmmserver/utils"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupPlayer 使用临时的 SQLite 数据库替换 db.DB，并创建一个拥有默认资产的玩家
+func setupPlayer(t *testing.T, deviceID string) *repository.GormPlayerRepository {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "mail.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	registry, err := utils.LoadItemRegistry("../../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	utils.SetItemRegistry(registry)
+
+	players := &repository.GormPlayerRepository{}
+	assetsJSON, _ := json.Marshal(utils.NewAssetsManagerWithRepository(players).GetDefaultAssetsData())
+	if err := players.Create(&model.PlayerData{DeviceID: deviceID, RoleID: 1000, AssetsData: string(assetsJSON)}); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+	return players
+}
+
+// 同一封邮件被并发领取多次时附件只发放一次，其余请求返回 -77
+func TestClaimGrantsAttachmentsExactlyOnce(t *testing.T) {
+	const deviceID = "device-mail"
+	players := setupPlayer(t, deviceID)
+	assets := utils.NewAssetsManagerWithRepository(players)
+	before, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+
+	sent, err := Send(deviceID, "补偿", "", []reward.Item{{ItemID: 30, Count: 5}}, 0)
+	if err != nil {
+		t.Fatalf("发送邮件失败: %v", err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Claim(players, deviceID, sent.ID)
+			var gameErr *game_error.GameError
+			switch {
+			case err == nil:
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			case !errors.As(err, &gameErr) || gameErr.Code != -77:
+				t.Errorf("重复领取返回 %v，期望 -77", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("%d 个并发的领取请求中有 %d 个成功，期望 1 个", n, claimed)
+	}
+
+	if mailIDs, items, err := ClaimAll(players, deviceID); err != nil || len(mailIDs) != 0 || len(items) != 0 {
+		t.Fatalf("附件领取后一键领取返回 %v, %v, %v，期望没有可领取的附件", mailIDs, items, err)
+	}
+	after, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	if after != before+5 {
+		t.Fatalf("资产 30 的数量为 %d，期望 %d", after, before+5)
+	}
+
+	view, err := NewService(db.DB).Read(deviceID, sent.ID, time.Now())
+	if err != nil || !view.IsClaimed || !view.IsRead {
+		t.Fatalf("领取后的邮件为 %+v, err=%v，期望已读且已领取", view, err)
+	}
+}
+
+// 删除的邮件ID被数据库重新分配给新邮件后，新邮件的附件仍然可以领取
+func TestClaimAfterMailIDReused(t *testing.T) {
+	const deviceID = "device-mail"
+	players := setupPlayer(t, deviceID)
+	assets := utils.NewAssetsManagerWithRepository(players)
+	attachments := []reward.Item{{ItemID: 30, Count: 5}}
+
+	first, err := Send(deviceID, "补偿", "", attachments, 0)
+	if err != nil {
+		t.Fatalf("发送邮件失败: %v", err)
+	}
+	if _, err := Claim(players, deviceID, first.ID); err != nil {
+		t.Fatalf("领取邮件失败: %v", err)
+	}
+	if err := Delete(deviceID, first.ID); err != nil {
+		t.Fatalf("删除邮件失败: %v", err)
+	}
+	before, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+
+	// 模拟数据库把已删除的ID分配给新发送的邮件
+	second, err := Send(deviceID, "补偿", "", attachments, 0)
+	if err != nil {
+		t.Fatalf("发送邮件失败: %v", err)
+	}
+	if err := db.DB.Model(&model.Mail{}).Where("id = ?", second.ID).Update("id", first.ID).Error; err != nil {
+		t.Fatalf("修改邮件ID失败: %v", err)
+	}
+	items, err := Claim(players, deviceID, first.ID)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("领取重新分配ID的邮件返回 %v, %v，期望发放附件", items, err)
+	}
+	after, err := assets.GetAssetCount(deviceID, 30)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	if after != before+5 {
+		t.Fatalf("资产 30 的数量为 %d，期望 %d", after, before+5)
+	}
+}
+
+// 群发邮件的各个筛选条件需要同时满足
+func TestBroadcastMatches(t *testing.T) {
+	player := model.PlayerData{RoleID: 1000, CreateAccountTime: 1000, PfID: 1, PlaytimeData: `{"isVIP":false}`}
+	vip := player
+	vip.PlaytimeData = `{"isVIP":true}`
+	cases := []struct {
+		name      string
+		broadcast model.BroadcastMail
+		player    model.PlayerData
+		want      bool
+	}{
+		{"no filters", model.BroadcastMail{RoleIDs: "[]"}, player, true},
+		{"roleID listed", model.BroadcastMail{RoleIDs: "[999,1000]"}, player, true},
+		{"roleID not listed", model.BroadcastMail{RoleIDs: "[999]"}, player, false},
+		{"created before", model.BroadcastMail{RoleIDs: "[]", CreatedBefore: 1001}, player, true},
+		{"created at the cutoff", model.BroadcastMail{RoleIDs: "[]", CreatedBefore: 1000}, player, false},
+		{"same pfID", model.BroadcastMail{RoleIDs: "[]", PfID: 1}, player, true},
+		{"other pfID", model.BroadcastMail{RoleIDs: "[]", PfID: 2}, player, false},
+		{"VIP only for VIP", model.BroadcastMail{RoleIDs: "[]", VIPOnly: true}, vip, true},
+		{"VIP only for non-VIP", model.BroadcastMail{RoleIDs: "[]", VIPOnly: true}, player, false},
+		{"VIP only without playtime data", model.BroadcastMail{RoleIDs: "[]", VIPOnly: true}, model.PlayerData{RoleID: 1000}, false},
+		{"all filters met", model.BroadcastMail{RoleIDs: "[1000]", CreatedBefore: 1001, PfID: 1, VIPOnly: true}, vip, true},
+		{"one filter not met", model.BroadcastMail{RoleIDs: "[1000]", CreatedBefore: 1001, PfID: 2, VIPOnly: true}, vip, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matches(&tc.broadcast, &tc.player); got != tc.want {
+				t.Fatalf("matches 返回 %t，期望 %t", got, tc.want)
+			}
+		})
+	}
+}
+
+// countBroadcastCopies 返回玩家收到的群发邮件副本数量和投递记录数量
+func countBroadcastCopies(t *testing.T, deviceID string, broadcastID uint64) (int64, int64) {
+	t.Helper()
+	var mails, states int64
+	if err := db.DB.Model(&model.Mail{}).Where("device_id = ?", deviceID).Count(&mails).Error; err != nil {
+		t.Fatalf("统计邮件失败: %v", err)
+	}
+	if err := db.DB.Model(&model.BroadcastMailState{}).Where("device_id = ? AND broadcast_id = ?", deviceID, broadcastID).Count(&states).Error; err != nil {
+		t.Fatalf("统计投递记录失败: %v", err)
+	}
+	return mails, states
+}
+
+// 并发投递同一封群发邮件时，投递记录的主键保证玩家只收到一份副本
+func TestConcurrentDeliveriesCreateOneCopy(t *testing.T) {
+	const deviceID = "device-mail"
+	setupPlayer(t, deviceID)
+	broadcast, err := SendBroadcast(Broadcast{Title: "维护补偿", Attachments: []reward.Item{{ItemID: 30, Count: 5}}})
+	if err != nil {
+		t.Fatalf("发送群发邮件失败: %v", err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	delivered := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count, err := NewService(db.DB).DeliverBroadcasts(deviceID, time.Now())
+			if err != nil {
+				t.Errorf("投递群发邮件失败: %v", err)
+				return
+			}
+			mu.Lock()
+			delivered += count
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if delivered != 1 {
+		t.Fatalf("%d 次并发投递共投递 %d 封，期望 1 封", n, delivered)
+	}
+	if mails, states := countBroadcastCopies(t, deviceID, broadcast.ID); mails != 1 || states != 1 {
+		t.Fatalf("玩家有 %d 封邮件、%d 条投递记录，期望各 1 条", mails, states)
+	}
+}
+
+// 不符合条件或未到发送时间时不投递也不记录，玩家之后满足条件时仍会收到，且只收到一次
+func TestDeliverOnceEligible(t *testing.T) {
+	const deviceID = "device-mail"
+	setupPlayer(t, deviceID)
+	now := time.Now()
+	broadcast, err := SendBroadcast(Broadcast{Title: "VIP 礼包", VIPOnly: true, SendAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("发送群发邮件失败: %v", err)
+	}
+	s := NewService(db.DB)
+	deliver := func(at time.Time) int {
+		t.Helper()
+		count, err := s.DeliverBroadcasts(deviceID, at)
+		if err != nil {
+			t.Fatalf("投递群发邮件失败: %v", err)
+		}
+		return count
+	}
+
+	if count := deliver(now); count != 0 {
+		t.Fatalf("未到发送时间时投递了 %d 封", count)
+	}
+	if count := deliver(now.Add(2 * time.Hour)); count != 0 {
+		t.Fatalf("非VIP玩家收到了 %d 封VIP群发邮件", count)
+	}
+	if mails, states := countBroadcastCopies(t, deviceID, broadcast.ID); mails != 0 || states != 0 {
+		t.Fatalf("不符合条件时有 %d 封邮件、%d 条投递记录，期望都没有", mails, states)
+	}
+
+	if err := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("playtime_data", `{"isVIP":true}`).Error; err != nil {
+		t.Fatalf("修改VIP状态失败: %v", err)
+	}
+	if count := deliver(now.Add(2 * time.Hour)); count != 1 {
+		t.Fatalf("成为VIP后投递了 %d 封，期望 1 封", count)
+	}
+	if count := deliver(now.Add(3 * time.Hour)); count != 0 {
+		t.Fatalf("再次投递了 %d 封，期望不再投递", count)
+	}
+	if mails, states := countBroadcastCopies(t, deviceID, broadcast.ID); mails != 1 || states != 1 {
+		t.Fatalf("玩家有 %d 封邮件、%d 条投递记录，期望各 1 条", mails, states)
+	}
+}
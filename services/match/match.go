@@ -0,0 +1,81 @@
+// internal/services/match/match.go
+package match
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxMatchIDLength 对局ID的最大长度，与 dmm_match_results.match_id 列的长度一致
+const maxMatchIDLength = 64
+
+// Service 记录玩家上报的对局结果
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService 创建一个使用指定数据库连接的对局结果服务
+func NewService(database *gorm.DB) *Service {
+	return &Service{db: database}
+}
+
+// Report 使用全局数据库连接记录对局结果，详见 Service.Report
+func Report(deviceID string, matchID string, won bool) error {
+	return NewService(db.DB).Report(deviceID, matchID, won)
+}
+
+// Report 记录玩家完成的一场对局
+// 对局ID为空或过长返回 -5，同一场对局重复上报返回 -8，超过 conf.Match.PerDevice 的上限返回 -15。
+// 对局ID由客户端上报，服务端暂时无法向对局服务器核实，上报上限用于限制刷完成对局任务的次数。
+// 与建号限流一样，先写入记录再统计窗口内的记录数（包括刚写入的这条），并发上报中通过的记录不会超过上限；
+// 超出上限的记录会被删除，不计入之后的统计。
+func (s *Service) Report(deviceID string, matchID string, won bool) error {
+	matchID = strings.TrimSpace(matchID)
+	if deviceID == "" || matchID == "" || len(matchID) > maxMatchIDLength {
+		return game_error.New(-5, "缺少对局参数")
+	}
+	row := model.MatchResult{DeviceID: deviceID, MatchID: matchID, Won: won}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil {
+		log.Printf("记录对局结果失败, deviceID=%s, matchID=%s: %v", deviceID, matchID, result.Error)
+		return game_error.New(-2, "数据库写入错误")
+	}
+	if result.RowsAffected == 0 {
+		return game_error.New(-8, "重复的对局结果")
+	}
+
+	rule := conf.Conf.Match.PerDevice
+	if rule.Limit <= 0 || rule.WindowSeconds <= 0 {
+		return nil
+	}
+	since := time.Now().Add(-time.Duration(rule.WindowSeconds) * time.Second)
+	var count int64
+	if err := s.db.Model(&model.MatchResult{}).Where("device_id = ? AND created_at > ?", deviceID, since).Count(&count).Error; err != nil {
+		log.Printf("统计对局结果失败, deviceID=%s: %v", deviceID, err)
+		s.remove(&row)
+		return game_error.New(-2, "数据库查询错误")
+	}
+	if count > int64(rule.Limit) {
+		log.Printf("拒绝对局结果: deviceID=%s 在%d秒内已上报%d场对局", deviceID, rule.WindowSeconds, count-1)
+		s.remove(&row)
+		return game_error.New(-15, "请求太过频繁，请稍后再试")
+	}
+	return nil
+}
+
+// remove 删除一条未通过限流的对局结果
+func (s *Service) remove(row *model.MatchResult) {
+	if err := s.db.Delete(&model.MatchResult{}, row.ID).Error; err != nil {
+		// 记录仍然计入统计，只会让限流更严格
+		log.Printf("删除对局结果失败, id=%d: %v", row.ID, err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"dmmserver/db"
 	"dmmserver/game_error"
 	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/utils"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +30,11 @@ type PlayerPlaytimeDB struct {
 
 // 玩家游玩时长数据结构
 type PlayerPlaytimeData struct {
+	PlayedTime      int64     // 已游玩时间（需要存入数据库）
+	IsVIP           bool      // 是否为VIP玩家（需要存入数据库）
+	DailyPlayTime   int64     // 每日可游玩时间，固定值，不允许重置（需要存入数据库）
+	TodayExtraTime  int64     // 今日额外游玩时间，只限今天（需要存入数据库）
+	LastUpdateTime  time.Time // 上次更新时间（需要存入数据库）
 	RemainingTime   int64     // 剩余游玩时间（计算得出，不存入数据库）
 	LastLoginTime   time.Time // 上次登录时间（只存在内存中）
 	DeviceID        string    // 设备ID（不需要存入数据库,只存在dmm_playerinfo数据表中）
@@ -59,6 +65,9 @@ var (
 	lastRefreshTime           time.Time   // 记录上次成功刷新数据的时间
 	noActivityStreakStartTime time.Time   // 记录无活动周期的开始时间
 	lastResetTime             time.Time   // 记录上次重置时间
+
+	stopCh   = make(chan struct{}) // 关闭后后台协程退出
+	stopOnce sync.Once
 )
 
 const (
@@ -208,6 +217,11 @@ func savePlaytimeDataToDB() {
 	refreshMutex.Lock() // 获取锁，防止并发刷新
 	defer refreshMutex.Unlock()
 
+	savePlaytimeDataLocked()
+}
+
+// savePlaytimeDataLocked 执行实际的保存操作，调用方需持有 refreshMutex
+func savePlaytimeDataLocked() {
 	// 检查是否需要导入strings包
 	var _ = strings.Split
 
@@ -245,12 +259,11 @@ func savePlaytimeDataToDB() {
 			return true // 继续遍历
 		}
 
-		// 更新数据库
-		result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("playtime_data", string(dataJSON))
-		if result.Error != nil {
-			log.Printf("更新玩家 %s 的游玩时长数据失败: %v", deviceID, result.Error)
+		// 通过玩家仓库更新数据库，递增版本号，让同时读取了该玩家的请求在提交时发现冲突
+		if err := repository.Default().UpdateColumns(deviceID, map[string]interface{}{"playtime_data": string(dataJSON)}); err != nil {
+			log.Printf("更新玩家 %s 的游玩时长数据失败: %v", deviceID, err)
 			errorCount++
-		} else if result.RowsAffected > 0 {
+		} else {
 			updateCount++
 		}
 
@@ -265,7 +278,14 @@ func startIntelligentTicker() {
 	ticker := time.NewTicker(refreshInterval) // 定时器周期设置为6小时
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-stopCh:
+			log.Println("Playtime service ticker stopped.")
+			return
+		case <-ticker.C:
+		}
+
 		if activityInWindow.Load() {
 			activityInWindow.Store(false)       // 重置活动标记，为下一个窗口期做准备
 			noActivityStreakStartTime = time.Time{} // 客户端有活动，重置无活动周期的开始时间
@@ -312,8 +332,15 @@ func startDailyResetTicker() {
 		waitDuration := time.Until(nextReset)
 		log.Printf("距离下一次游玩时长重置还有: %v", waitDuration)
 
-		// 等待到重置时间
-		time.Sleep(waitDuration)
+		// 等待到重置时间，收到停止信号时直接退出
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			log.Println("Playtime daily reset ticker stopped.")
+			return
+		case <-timer.C:
+		}
 
 		// 执行重置
 		resetAllPlaytimeData()
@@ -392,14 +419,24 @@ func resetAllPlaytimeData() {
 		return true // 继续遍历
 	})
 
-	// 保存到数据库
-	savePlaytimeDataToDB()
+	// 保存到数据库（已持有 refreshMutex，不能再调用 savePlaytimeDataToDB）
+	savePlaytimeDataLocked()
 
 	// 更新上次重置时间
 	lastResetTime = time.Now()
 	log.Println("所有玩家的游玩时长数据已重置")
 }
 
+// Stop 停止后台协程，并把内存中的游玩时长数据写回数据库
+// 由bootstrap在关闭服务器时调用，必须在关闭数据库连接之前执行
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopCh)
+		savePlaytimeDataToDB()
+		log.Println("Playtime service stopped, playtime data flushed.")
+	})
+}
+
 // CheckAndRefreshIfStale 检查设置是否过期，如果过期则刷新
 func CheckAndRefreshIfStale() {
 	// 如果上次刷新时间超过刷新间隔的两倍，则认为数据已过期
@@ -560,11 +597,10 @@ func AddPlayerPlaytime(deviceID string, additionalSeconds int64) error {
 		return err
 	}
 
-	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("playtime_data", string(dataJSON))
-	if result.Error != nil {
-		log.Printf("更新幸运玩家 %s 的游玩时长数据失败: %v", deviceID, result.Error)
-		return result.Error
+	// 通过玩家仓库更新数据库，递增版本号
+	if err := repository.Default().UpdateColumns(deviceID, map[string]interface{}{"playtime_data": string(dataJSON)}); err != nil {
+		log.Printf("更新幸运玩家 %s 的游玩时长数据失败: %v", deviceID, err)
+		return err
 	}
 
 	// 更新内存缓存
@@ -0,0 +1,65 @@
+// internal/services/playtime/playtime_test.go
+package playtime
+
+import (
+	"path/filepath"
+	"testing"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/model"
+	"dmmserver/repository"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 写回游玩时长通过玩家仓库递增版本号，同时读取了该玩家的请求在提交时会发现冲突，而不是覆盖这次写入
+func TestSavePlaytimeBumpsVersion(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "playtime.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	const deviceID = "device-playtime"
+	players := &repository.GormPlayerRepository{}
+	if err := players.Create(&model.PlayerData{DeviceID: deviceID, RoleID: 1000}); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+	request := repository.NewUnitOfWork(players)
+	if _, err := request.GetByDeviceID(deviceID); err != nil {
+		t.Fatalf("读取玩家失败: %v", err)
+	}
+
+	playerPlaytimeCache.Store(deviceID, PlayerPlaytimeData{PlayedTime: 120, DailyPlayTime: 3600})
+	t.Cleanup(func() { playerPlaytimeCache.Delete(deviceID) })
+	savePlaytimeDataToDB()
+
+	player, err := players.GetByDeviceID(deviceID)
+	if err != nil {
+		t.Fatalf("读取玩家失败: %v", err)
+	}
+	if player.Version != 1 || player.PlaytimeData == "" {
+		t.Fatalf("写回后 version=%d, playtime_data=%q", player.Version, player.PlaytimeData)
+	}
+
+	if err := request.UpdateColumns(deviceID, map[string]interface{}{"pf_id": 3}); err != nil {
+		t.Fatalf("修改玩家失败: %v", err)
+	}
+	if err := request.Commit(); err == nil {
+		t.Fatal("写回游玩时长之前读取的工作单元提交时没有发现冲突")
+	}
+}
@@ -0,0 +1,174 @@
+// internal/services/registration/registration.go
+package registration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/bits"
+	"time"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+)
+
+// now 返回当前时间，便于在验证滑动窗口时替换
+var now = time.Now
+
+// Attempt 描述一次自动建号请求的来源信息
+type Attempt struct {
+	DeviceID     string
+	IP           string
+	RealDeviceID string
+	DeviceInfo   string
+	PowTimestamp int64  // 客户端计算工作量证明时使用的时间戳
+	PowNonce     string // 客户端找到的满足难度要求的随机串
+}
+
+// Reservation 是 Reserve 为一次建号预留的名额，建号失败时通过 Release 归还
+type Reservation struct {
+	id uint
+}
+
+// Reserve 检查是否允许为该请求新建账号，允许时预留一个名额
+// 1. 如果配置了工作量证明，校验 powTimestamp/powNonce（缺失返回 -5，无效返回 -13）
+// 2. 先写入一条已接受的记录，再按 IP、realDeviceID、稳定deviceInfo 统计滑动窗口内的记录数（包括刚写入的这条），超出上限时把这条记录改为被拒绝并返回 -15
+//
+// 每个请求都在自己的记录写入之后才统计，并发请求中最后统计的那个一定能看到其他请求的记录，因此通过的请求不会超过上限。
+// 记录写入失败时拒绝建号（-2），不会绕过限流。被拒绝的尝试保留在表中，供封禁服务追查批量注册来源。
+func Reserve(a Attempt) (*Reservation, error) {
+	cfg := conf.Conf.Registration
+	row := newAttemptRow(a)
+
+	if cfg.ProofOfWork.Difficulty > 0 {
+		if a.PowNonce == "" || a.PowTimestamp == 0 {
+			reject(row, "缺少工作量证明")
+			return nil, game_error.New(-5, "缺少工作量证明参数")
+		}
+		if !verifyProofOfWork(a, cfg.ProofOfWork) {
+			reject(row, "工作量证明无效")
+			return nil, game_error.New(-13, "非法参数")
+		}
+	}
+
+	row.Accepted = true
+	if err := db.DB.Create(&row).Error; err != nil {
+		log.Printf("写入建号记录失败，拒绝建号: %v", err)
+		return nil, game_error.New(-2, "数据库写入错误")
+	}
+	reservation := &Reservation{id: row.ID}
+
+	checks := []struct {
+		name   string
+		column string
+		value  string
+		rule   conf.RateLimitRule
+	}{
+		{"IP", "ip", row.IP, cfg.PerIP},
+		{"realDeviceID", "real_device_id", row.RealDeviceID, cfg.PerRealDeviceID},
+		{"deviceInfo", "stable_device_info", row.StableDeviceInfo, cfg.PerDeviceInfo},
+	}
+	for _, check := range checks {
+		if check.value == "" || check.rule.Limit <= 0 || check.rule.WindowSeconds <= 0 {
+			continue
+		}
+		since := now().Add(-time.Duration(check.rule.WindowSeconds) * time.Second)
+		var count int64
+		err := db.DB.Model(&model.RegistrationAttempt{}).
+			Where(check.column+" = ? AND accepted = ? AND created_at > ?", check.value, true, since).
+			Count(&count).Error
+		if err != nil {
+			log.Printf("统计建号记录失败: %v", err)
+			Release(reservation, "统计建号记录失败")
+			return nil, game_error.New(-2, "数据库查询错误")
+		}
+		if count > int64(check.rule.Limit) {
+			reason := fmt.Sprintf("同一%s在%d秒内已创建%d个账号", check.name, check.rule.WindowSeconds, count-1)
+			log.Printf("拒绝自动建号: deviceID=%s, ip=%s, realDeviceID=%s, 原因: %s", row.DeviceID, row.IP, row.RealDeviceID, reason)
+			Release(reservation, reason)
+			return nil, game_error.New(-15, "请求太过频繁，请稍后再试")
+		}
+	}
+
+	return reservation, nil
+}
+
+// Release 归还一个预留的名额，把对应的记录改为被拒绝，之后不再计入滑动窗口统计
+// 用于超出上限的请求以及预留名额后建号失败的情况。
+func Release(r *Reservation, reason string) {
+	if r == nil {
+		return
+	}
+	err := db.DB.Model(&model.RegistrationAttempt{}).Where("id = ?", r.id).
+		Updates(map[string]interface{}{"accepted": false, "reason": reason}).Error
+	if err != nil {
+		// 记录仍然计入统计，只会让限流更严格
+		log.Printf("归还建号名额失败, id=%d: %v", r.id, err)
+	}
+}
+
+// reject 记录一次在预留名额之前就被拒绝的建号尝试
+func reject(row model.RegistrationAttempt, reason string) {
+	log.Printf("拒绝自动建号: deviceID=%s, ip=%s, realDeviceID=%s, 原因: %s", row.DeviceID, row.IP, row.RealDeviceID, reason)
+	row.Reason = reason
+	if err := db.DB.Create(&row).Error; err != nil {
+		log.Printf("写入建号记录失败: %v", err)
+	}
+}
+
+// newAttemptRow 把建号请求转换为 dmm_registration_attempts 中的一条记录
+// 这些值来自客户端，超过列长度的值保存为哈希，过长的 deviceInfo 不能导致写入失败而绕过限流。
+func newAttemptRow(a Attempt) model.RegistrationAttempt {
+	return model.RegistrationAttempt{
+		DeviceID:         storageKey(a.DeviceID, 191),
+		IP:               storageKey(a.IP, 64),
+		RealDeviceID:     storageKey(a.RealDeviceID, 191),
+		StableDeviceInfo: storageKey(model.GetStableDeviceInfo(a.DeviceInfo), 191),
+		CreatedAt:        now(),
+	}
+}
+
+// storageKey 不超过 size 字节的值原样返回，否则返回 "sha256:" 加上其哈希值的前 16 字节
+// 同一个值总是得到同一个键，统计结果与保存原值时一致。
+func storageKey(value string, size int) string {
+	if len(value) <= size {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// verifyProofOfWork 校验工作量证明
+// 客户端需要找到 powNonce，使 sha256("deviceID:powTimestamp:powNonce") 至少有 difficulty 个前导零比特，
+// 且 powTimestamp 与服务器时间的偏差不超过 maxSkewSeconds。
+func verifyProofOfWork(a Attempt, cfg conf.ProofOfWorkConf) bool {
+	if cfg.MaxSkewSeconds > 0 {
+		skew := now().Unix() - a.PowTimestamp
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > int64(cfg.MaxSkewSeconds) {
+			return false
+		}
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%s", a.DeviceID, a.PowTimestamp, a.PowNonce)))
+	return leadingZeroBits(sum[:]) >= cfg.Difficulty
+}
+
+// leadingZeroBits 计算字节串的前导零比特数
+func leadingZeroBits(b []byte) int {
+	count := 0
+	for _, v := range b {
+		if v == 0 {
+			count += 8
+			continue
+		}
+		count += bits.LeadingZeros8(v)
+		break
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+// internal/services/registration/registration_test.go
+package registration
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "registration.db") + "?_busy_timeout=5000"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB, oldConf := db.DB, conf.Conf
+	db.DB = d
+	conf.Conf = &conf.Config{}
+	t.Cleanup(func() {
+		db.DB, conf.Conf = oldDB, oldConf
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+func TestReserveConcurrentDoesNotExceedLimit(t *testing.T) {
+	setupDB(t)
+	const limit = 3
+	conf.Conf.Registration = conf.RegistrationConf{
+		PerIP: conf.RateLimitRule{Limit: limit, WindowSeconds: 3600},
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := Reserve(Attempt{DeviceID: "device" + string(rune('a'+i)), IP: "10.0.0.1"})
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if accepted > limit {
+		t.Fatalf("并发建号通过了 %d 个，超过上限 %d", accepted, limit)
+	}
+	var count int64
+	db.DB.Model(&model.RegistrationAttempt{}).Where("accepted = ?", true).Count(&count)
+	if count != int64(accepted) {
+		t.Fatalf("已接受的记录数为 %d，通过的请求数为 %d", count, accepted)
+	}
+}
+
+func TestReserveReleaseFreesSlot(t *testing.T) {
+	setupDB(t)
+	conf.Conf.Registration = conf.RegistrationConf{
+		PerIP: conf.RateLimitRule{Limit: 1, WindowSeconds: 3600},
+	}
+
+	r, err := Reserve(Attempt{DeviceID: "a", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("第一次建号被拒绝: %v", err)
+	}
+	if _, err := Reserve(Attempt{DeviceID: "b", IP: "10.0.0.1"}); err == nil {
+		t.Fatal("超过上限的建号没有被拒绝")
+	}
+	Release(r, "建号失败")
+	if _, err := Reserve(Attempt{DeviceID: "c", IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("归还名额后建号仍被拒绝: %v", err)
+	}
+}
+
+func TestReserveOversizedKeysStillCounted(t *testing.T) {
+	setupDB(t)
+	conf.Conf.Registration = conf.RegistrationConf{
+		PerDeviceInfo: conf.RateLimitRule{Limit: 1, WindowSeconds: 3600},
+	}
+
+	deviceInfo := strings.Repeat("x", 4096)
+	attempt := Attempt{DeviceID: strings.Repeat("d", 1024), IP: strings.Repeat("1", 256), DeviceInfo: deviceInfo}
+	if _, err := Reserve(attempt); err != nil {
+		t.Fatalf("第一次建号被拒绝: %v", err)
+	}
+	attempt.DeviceID = "other"
+	if _, err := Reserve(attempt); err == nil {
+		t.Fatal("超长 deviceInfo 绕过了建号限流")
+	}
+}
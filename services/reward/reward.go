@@ -0,0 +1,145 @@
+// internal/services/reward/reward.go
+package reward
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/gorm"
+)
+
+// reasonReward 发放奖励写入钱包流水的原因
+const reasonReward = "reward"
+
+// Item 描述一次发放中的单个物品
+type Item struct {
+	ItemID      int `json:"itemID"`
+	Count       int `json:"count"`       // 数量，只对资产、卡牌碎片和货币有效
+	ExpiredTime int `json:"expiredTime"` // 过期时间（Unix 秒），0 表示使用物品的默认有效期，详见 utils.ItemManager.GrantItem
+}
+
+// Service 在一个数据库事务中发放一组物品
+type Service struct {
+	db      *gorm.DB
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接的发放服务
+func NewService(database *gorm.DB) *Service {
+	return &Service{db: database}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// Grant 使用全局数据库连接发放奖励，详见 Service.Grant
+func Grant(deviceID string, key string, items []Item) (bool, error) {
+	return NewService(db.DB).Grant(deviceID, key, items)
+}
+
+// Grant 把 items 一次性发放给玩家，key 是调用方提供的幂等键
+// 每个物品按 configs/items.json 中的物品注册表交给对应的管理器，货币交给钱包。
+// 所有物品与幂等记录在同一个事务中写入：任何一个物品发放失败，整组奖励都不会生效。
+// 同一玩家的同一个 key 只会发放一次，重复调用返回 (false, nil)；本次实际发放时返回 (true, nil)。
+func (s *Service) Grant(deviceID string, key string, items []Item) (bool, error) {
+	if deviceID == "" || key == "" {
+		return false, game_error.New(-5, "缺少发放参数")
+	}
+	for _, item := range items {
+		if err := Validate(item); err != nil {
+			return false, err
+		}
+	}
+
+	granted := false
+	// 所有管理器共享事务内的工作单元，物品全部发放后一次性写回玩家数据
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		var err error
+		granted, err = GrantTx(tx, players, deviceID, key, items)
+		return err
+	})
+	if err != nil && s.alreadyGranted(deviceID, key) {
+		// 并发的相同请求已经先一步完成发放（幂等记录主键冲突）
+		return false, nil
+	}
+	return granted, repository.ToGameError(err, "发放奖励失败, deviceID=%s, key=%s", deviceID, key)
+}
+
+// Validate 检查物品ID是否已在物品注册表中登记，以及资产、卡牌碎片和货币的数量是否有效
+func Validate(item Item) error {
+	info, err := utils.NewItemManager().Resolve(item.ItemID)
+	if err != nil {
+		return err
+	}
+	if info.Category.Stackable() && item.Count <= 0 {
+		log.Printf("物品 %d 的发放数量无效: %d", item.ItemID, item.Count)
+		return game_error.New(-13, "非法参数")
+	}
+	return nil
+}
+
+// GrantTx 在调用方的事务 tx 中写入幂等记录并发放全部物品，供需要与其他修改一起提交的服务使用（如邮件附件）
+// 玩家数据通过调用方的工作单元 players 修改，由调用方在事务结束前提交，通常在 repository.RetryTx 中调用。
+// 货币直接在 tx 中增加钱包余额，流水的 RequestKey 为 key。
+// 同一玩家的同一个 key 已经发放过时返回 (false, nil)，不做任何修改。
+func GrantTx(tx *gorm.DB, players repository.PlayerRepository, deviceID string, key string, items []Item) (bool, error) {
+	if deviceID == "" || key == "" {
+		return false, game_error.New(-5, "缺少发放参数")
+	}
+	for _, item := range items {
+		if err := Validate(item); err != nil {
+			return false, err
+		}
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := tx.Model(&model.RewardGrant{}).Where("device_id = ? AND grant_key = ?", deviceID, key).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := tx.Create(&model.RewardGrant{DeviceID: deviceID, GrantKey: key, Items: string(itemsJSON)}).Error; err != nil {
+		return false, err
+	}
+
+	im := utils.NewItemManagerWithRepository(players)
+	for _, item := range items {
+		if err := grantItem(tx, im, deviceID, key, item); err != nil {
+			return false, fmt.Errorf("发放物品 %d 失败: %w", item.ItemID, err)
+		}
+	}
+	return true, nil
+}
+
+// grantItem 发放一个物品，货币在 tx 中增加钱包余额，其他物品交给物品管理器
+func grantItem(tx *gorm.DB, im *utils.ItemManager, deviceID string, key string, item Item) error {
+	if currency, ok := wallet.CurrencyOfItem(item.ItemID); ok {
+		_, err := wallet.NewService(tx).Credit(deviceID, currency, int64(item.Count), wallet.Trace{Reason: reasonReward, RequestKey: key})
+		return err
+	}
+	return im.GrantItem(deviceID, item.ItemID, item.Count, item.ExpiredTime)
+}
+
+// alreadyGranted 检查幂等记录是否已经存在
+func (s *Service) alreadyGranted(deviceID string, key string) bool {
+	var count int64
+	err := s.db.Model(&model.RewardGrant{}).Where("device_id = ? AND grant_key = ?", deviceID, key).Count(&count).Error
+	return err == nil && count > 0
+}
@@ -0,0 +1,186 @@
+// internal/services/reward/reward_test.go
+package reward
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupPlayer 使用临时的 SQLite 数据库替换 db.DB，并创建一个拥有默认资产的玩家
+func setupPlayer(t *testing.T, deviceID string) *repository.GormPlayerRepository {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "reward.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	registry, err := utils.LoadItemRegistry("../../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	utils.SetItemRegistry(registry)
+
+	players := &repository.GormPlayerRepository{}
+	assetsJSON, _ := json.Marshal(utils.NewAssetsManagerWithRepository(players).GetDefaultAssetsData())
+	player := model.PlayerData{DeviceID: deviceID, RoleID: 1000, AssetsData: string(assetsJSON), CardPieces: "[]"}
+	if err := players.Create(&player); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+	return players
+}
+
+// assetCount 返回玩家持有的资产数量
+func assetCount(t *testing.T, players repository.PlayerRepository, deviceID string, itemID int) int {
+	t.Helper()
+	count, err := utils.NewAssetsManagerWithRepository(players).GetAssetCount(deviceID, itemID)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	return count
+}
+
+// grantCount 返回玩家某个幂等键的发放记录数量
+func grantCount(t *testing.T, deviceID string, key string) int64 {
+	t.Helper()
+	var count int64
+	if err := db.DB.Model(&model.RewardGrant{}).Where("device_id = ? AND grant_key = ?", deviceID, key).Count(&count).Error; err != nil {
+		t.Fatalf("读取发放记录失败: %v", err)
+	}
+	return count
+}
+
+// 同一个幂等键重复发放（包括并发的重试）时奖励只生效一次
+func TestGrantSameKeyAppliesOnce(t *testing.T) {
+	const deviceID = "device-reward"
+	players := setupPlayer(t, deviceID)
+	before := assetCount(t, players, deviceID, 30)
+	items := []Item{{ItemID: 30, Count: 5}}
+
+	granted, err := Grant(deviceID, "test:once", items)
+	if err != nil || !granted {
+		t.Fatalf("第一次发放返回 granted=%v, err=%v", granted, err)
+	}
+	granted, err = Grant(deviceID, "test:once", items)
+	if err != nil || granted {
+		t.Fatalf("重复发放返回 granted=%v, err=%v，期望 false, nil", granted, err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			granted, err := Grant(deviceID, "test:concurrent", items)
+			if err != nil {
+				t.Errorf("并发发放失败: %v", err)
+				return
+			}
+			if granted {
+				mu.Lock()
+				applied++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if applied != 1 {
+		t.Fatalf("%d 个并发的相同发放中有 %d 个生效，期望 1 个", n, applied)
+	}
+
+	if got := assetCount(t, players, deviceID, 30); got != before+10 {
+		t.Fatalf("资产 30 的数量为 %d，期望 %d", got, before+10)
+	}
+	if grantCount(t, deviceID, "test:once") != 1 || grantCount(t, deviceID, "test:concurrent") != 1 {
+		t.Fatal("每个幂等键应只有一条发放记录")
+	}
+}
+
+// 列表中间的物品发放失败时整组奖励和幂等记录都回滚，之后可以用同一个键重新发放
+func TestGrantRollsBackOnMidListFailure(t *testing.T) {
+	const deviceID = "device-reward"
+	players := setupPlayer(t, deviceID)
+	before := assetCount(t, players, deviceID, 30)
+	if err := players.UpdateColumns(deviceID, map[string]interface{}{"owned_characters": "not json"}); err != nil {
+		t.Fatalf("修改玩家失败: %v", err)
+	}
+	items := []Item{{ItemID: 30, Count: 5}, {ItemID: 201}, {ItemID: 29, Count: 1}}
+
+	granted, err := Grant(deviceID, "test:rollback", items)
+	if err == nil || granted {
+		t.Fatalf("角色数据损坏时发放返回 granted=%v, err=%v，期望失败", granted, err)
+	}
+	if got := assetCount(t, players, deviceID, 30); got != before {
+		t.Fatalf("发放失败后资产 30 的数量为 %d，期望保持 %d", got, before)
+	}
+	if grantCount(t, deviceID, "test:rollback") != 0 {
+		t.Fatal("发放失败后仍然留下了幂等记录")
+	}
+
+	if err := players.UpdateColumns(deviceID, map[string]interface{}{"owned_characters": "[]"}); err != nil {
+		t.Fatalf("修改玩家失败: %v", err)
+	}
+	granted, err = Grant(deviceID, "test:rollback", items)
+	if err != nil || !granted {
+		t.Fatalf("修复数据后重新发放返回 granted=%v, err=%v", granted, err)
+	}
+	if got := assetCount(t, players, deviceID, 30); got != before+5 {
+		t.Fatalf("资产 30 的数量为 %d，期望 %d", got, before+5)
+	}
+}
+
+// 货币物品增加钱包余额而不是写入 assets_data，钱包流水的 RequestKey 为奖励的幂等键
+func TestGrantCreditsWalletCurrency(t *testing.T) {
+	const deviceID = "device-reward"
+	setupPlayer(t, deviceID)
+	items := []Item{{ItemID: 9900001, Count: 300}, {ItemID: 9900002, Count: 5}}
+
+	if granted, err := Grant(deviceID, "test:currency", items); err != nil || !granted {
+		t.Fatalf("发放货币返回 granted=%v, err=%v", granted, err)
+	}
+	if granted, err := Grant(deviceID, "test:currency", items); err != nil || granted {
+		t.Fatalf("重复发放货币返回 granted=%v, err=%v，期望 false, nil", granted, err)
+	}
+
+	playerWallet, err := wallet.Get(deviceID)
+	if err != nil {
+		t.Fatalf("读取钱包失败: %v", err)
+	}
+	if playerWallet.Gold != 300 || playerWallet.Diamonds != 5 {
+		t.Fatalf("钱包为 gold=%d, diamonds=%d，期望 300 和 5", playerWallet.Gold, playerWallet.Diamonds)
+	}
+	var entries []model.WalletLedgerEntry
+	if err := db.DB.Where("device_id = ? AND request_key = ?", deviceID, "test:currency").Find(&entries).Error; err != nil {
+		t.Fatalf("读取流水失败: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("有 %d 条发放货币的流水，期望 2 条", len(entries))
+	}
+}
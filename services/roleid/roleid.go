@@ -0,0 +1,178 @@
+// internal/services/roleid/roleid.go
+package roleid
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultSequenceName = "default"
+	defaultBlockSize    = 50
+)
+
+// ErrExhausted 表示当前序列配置的号段已经分配完
+var ErrExhausted = errors.New("roleID range exhausted")
+
+// Allocator 从数据库序列表中按段预留 RoleID，并在内存中依次分配
+// 预留操作通过 UPDATE next_value = next_value + blockSize 完成，行锁保证
+// 多个请求、多个服务器实例之间拿到的号段互不重叠。
+type Allocator struct {
+	mu        sync.Mutex
+	db        *gorm.DB
+	name      string
+	start     int64
+	end       int64 // 闭区间上限，0 表示不设上限
+	blockSize int64
+	next      int64 // 当前号段中下一个可分配的 RoleID
+	limit     int64 // 当前号段的上界（不含）
+}
+
+var defaultAllocator *Allocator
+
+// Init 模块初始化函数，由bootstrap调用
+func Init() {
+	log.Println("RoleID allocator is starting...")
+	allocator, err := NewAllocator(db.DB, conf.Conf.RoleID)
+	if err != nil {
+		log.Fatalf("Failed to initialize roleID allocator: %v", err)
+	}
+	defaultAllocator = allocator
+	log.Printf("RoleID allocator started successfully. sequence=%s", allocator.name)
+}
+
+// Next 使用全局分配器分配一个新的 RoleID
+func Next() (int, error) {
+	if defaultAllocator == nil {
+		return 0, errors.New("roleID allocator is not initialized")
+	}
+	return defaultAllocator.Next()
+}
+
+// NewAllocator 根据配置创建分配器，并确保序列记录存在
+// 对于已有数据的部署，序列起点会自动跳过 dmm_playerdata 中该号段内已使用的最大 RoleID。
+func NewAllocator(database *gorm.DB, cfg conf.RoleIDConf) (*Allocator, error) {
+	a := &Allocator{
+		db:        database,
+		name:      cfg.Sequence,
+		start:     cfg.Start,
+		end:       cfg.End,
+		blockSize: cfg.BlockSize,
+	}
+	if a.name == "" {
+		a.name = defaultSequenceName
+	}
+	if a.start <= 0 {
+		a.start = 1
+	}
+	if a.blockSize <= 0 {
+		a.blockSize = defaultBlockSize
+	}
+	if a.end != 0 && a.end < a.start {
+		return nil, fmt.Errorf("invalid roleID range [%d, %d]", a.start, a.end)
+	}
+
+	if err := a.ensureSequence(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ensureSequence 创建序列记录（已存在时不覆盖），并把序列推进到号段起点与已使用的最大 RoleID 之后
+func (a *Allocator) ensureSequence() error {
+	floor := a.start
+
+	// 兼容旧数据：旧版本使用 MAX(role_id)+1 分配，这里跳过号段内已经被占用的 RoleID
+	var maxRoleID int64
+	query := a.db.Model(&model.PlayerData{}).Where("role_id >= ?", a.start)
+	if a.end != 0 {
+		query = query.Where("role_id <= ?", a.end)
+	}
+	if err := query.Select("COALESCE(MAX(role_id), 0)").Row().Scan(&maxRoleID); err != nil {
+		return fmt.Errorf("query max roleID: %w", err)
+	}
+	if maxRoleID+1 > floor {
+		floor = maxRoleID + 1
+	}
+
+	seq := model.RoleIDSequence{Name: a.name, NextValue: floor}
+	if err := a.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return fmt.Errorf("create roleID sequence: %w", err)
+	}
+
+	// 序列已存在但落后于起点（例如调整了号段配置）时，向前推进
+	err := a.db.Model(&model.RoleIDSequence{}).
+		Where("name = ? AND next_value < ?", a.name, floor).
+		Update("next_value", floor).Error
+	if err != nil {
+		return fmt.Errorf("advance roleID sequence: %w", err)
+	}
+	return nil
+}
+
+// Next 分配一个新的 RoleID，当前号段用完时向数据库预留下一段
+func (a *Allocator) Next() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.next >= a.limit {
+		if err := a.reserveBlock(); err != nil {
+			return 0, err
+		}
+	}
+	roleID := a.next
+	a.next++
+	return int(roleID), nil
+}
+
+// reserveBlock 在一个事务内把序列推进 blockSize，并取得 [旧值, 新值) 作为本实例的号段
+func (a *Allocator) reserveBlock() error {
+	var blockEnd int64
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.RoleIDSequence{}).
+			Where("name = ?", a.name).
+			Update("next_value", gorm.Expr("next_value + ?", a.blockSize))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("roleID sequence %q not found", a.name)
+		}
+
+		var seq model.RoleIDSequence
+		if err := tx.Where("name = ?", a.name).First(&seq).Error; err != nil {
+			return err
+		}
+		blockEnd = seq.NextValue
+		return nil
+	})
+	if err != nil {
+		log.Printf("预留RoleID号段失败: %v", err)
+		return err
+	}
+
+	blockStart := blockEnd - a.blockSize
+	if a.end != 0 {
+		if blockStart > a.end {
+			log.Printf("RoleID号段已用完: sequence=%s, range=[%d, %d]", a.name, a.start, a.end)
+			return ErrExhausted
+		}
+		if blockEnd > a.end+1 {
+			blockEnd = a.end + 1
+		}
+	}
+
+	a.next = blockStart
+	a.limit = blockEnd
+	log.Printf("预留RoleID号段: sequence=%s, [%d, %d)", a.name, blockStart, blockEnd)
+	return nil
+}
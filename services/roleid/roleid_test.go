@@ -0,0 +1,85 @@
+// internal/services/roleid/roleid_test.go
+package roleid
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"dmmserver/conf"
+	"dmmserver/db/migrations"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "roleid.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return d
+}
+
+// 多个分配器（模拟多个服务器实例）各自被多个 goroutine 并发调用，分配出的 RoleID 不能重复
+func TestNextConcurrentUnique(t *testing.T) {
+	d := openDB(t)
+	cfg := conf.RoleIDConf{Start: 1000, BlockSize: 7}
+
+	const instances = 4
+	const goroutines = 8
+	const perGoroutine = 50
+
+	allocators := make([]*Allocator, instances)
+	for i := range allocators {
+		a, err := NewAllocator(d, cfg)
+		if err != nil {
+			t.Fatalf("创建分配器失败: %v", err)
+		}
+		allocators[i] = a
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	for i := 0; i < instances*goroutines; i++ {
+		wg.Add(1)
+		go func(a *Allocator) {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				roleID, err := a.Next()
+				if err != nil {
+					t.Errorf("分配 RoleID 失败: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[roleID] {
+					t.Errorf("RoleID %d 被重复分配", roleID)
+				}
+				seen[roleID] = true
+				mu.Unlock()
+			}
+		}(allocators[i%instances])
+	}
+	wg.Wait()
+
+	if want := instances * goroutines * perGoroutine; len(seen) != want {
+		t.Fatalf("分配了 %d 个不同的 RoleID，期望 %d 个", len(seen), want)
+	}
+	for roleID := range seen {
+		if roleID < 1000 {
+			t.Fatalf("RoleID %d 小于号段起点", roleID)
+		}
+	}
+}
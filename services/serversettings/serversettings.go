@@ -21,6 +21,9 @@ var (
 	activityInWindow	atomic.Bool	// 标记在当前窗口内是否有客户端活动
 	lastRefreshTime		time.Time	// 记录上次成功刷新设置的时间
 	noActivityStreakStartTime time.Time	// 记录无活动周期的开始时间
+
+	stopCh   = make(chan struct{}) // 关闭后后台刷新协程退出
+	stopOnce sync.Once
 )
 
 const (
@@ -93,7 +96,14 @@ func startIntelligentTicker() {
 	ticker := time.NewTicker(refreshInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-stopCh:
+			log.Println("ServerSettings service ticker stopped.")
+			return
+		case <-ticker.C:
+		}
+
 		if activityInWindow.Load() {
 			activityInWindow.Store(false)
 			noActivityStreakStartTime = time.Time{}
@@ -118,6 +128,13 @@ func startIntelligentTicker() {
 	}
 }
 
+// Stop 停止后台刷新协程，由bootstrap在关闭服务器时调用
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopCh)
+	})
+}
+
 // GetSettings 获取当前缓存的ServerSettings
 func GetSettings() model.ServerSettings {
 	settings, ok := currentSettings.Load().(model.ServerSettings)
@@ -183,4 +200,17 @@ func ParseMiscOptions(jsonStr string) map[string]interface{} {
 		return defaultOptions
 	}
 	return options
-}
\ No newline at end of file
+}
+
+// defaultOverDayTimeStamp 未配置 ServerOverDayTimeStamp 时使用的跨天时间戳
+const defaultOverDayTimeStamp int64 = 1660924815 // 2022-08-16 00:00:00
+
+// OverDayTimeStamp 返回服务器的跨天时间戳，未配置时返回默认值
+// 之后每个跨天时刻都是该时间戳加上整数天，每日和每周任务的重置都以它为准。
+func OverDayTimeStamp() int64 {
+	overDay := GetSettings().ServerOverDayTimeStamp
+	if overDay == 0 {
+		return defaultOverDayTimeStamp
+	}
+	return overDay
+}
@@ -0,0 +1,168 @@
+// internal/services/shop/catalog.go
+package shop
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"dmmserver/utils"
+)
+
+// catalogPath 商店目录数据文件的路径
+const catalogPath = "configs/shop.json"
+
+// CatalogItem 商店中的一个商品
+// 价格从玩家 assets_data 中的货币道具（CurrencyItemID）扣除。
+type CatalogItem struct {
+	ShopItemID      int   `json:"shopItemID"`
+	ItemID          int   `json:"itemID"`          // 购买后发放的物品ID，见 configs/items.json
+	Count           int   `json:"count"`           // 资产、卡牌碎片和货币每次购买发放的数量，其他物品固定为 1
+	Price           int64 `json:"price"`           // 价格
+	CurrencyItemID  int   `json:"currencyItemID"`  // 支付使用的货币道具ID，必须是资产道具，从 assets_data 中扣除
+	DurationSeconds int64 `json:"durationSeconds"` // 租借时长，0 表示永久；已拥有时在剩余有效期上延长
+	Stock           int   `json:"stock"`           // 全服限量，0 表示不限量
+	LimitPerPlayer  int   `json:"limitPerPlayer"`  // 每个玩家的限购次数，0 表示不限购
+	StartTime       int64 `json:"startTime"`       // 上架时间（Unix 秒），0 表示不限
+	EndTime         int64 `json:"endTime"`         // 下架时间（Unix 秒），0 表示不限
+}
+
+// OnSaleAt 判断商品在时刻 t 是否在售
+func (item *CatalogItem) OnSaleAt(t time.Time) bool {
+	now := t.Unix()
+	return (item.StartTime == 0 || now >= item.StartTime) && (item.EndTime == 0 || now < item.EndTime)
+}
+
+// currencyName 返回记录在购买记录中的支付货币名称
+func (item *CatalogItem) currencyName() string {
+	return fmt.Sprintf("item:%d", item.CurrencyItemID)
+}
+
+// catalogFile 是 shop.json 的结构
+type catalogFile struct {
+	Items []CatalogItem `json:"items"`
+}
+
+// Catalog 商店目录，按 shopItemID 查找商品
+type Catalog struct {
+	items []CatalogItem
+	byID  map[int]*CatalogItem
+}
+
+// NewCatalog 创建商店目录并检查每个商品的配置
+// 物品ID和支付道具必须已在物品注册表中登记，因此需要在加载物品注册表之后调用。
+func NewCatalog(items []CatalogItem) (*Catalog, error) {
+	catalog := &Catalog{items: append([]CatalogItem(nil), items...), byID: make(map[int]*CatalogItem, len(items))}
+	for i := range catalog.items {
+		item := &catalog.items[i]
+		if err := validateItem(item); err != nil {
+			return nil, fmt.Errorf("shop item %d: %w", item.ShopItemID, err)
+		}
+		if _, ok := catalog.byID[item.ShopItemID]; ok {
+			return nil, fmt.Errorf("duplicate shop item %d", item.ShopItemID)
+		}
+		catalog.byID[item.ShopItemID] = item
+	}
+	return catalog, nil
+}
+
+// validateItem 检查单个商品的配置，资产和卡牌碎片以外的物品把数量统一为 1
+func validateItem(item *CatalogItem) error {
+	if item.ShopItemID <= 0 {
+		return fmt.Errorf("invalid shopItemID")
+	}
+	info, ok := utils.Items().Resolve(item.ItemID)
+	if !ok {
+		return fmt.Errorf("item %d is not in the item registry", item.ItemID)
+	}
+	if info.Category.Stackable() {
+		if item.Count <= 0 {
+			return fmt.Errorf("count must be positive for stackable item %d", item.ItemID)
+		}
+		if item.DurationSeconds != 0 {
+			return fmt.Errorf("stackable item %d cannot be rented", item.ItemID)
+		}
+	} else {
+		if item.Count > 1 {
+			return fmt.Errorf("count must be 1 for non-stackable item %d", item.ItemID)
+		}
+		item.Count = 1
+	}
+	if item.Price <= 0 {
+		return fmt.Errorf("price must be positive")
+	}
+	currencyInfo, ok := utils.Items().Resolve(item.CurrencyItemID)
+	if !ok || currencyInfo.Category != utils.ItemCategoryAsset {
+		return fmt.Errorf("currencyItemID %d is not an asset item", item.CurrencyItemID)
+	}
+	if item.ItemID == item.CurrencyItemID {
+		return fmt.Errorf("item %d cannot be paid with itself", item.ItemID)
+	}
+	if item.DurationSeconds < 0 || item.Stock < 0 || item.LimitPerPlayer < 0 {
+		return fmt.Errorf("durationSeconds, stock and limitPerPlayer cannot be negative")
+	}
+	if item.StartTime != 0 && item.EndTime != 0 && item.EndTime <= item.StartTime {
+		return fmt.Errorf("endTime must be after startTime")
+	}
+	return nil
+}
+
+// LoadCatalog 从JSON文件加载商店目录
+func LoadCatalog(path string) (*Catalog, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file catalogFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewCatalog(file.Items)
+}
+
+// Item 按 shopItemID 查找商品
+func (c *Catalog) Item(shopItemID int) (*CatalogItem, bool) {
+	item, ok := c.byID[shopItemID]
+	return item, ok
+}
+
+// Items 返回全部商品，保持数据文件中的顺序
+func (c *Catalog) Items() []CatalogItem {
+	return append([]CatalogItem(nil), c.items...)
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   *Catalog
+)
+
+// Init 加载 configs/shop.json，由bootstrap在加载物品注册表之后调用
+func Init() error {
+	loaded, err := LoadCatalog(catalogPath)
+	if err != nil {
+		return err
+	}
+	SetCatalog(loaded)
+	log.Printf("Shop catalog loaded: %d items", len(loaded.items))
+	return nil
+}
+
+// CurrentCatalog 返回当前使用的商店目录，未加载时返回空目录
+func CurrentCatalog() *Catalog {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	if catalog == nil {
+		return &Catalog{byID: map[int]*CatalogItem{}}
+	}
+	return catalog
+}
+
+// SetCatalog 替换当前使用的商店目录
+func SetCatalog(c *Catalog) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	catalog = c
+}
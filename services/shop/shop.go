@@ -0,0 +1,265 @@
+// internal/services/shop/shop.go
+package shop
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reasonShopPurchase 购买钱包货币时写入钱包流水的原因
+const reasonShopPurchase = "shopPurchase"
+
+// Listing 是返回给客户端的一个商品及玩家的购买情况
+type Listing struct {
+	CatalogItem
+	Name        string `json:"name"`        // 物品名称，来自物品注册表
+	Icon        string `json:"icon"`        // 物品图标，来自物品注册表
+	Bought      int    `json:"bought"`      // 玩家已购买的次数
+	RemainStock int    `json:"remainStock"` // 剩余库存，不限量时为 -1
+}
+
+// Receipt 是一次购买的结果
+type Receipt struct {
+	ShopItemID     int   `json:"shopItemID"`
+	ItemID         int   `json:"itemID"`
+	Count          int   `json:"count"`
+	ExpiredTime    int   `json:"expiredTime"`    // 物品购买后的过期时间（Unix 秒），0 表示永久
+	CurrencyItemID int   `json:"currencyItemID"` // 支付使用的货币道具ID
+	Price          int64 `json:"price"`
+	Balance        int64 `json:"balance"` // 支付后剩余的货币道具数量
+}
+
+// Service 在一个数据库事务中完成扣款、发放物品和记录购买
+type Service struct {
+	db      *gorm.DB
+	catalog *Catalog
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接和商店目录的商店服务
+func NewService(database *gorm.DB, catalog *Catalog) *Service {
+	return &Service{db: database, catalog: catalog}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// List 使用全局数据库连接和当前商店目录列出商品，详见 Service.List
+func List(deviceID string) ([]Listing, error) {
+	return NewService(db.DB, CurrentCatalog()).List(deviceID, time.Now())
+}
+
+// Purchase 使用全局数据库连接和当前商店目录购买商品，与请求级工作单元 players 一起提交，详见 Service.Purchase
+func Purchase(players repository.PlayerRepository, deviceID string, shopItemID int, orderKey string, msgID string) (*Receipt, error) {
+	return NewService(db.DB, CurrentCatalog()).WithRequest(players).Purchase(deviceID, shopItemID, orderKey, msgID, time.Now())
+}
+
+// List 返回在 now 时在售的商品，以及玩家的已购买次数和剩余库存
+func (s *Service) List(deviceID string, now time.Time) ([]Listing, error) {
+	var boughtRows []struct {
+		ShopItemID int
+		Bought     int
+	}
+	err := s.db.Model(&model.ShopPurchase{}).
+		Select("shop_item_id, COUNT(*) AS bought").
+		Where("device_id = ?", deviceID).
+		Group("shop_item_id").
+		Scan(&boughtRows).Error
+	if err != nil {
+		log.Printf("读取购买记录失败, deviceID=%s: %v", deviceID, err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	bought := make(map[int]int, len(boughtRows))
+	for _, row := range boughtRows {
+		bought[row.ShopItemID] = row.Bought
+	}
+
+	var stocks []model.ShopStock
+	if err := s.db.Find(&stocks).Error; err != nil {
+		log.Printf("读取商品库存失败: %v", err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	sold := make(map[int]int, len(stocks))
+	for _, stock := range stocks {
+		sold[stock.ShopItemID] = stock.Sold
+	}
+
+	listings := []Listing{}
+	for _, item := range s.catalog.Items() {
+		if !item.OnSaleAt(now) {
+			continue
+		}
+		listing := Listing{CatalogItem: item, Bought: bought[item.ShopItemID], RemainStock: -1}
+		if info, ok := utils.Items().Resolve(item.ItemID); ok {
+			listing.Name = info.Name
+			listing.Icon = info.Icon
+		}
+		if item.Stock > 0 {
+			listing.RemainStock = max(item.Stock-sold[item.ShopItemID], 0)
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+// Purchase 购买一个商品，orderKey 由 repository.RequestKey 生成，客户端重发同一个请求时返回 -8
+// 从 assets_data 的货币道具扣款、发放物品、扣减库存和购买记录在同一个事务中写入，任何一步失败都不会产生修改。
+// 购买的物品是钱包货币时增加钱包余额，流水记录 msgID 和 orderKey。
+// 错误码：-6 商品不存在或不在售，-8 重复的购买请求，-137 已永久拥有或达到限购次数，-258 库存不足，-4 货币道具不足。
+func (s *Service) Purchase(deviceID string, shopItemID int, orderKey string, msgID string, now time.Time) (*Receipt, error) {
+	if deviceID == "" || orderKey == "" {
+		return nil, game_error.New(-5, "缺少购买参数")
+	}
+	item, ok := s.catalog.Item(shopItemID)
+	if !ok || !item.OnSaleAt(now) {
+		log.Printf("商品 %d 不存在或不在售, deviceID=%s", shopItemID, deviceID)
+		return nil, game_error.New(-6, "该物品已不在商店中")
+	}
+
+	var receipt *Receipt
+	// 扣款和发放共享事务内的工作单元，全部完成后一次性写回玩家数据
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		var count int64
+		if err := tx.Model(&model.ShopPurchase{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return game_error.New(-8, "重复购买")
+		}
+		if item.LimitPerPlayer > 0 {
+			if err := tx.Model(&model.ShopPurchase{}).Where("device_id = ? AND shop_item_id = ?", deviceID, item.ShopItemID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= int64(item.LimitPerPlayer) {
+				return game_error.New(-137, "物品已购买")
+			}
+		}
+
+		im := utils.NewItemManagerWithRepository(players)
+		grantExpiredTime, savedExpiredTime, err := s.expiredTimeFor(im, deviceID, item, now)
+		if err != nil {
+			return err
+		}
+		if err := reserveStock(tx, item); err != nil {
+			return err
+		}
+		balance, err := pay(players, deviceID, item)
+		if err != nil {
+			return err
+		}
+		if currency, ok := wallet.CurrencyOfItem(item.ItemID); ok {
+			trace := wallet.Trace{Reason: reasonShopPurchase, MsgID: msgID, RequestKey: orderKey}
+			if _, err := wallet.NewService(tx).Credit(deviceID, currency, int64(item.Count), trace); err != nil {
+				return err
+			}
+		} else if err := im.GrantItemAt(deviceID, item.ItemID, item.Count, grantExpiredTime, now); err != nil {
+			return err
+		}
+
+		purchase := model.ShopPurchase{
+			DeviceID:    deviceID,
+			OrderKey:    orderKey,
+			ShopItemID:  item.ShopItemID,
+			ItemID:      item.ItemID,
+			Count:       item.Count,
+			ExpiredTime: savedExpiredTime,
+			Currency:    item.currencyName(),
+			Price:       item.Price,
+		}
+		if err := tx.Create(&purchase).Error; err != nil {
+			return err
+		}
+		receipt = &Receipt{
+			ShopItemID:     item.ShopItemID,
+			ItemID:         item.ItemID,
+			Count:          item.Count,
+			ExpiredTime:    savedExpiredTime,
+			CurrencyItemID: item.CurrencyItemID,
+			Price:          item.Price,
+			Balance:        balance,
+		}
+		return nil
+	})
+	if err != nil && s.alreadyPurchased(deviceID, orderKey) {
+		// 并发的相同请求已经先一步完成购买（购买记录唯一索引冲突）
+		err = game_error.New(-8, "重复购买")
+	}
+	return receipt, repository.ToGameError(err, "购买商品失败, deviceID=%s, shopItemID=%d", deviceID, shopItemID)
+}
+
+// expiredTimeFor 检查玩家是否已拥有该物品，并计算发放时传给 GrantItem 的过期时间和实际保存的过期时间
+// 已永久拥有的物品不能再次购买；租借商品在剩余有效期上延长，永久商品把租借中的物品改为永久。
+func (s *Service) expiredTimeFor(im *utils.ItemManager, deviceID string, item *CatalogItem, now time.Time) (int, int, error) {
+	info, err := im.Resolve(item.ItemID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if info.Category.Stackable() {
+		return 0, 0, nil
+	}
+
+	grantExpiredTime, expiredTime, err := im.RenewalExpiredTime(deviceID, item.ItemID, item.DurationSeconds, now)
+	if errors.Is(err, utils.ErrOwnedPermanently) {
+		return 0, 0, game_error.New(-137, "物品已购买")
+	}
+	return grantExpiredTime, expiredTime, err
+}
+
+// reserveStock 限量商品的已售数量加一，售罄时返回 -258
+// 通过带数量条件的 UPDATE 完成，并发购买时不会超卖。
+func reserveStock(tx *gorm.DB, item *CatalogItem) error {
+	if item.Stock <= 0 {
+		return nil
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.ShopStock{ShopItemID: item.ShopItemID}).Error; err != nil {
+		return err
+	}
+	result := tx.Model(&model.ShopStock{}).
+		Where("shop_item_id = ? AND sold < ?", item.ShopItemID, item.Stock).
+		Update("sold", gorm.Expr("sold + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return game_error.New(-258, "库存不足")
+	}
+	return nil
+}
+
+// pay 从玩家 assets_data 中的货币道具扣除价格，返回扣除后剩余的数量，不足时返回 -4
+func pay(players repository.PlayerRepository, deviceID string, item *CatalogItem) (int64, error) {
+	am := utils.NewAssetsManagerWithRepository(players)
+	owned, err := am.GetAssetCount(deviceID, item.CurrencyItemID)
+	if err != nil {
+		return 0, err
+	}
+	if int64(owned) < item.Price {
+		return 0, game_error.New(-4, "白金币或钻石不足")
+	}
+	if err := am.ConsumeAsset(deviceID, item.CurrencyItemID, int(item.Price)); err != nil {
+		return 0, err
+	}
+	return int64(owned) - item.Price, nil
+}
+
+// alreadyPurchased 检查购买记录是否已经存在
+func (s *Service) alreadyPurchased(deviceID string, orderKey string) bool {
+	var count int64
+	err := s.db.Model(&model.ShopPurchase{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error
+	return err == nil && count > 0
+}
@@ -0,0 +1,156 @@
+// internal/services/synthesis/recipes.go
+package synthesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+)
+
+// recipesPath 合成配方数据文件的路径
+const recipesPath = "configs/recipes.json"
+
+// Material 合成消耗的一种碎片，可以是资产道具或卡牌碎片
+type Material struct {
+	ItemID int `json:"itemID"`
+	Count  int `json:"count"`
+}
+
+// Recipe 一个合成配方，每个目标物品只能有一个配方
+type Recipe struct {
+	ItemID          int        `json:"itemID"`          // 合成得到的物品ID，只能是皮肤部件、卡牌皮肤或卡牌样式
+	Materials       []Material `json:"materials"`       // 消耗的碎片
+	Currency        string     `json:"currency"`        // 额外消耗的钱包货币，如 "gold"，不消耗时留空
+	Price           int64      `json:"price"`           // 额外消耗的货币数量
+	DurationSeconds int64      `json:"durationSeconds"` // 合成物品的有效期，0 表示永久；已拥有时在剩余有效期上延长
+}
+
+// recipesFile 是 recipes.json 的结构
+type recipesFile struct {
+	Recipes []Recipe `json:"recipes"`
+}
+
+// RecipeBook 合成配方表，按目标物品ID查找配方
+type RecipeBook struct {
+	byItemID map[int]*Recipe
+}
+
+// NewRecipeBook 创建配方表并检查每个配方的配置
+// 目标物品和碎片必须已在物品注册表中登记，因此需要在加载物品注册表之后调用。
+func NewRecipeBook(recipes []Recipe) (*RecipeBook, error) {
+	book := &RecipeBook{byItemID: make(map[int]*Recipe, len(recipes))}
+	for i := range recipes {
+		recipe := recipes[i]
+		recipe.Materials = append([]Material(nil), recipe.Materials...)
+		if err := validateRecipe(&recipe); err != nil {
+			return nil, fmt.Errorf("recipe for item %d: %w", recipe.ItemID, err)
+		}
+		if _, ok := book.byItemID[recipe.ItemID]; ok {
+			return nil, fmt.Errorf("duplicate recipe for item %d", recipe.ItemID)
+		}
+		book.byItemID[recipe.ItemID] = &recipe
+	}
+	return book, nil
+}
+
+// validateRecipe 检查单个配方的目标物品、碎片和货币消耗
+func validateRecipe(recipe *Recipe) error {
+	info, ok := utils.Items().Resolve(recipe.ItemID)
+	if !ok {
+		return fmt.Errorf("item %d is not in the item registry", recipe.ItemID)
+	}
+	switch info.Category {
+	case utils.ItemCategorySkinPart, utils.ItemCategoryCardSkin, utils.ItemCategoryCardStyle:
+	default:
+		return fmt.Errorf("item %d is a %s and cannot be synthesized", recipe.ItemID, info.Category)
+	}
+	if len(recipe.Materials) == 0 && recipe.Price == 0 {
+		return fmt.Errorf("recipe consumes nothing")
+	}
+
+	seen := make(map[int]bool, len(recipe.Materials))
+	for _, material := range recipe.Materials {
+		materialInfo, ok := utils.Items().Resolve(material.ItemID)
+		if !ok || !materialInfo.Category.Stackable() || materialInfo.Category == utils.ItemCategoryCurrency {
+			return fmt.Errorf("material %d is not an asset or card piece", material.ItemID)
+		}
+		if material.Count <= 0 {
+			return fmt.Errorf("count of material %d must be positive", material.ItemID)
+		}
+		if seen[material.ItemID] {
+			return fmt.Errorf("duplicate material %d", material.ItemID)
+		}
+		seen[material.ItemID] = true
+	}
+
+	if (recipe.Currency == "") != (recipe.Price == 0) {
+		return fmt.Errorf("currency and price must be set together")
+	}
+	if recipe.Price < 0 {
+		return fmt.Errorf("price cannot be negative")
+	}
+	if recipe.Currency != "" && !wallet.Currency(recipe.Currency).Valid() {
+		return fmt.Errorf("unknown currency %q", recipe.Currency)
+	}
+	if recipe.DurationSeconds < 0 {
+		return fmt.Errorf("durationSeconds cannot be negative")
+	}
+	return nil
+}
+
+// LoadRecipeBook 从JSON文件加载配方表
+func LoadRecipeBook(path string) (*RecipeBook, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file recipesFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewRecipeBook(file.Recipes)
+}
+
+// Recipe 按目标物品ID查找配方，没有配方的物品不能合成
+func (b *RecipeBook) Recipe(itemID int) (*Recipe, bool) {
+	recipe, ok := b.byItemID[itemID]
+	return recipe, ok
+}
+
+var (
+	recipeBookMu sync.RWMutex
+	recipeBook   *RecipeBook
+)
+
+// Init 加载 configs/recipes.json，由bootstrap在加载物品注册表之后调用
+func Init() error {
+	loaded, err := LoadRecipeBook(recipesPath)
+	if err != nil {
+		return err
+	}
+	SetRecipeBook(loaded)
+	log.Printf("Synthesis recipes loaded: %d recipes", len(loaded.byItemID))
+	return nil
+}
+
+// CurrentRecipeBook 返回当前使用的配方表，未加载时返回空表（任何物品都不能合成）
+func CurrentRecipeBook() *RecipeBook {
+	recipeBookMu.RLock()
+	defer recipeBookMu.RUnlock()
+	if recipeBook == nil {
+		return &RecipeBook{byItemID: map[int]*Recipe{}}
+	}
+	return recipeBook
+}
+
+// SetRecipeBook 替换当前使用的配方表
+func SetRecipeBook(b *RecipeBook) {
+	recipeBookMu.Lock()
+	defer recipeBookMu.Unlock()
+	recipeBook = b
+}
@@ -0,0 +1,129 @@
+// internal/services/synthesis/synthesis.go
+package synthesis
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/gorm"
+)
+
+// reasonSynthesis 合成写入钱包流水的原因
+const reasonSynthesis = "synthesis"
+
+// Result 是一次合成的结果
+type Result struct {
+	ItemID      int `json:"itemID"`
+	ExpiredTime int `json:"expiredTime"` // 合成物品的过期时间（Unix 秒），0 表示永久
+}
+
+// Service 在一个数据库事务中扣除碎片和货币并发放合成的物品
+type Service struct {
+	db      *gorm.DB
+	recipes *RecipeBook
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接和配方表的合成服务
+func NewService(database *gorm.DB, recipes *RecipeBook) *Service {
+	return &Service{db: database, recipes: recipes}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// Synthesize 使用全局数据库连接和当前配方表合成物品，与请求级工作单元 players 一起提交，详见 Service.Synthesize
+func Synthesize(players repository.PlayerRepository, deviceID string, itemID int, orderKey string, msgID string) (*Result, error) {
+	return NewService(db.DB, CurrentRecipeBook()).WithRequest(players).Synthesize(deviceID, itemID, orderKey, msgID, time.Now())
+}
+
+// Synthesize 按配方消耗碎片和货币合成一个皮肤部件、卡牌皮肤或卡牌样式
+// orderKey 由 repository.RequestKey 生成，客户端重发同一个请求时返回 -8，合成记录与扣除和发放在同一个事务中写入。
+// 错误码：-53 没有配方或已永久拥有该物品，-52 碎片不足，-4 货币不足；任何一步失败都不会产生修改。
+func (s *Service) Synthesize(deviceID string, itemID int, orderKey string, msgID string, now time.Time) (*Result, error) {
+	if deviceID == "" || orderKey == "" {
+		return nil, game_error.New(-5, "缺少合成参数")
+	}
+	recipe, ok := s.recipes.Recipe(itemID)
+	if !ok {
+		log.Printf("物品 %d 没有合成配方, deviceID=%s", itemID, deviceID)
+		return nil, game_error.New(-53, "该皮肤无法购买或合成")
+	}
+
+	var result *Result
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		var count int64
+		if err := tx.Model(&model.Synthesis{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return game_error.New(-8, "重复的合成请求")
+		}
+
+		im := utils.NewItemManagerWithRepository(players)
+
+		grantExpiredTime, expiredTime, err := im.RenewalExpiredTime(deviceID, recipe.ItemID, recipe.DurationSeconds, now)
+		if errors.Is(err, utils.ErrOwnedPermanently) {
+			return game_error.New(-53, "该皮肤无法购买或合成")
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, material := range recipe.Materials {
+			if err := consumeMaterial(im, deviceID, material); err != nil {
+				return err
+			}
+		}
+		if recipe.Price > 0 {
+			if _, err := wallet.NewService(tx).Debit(deviceID, wallet.Currency(recipe.Currency), recipe.Price, wallet.Trace{Reason: reasonSynthesis, MsgID: msgID, RequestKey: orderKey}); err != nil {
+				return err
+			}
+		}
+		// 由 ItemManager 按物品分区交给 SkinPartManager、CardSkinManager 或 CardStyleManager 发放
+		if err := im.GrantItemAt(deviceID, recipe.ItemID, 1, grantExpiredTime, now); err != nil {
+			return err
+		}
+
+		record := model.Synthesis{DeviceID: deviceID, OrderKey: orderKey, ItemID: recipe.ItemID, ExpiredTime: expiredTime}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		result = &Result{ItemID: recipe.ItemID, ExpiredTime: expiredTime}
+		return nil
+	})
+	if err != nil && s.alreadySynthesized(deviceID, orderKey) {
+		// 并发的相同请求已经先一步完成合成（合成记录唯一索引冲突）
+		err = game_error.New(-8, "重复的合成请求")
+	}
+	return result, repository.ToGameError(err, "合成物品失败, deviceID=%s, itemID=%d", deviceID, itemID)
+}
+
+// alreadySynthesized 检查合成记录是否已经存在
+func (s *Service) alreadySynthesized(deviceID string, orderKey string) bool {
+	var count int64
+	err := s.db.Model(&model.Synthesis{}).Where("device_id = ? AND order_key = ?", deviceID, orderKey).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// consumeMaterial 扣除一种碎片，资产道具数量不足时同样返回 -52
+func consumeMaterial(im *utils.ItemManager, deviceID string, material Material) error {
+	err := im.RevokeItem(deviceID, material.ItemID, material.Count)
+	var gameErr *game_error.GameError
+	if errors.As(err, &gameErr) && gameErr.Code == -258 {
+		return game_error.New(-52, "碎片不足")
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+// internal/services/tasks/book.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"dmmserver/services/reward"
+	"dmmserver/services/wallet"
+)
+
+// bookPath 任务定义数据文件的路径
+const bookPath = "configs/tasks.json"
+
+// Period 任务的重置周期
+type Period string
+
+const (
+	Daily  Period = "daily"  // 每日任务，在每天的跨天时刻重置
+	Weekly Period = "weekly" // 每周任务，每 7 天重置一次
+)
+
+// Periods 返回全部任务周期
+func Periods() []Period {
+	return []Period{Daily, Weekly}
+}
+
+// seconds 返回周期的长度（秒）
+func (p Period) seconds() int64 {
+	if p == Weekly {
+		return 7 * 24 * 60 * 60
+	}
+	return 24 * 60 * 60
+}
+
+// Valid 判断是否为已知的任务周期
+func (p Period) Valid() bool {
+	return p == Daily || p == Weekly
+}
+
+// Event 推进任务进度的游戏事件
+type Event string
+
+const (
+	EventLogin        Event = "login"        // 登录（30001），每个每日周期只计入一次
+	EventMatchPlayed  Event = "matchPlayed"  // 完成一场对局（30090）
+	EventCardUpgraded Event = "cardUpgraded" // 升级卡牌（30030）
+)
+
+// Valid 判断是否为已知的游戏事件
+func (e Event) Valid() bool {
+	return e == EventLogin || e == EventMatchPlayed || e == EventCardUpgraded
+}
+
+// Task 一个任务定义：在一个周期内发生 Target 次 Event 后可以领取奖励
+type Task struct {
+	TaskID  int           `json:"taskID"`
+	Period  Period        `json:"period"`  // "daily" 或 "weekly"
+	Event   Event         `json:"event"`   // 推进进度的游戏事件
+	Target  int           `json:"target"`  // 完成任务需要的次数
+	Rewards []reward.Item `json:"rewards"` // 完成后领取的奖励
+}
+
+// AllSlots 作为 dailySlots 或 weeklySlots 时表示把该周期的全部任务分配给每个玩家
+const AllSlots = -1
+
+// Settings 任务分配和重置的配置
+type Settings struct {
+	DailySlots     int    `json:"dailySlots"`     // 每天分配的每日任务数量，AllSlots 表示分配全部每日任务，0 表示不分配
+	WeeklySlots    int    `json:"weeklySlots"`    // 每周分配的每周任务数量，AllSlots 表示分配全部每周任务，0 表示不分配
+	RerollsPerDay  int    `json:"rerollsPerDay"`  // 每天可以重置（更换）任务的次数
+	RerollCurrency string `json:"rerollCurrency"` // 重置任务消耗的钱包货币，免费时留空
+	RerollPrice    int64  `json:"rerollPrice"`    // 每次重置消耗的货币数量
+}
+
+// slots 返回周期内分配给每个玩家的任务数量，AllSlots 表示全部
+func (s Settings) slots(period Period) int {
+	if period == Weekly {
+		return s.WeeklySlots
+	}
+	return s.DailySlots
+}
+
+// bookFile 是 tasks.json 的结构
+type bookFile struct {
+	Settings
+	Tasks []Task `json:"tasks"`
+}
+
+// Book 任务定义表，按 taskID 查找任务
+type Book struct {
+	settings Settings
+	tasks    []Task
+	byID     map[int]*Task
+}
+
+// NewBook 创建任务定义表并检查每个任务的配置
+// 奖励的物品ID必须已在物品注册表中登记，因此需要在加载物品注册表之后调用。
+func NewBook(settings Settings, tasks []Task) (*Book, error) {
+	if settings.DailySlots < AllSlots || settings.WeeklySlots < AllSlots {
+		return nil, fmt.Errorf("dailySlots and weeklySlots must be %d (all tasks) or not negative", AllSlots)
+	}
+	if settings.RerollsPerDay < 0 || settings.RerollPrice < 0 {
+		return nil, fmt.Errorf("rerollsPerDay and rerollPrice cannot be negative")
+	}
+	if (settings.RerollCurrency == "") != (settings.RerollPrice == 0) {
+		return nil, fmt.Errorf("rerollCurrency and rerollPrice must be set together")
+	}
+	if settings.RerollCurrency != "" && !wallet.Currency(settings.RerollCurrency).Valid() {
+		return nil, fmt.Errorf("unknown currency %q", settings.RerollCurrency)
+	}
+
+	book := &Book{settings: settings, tasks: append([]Task(nil), tasks...), byID: make(map[int]*Task, len(tasks))}
+	for i := range book.tasks {
+		task := &book.tasks[i]
+		if err := validateTask(task); err != nil {
+			return nil, fmt.Errorf("task %d: %w", task.TaskID, err)
+		}
+		if _, ok := book.byID[task.TaskID]; ok {
+			return nil, fmt.Errorf("duplicate task %d", task.TaskID)
+		}
+		book.byID[task.TaskID] = task
+	}
+	return book, nil
+}
+
+// validateTask 检查单个任务的周期、事件、目标和奖励
+func validateTask(task *Task) error {
+	if task.TaskID <= 0 {
+		return fmt.Errorf("invalid taskID")
+	}
+	if !task.Period.Valid() {
+		return fmt.Errorf("unknown period %q", task.Period)
+	}
+	if !task.Event.Valid() {
+		return fmt.Errorf("unknown event %q", task.Event)
+	}
+	if task.Target <= 0 {
+		return fmt.Errorf("target must be positive")
+	}
+	if len(task.Rewards) == 0 {
+		return fmt.Errorf("task has no rewards")
+	}
+	for _, item := range task.Rewards {
+		if err := reward.Validate(item); err != nil {
+			return fmt.Errorf("reward %d: %w", item.ItemID, err)
+		}
+	}
+	return nil
+}
+
+// LoadBook 从JSON文件加载任务定义表
+func LoadBook(path string) (*Book, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file bookFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewBook(file.Settings, file.Tasks)
+}
+
+// Task 按 taskID 查找任务
+func (b *Book) Task(taskID int) (*Task, bool) {
+	task, ok := b.byID[taskID]
+	return task, ok
+}
+
+// Settings 返回任务分配和重置的配置
+func (b *Book) Settings() Settings {
+	return b.settings
+}
+
+// pool 返回周期内的全部任务，保持数据文件中的顺序
+func (b *Book) pool(period Period) []Task {
+	var tasks []Task
+	for _, task := range b.tasks {
+		if task.Period == period {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// taskIDsFor 返回由 event 推进进度的全部任务ID
+func (b *Book) taskIDsFor(event Event) []int {
+	var ids []int
+	for _, task := range b.tasks {
+		if task.Event == event {
+			ids = append(ids, task.TaskID)
+		}
+	}
+	return ids
+}
+
+var (
+	bookMu sync.RWMutex
+	book   *Book
+)
+
+// Init 加载 configs/tasks.json，由bootstrap在加载物品注册表之后调用
+func Init() error {
+	loaded, err := LoadBook(bookPath)
+	if err != nil {
+		return err
+	}
+	SetBook(loaded)
+	log.Printf("Task definitions loaded: %d tasks", len(loaded.tasks))
+	return nil
+}
+
+// CurrentBook 返回当前使用的任务定义表，未加载时返回空表（没有任务）
+func CurrentBook() *Book {
+	bookMu.RLock()
+	defer bookMu.RUnlock()
+	if book == nil {
+		return &Book{byID: map[int]*Task{}}
+	}
+	return book
+}
+
+// SetBook 替换当前使用的任务定义表
+func SetBook(b *Book) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	book = b
+}
@@ -0,0 +1,443 @@
+// internal/services/tasks/tasks.go
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/services/reward"
+	"dmmserver/services/serversettings"
+	"dmmserver/services/wallet"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reasonTaskReroll 重置任务写入钱包流水的原因
+const reasonTaskReroll = "taskReroll"
+
+// View 是返回给客户端的一个任务及玩家的进度
+type View struct {
+	TaskID    int           `json:"taskID"`
+	Period    Period        `json:"period"`
+	Event     Event         `json:"event"`
+	Target    int           `json:"target"`
+	Progress  int           `json:"progress"`
+	Completed bool          `json:"completed"`
+	Claimed   bool          `json:"claimed"`
+	Rewards   []reward.Item `json:"rewards"`
+}
+
+// Board 是玩家当前的全部任务，以及下次重置的时间和剩余的重置次数
+type Board struct {
+	Tasks           []View `json:"tasks"`
+	DailyResetTime  int64  `json:"dailyResetTime"`  // 每日任务下次重置的时间（Unix 秒）
+	WeeklyResetTime int64  `json:"weeklyResetTime"` // 每周任务下次重置的时间（Unix 秒）
+	RerollsLeft     int    `json:"rerollsLeft"`     // 今天剩余的重置次数
+	RerollCurrency  string `json:"rerollCurrency"`  // 重置消耗的货币，免费时为空
+	RerollPrice     int64  `json:"rerollPrice"`
+}
+
+// Service 分配每日和每周任务、记录进度并发放奖励
+// 周期以服务器的跨天时间戳 overDay 为起点：每日任务每 24 小时重置，每周任务每 7 天重置。
+type Service struct {
+	db      *gorm.DB
+	book    *Book
+	overDay int64
+	request repository.PlayerRepository // 处理器的请求级工作单元，见 WithRequest
+}
+
+// NewService 创建一个使用指定数据库连接、任务定义表和跨天时间戳的任务服务
+func NewService(database *gorm.DB, book *Book, overDay int64) *Service {
+	return &Service{db: database, book: book, overDay: overDay}
+}
+
+// WithRequest 返回把处理器的请求级工作单元 players 放进同一个事务的服务副本，详见 repository.RetryRequestTx
+func (s *Service) WithRequest(players repository.PlayerRepository) *Service {
+	copied := *s
+	copied.request = players
+	return &copied
+}
+
+// current 使用全局数据库连接、当前任务定义表和服务器设置中的跨天时间戳创建任务服务
+func current() *Service {
+	return NewService(db.DB, CurrentBook(), serversettings.OverDayTimeStamp())
+}
+
+// List 返回玩家当前的任务，详见 Service.List
+func List(deviceID string) (*Board, error) {
+	return current().List(deviceID, time.Now())
+}
+
+// Record 记录一次游戏事件，详见 Service.Record
+func Record(deviceID string, event Event, amount int) error {
+	return current().Record(deviceID, event, amount, time.Now())
+}
+
+// RecordLogin 记录一次登录，与请求级工作单元 players 一起提交，详见 Service.RecordLogin
+func RecordLogin(players repository.PlayerRepository, deviceID string) error {
+	return current().WithRequest(players).RecordLogin(deviceID, time.Now())
+}
+
+// Claim 领取任务奖励，与请求级工作单元 players 一起提交，详见 Service.Claim
+func Claim(players repository.PlayerRepository, deviceID string, taskID int) ([]reward.Item, error) {
+	return current().WithRequest(players).Claim(deviceID, taskID, time.Now())
+}
+
+// Reroll 更换一个任务，与请求级工作单元 players 一起提交，详见 Service.Reroll
+func Reroll(players repository.PlayerRepository, deviceID string, taskID int, requestKey string, msgID string) (*View, error) {
+	return current().WithRequest(players).Reroll(deviceID, taskID, requestKey, msgID, time.Now())
+}
+
+// List 返回玩家在 now 所在周期内的任务，周期变化时先重新分配任务
+func (s *Service) List(deviceID string, now time.Time) (*Board, error) {
+	var board *Board
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		state, err := s.refresh(tx, deviceID, now)
+		if err != nil {
+			return err
+		}
+		var rows []model.PlayerTask
+		if err := tx.Where("device_id = ?", deviceID).Find(&rows).Error; err != nil {
+			return err
+		}
+		assigned := make(map[int]*model.PlayerTask, len(rows))
+		for i := range rows {
+			assigned[rows[i].TaskID] = &rows[i]
+		}
+
+		settings := s.book.Settings()
+		board = &Board{
+			Tasks:           []View{},
+			DailyResetTime:  s.periodStart(Daily, now) + Daily.seconds(),
+			WeeklyResetTime: s.periodStart(Weekly, now) + Weekly.seconds(),
+			RerollsLeft:     max(settings.RerollsPerDay-state.Rerolls, 0),
+			RerollCurrency:  settings.RerollCurrency,
+			RerollPrice:     settings.RerollPrice,
+		}
+		// 按数据文件中的顺序返回，已经从数据文件中删除的任务不再显示
+		for _, period := range Periods() {
+			for _, task := range s.book.pool(period) {
+				if row, ok := assigned[task.TaskID]; ok {
+					board.Tasks = append(board.Tasks, toView(&task, row))
+				}
+			}
+		}
+		return nil
+	})
+	return board, repository.ToGameError(err, "读取任务失败, deviceID=%s", deviceID)
+}
+
+// Record 把由 event 推进的任务进度增加 amount，达到任务目标后不再增加
+// 周期变化时先重新分配任务，因此跨天后的第一个事件计入新一天的任务。
+func (s *Service) Record(deviceID string, event Event, amount int, now time.Time) error {
+	taskIDs := s.book.taskIDsFor(event)
+	if deviceID == "" || amount <= 0 || len(taskIDs) == 0 {
+		return nil
+	}
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, _ *repository.UnitOfWork) error {
+		if _, err := s.refresh(tx, deviceID, now); err != nil {
+			return err
+		}
+		return advance(tx, s.book, deviceID, taskIDs, amount)
+	})
+	return repository.ToGameError(err, "记录任务进度失败, deviceID=%s, event=%s", deviceID, event)
+}
+
+// RecordLogin 记录一次登录，每个每日周期只有第一次登录推进登录任务的进度
+// 同一天内重复登录（每次都会重新生成 authKey）不会让每日和每周的登录任务多计次数。
+func (s *Service) RecordLogin(deviceID string, now time.Time) error {
+	taskIDs := s.book.taskIDsFor(EventLogin)
+	if deviceID == "" || len(taskIDs) == 0 {
+		return nil
+	}
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, _ *repository.UnitOfWork) error {
+		if _, err := s.refresh(tx, deviceID, now); err != nil {
+			return err
+		}
+		// 带条件的 UPDATE 保证同一个每日周期内并发的登录中只有一个能推进进度
+		start := s.periodStart(Daily, now)
+		result := tx.Model(&model.PlayerTaskState{}).
+			Where("device_id = ? AND login_period_start <> ?", deviceID, start).
+			Update("login_period_start", start)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return advance(tx, s.book, deviceID, taskIDs, 1)
+	})
+	return repository.ToGameError(err, "记录登录任务进度失败, deviceID=%s", deviceID)
+}
+
+// advance 把玩家 taskIDs 中每个任务的进度增加 amount，不超过任务目标
+func advance(tx *gorm.DB, book *Book, deviceID string, taskIDs []int, amount int) error {
+	for _, taskID := range taskIDs {
+		task, _ := book.Task(taskID)
+		err := tx.Model(&model.PlayerTask{}).
+			Where("device_id = ? AND task_id = ? AND progress < ?", deviceID, taskID, task.Target).
+			Update("progress", gorm.Expr("CASE WHEN progress + ? > ? THEN ? ELSE progress + ? END", amount, task.Target, task.Target, amount)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim 领取一个已完成任务的奖励
+// 任务不存在或不在当前周期返回 -100，未完成返回 -101，已领取返回 -102；领取标记和奖励在同一个事务中写入。
+func (s *Service) Claim(deviceID string, taskID int, now time.Time) ([]reward.Item, error) {
+	var items []reward.Item
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, players *repository.UnitOfWork) error {
+		if _, err := s.refresh(tx, deviceID, now); err != nil {
+			return err
+		}
+		task, row, err := s.find(tx, deviceID, taskID)
+		if err != nil {
+			return err
+		}
+		if row.Claimed {
+			return game_error.New(-102, "无法重复领取奖励")
+		}
+		if row.Progress < task.Target {
+			return game_error.New(-101, "无法领取未完成任务的奖励")
+		}
+
+		// 带条件的 UPDATE 保证并发的领取请求中只有一个能把任务改为已领取
+		result := tx.Model(&model.PlayerTask{}).
+			Where("device_id = ? AND task_id = ? AND claimed = ?", deviceID, taskID, false).
+			Update("claimed", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return game_error.New(-102, "无法重复领取奖励")
+		}
+		key := fmt.Sprintf("task:%d:%d", taskID, row.PeriodStart)
+		granted, err := reward.GrantTx(tx, players, deviceID, key, task.Rewards)
+		if err != nil {
+			return err
+		}
+		if !granted {
+			return game_error.New(-102, "无法重复领取奖励")
+		}
+		items = task.Rewards
+		return nil
+	})
+	return items, repository.ToGameError(err, "领取任务奖励失败, deviceID=%s, taskID=%d", deviceID, taskID)
+}
+
+// Reroll 把一个未完成的任务更换为同一周期内尚未分配给玩家的另一个任务，新任务从 0 开始计算进度
+// 任务不存在或不在当前周期返回 -100，当天的重置次数已用完返回 -103，
+// 任务已完成、已领取或没有可以更换的任务返回 -104，货币不足返回 -4；扣款和更换在同一个事务中写入。
+// requestKey 由 repository.RequestKey 生成，客户端重发同一个请求时返回 -8，重置记录与扣款和更换在同一个事务中写入。
+func (s *Service) Reroll(deviceID string, taskID int, requestKey string, msgID string, now time.Time) (*View, error) {
+	if deviceID == "" || requestKey == "" {
+		return nil, game_error.New(-5, "缺少重置参数")
+	}
+	var view *View
+	err := repository.RetryRequestTx(s.db, s.request, func(tx *gorm.DB, _ *repository.UnitOfWork) error {
+		var count int64
+		if err := tx.Model(&model.TaskReroll{}).Where("device_id = ? AND request_key = ?", deviceID, requestKey).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return game_error.New(-8, "重复的重置请求")
+		}
+		state, err := s.refresh(tx, deviceID, now)
+		if err != nil {
+			return err
+		}
+		task, row, err := s.find(tx, deviceID, taskID)
+		if err != nil {
+			return err
+		}
+		if row.Claimed || row.Progress >= task.Target {
+			return game_error.New(-104, "无法重置当日任务")
+		}
+		settings := s.book.Settings()
+		if state.Rerolls >= settings.RerollsPerDay {
+			return game_error.New(-103, "无法再次重置任务")
+		}
+
+		var assignedIDs []int
+		if err := tx.Model(&model.PlayerTask{}).Where("device_id = ?", deviceID).Pluck("task_id", &assignedIDs).Error; err != nil {
+			return err
+		}
+		exclude := make(map[int]bool, len(assignedIDs))
+		for _, id := range assignedIDs {
+			exclude[id] = true
+		}
+		drawn := s.draw(task.Period, exclude, 1)
+		if len(drawn) == 0 {
+			return game_error.New(-104, "无法重置当日任务")
+		}
+
+		// 带次数条件的 UPDATE 保证并发的重置请求不会超过每天的次数
+		result := tx.Model(&model.PlayerTaskState{}).
+			Where("device_id = ? AND rerolls < ?", deviceID, settings.RerollsPerDay).
+			Update("rerolls", gorm.Expr("rerolls + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return game_error.New(-103, "无法再次重置任务")
+		}
+		if settings.RerollPrice > 0 {
+			if _, err := wallet.NewService(tx).Debit(deviceID, wallet.Currency(settings.RerollCurrency), settings.RerollPrice, wallet.Trace{Reason: reasonTaskReroll, MsgID: msgID, RequestKey: requestKey}); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("device_id = ? AND task_id = ?", deviceID, taskID).Delete(&model.PlayerTask{}).Error; err != nil {
+			return err
+		}
+		newRow := model.PlayerTask{DeviceID: deviceID, TaskID: drawn[0].TaskID, Period: string(task.Period), PeriodStart: row.PeriodStart}
+		if err := tx.Create(&newRow).Error; err != nil {
+			return err
+		}
+		record := model.TaskReroll{DeviceID: deviceID, RequestKey: requestKey, OldTaskID: taskID, NewTaskID: newRow.TaskID}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		newView := toView(&drawn[0], &newRow)
+		view = &newView
+		log.Printf("玩家 %s 把任务 %d 更换为 %d", deviceID, taskID, newRow.TaskID)
+		return nil
+	})
+	if err != nil && s.alreadyRerolled(deviceID, requestKey) {
+		// 并发的相同请求已经先一步完成重置（重置记录唯一索引冲突）
+		err = game_error.New(-8, "重复的重置请求")
+	}
+	return view, repository.ToGameError(err, "更换任务失败, deviceID=%s, taskID=%d", deviceID, taskID)
+}
+
+// alreadyRerolled 检查重置记录是否已经存在
+func (s *Service) alreadyRerolled(deviceID string, requestKey string) bool {
+	var count int64
+	err := s.db.Model(&model.TaskReroll{}).Where("device_id = ? AND request_key = ?", deviceID, requestKey).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// refresh 返回玩家的任务状态，玩家没有任务或任务所属的周期已经结束时重新分配该周期的任务
+// 周期开始时间通过带旧值条件的 UPDATE 修改，并发请求中只有一个会重新分配任务；跨天时同时清零重置次数。
+func (s *Service) refresh(tx *gorm.DB, deviceID string, now time.Time) (*model.PlayerTaskState, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.PlayerTaskState{DeviceID: deviceID}).Error; err != nil {
+		return nil, err
+	}
+	var state model.PlayerTaskState
+	if err := tx.Where("device_id = ?", deviceID).First(&state).Error; err != nil {
+		return nil, err
+	}
+
+	for _, period := range Periods() {
+		column, stored := "daily_period_start", &state.DailyPeriodStart
+		if period == Weekly {
+			column, stored = "weekly_period_start", &state.WeeklyPeriodStart
+		}
+		start := s.periodStart(period, now)
+		if *stored == start {
+			continue
+		}
+
+		updates := map[string]interface{}{column: start}
+		if period == Daily {
+			updates["rerolls"] = 0
+		}
+		result := tx.Model(&model.PlayerTaskState{}).Where("device_id = ? AND "+column+" = ?", deviceID, *stored).Updates(updates)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 其他请求已经重新分配了任务
+			continue
+		}
+		*stored = start
+		if period == Daily {
+			state.Rerolls = 0
+		}
+
+		if err := tx.Where("device_id = ? AND period = ?", deviceID, string(period)).Delete(&model.PlayerTask{}).Error; err != nil {
+			return nil, err
+		}
+		drawn := s.draw(period, nil, s.book.Settings().slots(period))
+		if len(drawn) == 0 {
+			continue
+		}
+		rows := make([]model.PlayerTask, len(drawn))
+		for i, task := range drawn {
+			rows[i] = model.PlayerTask{DeviceID: deviceID, TaskID: task.TaskID, Period: string(period), PeriodStart: start}
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// draw 从周期的任务中随机选出 n 个不在 exclude 中的任务，n 为 AllSlots 或不少于可选任务数时返回全部可选任务
+func (s *Service) draw(period Period, exclude map[int]bool, n int) []Task {
+	var candidates []Task
+	for _, task := range s.book.pool(period) {
+		if !exclude[task.TaskID] {
+			candidates = append(candidates, task)
+		}
+	}
+	if n == AllSlots || n >= len(candidates) {
+		return candidates
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return candidates[:n]
+}
+
+// periodStart 返回 now 所在周期的开始时间：跨天时间戳加上整数个周期长度
+func (s *Service) periodStart(period Period, now time.Time) int64 {
+	length := period.seconds()
+	elapsed := now.Unix() - s.overDay
+	start := s.overDay + elapsed/length*length
+	if elapsed < 0 && elapsed%length != 0 {
+		start -= length
+	}
+	return start
+}
+
+// find 返回玩家当前周期内的任务定义和进度，未分配或已从数据文件中删除时返回 -100
+func (s *Service) find(tx *gorm.DB, deviceID string, taskID int) (*Task, *model.PlayerTask, error) {
+	task, ok := s.book.Task(taskID)
+	if !ok {
+		return nil, nil, game_error.New(-100, "无法找到该任务")
+	}
+	var row model.PlayerTask
+	if err := tx.Where("device_id = ? AND task_id = ?", deviceID, taskID).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, game_error.New(-100, "无法找到该任务")
+		}
+		return nil, nil, err
+	}
+	return task, &row, nil
+}
+
+// toView 把任务定义和玩家进度转换为返回给客户端的格式
+func toView(task *Task, row *model.PlayerTask) View {
+	progress := min(row.Progress, task.Target)
+	return View{
+		TaskID:    task.TaskID,
+		Period:    task.Period,
+		Event:     task.Event,
+		Target:    task.Target,
+		Progress:  progress,
+		Completed: progress >= task.Target,
+		Claimed:   row.Claimed,
+		Rewards:   task.Rewards,
+	}
+}
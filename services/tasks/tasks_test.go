@@ -0,0 +1,189 @@
+// internal/services/tasks/tasks_test.go
+package tasks
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dmmserver/db/migrations"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/services/reward"
+	"dmmserver/services/wallet"
+	"dmmserver/utils"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTasks 创建临时的 SQLite 数据库并加载物品注册表
+func setupTasks(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "tasks.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	registry, err := utils.LoadItemRegistry("../../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	utils.SetItemRegistry(registry)
+	return d
+}
+
+// 同一个每日周期内多次登录只推进一次登录任务，进入下一个周期后再次计入
+func TestRecordLoginCountsOncePerDay(t *testing.T) {
+	d := setupTasks(t)
+	rewards := []reward.Item{{ItemID: 30, Count: 1}}
+	book, err := NewBook(Settings{DailySlots: AllSlots, WeeklySlots: AllSlots}, []Task{
+		{TaskID: 1, Period: Daily, Event: EventLogin, Target: 1, Rewards: rewards},
+		{TaskID: 2, Period: Weekly, Event: EventLogin, Target: 5, Rewards: rewards},
+	})
+	if err != nil {
+		t.Fatalf("创建任务定义表失败: %v", err)
+	}
+	overDay := time.Date(2026, 1, 5, 4, 0, 0, 0, time.UTC)
+	s := NewService(d, book, overDay.Unix())
+
+	const deviceID = "device-tasks"
+	logins := []time.Time{
+		overDay.Add(time.Hour),
+		overDay.Add(2 * time.Hour),
+		overDay.Add(23 * time.Hour),
+		overDay.Add(25 * time.Hour),
+		overDay.Add(26 * time.Hour),
+	}
+	for _, now := range logins {
+		if err := s.RecordLogin(deviceID, now); err != nil {
+			t.Fatalf("记录登录失败: %v", err)
+		}
+	}
+
+	board, err := s.List(deviceID, overDay.Add(26*time.Hour))
+	if err != nil {
+		t.Fatalf("读取任务失败: %v", err)
+	}
+	progress := map[int]int{}
+	for _, task := range board.Tasks {
+		progress[task.TaskID] = task.Progress
+	}
+	if progress[1] != 1 || progress[2] != 2 {
+		t.Fatalf("任务进度为 %v，期望每日登录任务为 1、每周登录任务为 2", progress)
+	}
+}
+
+// 分配数量为 AllSlots 时分配全部任务，0 时不分配，其他负数在加载时报错
+func TestSlots(t *testing.T) {
+	registry, err := utils.LoadItemRegistry("../../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	utils.SetItemRegistry(registry)
+
+	rewards := []reward.Item{{ItemID: 30, Count: 1}}
+	tasks := []Task{
+		{TaskID: 1, Period: Daily, Event: EventLogin, Target: 1, Rewards: rewards},
+		{TaskID: 2, Period: Daily, Event: EventLogin, Target: 2, Rewards: rewards},
+		{TaskID: 3, Period: Daily, Event: EventLogin, Target: 3, Rewards: rewards},
+	}
+	cases := []struct {
+		slots int
+		want  int
+	}{
+		{AllSlots, 3},
+		{0, 0},
+		{2, 2},
+		{5, 3},
+	}
+	for _, tc := range cases {
+		book, err := NewBook(Settings{DailySlots: tc.slots}, tasks)
+		if err != nil {
+			t.Fatalf("dailySlots=%d 时创建任务定义表失败: %v", tc.slots, err)
+		}
+		s := NewService(nil, book, 0)
+		if got := len(s.draw(Daily, nil, book.Settings().slots(Daily))); got != tc.want {
+			t.Fatalf("dailySlots=%d 时分配了 %d 个任务，期望 %d 个", tc.slots, got, tc.want)
+		}
+	}
+	if _, err := NewBook(Settings{WeeklySlots: -2}, tasks); err == nil {
+		t.Fatal("weeklySlots=-2 时没有报错")
+	}
+}
+
+// 客户端重发同一个 requestKey 的重置请求时返回 -8，任务只重置一次，货币只扣除一次
+func TestRerollRejectsRepeatedRequestKey(t *testing.T) {
+	d := setupTasks(t)
+	rewards := []reward.Item{{ItemID: 30, Count: 1}}
+	settings := Settings{DailySlots: 1, RerollsPerDay: 3, RerollCurrency: string(wallet.Diamonds), RerollPrice: 20}
+	book, err := NewBook(settings, []Task{
+		{TaskID: 1, Period: Daily, Event: EventLogin, Target: 1, Rewards: rewards},
+		{TaskID: 2, Period: Daily, Event: EventLogin, Target: 1, Rewards: rewards},
+	})
+	if err != nil {
+		t.Fatalf("创建任务定义表失败: %v", err)
+	}
+	overDay := time.Date(2026, 1, 5, 4, 0, 0, 0, time.UTC)
+	now := overDay.Add(time.Hour)
+	s := NewService(d, book, overDay.Unix())
+
+	const deviceID = "device-tasks"
+	if err := d.Create(&model.PlayerData{DeviceID: deviceID, RoleID: 1000}).Error; err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+	if _, err := wallet.NewService(d).Credit(deviceID, wallet.Diamonds, 100, wallet.Trace{Reason: "test"}); err != nil {
+		t.Fatalf("增加余额失败: %v", err)
+	}
+	board, err := s.List(deviceID, now)
+	if err != nil || len(board.Tasks) != 1 {
+		t.Fatalf("读取任务返回 %+v, %v，期望分配 1 个任务", board, err)
+	}
+	oldTaskID := board.Tasks[0].TaskID
+
+	const requestKey = "auth-device-tasks:7"
+	view, err := s.Reroll(deviceID, oldTaskID, requestKey, "30082", now)
+	if err != nil {
+		t.Fatalf("重置任务失败: %v", err)
+	}
+	// 重发的请求无论带的是旧任务还是新任务，都在重置之前被拒绝
+	for _, taskID := range []int{oldTaskID, view.TaskID} {
+		_, err := s.Reroll(deviceID, taskID, requestKey, "30082", now)
+		var gameErr *game_error.GameError
+		if !errors.As(err, &gameErr) || gameErr.Code != -8 {
+			t.Fatalf("重发重置任务 %d 的请求返回 %v，期望 -8", taskID, err)
+		}
+	}
+
+	board, err = s.List(deviceID, now)
+	if err != nil {
+		t.Fatalf("读取任务失败: %v", err)
+	}
+	if len(board.Tasks) != 1 || board.Tasks[0].TaskID != view.TaskID || board.RerollsLeft != 2 {
+		t.Fatalf("任务为 %+v，剩余重置次数 %d，期望只重置了一次", board.Tasks, board.RerollsLeft)
+	}
+	w, err := wallet.NewService(d).Get(deviceID)
+	if err != nil {
+		t.Fatalf("读取钱包失败: %v", err)
+	}
+	if w.Diamonds != 80 {
+		t.Fatalf("钻石余额为 %d，期望只扣除一次后剩余 80", w.Diamonds)
+	}
+	var rerolls int64
+	if err := d.Model(&model.TaskReroll{}).Where("device_id = ?", deviceID).Count(&rerolls).Error; err != nil {
+		t.Fatalf("统计重置记录失败: %v", err)
+	}
+	if rerolls != 1 {
+		t.Fatalf("有 %d 条重置记录，期望 1 条", rerolls)
+	}
+}
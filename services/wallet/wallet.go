@@ -0,0 +1,296 @@
+// internal/services/wallet/wallet.go
+package wallet
+
+import (
+	"log"
+
+	"dmmserver/conf"
+	"dmmserver/db"
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+	"dmmserver/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Currency 货币类型
+type Currency string
+
+const (
+	Gold                Currency = "gold"                // 金币
+	Diamonds            Currency = "diamonds"            // 钻石
+	Tickets             Currency = "tickets"             // 点券
+	ColoringAgent       Currency = "coloringAgent"       // 染色剂
+	NormalFortuneCards  Currency = "normalFortuneCards"  // 普通幸运卡
+	AdvanceFortuneCards Currency = "advanceFortuneCards" // 高级幸运卡
+)
+
+// ReasonInitial 新建钱包时写入初始余额的流水原因
+const ReasonInitial = "initial"
+
+// Trace 描述一次余额变动的来源，随变动写入流水
+type Trace struct {
+	Reason     string // 变动原因，如 "initial"、"cardUpgrade"、"reward"，不能为空
+	MsgID      string // 触发变动的 msg_id，后台操作为空
+	RequestKey string // 触发变动的请求的幂等键（如 repository.RequestKey 或奖励的幂等键），同一次请求的流水使用相同的值
+}
+
+// currencyColumns 货币类型对应的 dmm_wallets 列名
+var currencyColumns = map[Currency]string{
+	Gold:                "gold",
+	Diamonds:            "diamonds",
+	Tickets:             "tickets",
+	ColoringAgent:       "coloring_agent",
+	NormalFortuneCards:  "normal_fortune_cards",
+	AdvanceFortuneCards: "advance_fortune_cards",
+}
+
+// currencyItems 物品注册表 currency 分区中的原始ID（统一物品ID - offset）对应的货币类型，统一物品ID见 configs/items.json
+var currencyItems = map[int]Currency{
+	1: Gold,
+	2: Diamonds,
+	3: Tickets,
+	4: ColoringAgent,
+	5: NormalFortuneCards,
+	6: AdvanceFortuneCards,
+}
+
+// Currencies 返回所有货币类型
+func Currencies() []Currency {
+	return []Currency{Gold, Diamonds, Tickets, ColoringAgent, NormalFortuneCards, AdvanceFortuneCards}
+}
+
+// Valid 判断是否为已知的货币类型
+func (c Currency) Valid() bool {
+	_, ok := currencyColumns[c]
+	return ok
+}
+
+// CurrencyOfItem 返回物品ID对应的货币类型，不是物品注册表中 currency 分区的物品时返回 false
+// 奖励、邮件附件、礼包和商店通过它把货币物品交给钱包，而不是写入 assets_data。
+func CurrencyOfItem(itemID int) (Currency, bool) {
+	info, ok := utils.Items().Resolve(itemID)
+	if !ok || info.Category != utils.ItemCategoryCurrency {
+		return "", false
+	}
+	currency, ok := currencyItems[info.NativeID]
+	return currency, ok
+}
+
+// BalanceOf 返回钱包中指定货币的余额，未知货币返回 0
+func BalanceOf(w *model.Wallet, c Currency) int64 {
+	switch c {
+	case Gold:
+		return w.Gold
+	case Diamonds:
+		return w.Diamonds
+	case Tickets:
+		return w.Tickets
+	case ColoringAgent:
+		return w.ColoringAgent
+	case NormalFortuneCards:
+		return w.NormalFortuneCards
+	case AdvanceFortuneCards:
+		return w.AdvanceFortuneCards
+	default:
+		return 0
+	}
+}
+
+// Service 读写玩家的货币钱包和流水
+// 每次余额变动与对应的流水在同一个事务中写入；传入的数据库连接本身是事务时，
+// 变动会成为该事务的一部分（例如与发放物品一起提交或回滚）。
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService 创建一个使用指定数据库连接的钱包服务
+func NewService(database *gorm.DB) *Service {
+	return &Service{db: database}
+}
+
+// Get 使用全局数据库连接读取钱包，详见 Service.Get
+func Get(deviceID string) (*model.Wallet, error) {
+	return NewService(db.DB).Get(deviceID)
+}
+
+// Credit 使用全局数据库连接增加余额，详见 Service.Credit
+func Credit(deviceID string, currency Currency, amount int64, trace Trace) (int64, error) {
+	return NewService(db.DB).Credit(deviceID, currency, amount, trace)
+}
+
+// Debit 使用全局数据库连接扣除余额，详见 Service.Debit
+func Debit(deviceID string, currency Currency, amount int64, trace Trace) (int64, error) {
+	return NewService(db.DB).Debit(deviceID, currency, amount, trace)
+}
+
+// Get 读取玩家的钱包，玩家还没有钱包时按配置的初始余额创建
+func (s *Service) Get(deviceID string) (*model.Wallet, error) {
+	var wallet model.Wallet
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureWallet(tx, deviceID); err != nil {
+			return err
+		}
+		return tx.Where("device_id = ?", deviceID).First(&wallet).Error
+	})
+	if err != nil {
+		return nil, repository.ToGameError(err, "读取钱包失败, deviceID=%s", deviceID)
+	}
+	return &wallet, nil
+}
+
+// Credit 增加 amount 数量的货币并记录流水，返回变动后的余额
+// trace 描述变动的来源，原样写入流水。
+func (s *Service) Credit(deviceID string, currency Currency, amount int64, trace Trace) (int64, error) {
+	if amount <= 0 {
+		log.Printf("钱包增加数量无效, deviceID=%s, currency=%s, amount=%d", deviceID, currency, amount)
+		return 0, game_error.New(-13, "非法参数")
+	}
+	return s.change(deviceID, currency, amount, trace)
+}
+
+// Debit 扣除 amount 数量的货币并记录流水，返回变动后的余额
+// 余额不足时返回 -4，不会产生任何修改。
+func (s *Service) Debit(deviceID string, currency Currency, amount int64, trace Trace) (int64, error) {
+	if amount <= 0 {
+		log.Printf("钱包扣除数量无效, deviceID=%s, currency=%s, amount=%d", deviceID, currency, amount)
+		return 0, game_error.New(-13, "非法参数")
+	}
+	return s.change(deviceID, currency, -amount, trace)
+}
+
+// Ledger 按时间倒序返回玩家最近的 limit 条流水
+func (s *Service) Ledger(deviceID string, limit int) ([]model.WalletLedgerEntry, error) {
+	var entries []model.WalletLedgerEntry
+	err := s.db.Where("device_id = ?", deviceID).Order("id DESC").Limit(limit).Find(&entries).Error
+	if err != nil {
+		log.Printf("读取钱包流水失败, deviceID=%s: %v", deviceID, err)
+		return nil, game_error.New(-2, "数据库查询错误")
+	}
+	return entries, nil
+}
+
+// change 在一个事务中修改余额并追加流水，delta 为正数表示增加，负数表示扣除
+// 扣除通过带余额条件的 UPDATE 完成，并发扣除时不会出现负数余额。
+func (s *Service) change(deviceID string, currency Currency, delta int64, trace Trace) (int64, error) {
+	column, ok := currencyColumns[currency]
+	if !ok {
+		log.Printf("未知的货币类型, deviceID=%s, currency=%s", deviceID, currency)
+		return 0, game_error.New(-13, "非法参数")
+	}
+	if deviceID == "" || trace.Reason == "" {
+		return 0, game_error.New(-5, "缺少钱包变动参数")
+	}
+
+	var balance int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureWallet(tx, deviceID); err != nil {
+			return err
+		}
+
+		query := tx.Model(&model.Wallet{}).Where("device_id = ?", deviceID)
+		if delta < 0 {
+			query = query.Where(column+" >= ?", -delta)
+		}
+		result := query.Update(column, gorm.Expr(column+" + ?", delta))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return game_error.New(-4, "白金币或钻石不足")
+		}
+
+		if err := tx.Model(&model.Wallet{}).Where("device_id = ?", deviceID).Select(column).Row().Scan(&balance); err != nil {
+			return err
+		}
+		return tx.Create(&model.WalletLedgerEntry{
+			DeviceID:     deviceID,
+			Currency:     string(currency),
+			Amount:       delta,
+			BalanceAfter: balance,
+			Reason:       trace.Reason,
+			MsgID:        trace.MsgID,
+			RequestKey:   trace.RequestKey,
+		}).Error
+	})
+	if err != nil {
+		return 0, repository.ToGameError(err, "钱包操作失败, deviceID=%s", deviceID)
+	}
+	return balance, nil
+}
+
+// ensureWallet 玩家还没有钱包时按配置的初始余额创建，并为每个非零的初始余额追加一条流水
+// 并发创建时只有一个请求会插入成功，初始流水也只写入一次。
+func ensureWallet(tx *gorm.DB, deviceID string) error {
+	var count int64
+	if err := tx.Model(&model.Wallet{}).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if err := tx.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return game_error.New(-3, "未找到玩家数据")
+	}
+
+	wallet := initialWallet(deviceID)
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(wallet)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	for _, currency := range Currencies() {
+		if amount := BalanceOf(wallet, currency); amount != 0 {
+			entry := model.WalletLedgerEntry{
+				DeviceID:     deviceID,
+				Currency:     string(currency),
+				Amount:       amount,
+				BalanceAfter: amount,
+				Reason:       ReasonInitial,
+			}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// initialWallet 按 wallet.initialBalances 配置创建新钱包，未知货币和负数余额会被忽略
+func initialWallet(deviceID string) *model.Wallet {
+	wallet := &model.Wallet{DeviceID: deviceID}
+	if conf.Conf == nil {
+		return wallet
+	}
+	for name, amount := range conf.Conf.Wallet.InitialBalances {
+		if amount < 0 {
+			log.Printf("货币 %s 的初始余额为负数，已忽略", name)
+			continue
+		}
+		switch Currency(name) {
+		case Gold:
+			wallet.Gold = amount
+		case Diamonds:
+			wallet.Diamonds = amount
+		case Tickets:
+			wallet.Tickets = amount
+		case ColoringAgent:
+			wallet.ColoringAgent = amount
+		case NormalFortuneCards:
+			wallet.NormalFortuneCards = amount
+		case AdvanceFortuneCards:
+			wallet.AdvanceFortuneCards = amount
+		default:
+			log.Printf("未知的货币类型 %s，已忽略其初始余额", name)
+		}
+	}
+	return wallet
+}
@@ -0,0 +1,169 @@
+// internal/services/wallet/wallet_test.go
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/game_error"
+	"dmmserver/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupWallet 使用临时的 SQLite 数据库替换 db.DB，并创建一个钱包为空的玩家
+func setupWallet(t *testing.T, deviceID string) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "wallet.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := d.Create(&model.PlayerData{DeviceID: deviceID, RoleID: 1000}).Error; err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+}
+
+// ledger 按写入顺序返回玩家的全部流水
+func ledger(t *testing.T, deviceID string) []model.WalletLedgerEntry {
+	t.Helper()
+	var entries []model.WalletLedgerEntry
+	if err := db.DB.Where("device_id = ?", deviceID).Order("id").Find(&entries).Error; err != nil {
+		t.Fatalf("读取流水失败: %v", err)
+	}
+	return entries
+}
+
+// balance 返回玩家当前的余额
+func balance(t *testing.T, deviceID string, currency Currency) int64 {
+	t.Helper()
+	w, err := Get(deviceID)
+	if err != nil {
+		t.Fatalf("读取钱包失败: %v", err)
+	}
+	return BalanceOf(w, currency)
+}
+
+// 余额不足时返回 -4，余额和流水都不变
+func TestDebitInsufficientFunds(t *testing.T) {
+	const deviceID = "device-wallet"
+	setupWallet(t, deviceID)
+	if _, err := Credit(deviceID, Gold, 100, Trace{Reason: "test"}); err != nil {
+		t.Fatalf("增加余额失败: %v", err)
+	}
+
+	_, err := Debit(deviceID, Gold, 150, Trace{Reason: "test"})
+	var gameErr *game_error.GameError
+	if !errors.As(err, &gameErr) || gameErr.Code != -4 {
+		t.Fatalf("余额不足时返回 %v，期望 -4", err)
+	}
+	if got := balance(t, deviceID, Gold); got != 100 {
+		t.Fatalf("扣除失败后余额为 %d，期望 100", got)
+	}
+	if entries := ledger(t, deviceID); len(entries) != 1 {
+		t.Fatalf("扣除失败后有 %d 条流水，期望只有增加的 1 条", len(entries))
+	}
+}
+
+// 并发扣除时只有余额足够的请求成功，余额不会变成负数
+func TestConcurrentDebitsNeverGoNegative(t *testing.T) {
+	const deviceID = "device-wallet"
+	setupWallet(t, deviceID)
+	if _, err := Credit(deviceID, Diamonds, 100, Trace{Reason: "test"}); err != nil {
+		t.Fatalf("增加余额失败: %v", err)
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			after, err := Debit(deviceID, Diamonds, 30, Trace{Reason: "test"})
+			var gameErr *game_error.GameError
+			switch {
+			case err == nil:
+				if after < 0 {
+					t.Errorf("扣除后余额为 %d", after)
+				}
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			case !errors.As(err, &gameErr) || gameErr.Code != -4:
+				t.Errorf("并发扣除返回 %v，期望成功或 -4", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 3 {
+		t.Fatalf("%d 个并发扣除中有 %d 个成功，期望 3 个", n, succeeded)
+	}
+	if got := balance(t, deviceID, Diamonds); got != 10 {
+		t.Fatalf("余额为 %d，期望 10", got)
+	}
+}
+
+// 每次增加和扣除都追加一条流水，流水记录来源，Amount 之和等于余额
+func TestLedgerRowPerChange(t *testing.T) {
+	const deviceID = "device-wallet"
+	setupWallet(t, deviceID)
+	steps := []struct {
+		amount int64 // 正数为增加，负数为扣除
+		trace  Trace
+	}{
+		{50, Trace{Reason: "reward", RequestKey: "task:1001:0"}},
+		{-20, Trace{Reason: "cardUpgrade", MsgID: "30030", RequestKey: "auth:1"}},
+		{30, Trace{Reason: "reward", RequestKey: "mail:uuid"}},
+		{-60, Trace{Reason: "synthesis", MsgID: "30040", RequestKey: "auth:2"}},
+	}
+	for i, step := range steps {
+		var err error
+		if step.amount > 0 {
+			_, err = Credit(deviceID, Gold, step.amount, step.trace)
+		} else {
+			_, err = Debit(deviceID, Gold, -step.amount, step.trace)
+		}
+		if err != nil {
+			t.Fatalf("第 %d 次变动失败: %v", i+1, err)
+		}
+	}
+
+	entries := ledger(t, deviceID)
+	if len(entries) != len(steps) {
+		t.Fatalf("有 %d 条流水，期望 %d 条", len(entries), len(steps))
+	}
+	var sum int64
+	for i, entry := range entries {
+		sum += entry.Amount
+		step := steps[i]
+		if entry.Amount != step.amount || entry.BalanceAfter != sum || entry.Currency != string(Gold) {
+			t.Fatalf("第 %d 条流水为 %+v，期望数量 %d、变动后余额 %d", i+1, entry, step.amount, sum)
+		}
+		if entry.Reason != step.trace.Reason || entry.MsgID != step.trace.MsgID || entry.RequestKey != step.trace.RequestKey {
+			t.Fatalf("第 %d 条流水的来源为 %q/%q/%q，期望 %+v", i+1, entry.Reason, entry.MsgID, entry.RequestKey, step.trace)
+		}
+	}
+	if got := balance(t, deviceID, Gold); got != sum {
+		t.Fatalf("余额为 %d，流水之和为 %d", got, sum)
+	}
+}
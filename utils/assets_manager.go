@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"log"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
@@ -22,18 +21,31 @@ type AssetsData struct {
 }
 
 // AssetsManager 提供资产数据的管理功能
-type AssetsManager struct {}
+type AssetsManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewAssetsManager 创建一个新的资产管理器
 func NewAssetsManager() *AssetsManager {
-	return &AssetsManager{}
+	return NewAssetsManagerWithRepository(repository.Default())
+}
+
+// NewAssetsManagerWithRepository 使用指定的玩家仓库创建资产管理器
+func NewAssetsManagerWithRepository(repo repository.PlayerRepository) *AssetsManager {
+	return &AssetsManager{repo: repo}
+}
+
+// retry 以乐观锁执行资产数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (am *AssetsManager) retry(fn func(tx *AssetsManager) error) error {
+	return repository.RetryOnConflict(am.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewAssetsManagerWithRepository(repo))
+	})
 }
 
 // GetAssetsData 从数据库获取指定设备ID的资产数据
 func (am *AssetsManager) GetAssetsData(deviceID string) (*AssetsData, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := am.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -54,7 +66,7 @@ func (am *AssetsManager) GetAssetsData(deviceID string) (*AssetsData, error) {
 		return defaultAssetsData, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.AssetsData), &assetsData)
+	err = json.Unmarshal([]byte(playerData.AssetsData), &assetsData)
 	if err != nil {
 		log.Printf("解析资产数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -75,9 +87,8 @@ func (am *AssetsManager) SaveAssetsData(deviceID string, assetsData *AssetsData)
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("assets_data", string(assetsDataJSON))
-	if result.Error != nil {
-		log.Printf("更新资产数据失败: %v", result.Error)
+	if err := am.repo.UpdateColumns(deviceID, map[string]interface{}{"assets_data": string(assetsDataJSON)}); err != nil {
+		log.Printf("更新资产数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -100,17 +111,19 @@ func (am *AssetsManager) GetDefaultAssetsData() *AssetsData {
 
 // UpdateAssets 更新玩家拥有的资产数据
 func (am *AssetsManager) UpdateAssets(deviceID string, assets []Asset) error {
-	// 获取当前资产数据
-	assetsData, err := am.GetAssetsData(deviceID)
-	if err != nil {
-		return err
-	}
+	return am.retry(func(tx *AssetsManager) error {
+		// 获取当前资产数据
+		assetsData, err := tx.GetAssetsData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 更新拥有的资产数据
-	assetsData.OwnedAssets = assets
+		// 更新拥有的资产数据
+		assetsData.OwnedAssets = assets
 
-	// 保存到数据库
-	return am.SaveAssetsData(deviceID, assetsData)
+		// 保存到数据库
+		return tx.SaveAssetsData(deviceID, assetsData)
+	})
 }
 
 // GetAssets 获取玩家拥有的资产数据
@@ -126,84 +139,118 @@ func (am *AssetsManager) GetAssets(deviceID string) ([]Asset, error) {
 
 // AddAsset 添加一个新的资产到玩家拥有的资产列表或更新现有资产数量
 func (am *AssetsManager) AddAsset(deviceID string, itemID int, itemCount int) error {
-	// 获取当前资产数据
-	assetsData, err := am.GetAssetsData(deviceID)
-	if err != nil {
-		return err
-	}
+	return am.retry(func(tx *AssetsManager) error {
+		// 获取当前资产数据
+		assetsData, err := tx.GetAssetsData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 检查资产是否已存在
-	for i, asset := range assetsData.OwnedAssets {
-		if asset.ItemID == itemID {
-			// 如果已存在，更新数量
-			assetsData.OwnedAssets[i].ItemCount += itemCount
-			return am.SaveAssetsData(deviceID, assetsData)
+		// 检查资产是否已存在
+		for i, asset := range assetsData.OwnedAssets {
+			if asset.ItemID == itemID {
+				// 如果已存在，更新数量
+				assetsData.OwnedAssets[i].ItemCount += itemCount
+				return tx.SaveAssetsData(deviceID, assetsData)
+			}
 		}
-	}
 
-	// 添加新资产
-	assetsData.OwnedAssets = append(assetsData.OwnedAssets, Asset{ItemID: itemID, ItemCount: itemCount})
+		// 添加新资产
+		assetsData.OwnedAssets = append(assetsData.OwnedAssets, Asset{ItemID: itemID, ItemCount: itemCount})
 
-	// 保存到数据库
-	return am.SaveAssetsData(deviceID, assetsData)
+		// 保存到数据库
+		return tx.SaveAssetsData(deviceID, assetsData)
+	})
 }
 
 // UpdateAssetCount 更新指定资产的数量
 func (am *AssetsManager) UpdateAssetCount(deviceID string, itemID int, itemCount int) error {
-	// 获取当前资产数据
-	assetsData, err := am.GetAssetsData(deviceID)
-	if err != nil {
-		return err
-	}
+	return am.retry(func(tx *AssetsManager) error {
+		// 获取当前资产数据
+		assetsData, err := tx.GetAssetsData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并更新资产数量
-	found := false
-	for i, asset := range assetsData.OwnedAssets {
-		if asset.ItemID == itemID {
-			assetsData.OwnedAssets[i].ItemCount = itemCount
-			found = true
-			break
+		// 查找并更新资产数量
+		found := false
+		for i, asset := range assetsData.OwnedAssets {
+			if asset.ItemID == itemID {
+				assetsData.OwnedAssets[i].ItemCount = itemCount
+				found = true
+				break
+			}
 		}
-	}
 
-	if !found {
-		// 如果资产不存在，添加新资产
-		assetsData.OwnedAssets = append(assetsData.OwnedAssets, Asset{ItemID: itemID, ItemCount: itemCount})
-	}
+		if !found {
+			// 如果资产不存在，添加新资产
+			assetsData.OwnedAssets = append(assetsData.OwnedAssets, Asset{ItemID: itemID, ItemCount: itemCount})
+		}
+
+		// 保存到数据库
+		return tx.SaveAssetsData(deviceID, assetsData)
+	})
+}
+
+// ConsumeAsset 扣除指定数量的资产，数量不足时返回 -258，扣完后从列表中移除
+func (am *AssetsManager) ConsumeAsset(deviceID string, itemID int, itemCount int) error {
+	return am.retry(func(tx *AssetsManager) error {
+		assetsData, err := tx.GetAssetsData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 保存到数据库
-	return am.SaveAssetsData(deviceID, assetsData)
+		for i, asset := range assetsData.OwnedAssets {
+			if asset.ItemID != itemID {
+				continue
+			}
+			if asset.ItemCount < itemCount {
+				break
+			}
+			if asset.ItemCount == itemCount {
+				assetsData.OwnedAssets = append(assetsData.OwnedAssets[:i], assetsData.OwnedAssets[i+1:]...)
+			} else {
+				assetsData.OwnedAssets[i].ItemCount -= itemCount
+			}
+			return tx.SaveAssetsData(deviceID, assetsData)
+		}
+
+		log.Printf("玩家 %s 的资产 %d 数量不足 %d", deviceID, itemID, itemCount)
+		return game_error.New(-258, "库存不足")
+	})
 }
 
 // RemoveAsset 从玩家拥有的资产列表中移除一个资产
 func (am *AssetsManager) RemoveAsset(deviceID string, itemID int) error {
-	// 获取当前资产数据
-	assetsData, err := am.GetAssetsData(deviceID)
-	if err != nil {
-		return err
-	}
+	return am.retry(func(tx *AssetsManager) error {
+		// 获取当前资产数据
+		assetsData, err := tx.GetAssetsData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并移除资产
-	found := false
-	newAssets := []Asset{}
+		// 查找并移除资产
+		found := false
+		newAssets := []Asset{}
 
-	for _, asset := range assetsData.OwnedAssets {
-		if asset.ItemID != itemID {
-			newAssets = append(newAssets, asset)
-		} else {
-			found = true
+		for _, asset := range assetsData.OwnedAssets {
+			if asset.ItemID != itemID {
+				newAssets = append(newAssets, asset)
+			} else {
+				found = true
+			}
 		}
-	}
 
-	if !found {
-		return game_error.New(-2, "资产不存在")
-	}
+		if !found {
+			return game_error.New(-2, "资产不存在")
+		}
 
-	// 更新资产数据
-	assetsData.OwnedAssets = newAssets
+		// 更新资产数据
+		assetsData.OwnedAssets = newAssets
 
-	// 保存到数据库
-	return am.SaveAssetsData(deviceID, assetsData)
+		// 保存到数据库
+		return tx.SaveAssetsData(deviceID, assetsData)
+	})
 }
 
 // GetAssetCount 获取指定资产的数量
@@ -4,43 +4,84 @@ package utils
 import (
 	"encoding/json"
 	"log"
+	"time"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
-// OwnedHeadBox 表示玩家拥有的头像框结构
-type OwnedHeadBox struct {
-	HeadBoxID   []int `json:"headBoxID"`   // 头像框ID
-	ExpiredTime []int `json:"expiredTime"` // 过期时间
+// BoxesData 表示完整的装饰框数据结构
+// 保存格式为 {"ownedHeadBoxes":{"headBoxID":[],"expiredTime":[]},"ownedBubbleBoxes":{"bubbleBoxID":[],"expiredTime":[]}}
+type BoxesData struct {
+	OwnedHeadBoxes   Entitlements[int] // 拥有的头像框
+	OwnedBubbleBoxes Entitlements[int] // 拥有的聊天气泡
 }
 
-// OwnedBubbleBox 表示玩家拥有的聊天气泡结构
-type OwnedBubbleBox struct {
-	BubbleBoxID []int `json:"bubbleBoxID"` // 聊天气泡ID
-	ExpiredTime []int `json:"expiredTime"` // 过期时间
+// boxesDataJSON 是 BoxesData 在数据库中的保存格式
+type boxesDataJSON struct {
+	OwnedHeadBoxes   json.RawMessage `json:"ownedHeadBoxes"`
+	OwnedBubbleBoxes json.RawMessage `json:"ownedBubbleBoxes"`
 }
 
-// BoxesData 表示完整的装饰框数据结构
-type BoxesData struct {
-	OwnedHeadBoxes   OwnedHeadBox   `json:"ownedHeadBoxes"`   // 拥有的头像框
-	OwnedBubbleBoxes OwnedBubbleBox `json:"ownedBubbleBoxes"` // 拥有的聊天气泡
+// MarshalJSON 按数据库中的保存格式序列化装饰框数据
+func (b BoxesData) MarshalJSON() ([]byte, error) {
+	headBoxes, err := b.OwnedHeadBoxes.MarshalArraysJSON("headBoxID", "expiredTime")
+	if err != nil {
+		return nil, err
+	}
+	bubbleBoxes, err := b.OwnedBubbleBoxes.MarshalArraysJSON("bubbleBoxID", "expiredTime")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(boxesDataJSON{OwnedHeadBoxes: headBoxes, OwnedBubbleBoxes: bubbleBoxes})
+}
+
+// UnmarshalJSON 解析数据库中保存的装饰框数据
+func (b *BoxesData) UnmarshalJSON(data []byte) error {
+	var raw boxesDataJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = BoxesData{}
+	if len(raw.OwnedHeadBoxes) > 0 {
+		if err := b.OwnedHeadBoxes.UnmarshalArraysJSON(raw.OwnedHeadBoxes, "headBoxID", "expiredTime"); err != nil {
+			return err
+		}
+	}
+	if len(raw.OwnedBubbleBoxes) > 0 {
+		if err := b.OwnedBubbleBoxes.UnmarshalArraysJSON(raw.OwnedBubbleBoxes, "bubbleBoxID", "expiredTime"); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // BoxesManager 提供装饰框数据的管理功能
-type BoxesManager struct {}
+type BoxesManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewBoxesManager 创建一个新的装饰框管理器
 func NewBoxesManager() *BoxesManager {
-	return &BoxesManager{}
+	return NewBoxesManagerWithRepository(repository.Default())
+}
+
+// NewBoxesManagerWithRepository 使用指定的玩家仓库创建装饰框管理器
+func NewBoxesManagerWithRepository(repo repository.PlayerRepository) *BoxesManager {
+	return &BoxesManager{repo: repo}
+}
+
+// retry 以乐观锁执行装饰框数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (bm *BoxesManager) retry(fn func(tx *BoxesManager) error) error {
+	return repository.RetryOnConflict(bm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewBoxesManagerWithRepository(repo))
+	})
 }
 
 // GetBoxesData 从数据库获取指定设备ID的装饰框数据
 func (bm *BoxesManager) GetBoxesData(deviceID string) (*BoxesData, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := bm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -61,7 +102,7 @@ func (bm *BoxesManager) GetBoxesData(deviceID string) (*BoxesData, error) {
 		return defaultBoxesData, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.BoxesData), &boxesData)
+	err = json.Unmarshal([]byte(playerData.BoxesData), &boxesData)
 	if err != nil {
 		log.Printf("解析装饰框数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -82,9 +123,8 @@ func (bm *BoxesManager) SaveBoxesData(deviceID string, boxesData *BoxesData) err
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("boxes_data", string(boxesDataJSON))
-	if result.Error != nil {
-		log.Printf("更新装饰框数据失败: %v", result.Error)
+	if err := bm.repo.UpdateColumns(deviceID, map[string]interface{}{"boxes_data": string(boxesDataJSON)}); err != nil {
+		log.Printf("更新装饰框数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -95,14 +135,8 @@ func (bm *BoxesManager) SaveBoxesData(deviceID string, boxesData *BoxesData) err
 func (bm *BoxesManager) GetDefaultBoxesData() *BoxesData {
 	// 创建默认装饰框数据
 	defaultBoxesData := &BoxesData{
-		OwnedHeadBoxes: OwnedHeadBox{
-			HeadBoxID:   []int{900001},
-			ExpiredTime: []int{0},
-		},
-		OwnedBubbleBoxes: OwnedBubbleBox{
-			BubbleBoxID: []int{910001},
-			ExpiredTime: []int{0},
-		},
+		OwnedHeadBoxes:   NewEntitlements([]int{900001}, []int{0}),
+		OwnedBubbleBoxes: NewEntitlements([]int{910001}, []int{0}),
 	}
 	
 	return defaultBoxesData
@@ -113,18 +147,19 @@ func (bm *BoxesManager) GetDefaultBoxesData() *BoxesData {
 
 // UpdateHeadBoxes 更新玩家拥有的头像框数据
 func (bm *BoxesManager) UpdateHeadBoxes(deviceID string, headBoxIDs []int, expiredTimes []int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 更新拥有的头像框数据
-	boxesData.OwnedHeadBoxes.HeadBoxID = headBoxIDs
-	boxesData.OwnedHeadBoxes.ExpiredTime = expiredTimes
+		// 更新拥有的头像框数据
+		boxesData.OwnedHeadBoxes = NewEntitlements(headBoxIDs, expiredTimes)
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
 }
 
 // GetHeadBoxes 获取玩家拥有的头像框数据
@@ -135,66 +170,64 @@ func (bm *BoxesManager) GetHeadBoxes(deviceID string) ([]int, []int, error) {
 		return nil, nil, err
 	}
 
-	return boxesData.OwnedHeadBoxes.HeadBoxID, boxesData.OwnedHeadBoxes.ExpiredTime, nil
+	return boxesData.OwnedHeadBoxes.IDs(), boxesData.OwnedHeadBoxes.ExpiredTimes(), nil
 }
 
 // AddHeadBox 添加一个新的头像框到玩家拥有的头像框列表
 func (bm *BoxesManager) AddHeadBox(deviceID string, headBoxID int, expiredTime int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 检查头像框是否已存在
-	for i, id := range boxesData.OwnedHeadBoxes.HeadBoxID {
-		if id == headBoxID {
-			// 如果已存在，更新过期时间
-			boxesData.OwnedHeadBoxes.ExpiredTime[i] = expiredTime
-			return bm.SaveBoxesData(deviceID, boxesData)
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// 添加新头像框
-	boxesData.OwnedHeadBoxes.HeadBoxID = append(boxesData.OwnedHeadBoxes.HeadBoxID, headBoxID)
-	boxesData.OwnedHeadBoxes.ExpiredTime = append(boxesData.OwnedHeadBoxes.ExpiredTime, expiredTime)
+		// 添加新头像框，已存在时更新过期时间
+		boxesData.OwnedHeadBoxes.Add(headBoxID, expiredTime)
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
 }
 
 // RemoveHeadBox 从玩家拥有的头像框列表中移除一个头像框
 func (bm *BoxesManager) RemoveHeadBox(deviceID string, headBoxID int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并移除头像框
-	found := false
-	newIDs := []int{}
-	newExpiredTimes := []int{}
-
-	for i, id := range boxesData.OwnedHeadBoxes.HeadBoxID {
-		if id != headBoxID {
-			newIDs = append(newIDs, id)
-			newExpiredTimes = append(newExpiredTimes, boxesData.OwnedHeadBoxes.ExpiredTime[i])
-		} else {
-			found = true
+		// 查找并移除头像框
+		if !boxesData.OwnedHeadBoxes.Remove(headBoxID) {
+			return game_error.New(-2, "头像框不存在")
 		}
-	}
 
-	if !found {
-		return game_error.New(-2, "头像框不存在")
-	}
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
+}
 
-	// 更新头像框数据
-	boxesData.OwnedHeadBoxes.HeadBoxID = newIDs
-	boxesData.OwnedHeadBoxes.ExpiredTime = newExpiredTimes
+// EquipHeadBox 装备一个头像框，头像框必须已拥有且未过期
+func (bm *BoxesManager) EquipHeadBox(deviceID string, headBoxID int) error {
+	return bm.retry(func(tx *BoxesManager) error {
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
+		if err := boxesData.OwnedHeadBoxes.CheckEquippable(headBoxID, time.Now()); err != nil {
+			return err
+		}
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		pm := NewPublicInfoManagerWithRepository(tx.repo)
+		publicInfo, err := pm.GetPublicInfo(deviceID)
+		if err != nil {
+			return err
+		}
+		publicInfo.ActiveHeadBoxID = headBoxID
+		return pm.SavePublicInfo(deviceID, publicInfo)
+	})
 }
 
 // 聊天气泡相关方法
@@ -202,18 +235,19 @@ func (bm *BoxesManager) RemoveHeadBox(deviceID string, headBoxID int) error {
 
 // UpdateBubbleBoxes 更新玩家拥有的聊天气泡数据
 func (bm *BoxesManager) UpdateBubbleBoxes(deviceID string, bubbleBoxIDs []int, expiredTimes []int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 更新拥有的聊天气泡数据
-	boxesData.OwnedBubbleBoxes.BubbleBoxID = bubbleBoxIDs
-	boxesData.OwnedBubbleBoxes.ExpiredTime = expiredTimes
+		// 更新拥有的聊天气泡数据
+		boxesData.OwnedBubbleBoxes = NewEntitlements(bubbleBoxIDs, expiredTimes)
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
 }
 
 // GetBubbleBoxes 获取玩家拥有的聊天气泡数据
@@ -224,66 +258,64 @@ func (bm *BoxesManager) GetBubbleBoxes(deviceID string) ([]int, []int, error) {
 		return nil, nil, err
 	}
 
-	return boxesData.OwnedBubbleBoxes.BubbleBoxID, boxesData.OwnedBubbleBoxes.ExpiredTime, nil
+	return boxesData.OwnedBubbleBoxes.IDs(), boxesData.OwnedBubbleBoxes.ExpiredTimes(), nil
 }
 
 // AddBubbleBox 添加一个新的聊天气泡到玩家拥有的聊天气泡列表
 func (bm *BoxesManager) AddBubbleBox(deviceID string, bubbleBoxID int, expiredTime int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 检查聊天气泡是否已存在
-	for i, id := range boxesData.OwnedBubbleBoxes.BubbleBoxID {
-		if id == bubbleBoxID {
-			// 如果已存在，更新过期时间
-			boxesData.OwnedBubbleBoxes.ExpiredTime[i] = expiredTime
-			return bm.SaveBoxesData(deviceID, boxesData)
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// 添加新聊天气泡
-	boxesData.OwnedBubbleBoxes.BubbleBoxID = append(boxesData.OwnedBubbleBoxes.BubbleBoxID, bubbleBoxID)
-	boxesData.OwnedBubbleBoxes.ExpiredTime = append(boxesData.OwnedBubbleBoxes.ExpiredTime, expiredTime)
+		// 添加新聊天气泡，已存在时更新过期时间
+		boxesData.OwnedBubbleBoxes.Add(bubbleBoxID, expiredTime)
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
 }
 
 // RemoveBubbleBox 从玩家拥有的聊天气泡列表中移除一个聊天气泡
 func (bm *BoxesManager) RemoveBubbleBox(deviceID string, bubbleBoxID int) error {
-	// 获取当前装饰框数据
-	boxesData, err := bm.GetBoxesData(deviceID)
-	if err != nil {
-		return err
-	}
+	return bm.retry(func(tx *BoxesManager) error {
+		// 获取当前装饰框数据
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并移除聊天气泡
-	found := false
-	newIDs := []int{}
-	newExpiredTimes := []int{}
-
-	for i, id := range boxesData.OwnedBubbleBoxes.BubbleBoxID {
-		if id != bubbleBoxID {
-			newIDs = append(newIDs, id)
-			newExpiredTimes = append(newExpiredTimes, boxesData.OwnedBubbleBoxes.ExpiredTime[i])
-		} else {
-			found = true
+		// 查找并移除聊天气泡
+		if !boxesData.OwnedBubbleBoxes.Remove(bubbleBoxID) {
+			return game_error.New(-2, "聊天气泡不存在")
 		}
-	}
 
-	if !found {
-		return game_error.New(-2, "聊天气泡不存在")
-	}
+		// 保存到数据库
+		return tx.SaveBoxesData(deviceID, boxesData)
+	})
+}
 
-	// 更新聊天气泡数据
-	boxesData.OwnedBubbleBoxes.BubbleBoxID = newIDs
-	boxesData.OwnedBubbleBoxes.ExpiredTime = newExpiredTimes
+// EquipBubbleBox 装备一个聊天气泡，聊天气泡必须已拥有且未过期
+func (bm *BoxesManager) EquipBubbleBox(deviceID string, bubbleBoxID int) error {
+	return bm.retry(func(tx *BoxesManager) error {
+		boxesData, err := tx.GetBoxesData(deviceID)
+		if err != nil {
+			return err
+		}
+		if err := boxesData.OwnedBubbleBoxes.CheckEquippable(bubbleBoxID, time.Now()); err != nil {
+			return err
+		}
 
-	// 保存到数据库
-	return bm.SaveBoxesData(deviceID, boxesData)
+		pm := NewPublicInfoManagerWithRepository(tx.repo)
+		publicInfo, err := pm.GetPublicInfo(deviceID)
+		if err != nil {
+			return err
+		}
+		publicInfo.ActiveBubbleBoxID = bubbleBoxID
+		return pm.SavePublicInfo(deviceID, publicInfo)
+	})
 }
 
 // ParseBoxesDataFromJSON 从JSON字符串解析装饰框数据
@@ -321,12 +353,12 @@ func (bm *BoxesManager) ConvertToClientFormat(boxesDataJSON string) (map[string]
 	// 构建客户端需要的格式
 	result := map[string]interface{}{
 		"ownedHeadBoxes": map[string]interface{}{
-			"headBoxID":   boxesData.OwnedHeadBoxes.HeadBoxID,
-			"expiredTime": boxesData.OwnedHeadBoxes.ExpiredTime,
+			"headBoxID":   boxesData.OwnedHeadBoxes.IDs(),
+			"expiredTime": boxesData.OwnedHeadBoxes.ExpiredTimes(),
 		},
 		"ownedBubbleBoxes": map[string]interface{}{
-			"bubbleBoxID": boxesData.OwnedBubbleBoxes.BubbleBoxID,
-			"expiredTime": boxesData.OwnedBubbleBoxes.ExpiredTime,
+			"bubbleBoxID": boxesData.OwnedBubbleBoxes.IDs(),
+			"expiredTime": boxesData.OwnedBubbleBoxes.ExpiredTimes(),
 		},
 	}
 
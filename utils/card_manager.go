@@ -4,9 +4,10 @@ package utils
 import (
 	"encoding/json"
 	"log"
+	"strconv"
+	"time"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
@@ -18,19 +19,38 @@ type Card struct {
 	CurStyle interface{} `json:"curStyle"` // 当前使用的风格
 }
 
+// CardPiece 表示玩家拥有的某张卡牌的碎片数量
+type CardPiece struct {
+	CardID int `json:"cardID"` // 卡牌ID
+	Num    int `json:"num"`    // 碎片数量
+}
+
 // CardManager 提供卡牌数据的管理功能
-type CardManager struct {}
+type CardManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewCardManager 创建一个新的卡牌管理器
 func NewCardManager() *CardManager {
-	return &CardManager{}
+	return NewCardManagerWithRepository(repository.Default())
+}
+
+// NewCardManagerWithRepository 使用指定的玩家仓库创建卡牌管理器
+func NewCardManagerWithRepository(repo repository.PlayerRepository) *CardManager {
+	return &CardManager{repo: repo}
+}
+
+// retry 以乐观锁执行卡牌数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (cm *CardManager) retry(fn func(tx *CardManager) error) error {
+	return repository.RetryOnConflict(cm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewCardManagerWithRepository(repo))
+	})
 }
 
 // GetCards 从数据库获取指定设备ID的所有卡牌数据
 func (cm *CardManager) GetCards(deviceID string) ([]Card, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -51,7 +71,7 @@ func (cm *CardManager) GetCards(deviceID string) ([]Card, error) {
 		return defaultCards, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.Cards), &cards)
+	err = json.Unmarshal([]byte(playerData.Cards), &cards)
 	if err != nil {
 		log.Printf("解析卡牌数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -85,9 +105,8 @@ func (cm *CardManager) SaveCards(deviceID string, cards []Card) error {
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("cards", string(cardsJSON))
-	if result.Error != nil {
-		log.Printf("更新卡牌数据失败: %v", result.Error)
+	if err := cm.repo.UpdateColumns(deviceID, map[string]interface{}{"cards": string(cardsJSON)}); err != nil {
+		log.Printf("更新卡牌数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -195,111 +214,378 @@ func (cm *CardManager) GetDefaultCards() []Card {
 
 // UpdateCardField 更新指定卡牌的特定字段
 func (cm *CardManager) UpdateCardField(deviceID string, cardID int, fieldName string, fieldValue interface{}) error {
-	// 获取当前卡牌数据
-	cards, err := cm.GetCards(deviceID)
-	if err != nil {
-		return err
-	}
+	return cm.retry(func(tx *CardManager) error {
+		// 获取当前卡牌数据
+		cards, err := tx.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并更新指定卡牌的字段
-	cardFound := false
-	for i, card := range cards {
-		if card.ID == cardID {
+		// 换上的卡牌皮肤或样式已过期时拒绝
+		if err := tx.checkCardItemNotExpired(deviceID, fieldName, fieldValue); err != nil {
+			return err
+		}
+
+		// 查找并更新指定卡牌的字段
+		cardFound := false
+		for i, card := range cards {
+			if card.ID == cardID {
+				switch fieldName {
+				case "level":
+					if level, ok := fieldValue.(int); ok {
+						cards[i].Level = level
+					} else {
+						return game_error.New(-2, "字段类型错误")
+					}
+				case "curSkin":
+					cards[i].CurSkin = fieldValue
+				case "curStyle":
+					cards[i].CurStyle = fieldValue
+				default:
+					return game_error.New(-2, "不支持的字段名称")
+				}
+				cardFound = true
+				break
+			}
+		}
+
+		// 如果没有找到指定卡牌，则添加新卡牌
+		if !cardFound {
+			newCard := Card{ID: cardID}
 			switch fieldName {
 			case "level":
 				if level, ok := fieldValue.(int); ok {
-					cards[i].Level = level
+					newCard.Level = level
 				} else {
 					return game_error.New(-2, "字段类型错误")
 				}
 			case "curSkin":
-				cards[i].CurSkin = fieldValue
+				newCard.CurSkin = fieldValue
 			case "curStyle":
-				cards[i].CurStyle = fieldValue
+				newCard.CurStyle = fieldValue
 			default:
 				return game_error.New(-2, "不支持的字段名称")
 			}
-			cardFound = true
-			break
+			cards = append(cards, newCard)
 		}
+
+		// 保存更新后的卡牌数据
+		return tx.SaveCards(deviceID, cards)
+	})
+}
+
+// checkCardItemNotExpired 检查 curSkin/curStyle 的新值是否为已过期的卡牌皮肤或样式
+// 默认皮肤等不在拥有列表中的ID不做检查。
+func (cm *CardManager) checkCardItemNotExpired(deviceID string, fieldName string, fieldValue interface{}) error {
+	id, err := strconv.Atoi(idString(fieldValue))
+	if err != nil || id == 0 {
+		return nil
 	}
+	switch fieldName {
+	case "curSkin":
+		cardSkins, err := NewCardSkinManagerWithRepository(cm.repo).GetCardSkins(deviceID)
+		if err != nil {
+			return err
+		}
+		return cardSkins.CheckNotExpired(id, time.Now())
+	case "curStyle":
+		cardStyles, err := NewCardStyleManagerWithRepository(cm.repo).GetCardStyles(deviceID)
+		if err != nil {
+			return err
+		}
+		return cardStyles.CheckNotExpired(id, time.Now())
+	}
+	return nil
+}
+
+// EquipCardSkin 更换卡牌当前使用的皮肤
+// 皮肤必须已拥有且未过期，或者是该卡牌的默认皮肤（0 同样表示默认）：未拥有返回 -7，已过期返回 -136，未拥有该卡牌返回 -13。
+func (cm *CardManager) EquipCardSkin(deviceID string, cardID int, skinID int) error {
+	return cm.equipCardItem(deviceID, cardID, "curSkin", skinID)
+}
 
-	// 如果没有找到指定卡牌，则添加新卡牌
-	if !cardFound {
-		newCard := Card{ID: cardID}
-		switch fieldName {
-		case "level":
-			if level, ok := fieldValue.(int); ok {
-				newCard.Level = level
+// EquipCardStyle 更换卡牌当前使用的样式
+// 样式必须已拥有且未过期，或者是该卡牌的默认样式（0 同样表示默认，没有默认样式的卡牌不使用样式）：未拥有返回 -7，已过期返回 -136，未拥有该卡牌返回 -13。
+func (cm *CardManager) EquipCardStyle(deviceID string, cardID int, styleID int) error {
+	return cm.equipCardItem(deviceID, cardID, "curStyle", styleID)
+}
+
+// equipCardItem 检查卡牌皮肤或样式能否装备，并写入卡牌的 curSkin/curStyle
+func (cm *CardManager) equipCardItem(deviceID string, cardID int, fieldName string, id int) error {
+	return cm.retry(func(tx *CardManager) error {
+		cards, err := tx.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
+		index := -1
+		for i := range cards {
+			if cards[i].ID == cardID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return game_error.New(-13, "未拥有该卡牌")
+		}
+
+		var defaultID interface{}
+		for _, card := range tx.GetDefaultCards() {
+			if card.ID == cardID {
+				defaultID = card.CurSkin
+				if fieldName == "curStyle" {
+					defaultID = card.CurStyle
+				}
+				break
+			}
+		}
+		if id != 0 && idString(defaultID) != strconv.Itoa(id) {
+			var owned Entitlements[int]
+			if fieldName == "curSkin" {
+				cardSkins, err := NewCardSkinManagerWithRepository(tx.repo).GetCardSkins(deviceID)
+				if err != nil {
+					return err
+				}
+				owned = cardSkins.Entitlements
 			} else {
-				return game_error.New(-2, "字段类型错误")
+				cardStyles, err := NewCardStyleManagerWithRepository(tx.repo).GetCardStyles(deviceID)
+				if err != nil {
+					return err
+				}
+				owned = cardStyles.Entitlements
 			}
-		case "curSkin":
-			newCard.CurSkin = fieldValue
-		case "curStyle":
-			newCard.CurStyle = fieldValue
-		default:
-			return game_error.New(-2, "不支持的字段名称")
-		}
-		cards = append(cards, newCard)
-	}
+			if err := owned.CheckEquippable(id, time.Now()); err != nil {
+				return err
+			}
+		}
 
-	// 保存更新后的卡牌数据
-	return cm.SaveCards(deviceID, cards)
+		// 0 表示换回默认，与过期清理换回的值一致
+		var value interface{} = id
+		if id == 0 && defaultID != nil {
+			value = defaultID
+		}
+		if fieldName == "curSkin" {
+			cards[index].CurSkin = value
+		} else {
+			cards[index].CurStyle = value
+		}
+		return tx.SaveCards(deviceID, cards)
+	})
 }
 
 // AddCard 添加新卡牌或更新现有卡牌
 func (cm *CardManager) AddCard(deviceID string, card Card) error {
-	// 获取当前卡牌数据
-	cards, err := cm.GetCards(deviceID)
-	if err != nil {
-		return err
-	}
+	return cm.retry(func(tx *CardManager) error {
+		// 获取当前卡牌数据
+		cards, err := tx.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找是否已存在相同ID的卡牌
-	cardFound := false
-	for i, existingCard := range cards {
-		if existingCard.ID == card.ID {
-			// 更新现有卡牌
-			cards[i] = card
-			cardFound = true
-			break
+		// 查找是否已存在相同ID的卡牌
+		cardFound := false
+		for i, existingCard := range cards {
+			if existingCard.ID == card.ID {
+				// 更新现有卡牌
+				cards[i] = card
+				cardFound = true
+				break
+			}
 		}
-	}
 
-	// 如果没有找到相同ID的卡牌，则添加新卡牌
-	if !cardFound {
-		cards = append(cards, card)
-	}
+		// 如果没有找到相同ID的卡牌，则添加新卡牌
+		if !cardFound {
+			cards = append(cards, card)
+		}
 
-	// 保存更新后的卡牌数据
-	return cm.SaveCards(deviceID, cards)
+		// 保存更新后的卡牌数据
+		return tx.SaveCards(deviceID, cards)
+	})
 }
 
 // RemoveCard 移除指定ID的卡牌
 func (cm *CardManager) RemoveCard(deviceID string, cardID int) error {
-	// 获取当前卡牌数据
-	cards, err := cm.GetCards(deviceID)
+	return cm.retry(func(tx *CardManager) error {
+		// 获取当前卡牌数据
+		cards, err := tx.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
+
+		// 查找并移除指定ID的卡牌
+		found := false
+		for i, card := range cards {
+			if card.ID == cardID {
+				// 移除卡牌（通过切片操作）
+				cards = append(cards[:i], cards[i+1:]...)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			// 如果没有找到指定ID的卡牌，返回错误
+			return game_error.New(-2, "未找到指定ID的卡牌")
+		}
+
+		// 保存更新后的卡牌数据
+		return tx.SaveCards(deviceID, cards)
+	})
+}
+
+// GetCardPieces 从数据库获取指定设备ID的卡牌碎片数据，没有碎片时返回空数组
+func (cm *CardManager) GetCardPieces(deviceID string) ([]CardPiece, error) {
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+	return cm.ParseCardPiecesFromJSON(playerData.CardPieces)
+}
+
+// SaveCardPieces 保存卡牌碎片数据到数据库
+func (cm *CardManager) SaveCardPieces(deviceID string, pieces []CardPiece) error {
+	if pieces == nil {
+		pieces = []CardPiece{}
+	}
+	piecesJSON, err := json.Marshal(pieces)
 	if err != nil {
-		return err
+		log.Printf("序列化卡牌碎片数据失败: %v", err)
+		return game_error.New(-2, "数据处理错误")
 	}
 
-	// 查找并移除指定ID的卡牌
-	found := false
-	for i, card := range cards {
-		if card.ID == cardID {
-			// 移除卡牌（通过切片操作）
-			cards = append(cards[:i], cards[i+1:]...)
-			found = true
-			break
+	if err := cm.repo.UpdateColumns(deviceID, map[string]interface{}{"card_pieces": string(piecesJSON)}); err != nil {
+		log.Printf("更新卡牌碎片数据失败: %v", err)
+		return game_error.New(-2, "数据库更新错误")
+	}
+	return nil
+}
+
+// ParseCardPiecesFromJSON 解析卡牌碎片数据，数据为空时返回空数组
+// 与卡牌数据不同，碎片无法解析时返回错误而不是使用默认值，避免之后的写入覆盖玩家的碎片。
+func (cm *CardManager) ParseCardPiecesFromJSON(piecesJSON string) ([]CardPiece, error) {
+	pieces := []CardPiece{}
+	if piecesJSON == "" || piecesJSON == "null" {
+		return pieces, nil
+	}
+	if err := json.Unmarshal([]byte(piecesJSON), &pieces); err != nil {
+		log.Printf("解析卡牌碎片数据失败: %v", err)
+		return nil, game_error.New(-2, "数据处理错误")
+	}
+	return pieces, nil
+}
+
+// GetCardPieceCount 获取指定卡牌的碎片数量
+func (cm *CardManager) GetCardPieceCount(deviceID string, cardID int) (int, error) {
+	pieces, err := cm.GetCardPieces(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	for _, piece := range pieces {
+		if piece.CardID == cardID {
+			return piece.Num, nil
 		}
 	}
+	return 0, nil
+}
+
+// AddCardPieces 增加指定卡牌的碎片数量
+func (cm *CardManager) AddCardPieces(deviceID string, cardID int, num int) error {
+	return cm.retry(func(tx *CardManager) error {
+		pieces, err := tx.GetCardPieces(deviceID)
+		if err != nil {
+			return err
+		}
+		for i := range pieces {
+			if pieces[i].CardID == cardID {
+				pieces[i].Num += num
+				return tx.SaveCardPieces(deviceID, pieces)
+			}
+		}
+		return tx.SaveCardPieces(deviceID, append(pieces, CardPiece{CardID: cardID, Num: num}))
+	})
+}
+
+// ConsumeCardPieces 消耗指定卡牌的碎片，数量不足时返回 -52
+func (cm *CardManager) ConsumeCardPieces(deviceID string, cardID int, num int) error {
+	return cm.retry(func(tx *CardManager) error {
+		pieces, err := tx.GetCardPieces(deviceID)
+		if err != nil {
+			return err
+		}
+		if err := consumeCardPieces(pieces, cardID, num); err != nil {
+			return err
+		}
+		return tx.SaveCardPieces(deviceID, pieces)
+	})
+}
 
-	if !found {
-		// 如果没有找到指定ID的卡牌，返回错误
-		return game_error.New(-2, "未找到指定ID的卡牌")
+// consumeCardPieces 在内存中扣除碎片，数量不足时返回 -52
+func consumeCardPieces(pieces []CardPiece, cardID int, num int) error {
+	for i := range pieces {
+		if pieces[i].CardID == cardID {
+			if pieces[i].Num < num {
+				return game_error.New(-52, "您的碎片不足")
+			}
+			pieces[i].Num -= num
+			return nil
+		}
+	}
+	if num > 0 {
+		return game_error.New(-52, "您的碎片不足")
 	}
+	return nil
+}
 
-	// 保存更新后的卡牌数据
-	return cm.SaveCards(deviceID, cards)
-}
\ No newline at end of file
+// UpgradeCard 消耗 pieceCost 个碎片把卡牌从 fromLevel 升到下一级，返回升级后的卡牌和剩余碎片数量
+// 未拥有该卡牌时返回 -13，卡牌当前等级不是 fromLevel 时返回 -290，碎片不足时返回 -52。
+// 卡牌等级和碎片在同一次读-改-写中保存。
+func (cm *CardManager) UpgradeCard(deviceID string, cardID int, fromLevel int, pieceCost int) (*Card, int, error) {
+	var upgraded Card
+	var remaining int
+	err := cm.retry(func(tx *CardManager) error {
+		cards, err := tx.GetCards(deviceID)
+		if err != nil {
+			return err
+		}
+		index := -1
+		for i := range cards {
+			if cards[i].ID == cardID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return game_error.New(-13, "未拥有该卡牌")
+		}
+		if cards[index].Level != fromLevel {
+			log.Printf("卡牌 %d 的等级已变化: %d -> %d", cardID, fromLevel, cards[index].Level)
+			return game_error.New(-290, "数据不同步")
+		}
+
+		pieces, err := tx.GetCardPieces(deviceID)
+		if err != nil {
+			return err
+		}
+		if err := consumeCardPieces(pieces, cardID, pieceCost); err != nil {
+			return err
+		}
+		cards[index].Level++
+
+		if err := tx.SaveCardPieces(deviceID, pieces); err != nil {
+			return err
+		}
+		if err := tx.SaveCards(deviceID, cards); err != nil {
+			return err
+		}
+		upgraded = cards[index]
+		for _, piece := range pieces {
+			if piece.CardID == cardID {
+				remaining = piece.Num
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &upgraded, remaining, nil
+}
@@ -5,35 +5,57 @@ import (
 	"encoding/json"
 	"log"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
-// CardSkin 表示一个卡牌皮肤的结构
-type CardSkin struct {
-	CardOwnSkin         int `json:"cardOwnSkin"`
-	CardSkinExpiredTime int `json:"cardSkinExpiredTime"`
+// CardSkins 表示玩家拥有的卡牌皮肤
+// 保存格式为 [{"cardOwnSkin":0,"cardSkinExpiredTime":0}]
+type CardSkins struct {
+	Entitlements[int]
+}
+
+// MarshalJSON 按数据库中的保存格式序列化卡牌皮肤数据
+func (s CardSkins) MarshalJSON() ([]byte, error) {
+	return s.MarshalRecordsJSON("cardOwnSkin", "cardSkinExpiredTime")
+}
+
+// UnmarshalJSON 解析数据库中保存的卡牌皮肤数据
+func (s *CardSkins) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalRecordsJSON(data, "cardOwnSkin", "cardSkinExpiredTime")
 }
 
 // CardSkinManager 提供卡牌皮肤数据的管理功能
-type CardSkinManager struct {}
+type CardSkinManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewCardSkinManager 创建一个新的卡牌皮肤管理器
 func NewCardSkinManager() *CardSkinManager {
-	return &CardSkinManager{}
+	return NewCardSkinManagerWithRepository(repository.Default())
+}
+
+// NewCardSkinManagerWithRepository 使用指定的玩家仓库创建卡牌皮肤管理器
+func NewCardSkinManagerWithRepository(repo repository.PlayerRepository) *CardSkinManager {
+	return &CardSkinManager{repo: repo}
+}
+
+// retry 以乐观锁执行卡牌皮肤数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (cm *CardSkinManager) retry(fn func(tx *CardSkinManager) error) error {
+	return repository.RetryOnConflict(cm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewCardSkinManagerWithRepository(repo))
+	})
 }
 
 // GetCardSkins 从数据库获取指定设备ID的所有卡牌皮肤数据
-func (cm *CardSkinManager) GetCardSkins(deviceID string) ([]CardSkin, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
-		return nil, game_error.New(-3, "未找到玩家数据")
+func (cm *CardSkinManager) GetCardSkins(deviceID string) (CardSkins, error) {
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
+		return CardSkins{}, game_error.New(-3, "未找到玩家数据")
 	}
 
 	// 解析卡牌皮肤数据
-	var cardSkins []CardSkin
+	var cardSkins CardSkins
 	if playerData.CardSkins == "" || playerData.CardSkins == "null" {
 		// 如果没有卡牌皮肤数据，使用默认数据并保存到数据库
 		log.Printf("玩家 %s 的卡牌皮肤数据为空，使用默认数据", deviceID)
@@ -49,7 +71,7 @@ func (cm *CardSkinManager) GetCardSkins(deviceID string) ([]CardSkin, error) {
 		return defaultCardSkins, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.CardSkins), &cardSkins)
+	err = json.Unmarshal([]byte(playerData.CardSkins), &cardSkins)
 	if err != nil {
 		log.Printf("解析卡牌皮肤数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -62,7 +84,7 @@ func (cm *CardSkinManager) GetCardSkins(deviceID string) ([]CardSkin, error) {
 }
 
 // SaveCardSkins 保存卡牌皮肤数据到数据库
-func (cm *CardSkinManager) SaveCardSkins(deviceID string, cardSkins []CardSkin) error {
+func (cm *CardSkinManager) SaveCardSkins(deviceID string, cardSkins CardSkins) error {
 	// 将卡牌皮肤数据序列化为JSON
 	cardSkinsJSON, err := json.Marshal(cardSkins)
 	if err != nil {
@@ -71,9 +93,8 @@ func (cm *CardSkinManager) SaveCardSkins(deviceID string, cardSkins []CardSkin)
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("card_skins", string(cardSkinsJSON))
-	if result.Error != nil {
-		log.Printf("更新卡牌皮肤数据失败: %v", result.Error)
+	if err := cm.repo.UpdateColumns(deviceID, map[string]interface{}{"card_skins": string(cardSkinsJSON)}); err != nil {
+		log.Printf("更新卡牌皮肤数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -81,23 +102,14 @@ func (cm *CardSkinManager) SaveCardSkins(deviceID string, cardSkins []CardSkin)
 }
 
 // ConvertArraysToCardSkins 将两个独立的数组转换为卡牌皮肤对象数组
-func (cm *CardSkinManager) ConvertArraysToCardSkins(cardOwnSkins []int, cardSkinExpiredTimes []int) []CardSkin {
+func (cm *CardSkinManager) ConvertArraysToCardSkins(cardOwnSkins []int, cardSkinExpiredTimes []int) CardSkins {
 	// 确定数组长度，取两个数组中最小的长度
 	length := len(cardOwnSkins)
 	if len(cardSkinExpiredTimes) < length {
 		length = len(cardSkinExpiredTimes)
 	}
 
-	// 创建卡牌皮肤对象数组
-	cardSkins := make([]CardSkin, length)
-	for i := 0; i < length; i++ {
-		cardSkins[i] = CardSkin{
-			CardOwnSkin:         cardOwnSkins[i],
-			CardSkinExpiredTime: cardSkinExpiredTimes[i],
-		}
-	}
-
-	return cardSkins
+	return CardSkins{NewEntitlements(cardOwnSkins[:length], cardSkinExpiredTimes[:length])}
 }
 
 // UpdateCardSkinData 更新玩家的卡牌皮肤数据
@@ -109,24 +121,44 @@ func (cm *CardSkinManager) UpdateCardSkinData(deviceID string, cardOwnSkins []in
 	return cm.SaveCardSkins(deviceID, cardSkins)
 }
 
-// ExtractCardSkinArrays 从卡牌皮肤对象数组中提取两个独立的数组
-func (cm *CardSkinManager) ExtractCardSkinArrays(cardSkins []CardSkin) ([]int, []int, error) {
-	cardOwnSkins := make([]int, len(cardSkins))
-	cardSkinExpiredTimes := make([]int, len(cardSkins))
+// AddCardSkin 添加一个卡牌皮肤，已拥有时更新过期时间
+func (cm *CardSkinManager) AddCardSkin(deviceID string, cardSkinID int, expiredTime int) error {
+	return cm.retry(func(tx *CardSkinManager) error {
+		cardSkins, err := tx.GetCardSkins(deviceID)
+		if err != nil {
+			return err
+		}
 
-	for i, skin := range cardSkins {
-		cardOwnSkins[i] = skin.CardOwnSkin
-		cardSkinExpiredTimes[i] = skin.CardSkinExpiredTime
-	}
+		cardSkins.Add(cardSkinID, expiredTime)
+		return tx.SaveCardSkins(deviceID, cardSkins)
+	})
+}
+
+// RemoveCardSkin 移除一个卡牌皮肤
+func (cm *CardSkinManager) RemoveCardSkin(deviceID string, cardSkinID int) error {
+	return cm.retry(func(tx *CardSkinManager) error {
+		cardSkins, err := tx.GetCardSkins(deviceID)
+		if err != nil {
+			return err
+		}
+
+		if !cardSkins.Remove(cardSkinID) {
+			return game_error.New(-2, "卡牌皮肤不存在")
+		}
+		return tx.SaveCardSkins(deviceID, cardSkins)
+	})
+}
 
-	return cardOwnSkins, cardSkinExpiredTimes, nil
+// ExtractCardSkinArrays 从卡牌皮肤对象数组中提取两个独立的数组
+func (cm *CardSkinManager) ExtractCardSkinArrays(cardSkins CardSkins) ([]int, []int, error) {
+	return cardSkins.IDs(), cardSkins.ExpiredTimes(), nil
 }
 
 // ParseCardSkinsFromJSON 从JSON字符串解析卡牌皮肤数据并返回客户端需要的格式
 // 此方法用于当isSelf为true时，直接使用playerData中的数据而不再查询数据库
 func (cm *CardSkinManager) ParseCardSkinsFromJSON(cardSkinsJSON string) ([]int, []int, error) {
 	// 解析卡牌皮肤数据
-	var cardSkins []CardSkin
+	var cardSkins CardSkins
 	if cardSkinsJSON == "" || cardSkinsJSON == "null" {
 		// 如果没有卡牌皮肤数据，使用默认数据
 		log.Printf("卡牌皮肤数据为空，使用默认数据")
@@ -148,31 +180,32 @@ func (cm *CardSkinManager) ParseCardSkinsFromJSON(cardSkinsJSON string) ([]int,
 }
 
 // GetDefaultCardSkins 创建默认卡牌皮肤数据并返回
-func (cm *CardSkinManager) GetDefaultCardSkins() []CardSkin {
+func (cm *CardSkinManager) GetDefaultCardSkins() CardSkins {
 	// 创建默认卡牌皮肤数据 - 包含cardOwnSkin和cardSkinExpiredTime属性
-	defaultCardSkins := []CardSkin{
-		{CardOwnSkin: 600985, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600011, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600016, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600021, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600036, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600041, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600046, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600026, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600031, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600051, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600056, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600071, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600076, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600081, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600086, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600091, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600121, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600206, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600211, CardSkinExpiredTime: 0},
-		{CardOwnSkin: 600126, CardSkinExpiredTime: 0},
+	defaultSkinIDs := []int{
+		600985,
+		600011,
+		600016,
+		600021,
+		600036,
+		600041,
+		600046,
+		600026,
+		600031,
+		600051,
+		600056,
+		600071,
+		600076,
+		600081,
+		600086,
+		600091,
+		600121,
+		600206,
+		600211,
+		600126,
 		// 添加更多默认卡牌皮肤...
 	}
+	defaultCardSkins := CardSkins{NewEntitlements(defaultSkinIDs, make([]int, len(defaultSkinIDs)))}
 	
 	return defaultCardSkins
 }
\ No newline at end of file
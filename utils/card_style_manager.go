@@ -5,35 +5,57 @@ import (
 	"encoding/json"
 	"log"
 
-	"dmmserver/db"
 	"dmmserver/game_error"
-	"dmmserver/model"
+	"dmmserver/repository"
 )
 
-// CardStyle 表示一个卡牌样式的结构
-type CardStyle struct {
-	CardOwnStyle         int `json:"cardOwnStyle"`
-	CardStyleExpiredTime int `json:"cardStyleExpiredTime"`
+// CardStyles 表示玩家拥有的卡牌样式
+// 保存格式为 [{"cardOwnStyle":0,"cardStyleExpiredTime":0}]
+type CardStyles struct {
+	Entitlements[int]
+}
+
+// MarshalJSON 按数据库中的保存格式序列化卡牌样式数据
+func (s CardStyles) MarshalJSON() ([]byte, error) {
+	return s.MarshalRecordsJSON("cardOwnStyle", "cardStyleExpiredTime")
+}
+
+// UnmarshalJSON 解析数据库中保存的卡牌样式数据
+func (s *CardStyles) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalRecordsJSON(data, "cardOwnStyle", "cardStyleExpiredTime")
 }
 
 // CardStyleManager 提供卡牌样式数据的管理功能
-type CardStyleManager struct {}
+type CardStyleManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewCardStyleManager 创建一个新的卡牌样式管理器
 func NewCardStyleManager() *CardStyleManager {
-	return &CardStyleManager{}
+	return NewCardStyleManagerWithRepository(repository.Default())
+}
+
+// NewCardStyleManagerWithRepository 使用指定的玩家仓库创建卡牌样式管理器
+func NewCardStyleManagerWithRepository(repo repository.PlayerRepository) *CardStyleManager {
+	return &CardStyleManager{repo: repo}
+}
+
+// retry 以乐观锁执行卡牌样式数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (cm *CardStyleManager) retry(fn func(tx *CardStyleManager) error) error {
+	return repository.RetryOnConflict(cm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewCardStyleManagerWithRepository(repo))
+	})
 }
 
 // GetCardStyles 从数据库获取指定设备ID的所有卡牌样式数据
-func (cm *CardStyleManager) GetCardStyles(deviceID string) ([]CardStyle, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
-		return nil, game_error.New(-3, "未找到玩家数据")
+func (cm *CardStyleManager) GetCardStyles(deviceID string) (CardStyles, error) {
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
+		return CardStyles{}, game_error.New(-3, "未找到玩家数据")
 	}
 
 	// 解析卡牌样式数据
-	var cardStyles []CardStyle
+	var cardStyles CardStyles
 	if playerData.CardStyles == "" || playerData.CardStyles == "null" {
 		// 如果没有卡牌样式数据，使用默认数据并保存到数据库
 		log.Printf("玩家 %s 的卡牌样式数据为空，使用默认数据", deviceID)
@@ -49,20 +71,20 @@ func (cm *CardStyleManager) GetCardStyles(deviceID string) ([]CardStyle, error)
 		return defaultCardStyles, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.CardStyles), &cardStyles)
+	err = json.Unmarshal([]byte(playerData.CardStyles), &cardStyles)
 	if err != nil {
 		log.Printf("解析卡牌样式数据失败: %v", err)
 		// 解析失败时，直接返回错误
-		return nil, game_error.New(-2, "数据处理错误")
+		return CardStyles{}, game_error.New(-2, "数据处理错误")
 	}
 
 	return cardStyles, nil
 }
 
 // SaveCardStyles 保存卡牌样式数据到数据库
-func (cm *CardStyleManager) SaveCardStyles(deviceID string, cardStyles []CardStyle) error {
+func (cm *CardStyleManager) SaveCardStyles(deviceID string, cardStyles CardStyles) error {
 	// 检查卡牌样式数据是否为空
-	if len(cardStyles) == 0 {
+	if cardStyles.Len() == 0 {
 		// 如果为空，使用空数组而不是空字符串
 		log.Printf("卡牌样式数据为空，使用空数组 '[]' 代替")
 		// result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("card_styles", "[]")
@@ -81,9 +103,8 @@ func (cm *CardStyleManager) SaveCardStyles(deviceID string, cardStyles []CardSty
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("card_styles", string(cardStylesJSON))
-	if result.Error != nil {
-		log.Printf("更新卡牌样式数据失败: %v", result.Error)
+	if err := cm.repo.UpdateColumns(deviceID, map[string]interface{}{"card_styles": string(cardStylesJSON)}); err != nil {
+		log.Printf("更新卡牌样式数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -91,23 +112,14 @@ func (cm *CardStyleManager) SaveCardStyles(deviceID string, cardStyles []CardSty
 }
 
 // ConvertArraysToCardStyles 将两个独立的数组转换为卡牌样式对象数组
-func (cm *CardStyleManager) ConvertArraysToCardStyles(cardOwnStyles []int, cardStyleExpiredTimes []int) []CardStyle {
+func (cm *CardStyleManager) ConvertArraysToCardStyles(cardOwnStyles []int, cardStyleExpiredTimes []int) CardStyles {
 	// 确定数组长度，取两个数组中最小的长度
 	length := len(cardOwnStyles)
 	if len(cardStyleExpiredTimes) < length {
 		length = len(cardStyleExpiredTimes)
 	}
 
-	// 创建卡牌样式对象数组
-	cardStyles := make([]CardStyle, length)
-	for i := 0; i < length; i++ {
-		cardStyles[i] = CardStyle{
-			CardOwnStyle:         cardOwnStyles[i],
-			CardStyleExpiredTime: cardStyleExpiredTimes[i],
-		}
-	}
-
-	return cardStyles
+	return CardStyles{NewEntitlements(cardOwnStyles[:length], cardStyleExpiredTimes[:length])}
 }
 
 // UpdateCardStyleData 更新玩家的卡牌样式数据
@@ -125,45 +137,66 @@ func (cm *CardStyleManager) UpdateCardStyleData(deviceID string, cardOwnStyles [
 	return nil
 }
 
-// ExtractCardStyleArrays 从卡牌样式对象数组中提取两个独立的数组
-func (cm *CardStyleManager) ExtractCardStyleArrays(cardStyles []CardStyle) ([]int, []int, error) {
-	cardOwnStyles := make([]int, len(cardStyles))
-	cardStyleExpiredTimes := make([]int, len(cardStyles))
+// AddCardStyle 添加一个卡牌样式，已拥有时更新过期时间
+func (cm *CardStyleManager) AddCardStyle(deviceID string, cardStyleID int, expiredTime int) error {
+	return cm.retry(func(tx *CardStyleManager) error {
+		cardStyles, err := tx.GetCardStyles(deviceID)
+		if err != nil {
+			return err
+		}
 
-	for i, style := range cardStyles {
-		cardOwnStyles[i] = style.CardOwnStyle
-		cardStyleExpiredTimes[i] = style.CardStyleExpiredTime
-	}
+		cardStyles.Add(cardStyleID, expiredTime)
+		return tx.SaveCardStyles(deviceID, cardStyles)
+	})
+}
 
-	return cardOwnStyles, cardStyleExpiredTimes, nil
+// RemoveCardStyle 移除一个卡牌样式
+func (cm *CardStyleManager) RemoveCardStyle(deviceID string, cardStyleID int) error {
+	return cm.retry(func(tx *CardStyleManager) error {
+		cardStyles, err := tx.GetCardStyles(deviceID)
+		if err != nil {
+			return err
+		}
+
+		if !cardStyles.Remove(cardStyleID) {
+			return game_error.New(-2, "卡牌样式不存在")
+		}
+		return tx.SaveCardStyles(deviceID, cardStyles)
+	})
+}
+
+// ExtractCardStyleArrays 从卡牌样式对象数组中提取两个独立的数组
+func (cm *CardStyleManager) ExtractCardStyleArrays(cardStyles CardStyles) ([]int, []int, error) {
+	return cardStyles.IDs(), cardStyles.ExpiredTimes(), nil
 }
 
 // GetDefaultCardStyles 创建默认卡牌样式数据并返回
-func (cm *CardStyleManager) GetDefaultCardStyles() []CardStyle {
+func (cm *CardStyleManager) GetDefaultCardStyles() CardStyles {
 	// 创建默认卡牌样式数据 - 包含cardOwnStyle和cardStyleExpiredTime属性
-	defaultCardStyles := []CardStyle{
-		{CardOwnStyle: 650041, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650051, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650031, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650061, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650011, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650021, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650101, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650081, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650071, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650091, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650111, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650121, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650181, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650171, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650151, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650161, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650191, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650201, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650241, CardStyleExpiredTime: 0},
-		{CardOwnStyle: 650211, CardStyleExpiredTime: 0},
+	defaultStyleIDs := []int{
+		650041,
+		650051,
+		650031,
+		650061,
+		650011,
+		650021,
+		650101,
+		650081,
+		650071,
+		650091,
+		650111,
+		650121,
+		650181,
+		650171,
+		650151,
+		650161,
+		650191,
+		650201,
+		650241,
+		650211,
 		// 添加更多默认卡牌样式...
 	}
+	defaultCardStyles := CardStyles{NewEntitlements(defaultStyleIDs, make([]int, len(defaultStyleIDs)))}
 	
 	return defaultCardStyles
 }
@@ -172,7 +205,7 @@ func (cm *CardStyleManager) GetDefaultCardStyles() []CardStyle {
 // 此方法用于当isSelf为true时，直接使用playerData中的数据而不再查询数据库
 func (cm *CardStyleManager) ParseCardStylesFromJSON(cardStylesJSON string) ([]int, []int, error) {
 	// 解析卡牌样式数据
-	var cardStyles []CardStyle
+	var cardStyles CardStyles
 	if cardStylesJSON == "" || cardStylesJSON == "null" {
 		// 如果没有卡牌样式数据，使用默认数据
 		log.Printf("卡牌样式数据为空，使用默认数据")
@@ -4,9 +4,10 @@ package utils
 import (
 	"encoding/json"
 	"log"
+	"strconv"
+	"time"
 
-	"dmmserver/model"
-	"dmmserver/db"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
@@ -31,19 +32,32 @@ type CharacterSkinInfo struct {
 }
 
 // CharacterManager 提供角色数据的管理功能
-type CharacterManager struct {}
+type CharacterManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewCharacterManager 创建一个新的角色管理器
 func NewCharacterManager() *CharacterManager {
-	return &CharacterManager{}
+	return NewCharacterManagerWithRepository(repository.Default())
+}
+
+// NewCharacterManagerWithRepository 使用指定的玩家仓库创建角色管理器
+func NewCharacterManagerWithRepository(repo repository.PlayerRepository) *CharacterManager {
+	return &CharacterManager{repo: repo}
+}
+
+// retry 以乐观锁执行角色数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (cm *CharacterManager) retry(fn func(tx *CharacterManager) error) error {
+	return repository.RetryOnConflict(cm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewCharacterManagerWithRepository(repo))
+	})
 }
 
 // GetCharacters 从数据库获取指定设备ID的所有角色数据
 func (cm *CharacterManager) GetCharacters(deviceID string) ([]Character, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
-		return nil, result.Error
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 解析角色数据
@@ -53,7 +67,7 @@ func (cm *CharacterManager) GetCharacters(deviceID string) ([]Character, error)
 		return []Character{}, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.OwnedCharacters), &characters)
+	err = json.Unmarshal([]byte(playerData.OwnedCharacters), &characters)
 	if err != nil {
 		log.Printf("解析角色数据失败: %v", err)
 		return nil, err
@@ -88,10 +102,9 @@ func (cm *CharacterManager) SaveCharacters(deviceID string, characters []Charact
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("owned_characters", string(charactersJSON))
-	if result.Error != nil {
-		log.Printf("更新角色数据失败: %v", result.Error)
-		return result.Error
+	if err := cm.repo.UpdateColumns(deviceID, map[string]interface{}{"owned_characters": string(charactersJSON)}); err != nil {
+		log.Printf("更新角色数据失败: %v", err)
+		return err
 	}
 
 	return nil
@@ -99,74 +112,236 @@ func (cm *CharacterManager) SaveCharacters(deviceID string, characters []Charact
 
 // AddCharacter 添加一个新角色
 func (cm *CharacterManager) AddCharacter(deviceID string, character Character) error {
-	characters, err := cm.GetCharacters(deviceID)
-	if err != nil {
-		return err
-	}
+	return cm.retry(func(tx *CharacterManager) error {
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 检查角色是否已存在
-	for i := range characters {
-		if characters[i].CharacterID == character.CharacterID {
-			return game_error.New(-8, "角色已存在")
+		// 检查角色是否已存在
+		for i := range characters {
+			if characters[i].CharacterID == character.CharacterID {
+				return game_error.New(-8, "角色已存在")
+			}
 		}
-	}
 
-	// 添加新角色
-	characters = append(characters, character)
+		// 添加新角色
+		characters = append(characters, character)
 
-	// 保存到数据库
-	return cm.SaveCharacters(deviceID, characters)
+		// 保存到数据库
+		return tx.SaveCharacters(deviceID, characters)
+	})
 }
 
 // UpdateCharacter 更新角色数据
 func (cm *CharacterManager) UpdateCharacter(deviceID string, character Character) error {
-	characters, err := cm.GetCharacters(deviceID)
-	if err != nil {
-		return err
+	return cm.retry(func(tx *CharacterManager) error {
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
+
+		// 查找并更新角色
+		found := false
+		for i := range characters {
+			if characters[i].CharacterID == character.CharacterID {
+				if err := tx.checkSkinNotExpired(deviceID, characters[i].CurrentSkinInfo, character.CurrentSkinInfo); err != nil {
+					return err
+				}
+				characters[i] = character
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return game_error.New(-31, "角色不存在")
+		}
+
+		// 保存到数据库
+		return tx.SaveCharacters(deviceID, characters)
+	})
+}
+
+// checkSkinNotExpired 检查新换上的皮肤部件是否已过期，角色原本穿着的部件不做检查
+func (cm *CharacterManager) checkSkinNotExpired(deviceID string, current CharacterSkinInfo, updated CharacterSkinInfo) error {
+	wearing := map[string]bool{}
+	for _, id := range current.SkinPartIDs {
+		wearing[idString(id)] = true
 	}
 
-	// 查找并更新角色
-	found := false
-	for i := range characters {
-		if characters[i].CharacterID == character.CharacterID {
-			characters[i] = character
-			found = true
-			break
+	var owned Entitlements[string]
+	loaded := false
+	now := time.Now()
+	for _, id := range updated.SkinPartIDs {
+		skinPartID := idString(id)
+		if wearing[skinPartID] {
+			continue
+		}
+		if !loaded {
+			skinParts, err := NewSkinPartManagerWithRepository(cm.repo).GetSkinParts(deviceID)
+			if err != nil {
+				return err
+			}
+			owned = skinPartEntitlements(skinParts)
+			loaded = true
+		}
+		if err := owned.CheckNotExpired(skinPartID, now); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// DefaultActiveCharacterIDs 新玩家和没有出战角色数据的玩家使用的出战角色
+func (cm *CharacterManager) DefaultActiveCharacterIDs() []int {
+	return []int{100, 200}
+}
 
-	if !found {
-		return game_error.New(-31, "角色不存在")
+// ParseActiveCharacterIDsFromJSON 解析出战角色数据，数据为空或解析失败时返回默认出战角色
+func (cm *CharacterManager) ParseActiveCharacterIDsFromJSON(activeJSON string) []int {
+	if activeJSON == "" || activeJSON == "null" {
+		return cm.DefaultActiveCharacterIDs()
+	}
+	var ids []int
+	if err := json.Unmarshal([]byte(activeJSON), &ids); err != nil || len(ids) != len(cm.DefaultActiveCharacterIDs()) {
+		log.Printf("解析出战角色数据失败: %v，使用默认值", err)
+		return cm.DefaultActiveCharacterIDs()
 	}
+	return ids
+}
 
-	// 保存到数据库
-	return cm.SaveCharacters(deviceID, characters)
+// GetActiveCharacterIDs 获取玩家的出战角色
+func (cm *CharacterManager) GetActiveCharacterIDs(deviceID string) ([]int, error) {
+	playerData, err := cm.repo.GetByDeviceID(deviceID)
+	if err != nil {
+		return nil, game_error.New(-3, "未找到玩家数据")
+	}
+	return cm.ParseActiveCharacterIDsFromJSON(playerData.ActiveCharacters), nil
+}
+
+// EquipCharacter 把 slot 位置的出战角色换成 characterID
+// 角色必须已拥有且未过期：未拥有返回 -7，已过期返回 -136，slot 超出范围返回 -13。
+func (cm *CharacterManager) EquipCharacter(deviceID string, slot int, characterID int) error {
+	return cm.retry(func(tx *CharacterManager) error {
+		active, err := tx.GetActiveCharacterIDs(deviceID)
+		if err != nil {
+			return err
+		}
+		if slot < 0 || slot >= len(active) {
+			return game_error.New(-13, "非法参数")
+		}
+
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
+		owned := Entitlements[int]{}
+		for _, character := range characters {
+			owned.Add(character.CharacterID, character.ExpiredTime)
+		}
+		if err := owned.CheckEquippable(characterID, time.Now()); err != nil {
+			return err
+		}
+
+		active[slot] = characterID
+		activeJSON, err := json.Marshal(active)
+		if err != nil {
+			log.Printf("序列化出战角色数据失败: %v", err)
+			return err
+		}
+		if err := tx.repo.UpdateColumns(deviceID, map[string]interface{}{"active_characters": string(activeJSON)}); err != nil {
+			log.Printf("更新出战角色数据失败: %v", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// EquipSkinParts 给角色换上一组皮肤部件，部件的颜色、贴花和过期时间取自玩家拥有的皮肤部件
+// 每个部件都必须已拥有且未过期：未拥有返回 -7，已过期返回 -136，未拥有该角色返回 -31。
+func (cm *CharacterManager) EquipSkinParts(deviceID string, characterID int, skinPartIDs []string) error {
+	return cm.retry(func(tx *CharacterManager) error {
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
+		index := -1
+		for i := range characters {
+			if characters[i].CharacterID == characterID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return game_error.New(-31, "角色不存在")
+		}
+
+		skinParts, err := NewSkinPartManagerWithRepository(tx.repo).GetSkinParts(deviceID)
+		if err != nil {
+			return err
+		}
+		owned := skinPartEntitlements(skinParts)
+		now := time.Now()
+		skinInfo := CharacterSkinInfo{
+			SkinPartIDs:    []interface{}{},
+			SkinPartColors: []string{},
+			SkinDecals:     []int{},
+			ExpiredTime:    []int{},
+		}
+		seen := map[string]bool{}
+		for _, skinPartID := range skinPartIDs {
+			if seen[skinPartID] {
+				return game_error.New(-13, "非法参数")
+			}
+			seen[skinPartID] = true
+			if err := owned.CheckEquippable(skinPartID, now); err != nil {
+				return err
+			}
+			for _, part := range skinParts {
+				if part.SkinPartIDs != skinPartID {
+					continue
+				}
+				decal, _ := strconv.Atoi(idString(part.SkinDecals))
+				skinInfo.SkinPartIDs = append(skinInfo.SkinPartIDs, part.SkinPartIDs)
+				skinInfo.SkinPartColors = append(skinInfo.SkinPartColors, part.SkinPartColors)
+				skinInfo.SkinDecals = append(skinInfo.SkinDecals, decal)
+				skinInfo.ExpiredTime = append(skinInfo.ExpiredTime, part.ExpiredTime)
+				break
+			}
+		}
+
+		characters[index].CurrentSkinInfo = skinInfo
+		return tx.SaveCharacters(deviceID, characters)
+	})
 }
 
 // DeleteCharacter 删除角色
 func (cm *CharacterManager) DeleteCharacter(deviceID string, characterID int) error {
-	characters, err := cm.GetCharacters(deviceID)
-	if err != nil {
-		return err
-	}
+	return cm.retry(func(tx *CharacterManager) error {
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并删除角色
-	found := false
-	newCharacters := []Character{}
-	for i := range characters {
-		if characters[i].CharacterID != characterID {
-			newCharacters = append(newCharacters, characters[i])
-		} else {
-			found = true
+		// 查找并删除角色
+		found := false
+		newCharacters := []Character{}
+		for i := range characters {
+			if characters[i].CharacterID != characterID {
+				newCharacters = append(newCharacters, characters[i])
+			} else {
+				found = true
+			}
 		}
-	}
 
-	if !found {
-		return game_error.New(-31, "角色不存在")
-	}
+		if !found {
+			return game_error.New(-31, "角色不存在")
+		}
 
-	// 保存到数据库
-	return cm.SaveCharacters(deviceID, newCharacters)
+		// 保存到数据库
+		return tx.SaveCharacters(deviceID, newCharacters)
+	})
 }
 
 // ParseCharactersFromDB 从数据库JSON字符串解析角色数据
@@ -2,18 +2,51 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
+	"time"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
-// OwnedEmotion 表示玩家拥有的表情结构
-type OwnedEmotion struct {
-	ID          []interface{} `json:"id"`          // 表情ID，可以是整数或字符串
-	ExpiredTime []int         `json:"expiredTime"` // 过期时间
+// EmotionID 表示表情ID
+// 数据库中的表情ID可能是数字也可能是字符串，统一按文本比较；从数据库读出的ID由 Entitlements 按原来的写法写回，
+// 新添加的ID中纯数字的写成数字，其余写成字符串。
+type EmotionID string
+
+// MarshalJSON 纯数字的ID序列化为数字
+func (id EmotionID) MarshalJSON() ([]byte, error) {
+	if isJSONInteger(string(id)) {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON 接受数字或字符串
+func (id *EmotionID) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	*id = EmotionID(idString(v))
+	return nil
+}
+
+// isJSONInteger 判断文本是否可以原样作为JSON整数输出
+func isJSONInteger(s string) bool {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // EmotionConfig 表示角色的表情配置结构
@@ -24,23 +57,36 @@ type EmotionConfig struct {
 
 // EmotionData 表示完整的表情数据结构
 type EmotionData struct {
-	OwnedIngameEmotion   OwnedEmotion    `json:"ownedIngameEmotion"`   // 拥有的游戏内表情
-	IngameEmotionConfigs []EmotionConfig `json:"ingameEmotionConfigs"` // 游戏内表情配置
+	OwnedIngameEmotion   Entitlements[EmotionID] `json:"ownedIngameEmotion"`   // 拥有的游戏内表情，保存为 {"id":[],"expiredTime":[]}
+	IngameEmotionConfigs []EmotionConfig         `json:"ingameEmotionConfigs"` // 游戏内表情配置
 }
 
 // EmotionManager 提供表情数据的管理功能
-type EmotionManager struct {}
+type EmotionManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewEmotionManager 创建一个新的表情管理器
 func NewEmotionManager() *EmotionManager {
-	return &EmotionManager{}
+	return NewEmotionManagerWithRepository(repository.Default())
+}
+
+// NewEmotionManagerWithRepository 使用指定的玩家仓库创建表情管理器
+func NewEmotionManagerWithRepository(repo repository.PlayerRepository) *EmotionManager {
+	return &EmotionManager{repo: repo}
+}
+
+// retry 以乐观锁执行表情数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (em *EmotionManager) retry(fn func(tx *EmotionManager) error) error {
+	return repository.RetryOnConflict(em.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewEmotionManagerWithRepository(repo))
+	})
 }
 
 // GetEmotionData 从数据库获取指定设备ID的表情数据
 func (em *EmotionManager) GetEmotionData(deviceID string) (*EmotionData, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := em.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -61,7 +107,7 @@ func (em *EmotionManager) GetEmotionData(deviceID string) (*EmotionData, error)
 		return defaultEmotionData, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.EmotionData), &emotionData)
+	err = json.Unmarshal([]byte(playerData.EmotionData), &emotionData)
 	if err != nil {
 		log.Printf("解析表情数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -82,9 +128,8 @@ func (em *EmotionManager) SaveEmotionData(deviceID string, emotionData *EmotionD
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("emotion_data", string(emotionDataJSON))
-	if result.Error != nil {
-		log.Printf("更新表情数据失败: %v", result.Error)
+	if err := em.repo.UpdateColumns(deviceID, map[string]interface{}{"emotion_data": string(emotionDataJSON)}); err != nil {
+		log.Printf("更新表情数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -95,10 +140,7 @@ func (em *EmotionManager) SaveEmotionData(deviceID string, emotionData *EmotionD
 func (em *EmotionManager) GetDefaultEmotionData() *EmotionData {
 	// 创建默认表情数据
 	defaultEmotionData := &EmotionData{
-		OwnedIngameEmotion: OwnedEmotion{
-			ID:          []interface{}{950001, 960001, 960701},
-			ExpiredTime: []int{0, 0, 0},
-		},
+		OwnedIngameEmotion: NewEntitlements([]EmotionID{"950001", "960001", "960701"}, []int{0, 0, 0}),
 		IngameEmotionConfigs: []EmotionConfig{
 			{
 				Character: 100,
@@ -128,18 +170,27 @@ func (em *EmotionManager) GetDefaultEmotionData() *EmotionData {
 
 // UpdateOwnedEmotions 更新玩家拥有的表情数据
 func (em *EmotionManager) UpdateOwnedEmotions(deviceID string, ids []interface{}, expiredTimes []int) error {
-	// 获取当前表情数据
-	emotionData, err := em.GetEmotionData(deviceID)
-	if err != nil {
-		return err
-	}
+	return em.retry(func(tx *EmotionManager) error {
+		// 获取当前表情数据
+		emotionData, err := tx.GetEmotionData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 更新拥有的表情数据
-	emotionData.OwnedIngameEmotion.ID = ids
-	emotionData.OwnedIngameEmotion.ExpiredTime = expiredTimes
+		// 更新拥有的表情数据，ID保持传入时的数字或字符串写法
+		ownedJSON, err := json.Marshal(map[string]interface{}{"id": ids, "expiredTime": expiredTimes})
+		if err != nil {
+			log.Printf("序列化表情数据失败: %v", err)
+			return game_error.New(-2, "数据处理错误")
+		}
+		if err := emotionData.OwnedIngameEmotion.UnmarshalJSON(ownedJSON); err != nil {
+			log.Printf("解析表情ID失败: %v", err)
+			return game_error.New(-13, "非法参数")
+		}
 
-	// 保存到数据库
-	return em.SaveEmotionData(deviceID, emotionData)
+		// 保存到数据库
+		return tx.SaveEmotionData(deviceID, emotionData)
+	})
 }
 
 // GetOwnedEmotions 获取玩家拥有的表情数据
@@ -150,96 +201,100 @@ func (em *EmotionManager) GetOwnedEmotions(deviceID string) ([]interface{}, []in
 		return nil, nil, err
 	}
 
-	return emotionData.OwnedIngameEmotion.ID, emotionData.OwnedIngameEmotion.ExpiredTime, nil
+	// 按数据库中的写法返回ID：数字为 float64，字符串为 string
+	rawIDs, err := emotionData.OwnedIngameEmotion.RawIDs()
+	if err != nil {
+		log.Printf("序列化表情ID失败: %v", err)
+		return nil, nil, game_error.New(-2, "数据处理错误")
+	}
+	ids := []interface{}{}
+	for _, raw := range rawIDs {
+		var id interface{}
+		if err := json.Unmarshal(raw, &id); err != nil {
+			log.Printf("解析表情ID失败: %v", err)
+			return nil, nil, game_error.New(-2, "数据处理错误")
+		}
+		ids = append(ids, id)
+	}
+	return ids, emotionData.OwnedIngameEmotion.ExpiredTimes(), nil
 }
 
 // AddOwnedEmotion 添加一个新的表情到玩家拥有的表情列表
 func (em *EmotionManager) AddOwnedEmotion(deviceID string, id interface{}, expiredTime int) error {
-	// 获取当前表情数据
-	emotionData, err := em.GetEmotionData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 检查表情是否已存在
-	for i, emotionID := range emotionData.OwnedIngameEmotion.ID {
-		if emotionID == id {
-			// 如果已存在，更新过期时间
-			emotionData.OwnedIngameEmotion.ExpiredTime[i] = expiredTime
-			return em.SaveEmotionData(deviceID, emotionData)
+	return em.retry(func(tx *EmotionManager) error {
+		// 获取当前表情数据
+		emotionData, err := tx.GetEmotionData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// 添加新表情
-	emotionData.OwnedIngameEmotion.ID = append(emotionData.OwnedIngameEmotion.ID, id)
-	emotionData.OwnedIngameEmotion.ExpiredTime = append(emotionData.OwnedIngameEmotion.ExpiredTime, expiredTime)
+		// 添加新表情，已存在时更新过期时间
+		emotionData.OwnedIngameEmotion.Add(EmotionID(idString(id)), expiredTime)
 
-	// 保存到数据库
-	return em.SaveEmotionData(deviceID, emotionData)
+		// 保存到数据库
+		return tx.SaveEmotionData(deviceID, emotionData)
+	})
 }
 
 // RemoveOwnedEmotion 从玩家拥有的表情列表中移除一个表情
 func (em *EmotionManager) RemoveOwnedEmotion(deviceID string, id interface{}) error {
-	// 获取当前表情数据
-	emotionData, err := em.GetEmotionData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 查找并移除表情
-	found := false
-	newIDs := []interface{}{}
-	newExpiredTimes := []int{}
-
-	for i, emotionID := range emotionData.OwnedIngameEmotion.ID {
-		if emotionID != id {
-			newIDs = append(newIDs, emotionID)
-			newExpiredTimes = append(newExpiredTimes, emotionData.OwnedIngameEmotion.ExpiredTime[i])
-		} else {
-			found = true
+	return em.retry(func(tx *EmotionManager) error {
+		// 获取当前表情数据
+		emotionData, err := tx.GetEmotionData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
-
-	if !found {
-		return game_error.New(-2, "表情不存在")
-	}
 
-	// 更新表情数据
-	emotionData.OwnedIngameEmotion.ID = newIDs
-	emotionData.OwnedIngameEmotion.ExpiredTime = newExpiredTimes
+		// 查找并移除表情
+		if !emotionData.OwnedIngameEmotion.Remove(EmotionID(idString(id))) {
+			return game_error.New(-2, "表情不存在")
+		}
 
-	// 保存到数据库
-	return em.SaveEmotionData(deviceID, emotionData)
+		// 保存到数据库
+		return tx.SaveEmotionData(deviceID, emotionData)
+	})
 }
 
 // UpdateEmotionConfig 更新指定角色的表情配置
 func (em *EmotionManager) UpdateEmotionConfig(deviceID string, characterID int, config []interface{}) error {
-	// 获取当前表情数据
-	emotionData, err := em.GetEmotionData(deviceID)
-	if err != nil {
-		return err
-	}
+	return em.retry(func(tx *EmotionManager) error {
+		// 获取当前表情数据
+		emotionData, err := tx.GetEmotionData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并更新角色的表情配置
-	found := false
-	for i, emotionConfig := range emotionData.IngameEmotionConfigs {
-		if emotionConfig.Character == characterID {
-			emotionData.IngameEmotionConfigs[i].Config = config
-			found = true
-			break
+		// 配置中的表情必须已拥有且未过期，0 表示该位置为空
+		now := time.Now()
+		for _, id := range config {
+			if text := idString(id); text != "" && text != "0" {
+				if err := emotionData.OwnedIngameEmotion.CheckEquippable(EmotionID(text), now); err != nil {
+					return err
+				}
+			}
 		}
-	}
 
-	// 如果没有找到角色的配置，添加一个新的配置
-	if !found {
-		emotionData.IngameEmotionConfigs = append(emotionData.IngameEmotionConfigs, EmotionConfig{
-			Character: characterID,
-			Config:    config,
-		})
-	}
+		// 查找并更新角色的表情配置
+		found := false
+		for i, emotionConfig := range emotionData.IngameEmotionConfigs {
+			if emotionConfig.Character == characterID {
+				emotionData.IngameEmotionConfigs[i].Config = config
+				found = true
+				break
+			}
+		}
 
-	// 保存到数据库
-	return em.SaveEmotionData(deviceID, emotionData)
+		// 如果没有找到角色的配置，添加一个新的配置
+		if !found {
+			emotionData.IngameEmotionConfigs = append(emotionData.IngameEmotionConfigs, EmotionConfig{
+				Character: characterID,
+				Config:    config,
+			})
+		}
+
+		// 保存到数据库
+		return tx.SaveEmotionData(deviceID, emotionData)
+	})
 }
 
 // GetEmotionConfig 获取指定角色的表情配置
@@ -274,33 +329,35 @@ func (em *EmotionManager) GetAllEmotionConfigs(deviceID string) ([]EmotionConfig
 
 // RemoveEmotionConfig 移除指定角色的表情配置
 func (em *EmotionManager) RemoveEmotionConfig(deviceID string, characterID int) error {
-	// 获取当前表情数据
-	emotionData, err := em.GetEmotionData(deviceID)
-	if err != nil {
-		return err
-	}
+	return em.retry(func(tx *EmotionManager) error {
+		// 获取当前表情数据
+		emotionData, err := tx.GetEmotionData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并移除角色的表情配置
-	found := false
-	newConfigs := []EmotionConfig{}
+		// 查找并移除角色的表情配置
+		found := false
+		newConfigs := []EmotionConfig{}
 
-	for _, emotionConfig := range emotionData.IngameEmotionConfigs {
-		if emotionConfig.Character != characterID {
-			newConfigs = append(newConfigs, emotionConfig)
-		} else {
-			found = true
+		for _, emotionConfig := range emotionData.IngameEmotionConfigs {
+			if emotionConfig.Character != characterID {
+				newConfigs = append(newConfigs, emotionConfig)
+			} else {
+				found = true
+			}
 		}
-	}
 
-	if !found {
-		return game_error.New(-2, "角色表情配置不存在")
-	}
+		if !found {
+			return game_error.New(-2, "角色表情配置不存在")
+		}
 
-	// 更新表情配置
-	emotionData.IngameEmotionConfigs = newConfigs
+		// 更新表情配置
+		emotionData.IngameEmotionConfigs = newConfigs
 
-	// 保存到数据库
-	return em.SaveEmotionData(deviceID, emotionData)
+		// 保存到数据库
+		return tx.SaveEmotionData(deviceID, emotionData)
+	})
 }
 
 // ParseEmotionDataFromJSON 从JSON字符串解析表情数据
@@ -0,0 +1,379 @@
+// utils/entitlements.go
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"dmmserver/game_error"
+)
+
+// Entitlement 表示玩家拥有的一个限时物品
+type Entitlement[K comparable] struct {
+	ID          K   // 物品ID
+	ExpiredTime int // 过期时间（Unix 秒），0 表示永久
+
+	rawID         json.RawMessage // 从数据库读出的原始ID写法，序列化时原样写回
+	noExpiredTime bool            // 读出时过期时间数组较短，没有对应的过期时间
+}
+
+// ActiveAt 判断物品在时刻 t 是否仍然有效
+func (e Entitlement[K]) ActiveAt(t time.Time) bool {
+	return e.ExpiredTime == 0 || int64(e.ExpiredTime) > t.Unix()
+}
+
+// Entitlements 是玩家拥有的一组限时物品（头像框、聊天气泡、表情、炫光、卡牌皮肤、卡牌样式）
+// 保持添加时的顺序，新添加的ID不会重复。
+// 数据库中有两种保存格式：并列数组 {"id":[...],"expiredTime":[...]} 和对象数组 [{"id":..,"expiredTime":..}]，
+// 键名因物品而异，分别由 MarshalArraysJSON 和 MarshalRecordsJSON 按调用方给出的键名读写。
+// 已有数据原样保留：ID的原始写法（数字或字符串）、重复的ID以及长度不一致的并列数组在读写后保持不变，
+// 重复的ID按第一次出现的过期时间判断。
+type Entitlements[K comparable] struct {
+	items        []Entitlement[K]
+	extraExpired []int // 过期时间数组比ID数组长时多出的部分
+}
+
+// NewEntitlements 从并列的ID数组和过期时间数组创建
+// 过期时间数组较短时，缺少的过期时间按永久处理，序列化时仍然保持原来的长度。
+func NewEntitlements[K comparable](ids []K, expiredTimes []int) Entitlements[K] {
+	var e Entitlements[K]
+	for i, id := range ids {
+		item := Entitlement[K]{ID: id, noExpiredTime: i >= len(expiredTimes)}
+		if !item.noExpiredTime {
+			item.ExpiredTime = expiredTimes[i]
+		}
+		e.items = append(e.items, item)
+	}
+	if len(expiredTimes) > len(ids) {
+		e.extraExpired = append([]int{}, expiredTimes[len(ids):]...)
+	}
+	return e
+}
+
+// Len 返回物品数量
+func (e Entitlements[K]) Len() int {
+	return len(e.items)
+}
+
+// Items 返回全部物品的副本
+func (e Entitlements[K]) Items() []Entitlement[K] {
+	return append([]Entitlement[K]{}, e.items...)
+}
+
+// IDs 返回全部物品ID，没有物品时返回空数组而不是 nil
+func (e Entitlements[K]) IDs() []K {
+	ids := make([]K, len(e.items))
+	for i, item := range e.items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+// RawIDs 返回按原始写法序列化的物品ID，读出的ID保持数据库中的数字或字符串写法
+func (e Entitlements[K]) RawIDs() ([]json.RawMessage, error) {
+	ids := make([]json.RawMessage, len(e.items))
+	for i, item := range e.items {
+		if item.rawID != nil {
+			ids[i] = item.rawID
+			continue
+		}
+		raw, err := json.Marshal(item.ID)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = raw
+	}
+	return ids, nil
+}
+
+// ExpiredTimes 返回与 IDs 按下标对应的过期时间
+// 长度与读出时的过期时间数组一致：末尾没有过期时间的ID不补0，多出的过期时间原样保留。
+func (e Entitlements[K]) ExpiredTimes() []int {
+	n := len(e.items)
+	for n > 0 && e.items[n-1].noExpiredTime {
+		n--
+	}
+	expiredTimes := make([]int, 0, n+len(e.extraExpired))
+	for _, item := range e.items[:n] {
+		expiredTimes = append(expiredTimes, item.ExpiredTime)
+	}
+	return append(expiredTimes, e.extraExpired...)
+}
+
+// index 返回 id 的下标，不存在时返回 -1
+func (e Entitlements[K]) index(id K) int {
+	for i, item := range e.items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsOwned 判断是否拥有该物品（不考虑是否过期）
+func (e Entitlements[K]) IsOwned(id K) bool {
+	return e.index(id) >= 0
+}
+
+// ExpiredTime 返回物品的过期时间，未拥有时第二个返回值为 false
+func (e Entitlements[K]) ExpiredTime(id K) (int, bool) {
+	i := e.index(id)
+	if i < 0 {
+		return 0, false
+	}
+	return e.items[i].ExpiredTime, true
+}
+
+// IsActive 判断是否拥有该物品且在时刻 t 仍然有效
+func (e Entitlements[K]) IsActive(id K, t time.Time) bool {
+	i := e.index(id)
+	return i >= 0 && e.items[i].ActiveAt(t)
+}
+
+// ActiveAt 返回在时刻 t 仍然有效的物品
+func (e Entitlements[K]) ActiveAt(t time.Time) Entitlements[K] {
+	var active Entitlements[K]
+	for _, item := range e.items {
+		if item.ActiveAt(t) {
+			active.items = append(active.items, item)
+		}
+	}
+	return active
+}
+
+// CheckEquippable 检查物品能否装备
+// 已拥有且在时刻 t 仍有效时返回 nil，已过期返回 -136，未拥有返回 -7。
+func (e Entitlements[K]) CheckEquippable(id K, t time.Time) error {
+	if !e.IsOwned(id) {
+		return game_error.New(-7, "使用了未拥有的物品")
+	}
+	return e.CheckNotExpired(id, t)
+}
+
+// CheckNotExpired 物品已拥有但在时刻 t 已过期时返回 -136
+// 不在列表中的物品不做检查，用于卡牌默认皮肤这类不登记在拥有列表中的物品。
+func (e Entitlements[K]) CheckNotExpired(id K, t time.Time) error {
+	i := e.index(id)
+	if i >= 0 && !e.items[i].ActiveAt(t) {
+		return game_error.New(-136, "物品已过期")
+	}
+	return nil
+}
+
+// RemoveExpired 移除在时刻 t 已经过期的物品，返回被移除的ID
+func (e *Entitlements[K]) RemoveExpired(t time.Time) []K {
+	var removed []K
+	kept := e.items[:0:0]
+	for _, item := range e.items {
+		if item.ActiveAt(t) {
+			kept = append(kept, item)
+		} else {
+			removed = append(removed, item.ID)
+		}
+	}
+	e.items = kept
+	return removed
+}
+
+// Add 添加一个物品，已拥有时用 expiredTime 覆盖原来的过期时间
+func (e *Entitlements[K]) Add(id K, expiredTime int) {
+	found := false
+	for i := range e.items {
+		if e.items[i].ID == id {
+			e.items[i].ExpiredTime = expiredTime
+			e.items[i].noExpiredTime = false
+			found = true
+		}
+	}
+	if !found {
+		e.items = append(e.items, Entitlement[K]{ID: id, ExpiredTime: expiredTime})
+	}
+}
+
+// Extend 把物品的有效期延长 seconds 秒并返回新的过期时间
+// 未拥有或已过期的物品从 now 开始计算，永久物品保持永久；seconds <= 0 时改为永久。
+func (e *Entitlements[K]) Extend(id K, seconds int, now time.Time) int {
+	expiredTime := 0
+	if seconds > 0 {
+		start := int(now.Unix())
+		if current, ok := e.ExpiredTime(id); ok {
+			if current == 0 {
+				return 0
+			}
+			if current > start {
+				start = current
+			}
+		}
+		expiredTime = start + seconds
+	}
+	e.Add(id, expiredTime)
+	return expiredTime
+}
+
+// Remove 移除一个物品（包括重复的ID），未拥有时返回 false
+func (e *Entitlements[K]) Remove(id K) bool {
+	kept := e.items[:0:0]
+	for _, item := range e.items {
+		if item.ID != id {
+			kept = append(kept, item)
+		}
+	}
+	removed := len(kept) < len(e.items)
+	e.items = kept
+	return removed
+}
+
+// MarshalJSON 按 {"id":[...],"expiredTime":[...]} 格式序列化
+func (e Entitlements[K]) MarshalJSON() ([]byte, error) {
+	return e.MarshalArraysJSON("id", "expiredTime")
+}
+
+// UnmarshalJSON 解析 {"id":[...],"expiredTime":[...]} 格式
+func (e *Entitlements[K]) UnmarshalJSON(data []byte) error {
+	return e.UnmarshalArraysJSON(data, "id", "expiredTime")
+}
+
+// MarshalArraysJSON 序列化为并列数组 {"<idKey>":[...],"<expiredTimeKey>":[...]}
+func (e Entitlements[K]) MarshalArraysJSON(idKey, expiredTimeKey string) ([]byte, error) {
+	ids, err := e.RawIDs()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if err := writeJSONField(&buf, idKey, ids); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(',')
+	if err := writeJSONField(&buf, expiredTimeKey, e.ExpiredTimes()); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalArraysJSON 解析并列数组格式，缺少的键按空数组处理
+func (e *Entitlements[K]) UnmarshalArraysJSON(data []byte, idKey, expiredTimeKey string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var rawIDs []json.RawMessage
+	var expiredTimes []int
+	if err := unmarshalJSONField(fields, idKey, &rawIDs); err != nil {
+		return err
+	}
+	if err := unmarshalJSONField(fields, expiredTimeKey, &expiredTimes); err != nil {
+		return err
+	}
+	ids := make([]K, len(rawIDs))
+	for i, raw := range rawIDs {
+		if err := json.Unmarshal(raw, &ids[i]); err != nil {
+			return fmt.Errorf("字段 %s: %w", idKey, err)
+		}
+	}
+	result := NewEntitlements(ids, expiredTimes)
+	for i, raw := range rawIDs {
+		result.items[i].rawID = raw
+	}
+	*e = result
+	return nil
+}
+
+// MarshalRecordsJSON 序列化为对象数组 [{"<idKey>":..,"<expiredTimeKey>":..}]
+func (e Entitlements[K]) MarshalRecordsJSON(idKey, expiredTimeKey string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, item := range e.items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		var id interface{} = item.ID
+		if item.rawID != nil {
+			id = item.rawID
+		}
+		if err := writeJSONField(&buf, idKey, id); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		if err := writeJSONField(&buf, expiredTimeKey, item.ExpiredTime); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalRecordsJSON 解析对象数组格式，缺少过期时间的记录按永久处理，重复的记录原样保留
+func (e *Entitlements[K]) UnmarshalRecordsJSON(data []byte, idKey, expiredTimeKey string) error {
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	var result Entitlements[K]
+	for _, record := range records {
+		var item Entitlement[K]
+		if err := unmarshalJSONField(record, idKey, &item.ID); err != nil {
+			return err
+		}
+		if err := unmarshalJSONField(record, expiredTimeKey, &item.ExpiredTime); err != nil {
+			return err
+		}
+		item.rawID = record[idKey]
+		result.items = append(result.items, item)
+	}
+	*e = result
+	return nil
+}
+
+// writeJSONField 写入 "key":value
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) error {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(keyJSON)
+	buf.WriteByte(':')
+	buf.Write(valueJSON)
+	return nil
+}
+
+// unmarshalJSONField 解析 fields[key]，键不存在时保持零值
+func unmarshalJSONField(fields map[string]json.RawMessage, key string, v interface{}) error {
+	raw, ok := fields[key]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("字段 %s: %w", key, err)
+	}
+	return nil
+}
+
+// idString 把可能是数字或字符串的物品ID转换为文本，数字不使用科学计数法
+// 调用方传入的通常是 int 或字符串，从JSON解析出的数字可能是 float64 或 json.Number。
+func idString(id interface{}) string {
+	switch v := id.(type) {
+	case EmotionID:
+		return string(v)
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,63 @@
+// utils/entitlements_test.go
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// 读出后不做修改再写回时，数据库中的表情、装饰框、炫光和卡牌皮肤数据保持原样
+func TestEntitlementsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		v    interface{}
+	}{
+		{"字符串和数字混合的表情ID", `{"ownedIngameEmotion":{"id":[950001,"960701","abc"],"expiredTime":[0,0,0]},"ingameEmotionConfigs":[]}`, &EmotionData{}},
+		{"重复的表情ID", `{"ownedIngameEmotion":{"id":["960701",960701,960701],"expiredTime":[0,100,0]},"ingameEmotionConfigs":[]}`, &EmotionData{}},
+		{"过期时间数组较短", `{"ownedHeadBoxes":{"headBoxID":[900001,900002,900003],"expiredTime":[0]},"ownedBubbleBoxes":{"bubbleBoxID":[910001],"expiredTime":[]}}`, &BoxesData{}},
+		{"过期时间数组较长", `{"ownedHeadBoxes":{"headBoxID":[900001],"expiredTime":[0,5,6]},"ownedBubbleBoxes":{"bubbleBoxID":[],"expiredTime":[]}}`, &BoxesData{}},
+		{"炫光", `{"id":[1001,1001,1002],"expiredTime":[0],"config":1001}`, &LightnessData{}},
+		{"重复的卡牌皮肤", `[{"cardOwnSkin":600001,"cardSkinExpiredTime":0},{"cardOwnSkin":600001,"cardSkinExpiredTime":9}]`, &CardSkins{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := json.Unmarshal([]byte(tt.data), tt.v); err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			got, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatalf("序列化失败: %v", err)
+			}
+			if string(got) != tt.data {
+				t.Fatalf("写回的数据为\n%s\n期望\n%s", got, tt.data)
+			}
+		})
+	}
+}
+
+// 修改后其他ID的写法不变，新添加的ID与过期时间仍然一一对应
+func TestEntitlementsKeepShapeAfterChange(t *testing.T) {
+	var data EmotionData
+	if err := json.Unmarshal([]byte(`{"ownedIngameEmotion":{"id":["960701",950001,950002],"expiredTime":[0]},"ingameEmotionConfigs":[]}`), &data); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if expiredTime, owned := data.OwnedIngameEmotion.ExpiredTime("950002"); !owned || expiredTime != 0 {
+		t.Fatalf("缺少过期时间的表情应按永久处理: owned=%v, expiredTime=%d", owned, expiredTime)
+	}
+
+	data.OwnedIngameEmotion.Add("960001", 100)
+	got, err := json.Marshal(data.OwnedIngameEmotion)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	want := `{"id":["960701",950001,950002,960001],"expiredTime":[0,0,0,100]}`
+	if string(got) != want {
+		t.Fatalf("写回的数据为 %s，期望 %s", got, want)
+	}
+
+	if !data.OwnedIngameEmotion.Remove("960701") || data.OwnedIngameEmotion.IsOwned("960701") {
+		t.Fatal("移除表情失败")
+	}
+}
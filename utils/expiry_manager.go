@@ -0,0 +1,309 @@
+// utils/expiry_manager.go
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"dmmserver/game_error"
+	"dmmserver/model"
+	"dmmserver/repository"
+)
+
+// SweepResult 记录一次过期清理的结果
+type SweepResult struct {
+	Removed    int // 移除的过期物品数量
+	Unequipped int // 换回默认值的装备数量
+}
+
+// Add 累加另一次清理的结果
+func (r *SweepResult) Add(other SweepResult) {
+	r.Removed += other.Removed
+	r.Unequipped += other.Unequipped
+}
+
+// ExpiryManager 清理玩家已过期的限时物品
+// 过期的头像框、聊天气泡、表情、炫光、卡牌皮肤、卡牌样式和皮肤部件会从拥有列表中移除，
+// 正在使用的过期物品（头像框、聊天气泡、炫光配置、表情配置、卡牌的 curSkin/curStyle、角色当前皮肤）换回默认值。
+type ExpiryManager struct {
+	repo repository.PlayerRepository
+}
+
+// NewExpiryManager 创建一个新的过期清理管理器
+func NewExpiryManager() *ExpiryManager {
+	return NewExpiryManagerWithRepository(repository.Default())
+}
+
+// NewExpiryManagerWithRepository 使用指定的玩家仓库创建过期清理管理器
+func NewExpiryManagerWithRepository(repo repository.PlayerRepository) *ExpiryManager {
+	return &ExpiryManager{repo: repo}
+}
+
+// retry 以乐观锁执行过期清理的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (xm *ExpiryManager) retry(fn func(tx *ExpiryManager) error) error {
+	return repository.RetryOnConflict(xm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewExpiryManagerWithRepository(repo))
+	})
+}
+
+// SweepPlayer 清理一个玩家在 now 时已过期的物品
+// 所有修改的列在一次带版本比较的写入中保存，无法解析的列保持原样。
+func (xm *ExpiryManager) SweepPlayer(deviceID string, now time.Time) (SweepResult, error) {
+	var result SweepResult
+	err := xm.retry(func(tx *ExpiryManager) error {
+		playerData, err := tx.repo.GetByDeviceID(deviceID)
+		if err != nil {
+			return game_error.New(-3, "未找到玩家数据")
+		}
+
+		sweep := &expirySweep{player: playerData, now: now, columns: map[string]interface{}{}}
+		sweep.run()
+		result = sweep.result
+		if len(sweep.columns) == 0 {
+			return nil
+		}
+
+		if err := tx.repo.UpdateColumns(deviceID, sweep.columns); err != nil {
+			log.Printf("保存过期清理结果失败: %v", err)
+			return game_error.New(-2, "数据库更新错误")
+		}
+		return nil
+	})
+	return result, err
+}
+
+// expirySweep 在内存中清理一个玩家的过期物品，记录需要写回的列
+type expirySweep struct {
+	player  *model.PlayerData
+	now     time.Time
+	columns map[string]interface{} // 需要写回的列
+	result  SweepResult
+}
+
+func (s *expirySweep) run() {
+	s.sweepBoxes()
+	s.sweepLightness()
+	s.sweepEmotions()
+	s.sweepCardItems()
+	s.sweepSkinParts()
+}
+
+// decode 解析一列JSON数据，列为空或无法解析时返回 false
+func (s *expirySweep) decode(column string, data string, v interface{}) bool {
+	if data == "" || data == "null" {
+		return false
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		log.Printf("玩家 %s 的 %s 无法解析，跳过过期清理: %v", s.player.DeviceID, column, err)
+		return false
+	}
+	return true
+}
+
+// encode 序列化一列数据并加入待写回的列
+func (s *expirySweep) encode(column string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("玩家 %s 的 %s 序列化失败: %v", s.player.DeviceID, column, err)
+		return
+	}
+	s.columns[column] = string(data)
+}
+
+// sweepBoxes 移除过期的头像框和聊天气泡，正在使用的换回默认
+func (s *expirySweep) sweepBoxes() {
+	var boxesData BoxesData
+	if !s.decode("boxes_data", s.player.BoxesData, &boxesData) {
+		return
+	}
+	headBoxes := boxesData.OwnedHeadBoxes.RemoveExpired(s.now)
+	bubbleBoxes := boxesData.OwnedBubbleBoxes.RemoveExpired(s.now)
+	if len(headBoxes)+len(bubbleBoxes) == 0 {
+		return
+	}
+	s.result.Removed += len(headBoxes) + len(bubbleBoxes)
+	s.encode("boxes_data", boxesData)
+
+	if s.player.PublicInfo == "" || s.player.PublicInfo == "null" {
+		return
+	}
+	pm := &PublicInfoManager{}
+	publicInfo, err := pm.ParseKeyValuePublicInfo(s.player.PublicInfo)
+	if err != nil {
+		log.Printf("玩家 %s 的公开信息无法解析，跳过卸下过期装饰框: %v", s.player.DeviceID, err)
+		return
+	}
+	defaultPublicInfo := pm.GetDefaultPublicInfo()
+	unequipped := 0
+	if slices.Contains(headBoxes, publicInfo.ActiveHeadBoxID) {
+		publicInfo.ActiveHeadBoxID = defaultPublicInfo.ActiveHeadBoxID
+		unequipped++
+	}
+	if slices.Contains(bubbleBoxes, publicInfo.ActiveBubbleBoxID) {
+		publicInfo.ActiveBubbleBoxID = defaultPublicInfo.ActiveBubbleBoxID
+		unequipped++
+	}
+	if unequipped == 0 {
+		return
+	}
+	publicInfoStr, err := pm.ConvertPublicInfoToKeyValue(&publicInfo)
+	if err != nil {
+		log.Printf("玩家 %s 的公开信息转换失败: %v", s.player.DeviceID, err)
+		return
+	}
+	s.columns["public_info"] = publicInfoStr
+	s.result.Unequipped += unequipped
+}
+
+// sweepLightness 移除过期的炫光，正在使用的炫光换成不使用
+func (s *expirySweep) sweepLightness() {
+	var lightnessData LightnessData
+	if !s.decode("lightness_data", s.player.LightnessData, &lightnessData) {
+		return
+	}
+	removed := lightnessData.Owned.RemoveExpired(s.now)
+	if len(removed) == 0 {
+		return
+	}
+	s.result.Removed += len(removed)
+	if lightnessData.Config != 0 && slices.Contains(removed, lightnessData.Config) {
+		lightnessData.Config = 0
+		s.result.Unequipped++
+	}
+	s.encode("lightness_data", lightnessData)
+}
+
+// sweepEmotions 移除过期的表情，并把表情配置中引用它们的位置清空
+func (s *expirySweep) sweepEmotions() {
+	var emotionData EmotionData
+	if !s.decode("emotion_data", s.player.EmotionData, &emotionData) {
+		return
+	}
+	removed := emotionData.OwnedIngameEmotion.RemoveExpired(s.now)
+	if len(removed) == 0 {
+		return
+	}
+	s.result.Removed += len(removed)
+	for i := range emotionData.IngameEmotionConfigs {
+		config := emotionData.IngameEmotionConfigs[i].Config
+		for j, id := range config {
+			if slices.Contains(removed, EmotionID(idString(id))) {
+				config[j] = 0
+				s.result.Unequipped++
+			}
+		}
+	}
+	s.encode("emotion_data", emotionData)
+}
+
+// sweepCardItems 移除过期的卡牌皮肤和卡牌样式，卡牌正在使用的换回默认卡牌的皮肤和样式
+func (s *expirySweep) sweepCardItems() {
+	var removedSkins, removedStyles []int
+	var cardSkins CardSkins
+	if s.decode("card_skins", s.player.CardSkins, &cardSkins) {
+		if removedSkins = cardSkins.RemoveExpired(s.now); len(removedSkins) > 0 {
+			s.result.Removed += len(removedSkins)
+			s.encode("card_skins", cardSkins)
+		}
+	}
+	var cardStyles CardStyles
+	if s.decode("card_styles", s.player.CardStyles, &cardStyles) {
+		if removedStyles = cardStyles.RemoveExpired(s.now); len(removedStyles) > 0 {
+			s.result.Removed += len(removedStyles)
+			s.encode("card_styles", cardStyles)
+		}
+	}
+	if len(removedSkins)+len(removedStyles) == 0 {
+		return
+	}
+
+	var cards []Card
+	if !s.decode("cards", s.player.Cards, &cards) {
+		return
+	}
+	defaults := map[int]Card{}
+	for _, card := range (&CardManager{}).GetDefaultCards() {
+		defaults[card.ID] = card
+	}
+	unequipped := 0
+	for i := range cards {
+		if containsIDText(removedSkins, cards[i].CurSkin) {
+			cards[i].CurSkin = defaults[cards[i].ID].CurSkin
+			if cards[i].CurSkin == nil {
+				cards[i].CurSkin = 0
+			}
+			unequipped++
+		}
+		if containsIDText(removedStyles, cards[i].CurStyle) {
+			cards[i].CurStyle = defaults[cards[i].ID].CurStyle
+			if cards[i].CurStyle == nil {
+				cards[i].CurStyle = 0
+			}
+			unequipped++
+		}
+	}
+	if unequipped > 0 {
+		s.result.Unequipped += unequipped
+		s.encode("cards", cards)
+	}
+}
+
+// sweepSkinParts 移除过期的皮肤部件，穿着过期部件的角色换回默认皮肤
+func (s *expirySweep) sweepSkinParts() {
+	var skinParts []SkinPart
+	if !s.decode("owned_skins", s.player.OwnedSkins, &skinParts) {
+		return
+	}
+	owned := skinPartEntitlements(skinParts)
+	removed := owned.RemoveExpired(s.now)
+	if len(removed) == 0 {
+		return
+	}
+	kept := []SkinPart{}
+	for _, part := range skinParts {
+		if owned.IsOwned(part.SkinPartIDs) {
+			kept = append(kept, part)
+		}
+	}
+	s.result.Removed += len(removed)
+	s.encode("owned_skins", kept)
+
+	var characters []Character
+	if !s.decode("owned_characters", s.player.OwnedCharacters, &characters) {
+		return
+	}
+	cm := &CharacterManager{}
+	unequipped := 0
+	for i := range characters {
+		expired := false
+		for _, id := range characters[i].CurrentSkinInfo.SkinPartIDs {
+			if slices.Contains(removed, idString(id)) {
+				expired = true
+				break
+			}
+		}
+		if !expired {
+			continue
+		}
+		defaultSkin := cm.GetDefaultCharacter(characters[i].CharacterID).CurrentSkinInfo
+		if len(defaultSkin.SkinPartIDs) == 0 {
+			log.Printf("角色 %d 没有默认皮肤，保留玩家 %s 当前的皮肤", characters[i].CharacterID, s.player.DeviceID)
+			continue
+		}
+		characters[i].CurrentSkinInfo = defaultSkin
+		unequipped++
+	}
+	if unequipped > 0 {
+		s.result.Unequipped += unequipped
+		s.encode("owned_characters", characters)
+	}
+}
+
+// containsIDText 判断 ids 中是否包含 value，value 可以是数字或字符串
+func containsIDText(ids []int, value interface{}) bool {
+	id, err := strconv.Atoi(idString(value))
+	return err == nil && slices.Contains(ids, id)
+}
@@ -0,0 +1,222 @@
+// utils/expiry_manager_test.go
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dmmserver/model"
+	"dmmserver/repository"
+)
+
+// 过期清理把正在使用的过期物品换回默认值，未过期的装备保持不变
+func TestSweepPlayerUnequipsExpiredItems(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expired := int(now.Unix()) - 1
+	valid := int(now.Unix()) + 3600
+
+	publicInfo := func(headBoxID, bubbleBoxID int) string {
+		pm := &PublicInfoManager{}
+		info := pm.GetDefaultPublicInfo()
+		info.ActiveHeadBoxID = headBoxID
+		info.ActiveBubbleBoxID = bubbleBoxID
+		data, err := pm.ConvertPublicInfoToKeyValue(&info)
+		if err != nil {
+			t.Fatalf("转换公开信息失败: %v", err)
+		}
+		return data
+	}
+	boxes := func(headBoxExpiredTime, bubbleBoxExpiredTime int) string {
+		return jsonString(t, BoxesData{
+			OwnedHeadBoxes:   NewEntitlements([]int{900001, 900002}, []int{0, headBoxExpiredTime}),
+			OwnedBubbleBoxes: NewEntitlements([]int{910001, 910002}, []int{0, bubbleBoxExpiredTime}),
+		})
+	}
+	activeBoxes := func(t *testing.T, player *model.PlayerData) (int, int) {
+		info, err := (&PublicInfoManager{}).ParseKeyValuePublicInfo(player.PublicInfo)
+		if err != nil {
+			t.Fatalf("解析公开信息失败: %v", err)
+		}
+		return info.ActiveHeadBoxID, info.ActiveBubbleBoxID
+	}
+	cards := func(t *testing.T, player *model.PlayerData) map[int]Card {
+		var list []Card
+		if err := json.Unmarshal([]byte(player.Cards), &list); err != nil {
+			t.Fatalf("解析卡牌失败: %v", err)
+		}
+		result := map[int]Card{}
+		for _, card := range list {
+			result[card.ID] = card
+		}
+		return result
+	}
+
+	tests := []struct {
+		name           string
+		player         model.PlayerData
+		wantRemoved    int
+		wantUnequipped int
+		check          func(t *testing.T, player *model.PlayerData)
+	}{
+		{
+			name:           "过期的头像框和聊天气泡换回默认",
+			player:         model.PlayerData{BoxesData: boxes(expired, expired), PublicInfo: publicInfo(900002, 910002)},
+			wantRemoved:    2,
+			wantUnequipped: 2,
+			check: func(t *testing.T, player *model.PlayerData) {
+				if headBoxID, bubbleBoxID := activeBoxes(t, player); headBoxID != 900001 || bubbleBoxID != 910001 {
+					t.Fatalf("装饰框为 %d/%d，期望 900001/910001", headBoxID, bubbleBoxID)
+				}
+			},
+		},
+		{
+			name:           "未使用的过期头像框只移除",
+			player:         model.PlayerData{BoxesData: boxes(expired, valid), PublicInfo: publicInfo(900001, 910002)},
+			wantRemoved:    1,
+			wantUnequipped: 0,
+			check: func(t *testing.T, player *model.PlayerData) {
+				if headBoxID, bubbleBoxID := activeBoxes(t, player); headBoxID != 900001 || bubbleBoxID != 910002 {
+					t.Fatalf("装饰框为 %d/%d，期望 900001/910002", headBoxID, bubbleBoxID)
+				}
+			},
+		},
+		{
+			name: "过期的炫光配置改为不使用",
+			player: model.PlayerData{LightnessData: jsonString(t, LightnessData{
+				Owned:  NewEntitlements([]int{1001, 1002}, []int{expired, valid}),
+				Config: 1001,
+			})},
+			wantRemoved:    1,
+			wantUnequipped: 1,
+			check: func(t *testing.T, player *model.PlayerData) {
+				var lightness LightnessData
+				if err := json.Unmarshal([]byte(player.LightnessData), &lightness); err != nil {
+					t.Fatalf("解析炫光失败: %v", err)
+				}
+				if lightness.Config != 0 || lightness.Owned.IsOwned(1001) || !lightness.Owned.IsOwned(1002) {
+					t.Fatalf("炫光数据为 %s", player.LightnessData)
+				}
+			},
+		},
+		{
+			name: "未使用的过期炫光保留配置",
+			player: model.PlayerData{LightnessData: jsonString(t, LightnessData{
+				Owned:  NewEntitlements([]int{1001, 1002}, []int{expired, valid}),
+				Config: 1002,
+			})},
+			wantRemoved:    1,
+			wantUnequipped: 0,
+			check: func(t *testing.T, player *model.PlayerData) {
+				var lightness LightnessData
+				if err := json.Unmarshal([]byte(player.LightnessData), &lightness); err != nil {
+					t.Fatalf("解析炫光失败: %v", err)
+				}
+				if lightness.Config != 1002 {
+					t.Fatalf("炫光配置为 %d，期望 1002", lightness.Config)
+				}
+			},
+		},
+		{
+			name: "表情配置中的过期表情清空",
+			player: model.PlayerData{EmotionData: `{"ownedIngameEmotion":{"id":[950001,"960701",960801],"expiredTime":[0,` +
+				jsonString(t, expired) + `,` + jsonString(t, valid) + `]},` +
+				`"ingameEmotionConfigs":[{"character":100,"config":["950001","960701",960701,960801,0,0]},{"character":200,"config":[950001,0,0,0,0,0]}]}`},
+			wantRemoved:    1,
+			wantUnequipped: 2,
+			check: func(t *testing.T, player *model.PlayerData) {
+				var emotion EmotionData
+				if err := json.Unmarshal([]byte(player.EmotionData), &emotion); err != nil {
+					t.Fatalf("解析表情失败: %v", err)
+				}
+				want := []string{"950001", "0", "0", "960801", "0", "0"}
+				for i, id := range emotion.IngameEmotionConfigs[0].Config {
+					if idString(id) != want[i] {
+						t.Fatalf("角色 100 的表情配置为 %v，期望 %v", emotion.IngameEmotionConfigs[0].Config, want)
+					}
+				}
+				if emotion.OwnedIngameEmotion.IsOwned("960701") {
+					t.Fatal("过期表情没有移除")
+				}
+			},
+		},
+		{
+			name: "过期的卡牌皮肤和样式换回默认卡牌的值",
+			player: model.PlayerData{
+				CardSkins:  jsonString(t, CardSkins{NewEntitlements([]int{600001, 600002}, []int{expired, valid})}),
+				CardStyles: jsonString(t, CardStyles{NewEntitlements([]int{650001}, []int{expired})}),
+				Cards: jsonString(t, []Card{
+					{ID: 100, Level: 3, CurSkin: 600001, CurStyle: "650001"},
+					{ID: 101, Level: 2, CurSkin: 600002, CurStyle: 650001},
+					{ID: 999, Level: 1, CurSkin: 600001, CurStyle: 0},
+				}),
+			},
+			wantRemoved:    2,
+			wantUnequipped: 4,
+			check: func(t *testing.T, player *model.PlayerData) {
+				got := cards(t, player)
+				tests := []struct {
+					id                int
+					curSkin, curStyle string
+				}{
+					{100, "50001", "650061"},
+					{101, "600002", "0"},
+					{999, "0", "0"},
+				}
+				for _, tt := range tests {
+					card := got[tt.id]
+					if idString(card.CurSkin) != tt.curSkin || idString(card.CurStyle) != tt.curStyle {
+						t.Fatalf("卡牌 %d 的皮肤和样式为 %v/%v，期望 %s/%s", tt.id, card.CurSkin, card.CurStyle, tt.curSkin, tt.curStyle)
+					}
+				}
+				if got[100].Level != 3 {
+					t.Fatalf("卡牌 100 的等级被修改为 %d", got[100].Level)
+				}
+			},
+		},
+		{
+			name:           "没有过期物品时不写回",
+			player:         model.PlayerData{BoxesData: boxes(valid, valid), PublicInfo: publicInfo(900002, 910002)},
+			wantRemoved:    0,
+			wantUnequipped: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			player := tt.player
+			player.DeviceID = "device-sweep"
+			player.RoleID = 1000
+			repo := repository.NewMemoryPlayerRepository(player)
+
+			result, err := NewExpiryManagerWithRepository(repo).SweepPlayer(player.DeviceID, now)
+			if err != nil {
+				t.Fatalf("过期清理失败: %v", err)
+			}
+			if result.Removed != tt.wantRemoved || result.Unequipped != tt.wantUnequipped {
+				t.Fatalf("移除 %d 个、卸下 %d 个，期望移除 %d 个、卸下 %d 个",
+					result.Removed, result.Unequipped, tt.wantRemoved, tt.wantUnequipped)
+			}
+			after, err := repo.GetByDeviceID(player.DeviceID)
+			if err != nil {
+				t.Fatalf("读取玩家失败: %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, after)
+			}
+			if tt.wantRemoved == 0 && after.Version != player.Version {
+				t.Fatalf("没有过期物品时版本号从 %d 变为 %d", player.Version, after.Version)
+			}
+		})
+	}
+}
+
+// jsonString 把 v 序列化为JSON文本
+func jsonString(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	return string(data)
+}
@@ -5,6 +5,7 @@ import (
 	"dmmserver/db"
 	"dmmserver/game_error"
 	"dmmserver/model"
+	"dmmserver/repository"
 //	"fmt"
 	"log"
 
@@ -36,11 +37,10 @@ func RecordClientIP(c *gin.Context, deviceID string) error {
 	}
 	
 	// 首先查询dmm_playerdata获取roleID，作为基准数据
-	var playerData model.PlayerData
-	pdResult := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if pdResult.Error != nil {
+	playerData, err := repository.Default().GetByDeviceID(deviceID)
+	if err != nil {
 		log.Printf("未找到 deviceID 为 '%s' 的玩家数据，无法记录IP", deviceID)
-		return pdResult.Error
+		return err
 	}
 	
 	// 查找PlayerInfo记录
@@ -75,11 +75,10 @@ func RecordClientIP(c *gin.Context, deviceID string) error {
 		}
 	} else {
 		// 如果记录存在，需要验证roleID是否与dmm_playerdata表中的一致
-		var playerData model.PlayerData
-		pdResult := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-		if pdResult.Error != nil {
+		playerData, err := repository.Default().GetByDeviceID(deviceID)
+		if err != nil {
 			log.Printf("未找到 deviceID 为 '%s' 的玩家数据，无法验证roleID", deviceID)
-			return pdResult.Error
+			return err
 		}
 		
 		// 验证roleID是否匹配
@@ -89,7 +88,7 @@ func RecordClientIP(c *gin.Context, deviceID string) error {
 		}
 		
 		// 如果roleID匹配，添加新的IP到历史记录中
-		err := playerInfo.AddIP(ip)
+		err = playerInfo.AddIP(ip)
 		if err != nil {
 			log.Printf("添加IP到历史记录失败: %v", err)
 			return err
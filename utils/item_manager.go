@@ -0,0 +1,340 @@
+// utils/item_manager.go
+package utils
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"dmmserver/game_error"
+	"dmmserver/repository"
+)
+
+// ExpiryPermanent 作为 GrantItem 的 expiredTime 传入时表示永久，忽略物品的默认有效期
+const ExpiryPermanent = -1
+
+// ErrOwnedPermanently 表示玩家已经永久拥有该物品，不能再次获得
+var ErrOwnedPermanently = errors.New("item is owned permanently")
+
+// ErrCurrencyItem 表示物品是货币，保存在钱包中，需要由 services/wallet 在调用方的事务中增减
+var ErrCurrencyItem = errors.New("currency items are stored in the wallet")
+
+// ItemManager 按物品注册表把任意物品ID的发放和回收交给对应分区的管理器
+type ItemManager struct {
+	repo repository.PlayerRepository
+}
+
+// NewItemManager 创建一个新的物品管理器
+func NewItemManager() *ItemManager {
+	return NewItemManagerWithRepository(repository.Default())
+}
+
+// NewItemManagerWithRepository 使用指定的玩家仓库创建物品管理器
+func NewItemManagerWithRepository(repo repository.PlayerRepository) *ItemManager {
+	return &ItemManager{repo: repo}
+}
+
+// Resolve 解析物品ID，未在 configs/items.json 中登记的ID返回 -54
+func (im *ItemManager) Resolve(itemID int) (*ItemInfo, error) {
+	info, ok := Items().Resolve(itemID)
+	if !ok {
+		log.Printf("未知的物品ID: %d", itemID)
+		return nil, game_error.New(-54, "未知的物品ID")
+	}
+	return info, nil
+}
+
+// GrantItem 以当前时间发放一个物品，详见 GrantItemAt
+func (im *ItemManager) GrantItem(deviceID string, itemID int, count int, expiredTime int) error {
+	return im.GrantItemAt(deviceID, itemID, count, expiredTime, time.Now())
+}
+
+// GrantItemAt 在时刻 now 发放一个物品
+// count 只对资产和卡牌碎片有效，必须大于0。
+// expiredTime 为过期时间（Unix 秒）：0 表示使用物品的默认有效期，ExpiryPermanent 表示永久。
+// 其他类型已拥有时不会缩短有效期：永久拥有的物品保持永久，永久发放时改为永久，
+// 限时发放时把本次的有效期加在 max(now, 原过期时间) 上。
+// 货币保存在钱包中，返回 ErrCurrencyItem，调用方应改用 services/wallet。
+func (im *ItemManager) GrantItemAt(deviceID string, itemID int, count int, expiredTime int, now time.Time) error {
+	info, err := im.Resolve(itemID)
+	if err != nil {
+		return err
+	}
+	expiredTime = info.expiredTimeFor(expiredTime, now)
+	if info.Category.Stackable() {
+		return im.grant(deviceID, info, count, expiredTime)
+	}
+	// 读取原过期时间、计算续期和写回在同一次读-改-写中完成，冲突重试时按最新的过期时间重新计算，
+	// 并发的续期不会用读到的旧值覆盖彼此的延长
+	return im.retry(func(tx *ItemManager) error {
+		current, owned, err := tx.OwnedExpiredTime(deviceID, itemID)
+		if err != nil {
+			return err
+		}
+		return tx.grant(deviceID, info, count, renewExpiredTime(current, owned, expiredTime, now))
+	})
+}
+
+// retry 以乐观锁执行一次发放的读-改-写，分区管理器的读写都加入这一次重试
+func (im *ItemManager) retry(fn func(tx *ItemManager) error) error {
+	return repository.RetryOnConflict(im.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewItemManagerWithRepository(repo))
+	})
+}
+
+// grant 把物品交给对应分区的管理器，非叠加物品的 expiredTime 是续期后要保存的过期时间（0 表示永久）
+func (im *ItemManager) grant(deviceID string, info *ItemInfo, count int, expiredTime int) error {
+	itemID, id := info.ItemID, info.NativeID
+
+	switch info.Category {
+	case ItemCategoryAsset:
+		if count <= 0 {
+			log.Printf("资产 %d 的发放数量无效: %d", itemID, count)
+			return game_error.New(-13, "非法参数")
+		}
+		return NewAssetsManagerWithRepository(im.repo).AddAsset(deviceID, id, count)
+	case ItemCategoryCardPiece:
+		if count <= 0 {
+			log.Printf("卡牌碎片 %d 的发放数量无效: %d", itemID, count)
+			return game_error.New(-13, "非法参数")
+		}
+		return NewCardManagerWithRepository(im.repo).AddCardPieces(deviceID, id, count)
+	case ItemCategoryCharacter:
+		return im.grantCharacter(deviceID, id, expiredTime)
+	case ItemCategorySkinPart:
+		return im.grantSkinPart(deviceID, strconv.Itoa(id), expiredTime)
+	case ItemCategoryCardSkin:
+		return NewCardSkinManagerWithRepository(im.repo).AddCardSkin(deviceID, id, expiredTime)
+	case ItemCategoryCardStyle:
+		return NewCardStyleManagerWithRepository(im.repo).AddCardStyle(deviceID, id, expiredTime)
+	case ItemCategoryHeadBox:
+		return NewBoxesManagerWithRepository(im.repo).AddHeadBox(deviceID, id, expiredTime)
+	case ItemCategoryBubbleBox:
+		return NewBoxesManagerWithRepository(im.repo).AddBubbleBox(deviceID, id, expiredTime)
+	case ItemCategoryEmotion:
+		return NewEmotionManagerWithRepository(im.repo).AddOwnedEmotion(deviceID, id, expiredTime)
+	case ItemCategoryLightness:
+		return NewLightnessManagerWithRepository(im.repo).AddLightness(deviceID, id, expiredTime)
+	case ItemCategoryCurrency:
+		log.Printf("货币 %d 不能由物品管理器发放, deviceID=%s", itemID, deviceID)
+		return ErrCurrencyItem
+	}
+	return game_error.New(-54, "未知的物品类型")
+}
+
+// RevokeItem 回收一个物品，count 只对资产和卡牌碎片有效，资产数量不足时返回 -258，碎片不足时返回 -52
+// 货币保存在钱包中，返回 ErrCurrencyItem。
+func (im *ItemManager) RevokeItem(deviceID string, itemID int, count int) error {
+	info, err := im.Resolve(itemID)
+	if err != nil {
+		return err
+	}
+	id := info.NativeID
+
+	switch info.Category {
+	case ItemCategoryAsset:
+		if count <= 0 {
+			log.Printf("资产 %d 的回收数量无效: %d", itemID, count)
+			return game_error.New(-13, "非法参数")
+		}
+		return NewAssetsManagerWithRepository(im.repo).ConsumeAsset(deviceID, id, count)
+	case ItemCategoryCardPiece:
+		if count <= 0 {
+			log.Printf("卡牌碎片 %d 的回收数量无效: %d", itemID, count)
+			return game_error.New(-13, "非法参数")
+		}
+		return NewCardManagerWithRepository(im.repo).ConsumeCardPieces(deviceID, id, count)
+	case ItemCategoryCharacter:
+		return NewCharacterManagerWithRepository(im.repo).DeleteCharacter(deviceID, id)
+	case ItemCategorySkinPart:
+		return NewSkinPartManagerWithRepository(im.repo).RemoveSkinPart(deviceID, strconv.Itoa(id))
+	case ItemCategoryCardSkin:
+		return NewCardSkinManagerWithRepository(im.repo).RemoveCardSkin(deviceID, id)
+	case ItemCategoryCardStyle:
+		return NewCardStyleManagerWithRepository(im.repo).RemoveCardStyle(deviceID, id)
+	case ItemCategoryHeadBox:
+		return NewBoxesManagerWithRepository(im.repo).RemoveHeadBox(deviceID, id)
+	case ItemCategoryBubbleBox:
+		return NewBoxesManagerWithRepository(im.repo).RemoveBubbleBox(deviceID, id)
+	case ItemCategoryEmotion:
+		return NewEmotionManagerWithRepository(im.repo).RemoveOwnedEmotion(deviceID, id)
+	case ItemCategoryLightness:
+		return NewLightnessManagerWithRepository(im.repo).RemoveLightness(deviceID, id)
+	case ItemCategoryCurrency:
+		log.Printf("货币 %d 不能由物品管理器回收, deviceID=%s", itemID, deviceID)
+		return ErrCurrencyItem
+	}
+	return game_error.New(-54, "未知的物品类型")
+}
+
+// OwnedExpiredTime 返回玩家是否拥有一个非资产类物品及其过期时间（0 表示永久）
+// 不检查是否已经过期；资产、卡牌碎片和货币没有过期时间，请使用 AssetsManager.GetAssetCount 和 CardManager.GetCardPieceCount。
+func (im *ItemManager) OwnedExpiredTime(deviceID string, itemID int) (int, bool, error) {
+	info, err := im.Resolve(itemID)
+	if err != nil {
+		return 0, false, err
+	}
+	id := info.NativeID
+
+	switch info.Category {
+	case ItemCategoryCharacter:
+		characters, err := NewCharacterManagerWithRepository(im.repo).GetCharacters(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, character := range characters {
+			if character.CharacterID == id {
+				return character.ExpiredTime, true, nil
+			}
+		}
+		return 0, false, nil
+	case ItemCategorySkinPart:
+		skinParts, err := NewSkinPartManagerWithRepository(im.repo).GetSkinParts(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		expiredTime, owned := skinPartEntitlements(skinParts).ExpiredTime(strconv.Itoa(id))
+		return expiredTime, owned, nil
+	case ItemCategoryCardSkin:
+		cardSkins, err := NewCardSkinManagerWithRepository(im.repo).GetCardSkins(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		expiredTime, owned := cardSkins.ExpiredTime(id)
+		return expiredTime, owned, nil
+	case ItemCategoryCardStyle:
+		cardStyles, err := NewCardStyleManagerWithRepository(im.repo).GetCardStyles(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		expiredTime, owned := cardStyles.ExpiredTime(id)
+		return expiredTime, owned, nil
+	case ItemCategoryHeadBox, ItemCategoryBubbleBox:
+		boxesData, err := NewBoxesManagerWithRepository(im.repo).GetBoxesData(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		boxes := boxesData.OwnedHeadBoxes
+		if info.Category == ItemCategoryBubbleBox {
+			boxes = boxesData.OwnedBubbleBoxes
+		}
+		expiredTime, owned := boxes.ExpiredTime(id)
+		return expiredTime, owned, nil
+	case ItemCategoryEmotion:
+		emotionData, err := NewEmotionManagerWithRepository(im.repo).GetEmotionData(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		expiredTime, owned := emotionData.OwnedIngameEmotion.ExpiredTime(EmotionID(strconv.Itoa(id)))
+		return expiredTime, owned, nil
+	case ItemCategoryLightness:
+		lightnessData, err := NewLightnessManagerWithRepository(im.repo).GetLightnessData(deviceID)
+		if err != nil {
+			return 0, false, err
+		}
+		expiredTime, owned := lightnessData.Owned.ExpiredTime(id)
+		return expiredTime, owned, nil
+	case ItemCategoryAsset, ItemCategoryCardPiece, ItemCategoryCurrency:
+		return 0, false, game_error.New(-13, "非法参数")
+	}
+	return 0, false, game_error.New(-54, "未知的物品类型")
+}
+
+// RenewalExpiredTime 计算玩家在时刻 now 再获得一次非叠加物品后的过期时间
+// durationSeconds 为本次获得的有效期，0 表示永久：已拥有且未过期的租借物品在剩余有效期上延长，
+// 永久获得时把租借中的物品改为永久；已经永久拥有时返回 ErrOwnedPermanently。
+// 第一个返回值用作 GrantItemAt 的 expiredTime 参数（永久时为 ExpiryPermanent），第二个返回值为实际保存的过期时间（0 表示永久）。
+func (im *ItemManager) RenewalExpiredTime(deviceID string, itemID int, durationSeconds int64, now time.Time) (int, int, error) {
+	current, owned, err := im.OwnedExpiredTime(deviceID, itemID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if owned && current == 0 {
+		return 0, 0, ErrOwnedPermanently
+	}
+	if durationSeconds == 0 {
+		return ExpiryPermanent, 0, nil
+	}
+	grantExpiredTime := int(now.Unix() + durationSeconds)
+	return grantExpiredTime, renewExpiredTime(current, owned, grantExpiredTime, now), nil
+}
+
+// renewExpiredTime 计算在时刻 now 以过期时间 expiredTime 再获得一次物品后保存的过期时间（0 表示永久）
+// 未拥有时直接使用 expiredTime；已永久拥有或本次为永久时为永久；
+// 否则把本次的有效期 expiredTime - now 加在 max(now, current) 上，已经过期的 expiredTime 不改变原来的过期时间。
+func renewExpiredTime(current int, owned bool, expiredTime int, now time.Time) int {
+	if !owned {
+		return expiredTime
+	}
+	if current == 0 || expiredTime == 0 {
+		return 0
+	}
+	start := int(now.Unix())
+	seconds := expiredTime - start
+	if seconds <= 0 {
+		return current
+	}
+	if current > start {
+		start = current
+	}
+	return start + seconds
+}
+
+// expiredTimeFor 把 GrantItemAt 的 expiredTime 参数转换为本次获得的过期时间（0 表示永久）
+func (info *ItemInfo) expiredTimeFor(expiredTime int, now time.Time) int {
+	switch {
+	case expiredTime == ExpiryPermanent:
+		return 0
+	case expiredTime > 0:
+		return expiredTime
+	case info.DefaultDurationSeconds > 0:
+		return int(now.Unix() + info.DefaultDurationSeconds)
+	default:
+		return 0
+	}
+}
+
+// grantCharacter 新增角色，已拥有时只更新过期时间（由 GrantItemAt 在同一次重试中计算好续期后的值）
+func (im *ItemManager) grantCharacter(deviceID string, characterID int, expiredTime int) error {
+	cm := NewCharacterManagerWithRepository(im.repo)
+	return cm.retry(func(tx *CharacterManager) error {
+		characters, err := tx.GetCharacters(deviceID)
+		if err != nil {
+			return err
+		}
+		for i := range characters {
+			if characters[i].CharacterID == characterID {
+				characters[i].ExpiredTime = expiredTime
+				return tx.SaveCharacters(deviceID, characters)
+			}
+		}
+		character := tx.GetDefaultCharacter(characterID)
+		character.ExpiredTime = expiredTime
+		return tx.SaveCharacters(deviceID, append(characters, character))
+	})
+}
+
+// grantSkinPart 新增皮肤部件，已拥有时只更新过期时间（由 GrantItemAt 在同一次重试中计算好续期后的值），保留玩家选择的颜色和贴花
+func (im *ItemManager) grantSkinPart(deviceID string, skinPartID string, expiredTime int) error {
+	sm := NewSkinPartManagerWithRepository(im.repo)
+	return sm.retry(func(tx *SkinPartManager) error {
+		skinParts, err := tx.GetSkinParts(deviceID)
+		if err != nil {
+			return err
+		}
+		for i := range skinParts {
+			if skinParts[i].SkinPartIDs == skinPartID {
+				skinParts[i].ExpiredTime = expiredTime
+				return tx.SaveSkinParts(deviceID, skinParts)
+			}
+		}
+		skinParts = append(skinParts, SkinPart{
+			SkinPartIDs:    skinPartID,
+			SkinPartColors: "0",
+			ExpiredTime:    expiredTime,
+			SkinDecals:     0,
+		})
+		return tx.SaveSkinParts(deviceID, skinParts)
+	})
+}
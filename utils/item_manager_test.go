@@ -0,0 +1,285 @@
+// utils/item_manager_test.go
+package utils
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"dmmserver/db"
+	"dmmserver/db/migrations"
+	"dmmserver/model"
+	"dmmserver/repository"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupPlayer 创建使用独立 SQLite 数据库的测试环境，并按新账号的默认数据创建一个玩家
+func setupPlayer(t *testing.T, deviceID string) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "utils.db") + "?_busy_timeout=5000&_txlock=immediate"
+	d, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := migrations.Up(d); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	oldDB := db.DB
+	db.DB = d
+	t.Cleanup(func() {
+		db.DB = oldDB
+		if sqlDB, err := d.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	registry, err := LoadItemRegistry("../configs/items.json")
+	if err != nil {
+		t.Fatalf("加载物品注册表失败: %v", err)
+	}
+	SetItemRegistry(registry)
+
+	players := &repository.GormPlayerRepository{}
+	assetsJSON, _ := json.Marshal(NewAssetsManagerWithRepository(players).GetDefaultAssetsData())
+	boxesJSON, _ := json.Marshal(NewBoxesManagerWithRepository(players).GetDefaultBoxesData())
+	player := model.PlayerData{
+		DeviceID:   deviceID,
+		RoleID:     1000,
+		AssetsData: string(assetsJSON),
+		BoxesData:  string(boxesJSON),
+		CardPieces: "[]",
+	}
+	if err := players.Create(&player); err != nil {
+		t.Fatalf("创建玩家失败: %v", err)
+	}
+}
+
+// newRequest 创建一个带请求级工作单元的 gin.Context
+func newRequest() (*gin.Context, *repository.UnitOfWork) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return c, repository.Attach(c, &repository.GormPlayerRepository{})
+}
+
+// 两个请求同时读取同一玩家后各自发放物品，两次发放都必须生效，
+// 后提交的请求之前尚未提交的修改（不检查版本号的写入）也要保留。
+func TestGrantItemConcurrentRequests(t *testing.T) {
+	const deviceID = "device-race"
+	setupPlayer(t, deviceID)
+	before, err := NewAssetsManager().GetAssets(deviceID)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	initial := 0
+	for _, asset := range before {
+		if asset.ItemID == 30 {
+			initial = asset.ItemCount
+		}
+	}
+
+	grants := []struct {
+		pending map[string]interface{}
+		items   [][3]int // itemID, count, expiredTime
+	}{
+		{map[string]interface{}{"pf_id": 7}, [][3]int{{30, 5, 0}}},
+		{nil, [][3]int{{30, 7, 0}, {900002, 0, 0}}},
+	}
+
+	var loaded, done sync.WaitGroup
+	loaded.Add(len(grants))
+	errs := make([]error, len(grants))
+	for i, grant := range grants {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			_, uow := newRequest()
+			// 模拟会话校验：两个请求都先读取玩家，再开始发放
+			if _, err := uow.GetByDeviceID(deviceID); err != nil {
+				errs[i] = err
+				loaded.Done()
+				return
+			}
+			if grant.pending != nil {
+				if err := uow.UpdateColumns(deviceID, grant.pending); err != nil {
+					errs[i] = err
+					loaded.Done()
+					return
+				}
+			}
+			loaded.Done()
+			loaded.Wait()
+
+			im := NewItemManagerWithRepository(uow)
+			for _, item := range grant.items {
+				if err := im.GrantItem(deviceID, item[0], item[1], item[2]); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			errs[i] = uow.Commit()
+		}(i)
+	}
+	done.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("第 %d 个请求失败: %v", i+1, err)
+		}
+	}
+
+	after, err := NewAssetsManager().GetAssets(deviceID)
+	if err != nil {
+		t.Fatalf("读取资产失败: %v", err)
+	}
+	for _, asset := range after {
+		if asset.ItemID == 30 && asset.ItemCount != initial+12 {
+			t.Fatalf("资产 30 的数量为 %d，期望 %d", asset.ItemCount, initial+12)
+		}
+	}
+	boxes, err := NewBoxesManager().GetBoxesData(deviceID)
+	if err != nil {
+		t.Fatalf("读取装饰框失败: %v", err)
+	}
+	if !boxes.OwnedHeadBoxes.IsOwned(900002) {
+		t.Fatal("并发发放的头像框没有生效")
+	}
+	player, err := repository.Default().GetByDeviceID(deviceID)
+	if err != nil {
+		t.Fatalf("读取玩家失败: %v", err)
+	}
+	if player.PfID != 7 {
+		t.Fatalf("发放前尚未提交的修改丢失: pf_id=%d", player.PfID)
+	}
+}
+
+// 多个请求在都读取了玩家之后同时续期同一个物品，每一次续期都在前一次的基础上延长
+func TestGrantItemConcurrentRenewals(t *testing.T) {
+	const deviceID = "device-renew-race"
+	now := time.Unix(1700000000, 0)
+	initial := int(now.Unix()) + 600
+	setupPlayer(t, deviceID)
+	items := []int{900002, 202}
+	for _, itemID := range items {
+		if err := NewItemManager().GrantItemAt(deviceID, itemID, 1, initial, now); err != nil {
+			t.Fatalf("首次发放失败: %v", err)
+		}
+	}
+
+	const n = 3
+	var loaded, done sync.WaitGroup
+	loaded.Add(n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			_, uow := newRequest()
+			// 所有请求都先读到同一个过期时间，再开始续期
+			_, err := uow.GetByDeviceID(deviceID)
+			loaded.Done()
+			loaded.Wait()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			im := NewItemManagerWithRepository(uow)
+			for _, itemID := range items {
+				if err := im.GrantItemAt(deviceID, itemID, 1, int(now.Unix())+3600, now); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			errs[i] = uow.Commit()
+		}(i)
+	}
+	done.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("第 %d 个请求失败: %v", i+1, err)
+		}
+	}
+
+	for _, itemID := range items {
+		got, _, err := NewItemManager().OwnedExpiredTime(deviceID, itemID)
+		if err != nil {
+			t.Fatalf("读取过期时间失败: %v", err)
+		}
+		if want := initial + n*3600; got != want {
+			t.Fatalf("物品 %d 的过期时间为 %d，期望 %d", itemID, got, want)
+		}
+	}
+}
+
+// 再次获得已拥有的非叠加物品时不能缩短有效期：永久物品保持永久，限时获得在剩余有效期上延长
+func TestGrantItemRenewsOwnedItems(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	at := func(seconds int) int { return int(now.Unix()) + seconds }
+
+	tests := []struct {
+		name    string
+		itemID  int
+		initial int // 首次发放的 expiredTime
+		grant   int // 再次发放的 expiredTime
+		want    int
+	}{
+		{"永久头像框限时发放保持永久", 900002, ExpiryPermanent, at(3600), 0},
+		{"租借头像框限时发放延长", 900003, at(600), at(3600), at(4200)},
+		{"已过期头像框从现在开始计算", 900004, at(-600), at(3600), at(3600)},
+		{"租借头像框永久发放改为永久", 900005, at(600), ExpiryPermanent, 0},
+		{"租借头像框发放已过期的时间不变", 900006, at(600), at(-60), at(600)},
+		{"永久角色限时发放保持永久", 201, ExpiryPermanent, at(3600), 0},
+		{"租借角色限时发放延长", 202, at(600), at(3600), at(4200)},
+	}
+
+	setupPlayer(t, "device-renew")
+	im := NewItemManager()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := im.GrantItemAt("device-renew", tt.itemID, 1, tt.initial, now); err != nil {
+				t.Fatalf("首次发放失败: %v", err)
+			}
+			if err := im.GrantItemAt("device-renew", tt.itemID, 1, tt.grant, now); err != nil {
+				t.Fatalf("再次发放失败: %v", err)
+			}
+			got, owned, err := im.OwnedExpiredTime("device-renew", tt.itemID)
+			if err != nil || !owned {
+				t.Fatalf("读取过期时间失败: owned=%v, err=%v", owned, err)
+			}
+			if got != tt.want {
+				t.Fatalf("过期时间为 %d，期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// 商店和合成先用 RenewalExpiredTime 计算保存的过期时间再发放，发放时不能再延长一次
+func TestRenewalExpiredTimeMatchesGrant(t *testing.T) {
+	const deviceID = "device-renewal"
+	now := time.Unix(1700000000, 0)
+	setupPlayer(t, deviceID)
+	im := NewItemManager()
+	if err := im.GrantItemAt(deviceID, 900002, 1, int(now.Unix())+600, now); err != nil {
+		t.Fatalf("首次发放失败: %v", err)
+	}
+
+	grantExpiredTime, saved, err := im.RenewalExpiredTime(deviceID, 900002, 3600, now)
+	if err != nil {
+		t.Fatalf("计算续期失败: %v", err)
+	}
+	if err := im.GrantItemAt(deviceID, 900002, 1, grantExpiredTime, now); err != nil {
+		t.Fatalf("续期发放失败: %v", err)
+	}
+	got, _, err := im.OwnedExpiredTime(deviceID, 900002)
+	if err != nil {
+		t.Fatalf("读取过期时间失败: %v", err)
+	}
+	if want := int(now.Unix()) + 4200; saved != want || got != want {
+		t.Fatalf("保存的过期时间为 %d，计算结果为 %d，期望 %d", got, saved, want)
+	}
+}
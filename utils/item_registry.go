@@ -0,0 +1,227 @@
+// utils/item_registry.go
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// itemRegistryPath 物品注册表数据文件的路径
+const itemRegistryPath = "configs/items.json"
+
+// ItemCategory 物品所属的玩家数据分区
+type ItemCategory string
+
+const (
+	ItemCategoryAsset     ItemCategory = "asset"     // 资产（道具、货币），存放在 assets_data
+	ItemCategoryCharacter ItemCategory = "character" // 角色，存放在 owned_characters
+	ItemCategorySkinPart  ItemCategory = "skinPart"  // 皮肤部件，存放在 owned_skins（ID 以字符串保存）
+	ItemCategoryCardSkin  ItemCategory = "cardSkin"  // 卡牌皮肤，存放在 card_skins
+	ItemCategoryCardStyle ItemCategory = "cardStyle" // 卡牌样式，存放在 card_styles
+	ItemCategoryHeadBox   ItemCategory = "headBox"   // 头像框，存放在 boxes_data
+	ItemCategoryBubbleBox ItemCategory = "bubbleBox" // 聊天框，存放在 boxes_data
+	ItemCategoryEmotion   ItemCategory = "emotion"   // 表情，存放在 emotion_data
+	ItemCategoryLightness ItemCategory = "lightness" // 炫光，存放在 lightness_data
+	ItemCategoryCardPiece ItemCategory = "cardPiece" // 卡牌碎片，存放在 card_pieces（原始ID为卡牌ID）
+	ItemCategoryCurrency  ItemCategory = "currency"  // 货币，存放在 dmm_wallets，只能通过 services/wallet 在事务中增减
+)
+
+// Stackable 判断该分区的物品是否按数量发放（资产、卡牌碎片、货币），其他分区的物品只有拥有与否和过期时间
+func (c ItemCategory) Stackable() bool {
+	return c == ItemCategoryAsset || c == ItemCategoryCardPiece || c == ItemCategoryCurrency
+}
+
+// itemCategoryManagers 每个分区由哪个管理器负责读写
+var itemCategoryManagers = map[ItemCategory]string{
+	ItemCategoryAsset:     "AssetsManager",
+	ItemCategoryCharacter: "CharacterManager",
+	ItemCategorySkinPart:  "SkinPartManager",
+	ItemCategoryCardSkin:  "CardSkinManager",
+	ItemCategoryCardStyle: "CardStyleManager",
+	ItemCategoryHeadBox:   "BoxesManager",
+	ItemCategoryBubbleBox: "BoxesManager",
+	ItemCategoryEmotion:   "EmotionManager",
+	ItemCategoryLightness: "LightnessManager",
+	ItemCategoryCardPiece: "CardManager",
+	ItemCategoryCurrency:  "wallet.Service",
+}
+
+// ItemRange 一段连续的物品ID及其所属分区
+// 不同分区的原始ID可能重叠（如炫光 1001 与皮肤部件 "1001"），这时给其中一个分区配置 Offset，
+// 统一物品ID = Offset + 原始ID，例如 offset=8000000 时炫光 1001 的统一物品ID为 8001001。
+type ItemRange struct {
+	Category               ItemCategory `json:"category"`
+	MinID                  int          `json:"minID"`
+	MaxID                  int          `json:"maxID"`
+	Offset                 int          `json:"offset"`
+	Name                   string       `json:"name"`                   // 该类物品的通用名称
+	DefaultDurationSeconds int64        `json:"defaultDurationSeconds"` // 发放时未指定过期时间使用的有效期，0 表示永久
+}
+
+// ItemDefinition 单个物品的显示信息，未填写的字段使用所在范围的默认值
+type ItemDefinition struct {
+	ItemID                 int    `json:"itemID"`
+	Name                   string `json:"name"`
+	Description            string `json:"description"`
+	Icon                   string `json:"icon"`
+	DefaultDurationSeconds *int64 `json:"defaultDurationSeconds,omitempty"`
+}
+
+// ItemInfo 是注册表对一个物品ID的解析结果
+type ItemInfo struct {
+	ItemID                 int          // 统一物品ID
+	NativeID               int          // 分区中实际保存的ID（统一物品ID - Offset）
+	Category               ItemCategory // 所属分区
+	Manager                string       // 负责该分区的管理器名称
+	Name                   string
+	Description            string
+	Icon                   string
+	DefaultDurationSeconds int64
+}
+
+// itemRegistryFile 是 items.json 的结构
+type itemRegistryFile struct {
+	Ranges []ItemRange      `json:"ranges"`
+	Items  []ItemDefinition `json:"items"`
+}
+
+// ItemRegistry 把物品ID解析为分区、显示信息、默认有效期和负责的管理器
+type ItemRegistry struct {
+	ranges []ItemRange // 按 MinID 排序
+	items  map[int]ItemDefinition
+}
+
+// NewItemRegistry 创建注册表，并检查范围不重叠、分区已知、单个物品都落在某个范围内
+func NewItemRegistry(ranges []ItemRange, items []ItemDefinition) (*ItemRegistry, error) {
+	sorted := append([]ItemRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinID < sorted[j].MinID })
+	for i, r := range sorted {
+		if _, ok := itemCategoryManagers[r.Category]; !ok {
+			return nil, fmt.Errorf("unknown item category %q", r.Category)
+		}
+		if r.MinID > r.MaxID || r.MinID-r.Offset <= 0 {
+			return nil, fmt.Errorf("invalid item range %d-%d (offset %d)", r.MinID, r.MaxID, r.Offset)
+		}
+		if i > 0 && r.MinID <= sorted[i-1].MaxID {
+			return nil, fmt.Errorf("item range %d-%d overlaps %d-%d", r.MinID, r.MaxID, sorted[i-1].MinID, sorted[i-1].MaxID)
+		}
+	}
+
+	registry := &ItemRegistry{ranges: sorted, items: make(map[int]ItemDefinition, len(items))}
+	for _, item := range items {
+		if _, ok := registry.findRange(item.ItemID); !ok {
+			return nil, fmt.Errorf("item %d is not inside any item range", item.ItemID)
+		}
+		if _, ok := registry.items[item.ItemID]; ok {
+			return nil, fmt.Errorf("duplicate item %d", item.ItemID)
+		}
+		registry.items[item.ItemID] = item
+	}
+	return registry, nil
+}
+
+// LoadItemRegistry 从JSON文件加载注册表
+func LoadItemRegistry(path string) (*ItemRegistry, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file itemRegistryFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return NewItemRegistry(file.Ranges, file.Items)
+}
+
+// findRange 返回物品ID所在的范围
+func (r *ItemRegistry) findRange(itemID int) (*ItemRange, bool) {
+	i := sort.Search(len(r.ranges), func(i int) bool { return r.ranges[i].MaxID >= itemID })
+	if i < len(r.ranges) && r.ranges[i].MinID <= itemID {
+		return &r.ranges[i], true
+	}
+	return nil, false
+}
+
+// Resolve 解析物品ID，ID不在任何范围内时返回 false
+func (r *ItemRegistry) Resolve(itemID int) (*ItemInfo, bool) {
+	itemRange, ok := r.findRange(itemID)
+	if !ok {
+		return nil, false
+	}
+	info := &ItemInfo{
+		ItemID:                 itemID,
+		NativeID:               itemID - itemRange.Offset,
+		Category:               itemRange.Category,
+		Manager:                itemCategoryManagers[itemRange.Category],
+		Name:                   itemRange.Name,
+		DefaultDurationSeconds: itemRange.DefaultDurationSeconds,
+	}
+	if item, ok := r.items[itemID]; ok {
+		if item.Name != "" {
+			info.Name = item.Name
+		}
+		info.Description = item.Description
+		info.Icon = item.Icon
+		if item.DefaultDurationSeconds != nil {
+			info.DefaultDurationSeconds = *item.DefaultDurationSeconds
+		}
+	}
+	return info, true
+}
+
+// ItemID 把分区中的原始ID转换为统一物品ID，分区没有包含该ID的范围时返回 false
+func (r *ItemRegistry) ItemID(category ItemCategory, nativeID int) (int, bool) {
+	for _, itemRange := range r.ranges {
+		itemID := nativeID + itemRange.Offset
+		if itemRange.Category == category && itemID >= itemRange.MinID && itemID <= itemRange.MaxID {
+			return itemID, true
+		}
+	}
+	return 0, false
+}
+
+var (
+	itemRegistryMu sync.RWMutex
+	itemRegistry   *ItemRegistry
+)
+
+// InitItemRegistry 从 configs/items.json 加载全局物品注册表，由 bootstrap 在启动时调用
+func InitItemRegistry() error {
+	registry, err := LoadItemRegistry(itemRegistryPath)
+	if err != nil {
+		return err
+	}
+	SetItemRegistry(registry)
+	log.Printf("Item registry loaded: %d ranges, %d items", len(registry.ranges), len(registry.items))
+	return nil
+}
+
+// Items 返回全局物品注册表
+// 没有调用过 InitItemRegistry（例如在命令行工具中）时会尝试加载一次，失败时返回空注册表。
+func Items() *ItemRegistry {
+	itemRegistryMu.RLock()
+	registry := itemRegistry
+	itemRegistryMu.RUnlock()
+	if registry != nil {
+		return registry
+	}
+
+	if err := InitItemRegistry(); err != nil {
+		log.Printf("加载物品注册表失败: %v", err)
+		SetItemRegistry(&ItemRegistry{items: map[int]ItemDefinition{}})
+	}
+	itemRegistryMu.RLock()
+	defer itemRegistryMu.RUnlock()
+	return itemRegistry
+}
+
+// SetItemRegistry 替换全局物品注册表，测试中可以传入 NewItemRegistry 创建的注册表
+func SetItemRegistry(registry *ItemRegistry) {
+	itemRegistryMu.Lock()
+	defer itemRegistryMu.Unlock()
+	itemRegistry = registry
+}
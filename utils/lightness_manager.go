@@ -2,34 +2,76 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
+	"time"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
 // LightnessData 表示完整的炫光数据结构
+// 保存格式为 {"id":[],"expiredTime":[],"config":0}
 type LightnessData struct {
-	ID          []int `json:"id"`          // 炫光ID
-	ExpiredTime []int `json:"expiredTime"` // 过期时间
-	Config      int   `json:"config"`      // 炫光配置
+	Owned  Entitlements[int] // 拥有的炫光
+	Config int               // 炫光配置
+}
+
+// MarshalJSON 按数据库中的保存格式 {"id":[],"expiredTime":[],"config":0} 序列化炫光数据
+func (l LightnessData) MarshalJSON() ([]byte, error) {
+	owned, err := l.Owned.MarshalArraysJSON("id", "expiredTime")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(owned[:len(owned)-1])
+	buf.WriteByte(',')
+	if err := writeJSONField(&buf, "config", l.Config); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON 解析数据库中保存的炫光数据
+func (l *LightnessData) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Config int `json:"config"` // 炫光配置
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*l = LightnessData{Config: raw.Config}
+	return l.Owned.UnmarshalArraysJSON(data, "id", "expiredTime")
 }
 
 // LightnessManager 提供炫光数据的管理功能
-type LightnessManager struct {}
+type LightnessManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewLightnessManager 创建一个新的炫光管理器
 func NewLightnessManager() *LightnessManager {
-	return &LightnessManager{}
+	return NewLightnessManagerWithRepository(repository.Default())
+}
+
+// NewLightnessManagerWithRepository 使用指定的玩家仓库创建炫光管理器
+func NewLightnessManagerWithRepository(repo repository.PlayerRepository) *LightnessManager {
+	return &LightnessManager{repo: repo}
+}
+
+// retry 以乐观锁执行炫光数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (lm *LightnessManager) retry(fn func(tx *LightnessManager) error) error {
+	return repository.RetryOnConflict(lm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewLightnessManagerWithRepository(repo))
+	})
 }
 
 // GetLightnessData 从数据库获取指定设备ID的炫光数据
 func (lm *LightnessManager) GetLightnessData(deviceID string) (*LightnessData, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := lm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -50,7 +92,7 @@ func (lm *LightnessManager) GetLightnessData(deviceID string) (*LightnessData, e
 		return defaultLightnessData, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.LightnessData), &lightnessData)
+	err = json.Unmarshal([]byte(playerData.LightnessData), &lightnessData)
 	if err != nil {
 		log.Printf("解析炫光数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -71,9 +113,8 @@ func (lm *LightnessManager) SaveLightnessData(deviceID string, lightnessData *Li
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("lightness_data", string(lightnessDataJSON))
-	if result.Error != nil {
-		log.Printf("更新炫光数据失败: %v", result.Error)
+	if err := lm.repo.UpdateColumns(deviceID, map[string]interface{}{"lightness_data": string(lightnessDataJSON)}); err != nil {
+		log.Printf("更新炫光数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -84,9 +125,8 @@ func (lm *LightnessManager) SaveLightnessData(deviceID string, lightnessData *Li
 func (lm *LightnessManager) GetDefaultLightnessData() *LightnessData {
 	// 创建默认炫光数据
 	defaultLightnessData := &LightnessData{
-		ID:          []int{},
-		ExpiredTime: []int{},
-		Config:      0,
+		Owned:  NewEntitlements([]int{}, []int{}),
+		Config: 0,
 	}
 	
 	return defaultLightnessData
@@ -94,92 +134,79 @@ func (lm *LightnessManager) GetDefaultLightnessData() *LightnessData {
 
 // UpdateLightnessIDs 更新玩家拥有的炫光ID列表
 func (lm *LightnessManager) UpdateLightnessIDs(deviceID string, ids []int, expiredTimes []int) error {
-	// 获取当前炫光数据
-	lightnessData, err := lm.GetLightnessData(deviceID)
-	if err != nil {
-		return err
-	}
+	return lm.retry(func(tx *LightnessManager) error {
+		// 获取当前炫光数据
+		lightnessData, err := tx.GetLightnessData(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 更新炫光ID和过期时间
-	lightnessData.ID = ids
-	lightnessData.ExpiredTime = expiredTimes
+		// 更新炫光ID和过期时间
+		lightnessData.Owned = NewEntitlements(ids, expiredTimes)
 
-	// 保存到数据库
-	return lm.SaveLightnessData(deviceID, lightnessData)
+		// 保存到数据库
+		return tx.SaveLightnessData(deviceID, lightnessData)
+	})
 }
 
 // UpdateLightnessConfig 更新玩家的炫光配置
 func (lm *LightnessManager) UpdateLightnessConfig(deviceID string, config int) error {
-	// 获取当前炫光数据
-	lightnessData, err := lm.GetLightnessData(deviceID)
-	if err != nil {
-		return err
-	}
+	return lm.retry(func(tx *LightnessManager) error {
+		// 获取当前炫光数据
+		lightnessData, err := tx.GetLightnessData(deviceID)
+		if err != nil {
+			return err
+		}
+
+		// 装备的炫光必须已拥有且未过期，0 表示不使用炫光
+		if config != 0 {
+			if err := lightnessData.Owned.CheckEquippable(config, time.Now()); err != nil {
+				return err
+			}
+		}
 
-	// 更新炫光配置
-	lightnessData.Config = config
+		// 更新炫光配置
+		lightnessData.Config = config
 
-	// 保存到数据库
-	return lm.SaveLightnessData(deviceID, lightnessData)
+		// 保存到数据库
+		return tx.SaveLightnessData(deviceID, lightnessData)
+	})
 }
 
 // AddLightness 添加一个新的炫光到玩家拥有的炫光列表
 func (lm *LightnessManager) AddLightness(deviceID string, id int, expiredTime int) error {
-	// 获取当前炫光数据
-	lightnessData, err := lm.GetLightnessData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 检查炫光是否已存在
-	for i, lightnessID := range lightnessData.ID {
-		if lightnessID == id {
-			// 如果已存在，更新过期时间
-			lightnessData.ExpiredTime[i] = expiredTime
-			return lm.SaveLightnessData(deviceID, lightnessData)
+	return lm.retry(func(tx *LightnessManager) error {
+		// 获取当前炫光数据
+		lightnessData, err := tx.GetLightnessData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// 添加新炫光
-	lightnessData.ID = append(lightnessData.ID, id)
-	lightnessData.ExpiredTime = append(lightnessData.ExpiredTime, expiredTime)
+		// 添加新炫光，已存在时更新过期时间
+		lightnessData.Owned.Add(id, expiredTime)
 
-	// 保存到数据库
-	return lm.SaveLightnessData(deviceID, lightnessData)
+		// 保存到数据库
+		return tx.SaveLightnessData(deviceID, lightnessData)
+	})
 }
 
 // RemoveLightness 从玩家拥有的炫光列表中移除一个炫光
 func (lm *LightnessManager) RemoveLightness(deviceID string, id int) error {
-	// 获取当前炫光数据
-	lightnessData, err := lm.GetLightnessData(deviceID)
-	if err != nil {
-		return err
-	}
-
-	// 查找并移除炫光
-	found := false
-	newIDs := []int{}
-	newExpiredTimes := []int{}
-
-	for i, lightnessID := range lightnessData.ID {
-		if lightnessID != id {
-			newIDs = append(newIDs, lightnessID)
-			newExpiredTimes = append(newExpiredTimes, lightnessData.ExpiredTime[i])
-		} else {
-			found = true
+	return lm.retry(func(tx *LightnessManager) error {
+		// 获取当前炫光数据
+		lightnessData, err := tx.GetLightnessData(deviceID)
+		if err != nil {
+			return err
 		}
-	}
 
-	if !found {
-		return game_error.New(-2, "炫光不存在")
-	}
-
-	// 更新炫光数据
-	lightnessData.ID = newIDs
-	lightnessData.ExpiredTime = newExpiredTimes
+		// 查找并移除炫光
+		if !lightnessData.Owned.Remove(id) {
+			return game_error.New(-2, "炫光不存在")
+		}
 
-	// 保存到数据库
-	return lm.SaveLightnessData(deviceID, lightnessData)
+		// 保存到数据库
+		return tx.SaveLightnessData(deviceID, lightnessData)
+	})
 }
 
 // GetLightnessIDs 获取玩家拥有的炫光ID列表
@@ -190,7 +217,7 @@ func (lm *LightnessManager) GetLightnessIDs(deviceID string) ([]int, []int, erro
 		return nil, nil, err
 	}
 
-	return lightnessData.ID, lightnessData.ExpiredTime, nil
+	return lightnessData.Owned.IDs(), lightnessData.Owned.ExpiredTimes(), nil
 }
 
 // GetLightnessConfig 获取玩家的炫光配置
@@ -8,9 +8,8 @@ import (
 	"strconv"
 	"strings"
 
-	"dmmserver/db"
 	"dmmserver/game_error"
-	"dmmserver/model"
+	"dmmserver/repository"
 )
 
 // PublicInfo 表示玩家公开信息的结构
@@ -49,18 +48,24 @@ type UnionBadge struct {
 }
 
 // PublicInfoManager 提供玩家公开信息的管理功能
-type PublicInfoManager struct{}
+type PublicInfoManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewPublicInfoManager 创建一个新的玩家公开信息管理器
 func NewPublicInfoManager() *PublicInfoManager {
-	return &PublicInfoManager{}
+	return NewPublicInfoManagerWithRepository(repository.Default())
+}
+
+// NewPublicInfoManagerWithRepository 使用指定的玩家仓库创建玩家公开信息管理器
+func NewPublicInfoManagerWithRepository(repo repository.PlayerRepository) *PublicInfoManager {
+	return &PublicInfoManager{repo: repo}
 }
 
 // GetPublicInfo 从数据库获取指定设备ID的玩家公开信息
 func (pm *PublicInfoManager) GetPublicInfo(deviceID string) (*PublicInfo, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := pm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -82,7 +87,7 @@ func (pm *PublicInfoManager) GetPublicInfo(deviceID string) (*PublicInfo, error)
 	}
 
 	// 解析键值对格式
-	publicInfo, err := pm.ParseKeyValuePublicInfo(playerData.PublicInfo)
+	publicInfo, err = pm.ParseKeyValuePublicInfo(playerData.PublicInfo)
 	if err != nil {
 		log.Printf("解析公开信息数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -96,9 +101,8 @@ func (pm *PublicInfoManager) GetPublicInfo(deviceID string) (*PublicInfo, error)
 
 // GetPublicInfoByRoleID 通过角色ID获取玩家公开信息
 func (pm *PublicInfoManager) GetPublicInfoByRoleID(roleID int) (*PublicInfo, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("role_id = ?", roleID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := pm.repo.GetByRoleID(roleID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -120,7 +124,7 @@ func (pm *PublicInfoManager) GetPublicInfoByRoleID(roleID int) (*PublicInfo, err
 	}
 
 	// 解析键值对格式
-	publicInfo, err := pm.ParseKeyValuePublicInfo(playerData.PublicInfo)
+	publicInfo, err = pm.ParseKeyValuePublicInfo(playerData.PublicInfo)
 	if err != nil {
 		log.Printf("解析公开信息数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -142,9 +146,8 @@ func (pm *PublicInfoManager) SavePublicInfo(deviceID string, publicInfo *PublicI
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("public_info", publicInfoStr)
-	if result.Error != nil {
-		log.Printf("更新公开信息数据失败: %v", result.Error)
+	if err := pm.repo.UpdateColumns(deviceID, map[string]interface{}{"public_info": publicInfoStr}); err != nil {
+		log.Printf("更新公开信息数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -161,9 +164,8 @@ func (pm *PublicInfoManager) SavePublicInfoByRoleID(roleID int, publicInfo *Publ
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("role_id = ?", roleID).Update("public_info", publicInfoStr)
-	if result.Error != nil {
-		log.Printf("更新公开信息数据失败: %v", result.Error)
+	if err := pm.repo.UpdateColumnsByRoleID(roleID, map[string]interface{}{"public_info": publicInfoStr}); err != nil {
+		log.Printf("更新公开信息数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -8,9 +8,8 @@ import (
 	"strings"
 	"encoding/json"
 
-	"dmmserver/db"
 	"dmmserver/game_error"
-	"dmmserver/model"
+	"dmmserver/repository"
 )
 
 // RadarInfo 表示玩家雷达信息的结构
@@ -22,18 +21,24 @@ type RadarInfo struct {
 }
 
 // RadarManager 提供玩家雷达信息的管理功能
-type RadarManager struct{}
+type RadarManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewRadarManager 创建一个新的玩家雷达信息管理器
 func NewRadarManager() *RadarManager {
-	return &RadarManager{}
+	return NewRadarManagerWithRepository(repository.Default())
+}
+
+// NewRadarManagerWithRepository 使用指定的玩家仓库创建玩家雷达信息管理器
+func NewRadarManagerWithRepository(repo repository.PlayerRepository) *RadarManager {
+	return &RadarManager{repo: repo}
 }
 
 // GetRadarInfo 从数据库获取指定设备ID的玩家雷达信息
 func (rm *RadarManager) GetRadarInfo(deviceID string) (*RadarInfo, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := rm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -55,7 +60,7 @@ func (rm *RadarManager) GetRadarInfo(deviceID string) (*RadarInfo, error) {
 	}
 
 	// 解析键值对格式
-	radarInfo, err := rm.ParseKeyValueRadarInfo(playerData.PlayerRadar)
+	radarInfo, err = rm.ParseKeyValueRadarInfo(playerData.PlayerRadar)
 	if err != nil {
 		log.Printf("解析雷达信息数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -69,9 +74,8 @@ func (rm *RadarManager) GetRadarInfo(deviceID string) (*RadarInfo, error) {
 
 // GetRadarInfoByRoleID 通过角色ID获取玩家雷达信息
 func (rm *RadarManager) GetRadarInfoByRoleID(roleID int) (*RadarInfo, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("role_id = ?", roleID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := rm.repo.GetByRoleID(roleID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -93,7 +97,7 @@ func (rm *RadarManager) GetRadarInfoByRoleID(roleID int) (*RadarInfo, error) {
 	}
 
 	// 解析键值对格式
-	radarInfo, err := rm.ParseKeyValueRadarInfo(playerData.PlayerRadar)
+	radarInfo, err = rm.ParseKeyValueRadarInfo(playerData.PlayerRadar)
 	if err != nil {
 		log.Printf("解析雷达信息数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -115,9 +119,8 @@ func (rm *RadarManager) SaveRadarInfo(deviceID string, radarInfo *RadarInfo) err
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("player_radar", radarInfoStr)
-	if result.Error != nil {
-		log.Printf("更新雷达信息数据失败: %v", result.Error)
+	if err := rm.repo.UpdateColumns(deviceID, map[string]interface{}{"player_radar": radarInfoStr}); err != nil {
+		log.Printf("更新雷达信息数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -134,9 +137,8 @@ func (rm *RadarManager) SaveRadarInfoByRoleID(roleID int, radarInfo *RadarInfo)
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("role_id = ?", roleID).Update("player_radar", radarInfoStr)
-	if result.Error != nil {
-		log.Printf("更新雷达信息数据失败: %v", result.Error)
+	if err := rm.repo.UpdateColumnsByRoleID(roleID, map[string]interface{}{"player_radar": radarInfoStr}); err != nil {
+		log.Printf("更新雷达信息数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
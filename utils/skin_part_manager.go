@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"log"
 
-	"dmmserver/db"
-	"dmmserver/model"
+	"dmmserver/repository"
 	"dmmserver/game_error"
 )
 
@@ -19,18 +18,31 @@ type SkinPart struct {
 }
 
 // SkinPartManager 提供皮肤部件数据的管理功能
-type SkinPartManager struct {}
+type SkinPartManager struct {
+	repo repository.PlayerRepository
+}
 
 // NewSkinPartManager 创建一个新的皮肤部件管理器
 func NewSkinPartManager() *SkinPartManager {
-	return &SkinPartManager{}
+	return NewSkinPartManagerWithRepository(repository.Default())
+}
+
+// NewSkinPartManagerWithRepository 使用指定的玩家仓库创建皮肤部件管理器
+func NewSkinPartManagerWithRepository(repo repository.PlayerRepository) *SkinPartManager {
+	return &SkinPartManager{repo: repo}
+}
+
+// retry 以乐观锁执行皮肤部件数据的读-改-写，其他请求同时修改了该玩家时重新读取并重试
+func (sm *SkinPartManager) retry(fn func(tx *SkinPartManager) error) error {
+	return repository.RetryOnConflict(sm.repo, func(repo repository.PlayerRepository) error {
+		return fn(NewSkinPartManagerWithRepository(repo))
+	})
 }
 
 // GetSkinParts 从数据库获取指定设备ID的所有皮肤部件数据
 func (sm *SkinPartManager) GetSkinParts(deviceID string) ([]SkinPart, error) {
-	var playerData model.PlayerData
-	result := db.DB.Where("device_id = ?", deviceID).First(&playerData)
-	if result.Error != nil {
+	playerData, err := sm.repo.GetByDeviceID(deviceID)
+	if err != nil {
 		return nil, game_error.New(-3, "未找到玩家数据")
 	}
 
@@ -51,7 +63,7 @@ func (sm *SkinPartManager) GetSkinParts(deviceID string) ([]SkinPart, error) {
 		return defaultSkinParts, nil
 	}
 
-	err := json.Unmarshal([]byte(playerData.OwnedSkins), &skinParts)
+	err = json.Unmarshal([]byte(playerData.OwnedSkins), &skinParts)
 	if err != nil {
 		log.Printf("解析皮肤部件数据失败: %v", err)
 		// 解析失败时，使用默认数据但不保存到数据库
@@ -69,9 +81,8 @@ func (sm *SkinPartManager) SaveSkinParts(deviceID string, skinParts []SkinPart)
 	if len(skinParts) == 0 {
 		// 如果为空，使用空数组而不是空字符串
 		log.Printf("皮肤部件数据为空，使用空数组 '[]' 代替")
-		result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("owned_skins", "[]")
-		if result.Error != nil {
-			log.Printf("更新皮肤部件数据失败: %v", result.Error)
+		if err := sm.repo.UpdateColumns(deviceID, map[string]interface{}{"owned_skins": "[]"}); err != nil {
+			log.Printf("更新皮肤部件数据失败: %v", err)
 			return game_error.New(-2, "数据库更新错误")
 		}
 		return nil
@@ -85,9 +96,8 @@ func (sm *SkinPartManager) SaveSkinParts(deviceID string, skinParts []SkinPart)
 	}
 
 	// 更新数据库
-	result := db.DB.Model(&model.PlayerData{}).Where("device_id = ?", deviceID).Update("owned_skins", string(skinPartsJSON))
-	if result.Error != nil {
-		log.Printf("更新皮肤部件数据失败: %v", result.Error)
+	if err := sm.repo.UpdateColumns(deviceID, map[string]interface{}{"owned_skins": string(skinPartsJSON)}); err != nil {
+		log.Printf("更新皮肤部件数据失败: %v", err)
 		return game_error.New(-2, "数据库更新错误")
 	}
 
@@ -171,121 +181,136 @@ func (sm *SkinPartManager) GetDefaultSkinParts() []SkinPart {
 
 // UpdateSkinPartField 更新指定皮肤部件的特定字段
 func (sm *SkinPartManager) UpdateSkinPartField(deviceID string, skinPartID string, fieldName string, fieldValue interface{}) error {
-	// 获取当前皮肤部件数据
-	skinParts, err := sm.GetSkinParts(deviceID)
-	if err != nil {
-		return err
-	}
+	return sm.retry(func(tx *SkinPartManager) error {
+		// 获取当前皮肤部件数据
+		skinParts, err := tx.GetSkinParts(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并更新指定皮肤部件的字段
-	partFound := false
-	for i, part := range skinParts {
-		if part.SkinPartIDs == skinPartID {
+		// 查找并更新指定皮肤部件的字段
+		partFound := false
+		for i, part := range skinParts {
+			if part.SkinPartIDs == skinPartID {
+				switch fieldName {
+				case "skinPartColors":
+					if colors, ok := fieldValue.(string); ok {
+						skinParts[i].SkinPartColors = colors
+					} else {
+						return game_error.New(-2, "字段类型错误")
+					}
+				case "expiredTime":
+					if expTime, ok := fieldValue.(int); ok {
+						skinParts[i].ExpiredTime = expTime
+					} else {
+						return game_error.New(-2, "字段类型错误")
+					}
+				case "skinDecals":
+					skinParts[i].SkinDecals = fieldValue
+				default:
+					return game_error.New(-2, "不支持的字段名称")
+				}
+				partFound = true
+				break
+			}
+		}
+
+		// 如果没有找到指定皮肤部件，则添加新皮肤部件
+		if !partFound {
+			newPart := SkinPart{SkinPartIDs: skinPartID}
 			switch fieldName {
 			case "skinPartColors":
 				if colors, ok := fieldValue.(string); ok {
-					skinParts[i].SkinPartColors = colors
+					newPart.SkinPartColors = colors
 				} else {
 					return game_error.New(-2, "字段类型错误")
 				}
 			case "expiredTime":
 				if expTime, ok := fieldValue.(int); ok {
-					skinParts[i].ExpiredTime = expTime
+					newPart.ExpiredTime = expTime
 				} else {
 					return game_error.New(-2, "字段类型错误")
 				}
 			case "skinDecals":
-				skinParts[i].SkinDecals = fieldValue
+				newPart.SkinDecals = fieldValue
 			default:
 				return game_error.New(-2, "不支持的字段名称")
 			}
-			partFound = true
-			break
+			skinParts = append(skinParts, newPart)
 		}
-	}
 
-	// 如果没有找到指定皮肤部件，则添加新皮肤部件
-	if !partFound {
-		newPart := SkinPart{SkinPartIDs: skinPartID}
-		switch fieldName {
-		case "skinPartColors":
-			if colors, ok := fieldValue.(string); ok {
-				newPart.SkinPartColors = colors
-			} else {
-				return game_error.New(-2, "字段类型错误")
-			}
-		case "expiredTime":
-			if expTime, ok := fieldValue.(int); ok {
-				newPart.ExpiredTime = expTime
-			} else {
-				return game_error.New(-2, "字段类型错误")
-			}
-		case "skinDecals":
-			newPart.SkinDecals = fieldValue
-		default:
-			return game_error.New(-2, "不支持的字段名称")
-		}
-		skinParts = append(skinParts, newPart)
-	}
+		// 保存更新后的皮肤部件数据
+		return tx.SaveSkinParts(deviceID, skinParts)
+	})
+}
 
-	// 保存更新后的皮肤部件数据
-	return sm.SaveSkinParts(deviceID, skinParts)
+// skinPartEntitlements 把皮肤部件列表转换为按部件ID索引的限时物品，用于检查是否过期
+func skinPartEntitlements(skinParts []SkinPart) Entitlements[string] {
+	var owned Entitlements[string]
+	for _, part := range skinParts {
+		owned.Add(part.SkinPartIDs, part.ExpiredTime)
+	}
+	return owned
 }
 
 // AddSkinPart 添加新皮肤部件或更新现有皮肤部件
 func (sm *SkinPartManager) AddSkinPart(deviceID string, skinPart SkinPart) error {
-	// 获取当前皮肤部件数据
-	skinParts, err := sm.GetSkinParts(deviceID)
-	if err != nil {
-		return err
-	}
+	return sm.retry(func(tx *SkinPartManager) error {
+		// 获取当前皮肤部件数据
+		skinParts, err := tx.GetSkinParts(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找是否已存在相同ID的皮肤部件
-	partFound := false
-	for i, existingPart := range skinParts {
-		if existingPart.SkinPartIDs == skinPart.SkinPartIDs {
-			// 更新现有皮肤部件
-			skinParts[i] = skinPart
-			partFound = true
-			break
+		// 查找是否已存在相同ID的皮肤部件
+		partFound := false
+		for i, existingPart := range skinParts {
+			if existingPart.SkinPartIDs == skinPart.SkinPartIDs {
+				// 更新现有皮肤部件
+				skinParts[i] = skinPart
+				partFound = true
+				break
+			}
 		}
-	}
 
-	// 如果没有找到相同ID的皮肤部件，则添加新皮肤部件
-	if !partFound {
-		skinParts = append(skinParts, skinPart)
-	}
+		// 如果没有找到相同ID的皮肤部件，则添加新皮肤部件
+		if !partFound {
+			skinParts = append(skinParts, skinPart)
+		}
 
-	// 保存更新后的皮肤部件数据
-	return sm.SaveSkinParts(deviceID, skinParts)
+		// 保存更新后的皮肤部件数据
+		return tx.SaveSkinParts(deviceID, skinParts)
+	})
 }
 
 // RemoveSkinPart 移除指定ID的皮肤部件
 func (sm *SkinPartManager) RemoveSkinPart(deviceID string, skinPartID string) error {
-	// 获取当前皮肤部件数据
-	skinParts, err := sm.GetSkinParts(deviceID)
-	if err != nil {
-		return err
-	}
+	return sm.retry(func(tx *SkinPartManager) error {
+		// 获取当前皮肤部件数据
+		skinParts, err := tx.GetSkinParts(deviceID)
+		if err != nil {
+			return err
+		}
 
-	// 查找并移除指定ID的皮肤部件
-	found := false
-	for i, part := range skinParts {
-		if part.SkinPartIDs == skinPartID {
-			// 移除皮肤部件（通过切片操作）
-			skinParts = append(skinParts[:i], skinParts[i+1:]...)
-			found = true
-			break
+		// 查找并移除指定ID的皮肤部件
+		found := false
+		for i, part := range skinParts {
+			if part.SkinPartIDs == skinPartID {
+				// 移除皮肤部件（通过切片操作）
+				skinParts = append(skinParts[:i], skinParts[i+1:]...)
+				found = true
+				break
+			}
 		}
-	}
 
-	if !found {
-		// 如果没有找到指定ID的皮肤部件，返回错误
-		return game_error.New(-2, "未找到指定ID的皮肤部件")
-	}
+		if !found {
+			// 如果没有找到指定ID的皮肤部件，返回错误
+			return game_error.New(-2, "未找到指定ID的皮肤部件")
+		}
 
-	// 保存更新后的皮肤部件数据
-	return sm.SaveSkinParts(deviceID, skinParts)
+		// 保存更新后的皮肤部件数据
+		return tx.SaveSkinParts(deviceID, skinParts)
+	})
 }
 
 // ParseSkinPartsFromDB 从数据库JSON字符串解析皮肤部件数据并转换为客户端所需格式